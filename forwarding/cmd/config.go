@@ -0,0 +1,836 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"forwarding/forwarder"
+	"forwarding/forwarder/connection"
+	"forwarding/forwarder/httpcache"
+	"forwarding/metrics_processing"
+	"forwarding/metrics_processing/fault"
+	"forwarding/metrics_processing/probe"
+	"forwarding/tracing"
+	"log"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The configuration file is laid over the compiled-in defaults, so every key
+// is optional except metrics.server_addr. Durations are strings such as
+// "250ms" or "5m". On SIGHUP the file is read again: [buffer], [timeouts]
+// except udp_flow_idle, [probe] and metrics.report_interval take effect at
+// once, changes to other sections are reported and need a restart.
+
+// Config struct to hold configuration from toml file
+type ForwardingConfig struct {
+	Metrics           MetricsConfig           `toml:"metrics"`
+	Admin             AdminConfig             `toml:"admin"`
+	Probe             ProbeConfig             `toml:"probe"`
+	Access            AccessConfig            `toml:"access"`
+	Relay             RelayConfig             `toml:"relay"`
+	Buffer            BufferConfig            `toml:"buffer"`
+	Retry             RetryConfig             `toml:"retry"`
+	PathHealth        PathHealthConfig        `toml:"path_health"`
+	QoS               QoSConfig               `toml:"qos"`
+	Hedging           HedgingConfig           `toml:"hedging"`
+	RateLimit         RateLimitConfig         `toml:"rate_limit"`
+	Tuner             TunerConfig             `toml:"tuner"`
+	Compression       CompressionConfig       `toml:"compression"`
+	Egress            EgressConfig            `toml:"egress"`
+	FaultDetection    FaultDetectionConfig    `toml:"fault_detection"`
+	Timeouts          TimeoutConfig           `toml:"timeouts"`
+	Drain             DrainConfig             `toml:"drain"`
+	Tunnels           []TunnelEntry           `toml:"tunnel"`
+	UDP               []TunnelEntry           `toml:"udp"`
+	Cache             CacheConfig             `toml:"cache"`
+	Tracing           TracingConfig           `toml:"tracing"`
+	TransportSecurity TransportSecurityConfig `toml:"transport_security"`
+}
+
+type MetricsConfig struct {
+	ServerAddr     string        `toml:"server_addr"`
+	ListenAddr     string        `toml:"listen_addr"` // serves /metrics for Prometheus
+	ReportInterval time.Duration `toml:"report_interval"`
+	DataDir        string        `toml:"data_dir"`
+}
+
+// AdminConfig sets where the admin API listens; keep it on a loopback or management address.
+type AdminConfig struct {
+	ListenAddr string `toml:"listen_addr"`
+}
+
+type ProbeConfig struct {
+	Timeout    time.Duration `toml:"timeout"`
+	NodePort   string        `toml:"node_port"`
+	OriginPort string        `toml:"origin_port"`
+}
+
+type AccessConfig struct {
+	HTTPPort            string        `toml:"http_port"`
+	HTTPSPort           string        `toml:"https_port"` // empty disables TLS termination
+	CertDir             string        `toml:"cert_dir"`
+	CertReloadInterval  time.Duration `toml:"cert_reload_interval"`
+	ResponsePort        string        `toml:"response_port"`
+	UnknownDomainStatus int           `toml:"unknown_domain_status"`
+}
+
+type RelayConfig struct {
+	RequestPort        string `toml:"request_port"`
+	ResponsePort       string `toml:"response_port"`
+	RelayPort          string `toml:"relay_port"`
+	SourcePort         string `toml:"source_port"`
+	AccessResponsePort string `toml:"access_response_port"`
+	RelayResponsePort  string `toml:"relay_response_port"`
+}
+
+type BufferConfig struct {
+	BuffersPerPath       int                      `toml:"buffers_per_path"`
+	MaxRequestsPerBuffer int                      `toml:"max_requests_per_buffer"`
+	MaxBufferSize        int                      `toml:"max_buffer_size"`
+	MaxWaitTime          time.Duration            `toml:"max_wait_time"`
+	ClassMaxWaitTime     map[string]time.Duration `toml:"class_max_wait_time"` // by traffic class name
+	MinIdleTime          time.Duration            `toml:"min_idle_time"`
+	MaxPathIdleTime      time.Duration            `toml:"max_path_idle_time"`
+	StatisticsInterval   time.Duration            `toml:"statistics_interval"`
+	CleanupInterval      time.Duration            `toml:"cleanup_interval"`
+}
+
+type RetryConfig struct {
+	MaxAttempts       int           `toml:"max_attempts"`
+	AttemptTimeout    time.Duration `toml:"attempt_timeout"`
+	TotalBudget       time.Duration `toml:"total_budget"`
+	MaxReplayBodySize int64         `toml:"max_replay_body_size"`
+	FailedPathPenalty time.Duration `toml:"failed_path_penalty"`
+	IdempotentMethods []string      `toml:"idempotent_methods"`
+}
+
+// PathHealthConfig sets how the access node scores paths and when its
+// circuit breaker ejects one.
+type PathHealthConfig struct {
+	Alpha              float64       `toml:"alpha"`
+	FailureThreshold   int           `toml:"failure_threshold"`
+	ErrorRateThreshold float64       `toml:"error_rate_threshold"`
+	MinSamples         int           `toml:"min_samples"`
+	OpenDuration       time.Duration `toml:"open_duration"`
+	IdleExpiry         time.Duration `toml:"idle_expiry"`
+}
+
+// QoSConfig puts access requests into traffic classes: the class named in
+// class_header if the client sent a valid one, else the first matching rule,
+// else default_class.
+type QoSConfig struct {
+	ClassHeader  string    `toml:"class_header"`
+	Rules        []QoSRule `toml:"rules"`
+	DefaultClass string    `toml:"default_class"`
+}
+
+type QoSRule struct {
+	Domain     string `toml:"domain"`      // empty matches every domain
+	PathPrefix string `toml:"path_prefix"` // empty matches every path
+	Class      string `toml:"class"`
+}
+
+// HedgingConfig sends small idempotent requests of the listed traffic classes
+// on a second, relay-disjoint path when the first is slow.
+type HedgingConfig struct {
+	Enabled         bool          `toml:"enabled"`
+	Classes         []string      `toml:"classes"`
+	MaxBodySize     int64         `toml:"max_body_size"`
+	DelayPercentile float64       `toml:"delay_percentile"` // 0 sends both copies at once
+	MinDelay        time.Duration `toml:"min_delay"`
+	BudgetPercent   float64       `toml:"budget_percent"`
+	BudgetWindow    time.Duration `toml:"budget_window"`
+}
+
+// RateLimitConfig sets the access node's own token-bucket limits; rates of 0
+// are unlimited. Per-domain limits come from the controller, whose per-client
+// rate for a domain replaces client_requests_per_second.
+type RateLimitConfig struct {
+	RequestsPerSecond       float64       `toml:"requests_per_second"`
+	Burst                   int           `toml:"burst"`
+	ClientRequestsPerSecond float64       `toml:"client_requests_per_second"`
+	ClientBurst             int           `toml:"client_burst"`
+	IdleTimeout             time.Duration `toml:"idle_timeout"`
+}
+
+// TunerConfig enables the LinUCB tuner of the merge buffers and SMUX
+// sessions; it tries every combination of the listed values. An empty
+// model_file keeps the model in tuner_model.json of metrics.data_dir.
+type TunerConfig struct {
+	Enabled              bool            `toml:"enabled"`
+	Interval             time.Duration   `toml:"interval"`
+	Alpha                float64         `toml:"alpha"`
+	ThroughputWeight     float64         `toml:"throughput_weight"`
+	ModelFile            string          `toml:"model_file"`
+	MaxWaitTimes         []time.Duration `toml:"max_wait_times"`
+	MaxRequestsPerBuffer []int           `toml:"max_requests_per_buffer"`
+	BuffersPerPath       []int           `toml:"buffers_per_path"`
+	SessionsPerPeer      []int           `toml:"sessions_per_peer"`
+}
+
+type TimeoutConfig struct {
+	QueueSubmit     time.Duration `toml:"queue_submit"`
+	ResponseRead    time.Duration `toml:"response_read"`
+	DirectProxy     time.Duration `toml:"direct_proxy"`
+	StreamIdle      time.Duration `toml:"stream_idle"`
+	StateExpiration time.Duration `toml:"state_expiration"`
+	StateCleanup    time.Duration `toml:"state_cleanup"`
+	UDPFlowIdle     time.Duration `toml:"udp_flow_idle"` // read at startup, unlike the others
+}
+
+// CompressionConfig sets the per-hop deflate compression of packet payloads.
+type CompressionConfig struct {
+	Enabled bool `toml:"enabled"`
+	MinSize int  `toml:"min_size"`
+	Level   int  `toml:"level"`
+}
+
+// EgressConfig sets the send slots a relay shares between the traffic classes.
+type EgressConfig struct {
+	Slots          int `toml:"slots"` // 0 for no limit
+	StandardWeight int `toml:"standard_weight"`
+	BulkWeight     int `toml:"bulk_weight"`
+}
+
+// FaultDetectionConfig sets when the node reports a fault to the controller.
+type FaultDetectionConfig struct {
+	ProbeFailureThreshold   int           `toml:"probe_failure_threshold"`
+	SessionFailureThreshold int           `toml:"session_failure_threshold"`
+	OriginFailureThreshold  int           `toml:"origin_failure_threshold"`
+	ResourceSampleThreshold int           `toml:"resource_sample_threshold"`
+	CPUUsageThreshold       float64       `toml:"cpu_usage_threshold"`
+	MemoryUsageThreshold    float64       `toml:"memory_usage_threshold"`
+	DiskUsageThreshold      float64       `toml:"disk_usage_threshold"`
+	InitialBackoff          time.Duration `toml:"initial_backoff"`
+	MaxBackoff              time.Duration `toml:"max_backoff"`
+}
+
+type DrainConfig struct {
+	Timeout        time.Duration `toml:"timeout"`         // requests still in flight then are failed
+	HandoffTimeout time.Duration `toml:"handoff_timeout"` // for the new process to take over the sockets
+}
+
+// TunnelEntry exposes a TCP or UDP port on the access node that is carried to OriginPort on Domain's origin.
+type TunnelEntry struct {
+	ListenPort string `toml:"listen_port"`
+	Domain     string `toml:"domain"`
+	OriginPort int    `toml:"origin_port"`
+}
+
+// CacheConfig enables the edge response cache of the access node. Sizes are in megabytes.
+type CacheConfig struct {
+	Enabled         bool     `toml:"enabled"`
+	Domains         []string `toml:"domains"`
+	DisabledDomains []string `toml:"disabled_domains"`
+	MemoryMB        int64    `toml:"memory_mb"`
+	DiskDir         string   `toml:"disk_dir"`
+	DiskMB          int64    `toml:"disk_mb"`
+	MaxObjectMB     int64    `toml:"max_object_mb"`
+}
+
+// TracingConfig exports request spans over OTLP/HTTP to endpoint, or with
+// exporter = "file" as OTLP JSON lines to file.
+type TracingConfig struct {
+	Enabled      bool          `toml:"enabled"`
+	Exporter     string        `toml:"exporter"`
+	Endpoint     string        `toml:"endpoint"`
+	File         string        `toml:"file"`
+	ServiceName  string        `toml:"service_name"`
+	SampleRatio  float64       `toml:"sample_ratio"`
+	BatchTimeout time.Duration `toml:"batch_timeout"`
+}
+
+// TransportSecurityConfig sets whether inter-node links must run over
+// mutual TLS; see the upgrade order in forwarding_config.toml.
+type TransportSecurityConfig struct {
+	RequireMutualTLS bool          `toml:"require_mutual_tls"`
+	HandshakeTimeout time.Duration `toml:"handshake_timeout"`
+}
+
+func defaultConfig() ForwardingConfig {
+	dataPlane := metrics_processing.DefaultConfig()
+	probeConfig := probe.DefaultConfig()
+	access := forwarder.DefaultAccessConfig
+	relay := forwarder.DefaultRelayConfig
+	buffer := forwarder.DefaultBufferConfig()
+	retry := forwarder.DefaultRetryConfig()
+	pathHealth := forwarder.DefaultPathHealthConfig()
+	compression := forwarder.DefaultCompressionConfig()
+	egress := forwarder.DefaultEgressConfig()
+	detector := fault.DefaultDetectorConfig()
+	qos := forwarder.DefaultQoSConfig()
+	hedge := forwarder.DefaultHedgeConfig()
+	rateLimit := forwarder.DefaultRateLimitConfig()
+	tuner := forwarder.DefaultTunerConfig()
+	timeouts := forwarder.DefaultTimeoutConfig()
+	tracingConfig := tracing.DefaultConfig()
+	transportSecurity := connection.DefaultTransportSecurityConfig
+
+	classWait := make(map[string]time.Duration, len(buffer.ClassMaxWaitTime))
+	for class, wait := range buffer.ClassMaxWaitTime {
+		classWait[forwarder.TrafficClassName(class)] = wait
+	}
+	hedgeClasses := make([]string, 0, len(hedge.Classes))
+	for _, class := range hedge.Classes {
+		hedgeClasses = append(hedgeClasses, forwarder.TrafficClassName(class))
+	}
+	return ForwardingConfig{
+		Metrics: MetricsConfig{
+			ListenAddr:     ":9100",
+			ReportInterval: dataPlane.ReportInterval,
+			DataDir:        dataPlane.DataDir,
+		},
+		Admin: AdminConfig{ListenAddr: "127.0.0.1:9101"},
+		Probe: ProbeConfig{
+			Timeout:    probeConfig.Timeout,
+			NodePort:   probeConfig.NodePort,
+			OriginPort: probeConfig.OriginPort,
+		},
+		Access: AccessConfig{
+			HTTPPort:            access.HttpPort,
+			HTTPSPort:           access.HttpsPort,
+			CertDir:             access.CertDir,
+			CertReloadInterval:  access.CertReloadInterval,
+			ResponsePort:        access.ResponsePort,
+			UnknownDomainStatus: access.UnknownDomainStatus,
+		},
+		Relay: RelayConfig{
+			RequestPort:        relay.RequestPort,
+			ResponsePort:       relay.ResponsePort,
+			RelayPort:          relay.RelayPort,
+			SourcePort:         relay.SourcePort,
+			AccessResponsePort: relay.AccessResponsePort,
+			RelayResponsePort:  relay.RelayResponsePort,
+		},
+		Buffer: BufferConfig{
+			BuffersPerPath:       buffer.BuffersPerPath,
+			MaxRequestsPerBuffer: buffer.MaxRequestsPerBuffer,
+			MaxBufferSize:        buffer.MaxBufferSize,
+			MaxWaitTime:          buffer.MaxWaitTime,
+			ClassMaxWaitTime:     classWait,
+			MinIdleTime:          buffer.MinIdleTime,
+			MaxPathIdleTime:      buffer.MaxPathIdleTime,
+			StatisticsInterval:   buffer.StatisticsInterval,
+			CleanupInterval:      buffer.CleanupInterval,
+		},
+		Retry: RetryConfig{
+			MaxAttempts:       retry.MaxAttempts,
+			AttemptTimeout:    retry.AttemptTimeout,
+			TotalBudget:       retry.TotalBudget,
+			MaxReplayBodySize: retry.MaxReplayBodySize,
+			FailedPathPenalty: retry.FailedPathPenalty,
+			IdempotentMethods: retry.IdempotentMethods,
+		},
+		PathHealth: PathHealthConfig{
+			Alpha:              pathHealth.Alpha,
+			FailureThreshold:   pathHealth.FailureThreshold,
+			ErrorRateThreshold: pathHealth.ErrorRateThreshold,
+			MinSamples:         pathHealth.MinSamples,
+			OpenDuration:       pathHealth.OpenDuration,
+			IdleExpiry:         pathHealth.IdleExpiry,
+		},
+		QoS: QoSConfig{
+			ClassHeader:  qos.ClassHeader,
+			DefaultClass: qos.DefaultClass,
+		},
+		Hedging: HedgingConfig{
+			Enabled:         hedge.Enabled,
+			Classes:         hedgeClasses,
+			MaxBodySize:     hedge.MaxBodySize,
+			DelayPercentile: hedge.DelayPercentile,
+			MinDelay:        hedge.MinDelay,
+			BudgetPercent:   hedge.BudgetPercent,
+			BudgetWindow:    hedge.BudgetWindow,
+		},
+		RateLimit: RateLimitConfig{
+			RequestsPerSecond:       rateLimit.RequestsPerSecond,
+			Burst:                   rateLimit.Burst,
+			ClientRequestsPerSecond: rateLimit.ClientRequestsPerSecond,
+			ClientBurst:             rateLimit.ClientBurst,
+			IdleTimeout:             rateLimit.IdleTimeout,
+		},
+		Tuner: TunerConfig{
+			Enabled:              tuner.Enabled,
+			Interval:             tuner.Interval,
+			Alpha:                tuner.Alpha,
+			ThroughputWeight:     tuner.ThroughputWeight,
+			MaxWaitTimes:         tuner.MaxWaitTimes,
+			MaxRequestsPerBuffer: tuner.MaxRequestsPerBuffer,
+			BuffersPerPath:       tuner.BuffersPerPath,
+			SessionsPerPeer:      tuner.SessionsPerPeer,
+		},
+		Timeouts: TimeoutConfig{
+			QueueSubmit:     timeouts.QueueSubmit,
+			ResponseRead:    timeouts.ResponseRead,
+			DirectProxy:     timeouts.DirectProxy,
+			StreamIdle:      timeouts.StreamIdle,
+			StateExpiration: timeouts.StateExpiration,
+			StateCleanup:    timeouts.StateCleanup,
+			UDPFlowIdle:     forwarder.DefaultUDPFlowIdleTimeout,
+		},
+		Compression: CompressionConfig{
+			Enabled: compression.Enabled,
+			MinSize: compression.MinSize,
+			Level:   compression.Level,
+		},
+		Egress: EgressConfig{
+			Slots:          egress.Slots,
+			StandardWeight: egress.StandardWeight,
+			BulkWeight:     egress.BulkWeight,
+		},
+		FaultDetection: FaultDetectionConfig{
+			ProbeFailureThreshold:   detector.ProbeFailureThreshold,
+			SessionFailureThreshold: detector.SessionFailureThreshold,
+			OriginFailureThreshold:  detector.OriginFailureThreshold,
+			ResourceSampleThreshold: detector.ResourceSampleThreshold,
+			CPUUsageThreshold:       detector.CPUUsageThreshold,
+			MemoryUsageThreshold:    detector.MemoryUsageThreshold,
+			DiskUsageThreshold:      detector.DiskUsageThreshold,
+			InitialBackoff:          detector.InitialBackoff,
+			MaxBackoff:              detector.MaxBackoff,
+		},
+		Drain: DrainConfig{
+			Timeout:        30 * time.Second,
+			HandoffTimeout: 10 * time.Second,
+		},
+		Cache: CacheConfig{
+			MemoryMB:    httpcache.DefaultConfig.MemoryBytes >> 20,
+			DiskMB:      httpcache.DefaultConfig.DiskBytes >> 20,
+			MaxObjectMB: httpcache.DefaultConfig.MaxObjectBytes >> 20,
+		},
+		Tracing: TracingConfig{
+			Exporter:     tracingConfig.Exporter,
+			Endpoint:     tracingConfig.Endpoint,
+			File:         tracingConfig.FilePath,
+			ServiceName:  tracingConfig.ServiceName,
+			SampleRatio:  tracingConfig.SampleRatio,
+			BatchTimeout: tracingConfig.BatchTimeout,
+		},
+		TransportSecurity: TransportSecurityConfig{
+			RequireMutualTLS: transportSecurity.RequireMutualTLS,
+			HandshakeTimeout: transportSecurity.HandshakeTimeout,
+		},
+	}
+}
+
+func loadConfig(path string) (*ForwardingConfig, error) {
+	config := defaultConfig()
+	meta, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config file %s: %w", path, err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown keys in config file %s: %v", path, undecoded)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// validMethod accepts methods as they appear in requests, e.g. GET.
+func validMethod(method string) bool {
+	return method != "" && strings.Trim(method, "ABCDEFGHIJKLMNOPQRSTUVWXYZ-_") == ""
+}
+
+func (c *ForwardingConfig) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Metrics.ServerAddr != "", "metrics.server_addr is required")
+	check(c.Metrics.ReportInterval > 0, "metrics.report_interval must be positive")
+	check(c.Metrics.DataDir != "", "metrics.data_dir must not be empty")
+	check(c.Probe.Timeout > 0, "probe.timeout must be positive")
+
+	ports := map[string]string{
+		"probe.node_port":            c.Probe.NodePort,
+		"probe.origin_port":          c.Probe.OriginPort,
+		"access.http_port":           c.Access.HTTPPort,
+		"access.response_port":       c.Access.ResponsePort,
+		"relay.request_port":         c.Relay.RequestPort,
+		"relay.response_port":        c.Relay.ResponsePort,
+		"relay.relay_port":           c.Relay.RelayPort,
+		"relay.source_port":          c.Relay.SourcePort,
+		"relay.access_response_port": c.Relay.AccessResponsePort,
+		"relay.relay_response_port":  c.Relay.RelayResponsePort,
+	}
+	if c.Access.HTTPSPort != "" {
+		ports["access.https_port"] = c.Access.HTTPSPort
+	}
+	for name, port := range ports {
+		check(validPort(port), "%s %q is not a port number", name, port)
+	}
+	check(c.Relay.AccessResponsePort == c.Access.ResponsePort,
+		"relay.access_response_port %q must match access.response_port %q", c.Relay.AccessResponsePort, c.Access.ResponsePort)
+	check(c.Access.UnknownDomainStatus == http.StatusMisdirectedRequest || c.Access.UnknownDomainStatus == http.StatusNotFound,
+		"access.unknown_domain_status must be 421 or 404, got %d", c.Access.UnknownDomainStatus)
+	check(c.Access.CertReloadInterval > 0, "access.cert_reload_interval must be positive")
+
+	if buffer, err := c.bufferConfig(); err != nil {
+		errs = append(errs, fmt.Errorf("buffer: %w", err))
+	} else if err := buffer.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("buffer: %w", err))
+	}
+	if err := c.timeoutConfig().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.Retry.MaxAttempts >= 1, "retry.max_attempts must be at least 1")
+	check(c.Retry.AttemptTimeout > 0 && c.Retry.TotalBudget > 0 && c.Retry.FailedPathPenalty > 0,
+		"retry timeouts must be positive")
+	check(c.Retry.MaxReplayBodySize >= 0, "retry.max_replay_body_size must not be negative")
+	for _, method := range c.Retry.IdempotentMethods {
+		check(validMethod(method), "retry.idempotent_methods: %q is not an HTTP method", method)
+	}
+	if err := c.pathHealthConfig().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("path_health: %w", err))
+	}
+	check(c.Timeouts.UDPFlowIdle > 0, "timeouts.udp_flow_idle must be positive")
+	if err := c.compressionConfig().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("compression: %w", err))
+	}
+	if err := c.egressConfig().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("egress: %w", err))
+	}
+	if err := c.detectorConfig().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("fault_detection: %w", err))
+	}
+	if err := c.qosConfig().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("qos: %w", err))
+	}
+	if hedge, err := c.hedgeConfig(); err != nil {
+		errs = append(errs, fmt.Errorf("hedging: %w", err))
+	} else if err := hedge.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("hedging: %w", err))
+	}
+	if err := c.rateLimitConfig().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit: %w", err))
+	}
+	if err := c.tunerConfig().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("tuner: %w", err))
+	}
+	check(c.Drain.Timeout > 0 && c.Drain.HandoffTimeout > 0, "drain timeouts must be positive")
+
+	for i, tunnel := range append(append([]TunnelEntry{}, c.Tunnels...), c.UDP...) {
+		check(validPort(tunnel.ListenPort), "tunnel %d: listen_port %q is not a port number", i+1, tunnel.ListenPort)
+		check(tunnel.Domain != "", "tunnel %d: domain is required", i+1)
+		check(tunnel.OriginPort > 0 && tunnel.OriginPort <= 65535, "tunnel %d: origin_port %d is not a port number", i+1, tunnel.OriginPort)
+	}
+	if c.Cache.Enabled {
+		check(c.Cache.MemoryMB > 0 && c.Cache.MaxObjectMB > 0, "cache.memory_mb and cache.max_object_mb must be positive")
+		check(c.Cache.DiskDir == "" || c.Cache.DiskMB > 0, "cache.disk_mb must be positive when disk_dir is set")
+	}
+	if err := c.tracingConfig().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("tracing: %w", err))
+	}
+	if err := c.transportSecurityConfig().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("transport_security: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *ForwardingConfig) pathHealthConfig() forwarder.PathHealthConfig {
+	return forwarder.PathHealthConfig{
+		Alpha:              c.PathHealth.Alpha,
+		FailureThreshold:   c.PathHealth.FailureThreshold,
+		ErrorRateThreshold: c.PathHealth.ErrorRateThreshold,
+		MinSamples:         c.PathHealth.MinSamples,
+		OpenDuration:       c.PathHealth.OpenDuration,
+		IdleExpiry:         c.PathHealth.IdleExpiry,
+	}
+}
+
+func (c *ForwardingConfig) compressionConfig() forwarder.CompressionConfig {
+	config := forwarder.DefaultCompressionConfig()
+	config.Enabled = c.Compression.Enabled
+	config.MinSize = c.Compression.MinSize
+	config.Level = c.Compression.Level
+	return config
+}
+
+func (c *ForwardingConfig) egressConfig() forwarder.EgressConfig {
+	return forwarder.EgressConfig{
+		Slots:          c.Egress.Slots,
+		StandardWeight: c.Egress.StandardWeight,
+		BulkWeight:     c.Egress.BulkWeight,
+	}
+}
+
+func (c *ForwardingConfig) detectorConfig() fault.DetectorConfig {
+	config := fault.DefaultDetectorConfig()
+	config.ProbeFailureThreshold = c.FaultDetection.ProbeFailureThreshold
+	config.SessionFailureThreshold = c.FaultDetection.SessionFailureThreshold
+	config.OriginFailureThreshold = c.FaultDetection.OriginFailureThreshold
+	config.ResourceSampleThreshold = c.FaultDetection.ResourceSampleThreshold
+	config.CPUUsageThreshold = c.FaultDetection.CPUUsageThreshold
+	config.MemoryUsageThreshold = c.FaultDetection.MemoryUsageThreshold
+	config.DiskUsageThreshold = c.FaultDetection.DiskUsageThreshold
+	config.InitialBackoff = c.FaultDetection.InitialBackoff
+	config.MaxBackoff = c.FaultDetection.MaxBackoff
+	return config
+}
+
+func (c *ForwardingConfig) qosConfig() forwarder.QoSConfig {
+	config := forwarder.QoSConfig{
+		ClassHeader:  c.QoS.ClassHeader,
+		DefaultClass: c.QoS.DefaultClass,
+	}
+	for _, rule := range c.QoS.Rules {
+		config.Rules = append(config.Rules, forwarder.QoSRule{
+			Domain:     rule.Domain,
+			PathPrefix: rule.PathPrefix,
+			Class:      rule.Class,
+		})
+	}
+	return config
+}
+
+func (c *ForwardingConfig) hedgeConfig() (forwarder.HedgeConfig, error) {
+	classes := make([]byte, 0, len(c.Hedging.Classes))
+	for _, name := range c.Hedging.Classes {
+		class, err := forwarder.ParseTrafficClass(name)
+		if err != nil {
+			return forwarder.HedgeConfig{}, fmt.Errorf("classes: %w", err)
+		}
+		classes = append(classes, class)
+	}
+	return forwarder.HedgeConfig{
+		Enabled:         c.Hedging.Enabled,
+		Classes:         classes,
+		MaxBodySize:     c.Hedging.MaxBodySize,
+		DelayPercentile: c.Hedging.DelayPercentile,
+		MinDelay:        c.Hedging.MinDelay,
+		BudgetPercent:   c.Hedging.BudgetPercent,
+		BudgetWindow:    c.Hedging.BudgetWindow,
+	}, nil
+}
+
+func (c *ForwardingConfig) rateLimitConfig() forwarder.RateLimitConfig {
+	return forwarder.RateLimitConfig{
+		RequestsPerSecond:       c.RateLimit.RequestsPerSecond,
+		Burst:                   c.RateLimit.Burst,
+		ClientRequestsPerSecond: c.RateLimit.ClientRequestsPerSecond,
+		ClientBurst:             c.RateLimit.ClientBurst,
+		IdleTimeout:             c.RateLimit.IdleTimeout,
+	}
+}
+
+func (c *ForwardingConfig) tunerConfig() forwarder.TunerConfig {
+	modelFile := c.Tuner.ModelFile
+	if modelFile == "" {
+		modelFile = filepath.Join(c.Metrics.DataDir, "tuner_model.json")
+	}
+	return forwarder.TunerConfig{
+		Enabled:              c.Tuner.Enabled,
+		Interval:             c.Tuner.Interval,
+		Alpha:                c.Tuner.Alpha,
+		ThroughputWeight:     c.Tuner.ThroughputWeight,
+		ModelFile:            modelFile,
+		MaxWaitTimes:         c.Tuner.MaxWaitTimes,
+		MaxRequestsPerBuffer: c.Tuner.MaxRequestsPerBuffer,
+		BuffersPerPath:       c.Tuner.BuffersPerPath,
+		SessionsPerPeer:      c.Tuner.SessionsPerPeer,
+	}
+}
+
+func (c *ForwardingConfig) bufferConfig() (forwarder.BufferConfig, error) {
+	classWait := make(map[byte]time.Duration, len(c.Buffer.ClassMaxWaitTime))
+	for name, wait := range c.Buffer.ClassMaxWaitTime {
+		class, err := forwarder.ParseTrafficClass(name)
+		if err != nil {
+			return forwarder.BufferConfig{}, fmt.Errorf("class_max_wait_time: %w", err)
+		}
+		classWait[class] = wait
+	}
+	return forwarder.BufferConfig{
+		BuffersPerPath:       c.Buffer.BuffersPerPath,
+		MinIdleTime:          c.Buffer.MinIdleTime,
+		MaxPathIdleTime:      c.Buffer.MaxPathIdleTime,
+		MaxRequestsPerBuffer: c.Buffer.MaxRequestsPerBuffer,
+		MaxBufferSize:        c.Buffer.MaxBufferSize,
+		MaxWaitTime:          c.Buffer.MaxWaitTime,
+		ClassMaxWaitTime:     classWait,
+		StatisticsInterval:   c.Buffer.StatisticsInterval,
+		CleanupInterval:      c.Buffer.CleanupInterval,
+	}, nil
+}
+
+func (c *ForwardingConfig) timeoutConfig() forwarder.TimeoutConfig {
+	return forwarder.TimeoutConfig{
+		QueueSubmit:     c.Timeouts.QueueSubmit,
+		ResponseRead:    c.Timeouts.ResponseRead,
+		DirectProxy:     c.Timeouts.DirectProxy,
+		StreamIdle:      c.Timeouts.StreamIdle,
+		StateExpiration: c.Timeouts.StateExpiration,
+		StateCleanup:    c.Timeouts.StateCleanup,
+	}
+}
+
+func (c *ForwardingConfig) tracingConfig() tracing.Config {
+	config := tracing.DefaultConfig()
+	config.Enabled = c.Tracing.Enabled
+	config.Exporter = c.Tracing.Exporter
+	config.Endpoint = c.Tracing.Endpoint
+	config.FilePath = c.Tracing.File
+	config.ServiceName = c.Tracing.ServiceName
+	config.SampleRatio = c.Tracing.SampleRatio
+	config.BatchTimeout = c.Tracing.BatchTimeout
+	return config
+}
+
+func (c *ForwardingConfig) transportSecurityConfig() connection.TransportSecurityConfig {
+	return connection.TransportSecurityConfig{
+		RequireMutualTLS: c.TransportSecurity.RequireMutualTLS,
+		HandshakeTimeout: c.TransportSecurity.HandshakeTimeout,
+	}
+}
+
+func (c *ForwardingConfig) probeConfig() probe.Config {
+	return probe.Config{Timeout: c.Probe.Timeout, NodePort: c.Probe.NodePort, OriginPort: c.Probe.OriginPort}
+}
+
+func (c *ForwardingConfig) dataPlaneConfig() metrics_processing.Config {
+	return metrics_processing.Config{
+		ServerAddr:     c.Metrics.ServerAddr,
+		DataDir:        c.Metrics.DataDir,
+		ReportInterval: c.Metrics.ReportInterval,
+	}
+}
+
+// accessConfig must only be called on a validated config.
+func (c *ForwardingConfig) accessConfig() forwarder.AccessConfig {
+	config := forwarder.DefaultAccessConfig
+	config.HttpPort = c.Access.HTTPPort
+	config.HttpsPort = c.Access.HTTPSPort
+	config.CertDir = c.Access.CertDir
+	config.CertReloadInterval = c.Access.CertReloadInterval
+	config.ResponsePort = c.Access.ResponsePort
+	config.RelayPort = c.Relay.RelayPort // the first relay listens where every relay does
+	config.UnknownDomainStatus = c.Access.UnknownDomainStatus
+	config.Buffer, _ = c.bufferConfig()
+
+	config.Retry.MaxAttempts = c.Retry.MaxAttempts
+	config.Retry.AttemptTimeout = c.Retry.AttemptTimeout
+	config.Retry.TotalBudget = c.Retry.TotalBudget
+	config.Retry.MaxReplayBodySize = c.Retry.MaxReplayBodySize
+	config.Retry.FailedPathPenalty = c.Retry.FailedPathPenalty
+	config.Retry.IdempotentMethods = c.Retry.IdempotentMethods
+	config.PathHealth = c.pathHealthConfig()
+	config.QoS = c.qosConfig()
+	config.Hedge, _ = c.hedgeConfig()
+	config.RateLimit = c.rateLimitConfig()
+	config.UDPFlowIdleTimeout = c.Timeouts.UDPFlowIdle
+	config.Compression = c.compressionConfig()
+
+	for _, tunnel := range c.Tunnels {
+		config.Tunnels = append(config.Tunnels, forwarder.TunnelConfig{
+			ListenPort: tunnel.ListenPort,
+			Domain:     tunnel.Domain,
+			OriginPort: tunnel.OriginPort,
+		})
+	}
+	for _, forward := range c.UDP {
+		config.UDP = append(config.UDP, forwarder.UDPForwardConfig{
+			ListenPort: forward.ListenPort,
+			Domain:     forward.Domain,
+			OriginPort: forward.OriginPort,
+		})
+	}
+
+	config.Cache.Enabled = c.Cache.Enabled
+	config.Cache.Domains = c.Cache.Domains
+	config.Cache.DisabledDomains = c.Cache.DisabledDomains
+	config.Cache.DiskDir = c.Cache.DiskDir
+	config.Cache.MemoryBytes = c.Cache.MemoryMB << 20
+	config.Cache.DiskBytes = c.Cache.DiskMB << 20
+	config.Cache.MaxObjectBytes = c.Cache.MaxObjectMB << 20
+	return config
+}
+
+// relayConfig must only be called on a validated config.
+func (c *ForwardingConfig) relayConfig() forwarder.RelayConfig {
+	config := forwarder.DefaultRelayConfig
+	config.RequestPort = c.Relay.RequestPort
+	config.ResponsePort = c.Relay.ResponsePort
+	config.RelayPort = c.Relay.RelayPort
+	config.SourcePort = c.Relay.SourcePort
+	config.AccessResponsePort = c.Relay.AccessResponsePort
+	config.RelayResponsePort = c.Relay.RelayResponsePort
+	config.Egress = c.egressConfig()
+	config.UDPFlowIdleTimeout = c.Timeouts.UDPFlowIdle
+	config.Compression = c.compressionConfig()
+	config.Buffer, _ = c.bufferConfig()
+	return config
+}
+
+// applyReload puts the reloadable settings of next into effect and reports
+// the sections whose changes need a restart. Reloadable are [buffer],
+// [timeouts] except udp_flow_idle, [probe] and metrics.report_interval; the
+// proxies, path health, compression, egress and fault detection read their
+// settings only when the node starts.
+func applyReload(current, next *ForwardingConfig) error {
+	buffer, _ := next.bufferConfig()
+	// The tuner only starts with the node, so the running config says whether it owns the merge settings
+	tuned := current.Tuner.Enabled
+	if tuned {
+		log.Printf("[Config-INFO] Tuner is enabled, keeping its max_wait_time, max_requests_per_buffer and buffers_per_path.")
+	}
+	if err := forwarder.ApplyBufferConfig(buffer, tuned); err != nil {
+		return fmt.Errorf("failed to apply buffer config: %w", err)
+	}
+	forwarder.SetTimeouts(next.timeoutConfig())
+	probe.SetConfig(next.probeConfig())
+	if next.Metrics.ReportInterval != current.Metrics.ReportInterval {
+		metrics_processing.SetReportInterval(next.Metrics.ReportInterval)
+	}
+
+	// Everything else is read once at startup
+	if next.Timeouts.UDPFlowIdle != current.Timeouts.UDPFlowIdle {
+		log.Printf("[Config-WARN] Changes to timeouts.udp_flow_idle take effect after a restart.")
+	}
+	restart := []struct {
+		name          string
+		current, next interface{}
+	}{
+		{"metrics", MetricsConfig{current.Metrics.ServerAddr, current.Metrics.ListenAddr, 0, current.Metrics.DataDir},
+			MetricsConfig{next.Metrics.ServerAddr, next.Metrics.ListenAddr, 0, next.Metrics.DataDir}},
+		{"admin", current.Admin, next.Admin},
+		{"access", current.Access, next.Access},
+		{"relay", current.Relay, next.Relay},
+		{"retry", current.Retry, next.Retry},
+		{"path_health", current.PathHealth, next.PathHealth},
+		{"qos", current.QoS, next.QoS},
+		{"hedging", current.Hedging, next.Hedging},
+		{"rate_limit", current.RateLimit, next.RateLimit},
+		{"tuner", current.Tuner, next.Tuner},
+		{"compression", current.Compression, next.Compression},
+		{"egress", current.Egress, next.Egress},
+		{"fault_detection", current.FaultDetection, next.FaultDetection},
+		{"drain", current.Drain, next.Drain},
+		{"tunnel", current.Tunnels, next.Tunnels},
+		{"udp", current.UDP, next.UDP},
+		{"cache", current.Cache, next.Cache},
+		{"tracing", current.Tracing, next.Tracing},
+		{"transport_security", current.TransportSecurity, next.TransportSecurity},
+	}
+	for _, section := range restart {
+		if !reflect.DeepEqual(section.current, section.next) {
+			log.Printf("[Config-WARN] Changes to [%s] take effect after a restart.", section.name)
+		}
+	}
+	return nil
+}
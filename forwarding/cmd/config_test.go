@@ -0,0 +1,263 @@
+package main
+
+import (
+	"forwarding/forwarder"
+	"forwarding/forwarder/connection"
+	"forwarding/metrics_processing/fault"
+	"forwarding/metrics_processing/probe"
+	packet "forwarding/packet_handler"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "forwarding_config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, `
+[metrics]
+server_addr = "127.0.0.1:8080"
+report_interval = "10s"
+
+[buffer]
+max_wait_time = "8ms"
+class_max_wait_time = { bulk = "40ms" }
+
+[timeouts]
+stream_idle = "2m"
+udp_flow_idle = "30s"
+
+[tuner]
+enabled = true
+max_wait_times = ["1ms", "3ms"]
+
+[retry]
+idempotent_methods = ["GET", "HEAD"]
+
+[path_health]
+failure_threshold = 2
+
+[compression]
+enabled = false
+
+[egress]
+slots = 8
+
+[fault_detection]
+origin_failure_threshold = 10
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Metrics.ReportInterval != 10*time.Second || cfg.Probe.NodePort != probe.DefaultConfig().NodePort {
+		t.Fatalf("metrics/probe %+v %+v", cfg.Metrics, cfg.Probe)
+	}
+	access := cfg.accessConfig()
+	if access.HttpPort != forwarder.DefaultAccessConfig.HttpPort || access.Retry.MaxAttempts != forwarder.DefaultRetryConfig().MaxAttempts {
+		t.Fatalf("access defaults not kept: %+v", access)
+	}
+	buffer := access.Buffer
+	if buffer.MaxWaitTime != 8*time.Millisecond || buffer.BuffersPerPath != forwarder.DefaultBufferConfig().BuffersPerPath {
+		t.Fatalf("buffer %+v", buffer)
+	}
+	bulk, _ := forwarder.ParseTrafficClass("bulk")
+	if buffer.ClassMaxWaitTime[bulk] != 40*time.Millisecond {
+		t.Fatalf("class wait %v", buffer.ClassMaxWaitTime)
+	}
+	if tuner := cfg.tunerConfig(); !tuner.Enabled || len(tuner.MaxWaitTimes) != 2 || tuner.MaxWaitTimes[1] != 3*time.Millisecond ||
+		tuner.ModelFile != filepath.Join(cfg.Metrics.DataDir, "tuner_model.json") {
+		t.Fatalf("tuner %+v", tuner)
+	}
+	if methods := access.Retry.IdempotentMethods; len(methods) != 2 || methods[1] != "HEAD" {
+		t.Fatalf("idempotent methods %v", methods)
+	}
+	if health := access.PathHealth; health.FailureThreshold != 2 || health.Alpha != forwarder.DefaultPathHealthConfig().Alpha {
+		t.Fatalf("path health %+v", health)
+	}
+	relay := cfg.relayConfig()
+	if access.Compression.Enabled || relay.Compression.Enabled || relay.Compression.MinSize != forwarder.DefaultCompressionConfig().MinSize {
+		t.Fatalf("compression %+v %+v", access.Compression, relay.Compression)
+	}
+	if relay.Egress.Slots != 8 || relay.Egress.StandardWeight != forwarder.DefaultEgressConfig().StandardWeight {
+		t.Fatalf("egress %+v", relay.Egress)
+	}
+	if access.UDPFlowIdleTimeout != 30*time.Second || relay.UDPFlowIdleTimeout != 30*time.Second {
+		t.Fatalf("udp flow idle %v %v", access.UDPFlowIdleTimeout, relay.UDPFlowIdleTimeout)
+	}
+	if detector := cfg.detectorConfig(); detector.OriginFailureThreshold != 10 || detector.ProbeFailureThreshold != fault.DefaultDetectorConfig().ProbeFailureThreshold {
+		t.Fatalf("fault detection %+v", detector)
+	}
+	if security := cfg.transportSecurityConfig(); security.RequireMutualTLS || security.HandshakeTimeout != connection.DefaultTransportSecurityConfig.HandshakeTimeout {
+		t.Fatalf("transport security should default to optional mutual TLS: %+v", security)
+	}
+	if timeouts := cfg.timeoutConfig(); timeouts.StreamIdle != 2*time.Minute || timeouts.QueueSubmit != forwarder.DefaultTimeoutConfig().QueueSubmit {
+		t.Fatalf("timeouts %+v", timeouts)
+	}
+}
+
+func TestLoadConfigRejectsInvalid(t *testing.T) {
+	_, err := loadConfig(writeConfig(t, `
+[metrics]
+report_interval = "0s"
+
+[access]
+http_port = "http"
+unknown_domain_status = 500
+
+[buffer]
+max_requests_per_buffer = 0
+class_max_wait_time = { realtime = "1ms" }
+
+[[qos.rules]]
+path_prefix = "/api/"
+class = "premium"
+
+[retry]
+idempotent_methods = ["get"]
+
+[path_health]
+alpha = 0.0
+
+[compression]
+level = 12
+
+[egress]
+bulk_weight = 0
+
+[fault_detection]
+cpu_usage_threshold = 120.0
+
+[hedging]
+classes = ["urgent"]
+
+[rate_limit]
+client_burst = -1
+
+[tuner]
+sessions_per_peer = [0]
+
+[timeouts]
+queue_submit = "-1s"
+udp_flow_idle = "0s"
+
+[[tunnel]]
+listen_port = "3306"
+origin_port = 3306
+
+[tracing]
+enabled = true
+exporter = "zipkin"
+
+[transport_security]
+handshake_timeout = "0s"
+`))
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	for _, want := range []string{"server_addr", "report_interval", "access.http_port", "unknown_domain_status", "realtime", "rule 1", "premium", "urgent", "idempotent_methods", "path_health: alpha", "compression: level", "egress: standard_weight and bulk_weight", "cpu_usage_threshold", "udp_flow_idle", "bursts must not be negative", "sessions_per_peer", "queue_submit", "tunnel 1: domain", "zipkin", "handshake_timeout"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s: %v", want, err)
+		}
+	}
+
+	if _, err := loadConfig(writeConfig(t, "[metrics]\nserver_addr = \"127.0.0.1:8080\"\nreport_intervall = \"1s\"\n")); err == nil || !strings.Contains(err.Error(), "report_intervall") {
+		t.Fatalf("unknown key: %v", err)
+	}
+}
+
+func TestLoadConfigQoS(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, `
+[metrics]
+server_addr = "127.0.0.1:8080"
+
+[qos]
+class_header = "X-Traffic-Class"
+default_class = "bulk"
+
+[[qos.rules]]
+domain = "API.example.com"
+path_prefix = "/v1/"
+class = "interactive"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	qos := cfg.accessConfig().QoS
+
+	if class := qos.Classify(httptest.NewRequest("GET", "http://api.example.com/v1/orders", nil), "api.example.com"); class != packet.PriorityInteractive {
+		t.Errorf("rule did not classify the request as interactive: %s", forwarder.TrafficClassName(class))
+	}
+	if class := qos.Classify(httptest.NewRequest("GET", "http://api.example.com/static/app.js", nil), "api.example.com"); class != packet.PriorityBulk {
+		t.Errorf("unmatched request got %s, want default_class bulk", forwarder.TrafficClassName(class))
+	}
+	req := httptest.NewRequest("GET", "http://www.example.com/", nil)
+	req.Header.Set("X-Traffic-Class", "standard")
+	if class := qos.Classify(req, "www.example.com"); class != packet.PriorityStandard || req.Header.Get("X-Traffic-Class") != "" {
+		t.Errorf("class header not honoured and removed: %s %v", forwarder.TrafficClassName(class), req.Header)
+	}
+}
+
+func TestLoadConfigHedgedClasses(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, `
+[metrics]
+server_addr = "127.0.0.1:8080"
+
+[[qos.rules]]
+path_prefix = "/downloads/"
+class = "bulk"
+
+[hedging]
+enabled = true
+classes = ["bulk"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	access := cfg.accessConfig()
+
+	download := access.QoS.Classify(httptest.NewRequest("GET", "http://www.example.com/downloads/app.tar", nil), "www.example.com")
+	if !access.Hedge.Qualifies(download, true, 0) {
+		t.Errorf("request classified %s is not hedged", forwarder.TrafficClassName(download))
+	}
+	page := access.QoS.Classify(httptest.NewRequest("GET", "http://www.example.com/index.html", nil), "www.example.com")
+	if access.Hedge.Qualifies(page, true, 0) {
+		t.Errorf("request classified %s is hedged", forwarder.TrafficClassName(page))
+	}
+
+	if _, err := loadConfig(writeConfig(t, "[metrics]\nserver_addr = \"127.0.0.1:8080\"\n\n[hedging]\nenabled = true\nclasses = []\n")); err == nil || !strings.Contains(err.Error(), "hedging: classes") {
+		t.Fatalf("enabled hedging without classes: %v", err)
+	}
+}
+
+func TestLoadConfigRequireMutualTLS(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, `
+[metrics]
+server_addr = "127.0.0.1:8080"
+
+[transport_security]
+require_mutual_tls = true
+handshake_timeout = "3s"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if security := cfg.transportSecurityConfig(); !security.RequireMutualTLS || security.HandshakeTimeout != 3*time.Second {
+		t.Fatalf("transport security %+v", security)
+	}
+}
+
+func TestExampleConfigLoads(t *testing.T) {
+	if _, err := loadConfig("forwarding_config.toml"); err != nil {
+		t.Fatal(err)
+	}
+}
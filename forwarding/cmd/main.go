@@ -3,93 +3,55 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
 	"forwarding/forwarder"
+	"forwarding/forwarder/connection"
 	"forwarding/metrics_processing"
+	"forwarding/metrics_processing/exporter"
+	"forwarding/metrics_processing/fault"
+	"forwarding/metrics_processing/probe"
 	"forwarding/router"
+	"forwarding/tracing"
 	"log"
-	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
-
-	"github.com/BurntSushi/toml"
 )
 
-// Config struct to hold configuration from toml file
-type ForwardingConfig struct {
-	Metrics MetricsConfig `toml:"metrics"`
-}
-
-type MetricsConfig struct {
-	ServerAddr string `toml:"server_addr"`
-}
-
-func loadConfig(path string) (*ForwardingConfig, error) {
-	var config ForwardingConfig
-	if _, err := toml.DecodeFile(path, &config); err != nil {
-		return nil, fmt.Errorf("failed to load config file %s: %w", path, err)
-	}
-	// Provide a default value if not specified in the config, or handle error
-	if config.Metrics.ServerAddr == "" {
-		log.Println("Metrics ServerAddr not specified in config, using default or handling error as needed.")
-		// For now, let's set a default if empty, or you can make it a fatal error.
-		// config.Metrics.ServerAddr = "127.0.0.1:8080" // Example default
-	}
-	return &config, nil
-}
-
 func main() {
 	configFile := flag.String("config", "forwarding_config.toml", "Path to the configuration file")
-	port := flag.Int("port", 50051, "TCP listener port for the forwarding service")
 	flag.Parse()
 
 	cfg, err := loadConfig(*configFile)
 	if err != nil {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
-	if cfg.Metrics.ServerAddr == "" {
-		log.Fatalf("Metrics server_addr is not configured in %s", *configFile)
-	}
-
-	addr := fmt.Sprintf(":%d", *port)
-
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		log.Fatalf("TCP: %v", err)
+	forwarder.SetTimeouts(cfg.timeoutConfig())
+	probe.SetConfig(cfg.probeConfig())
+	connection.SetTransportSecurityConfig(cfg.transportSecurityConfig())
+	fault.SetDetectorConfig(cfg.detectorConfig())
+	forwarder.SetDrainNotifier(metrics_processing.SetDraining)
+	if err := tracing.Setup(cfg.tracingConfig()); err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
 	}
-	defer listener.Close()
-
-	log.Printf("TCP， %d", *port)
-	log.Printf("Ctrl+C")
 
+	// SIGINT and SIGTERM drain the node and exit, SIGHUP reloads the
+	// configuration and SIGUSR2 hands the sockets to a new process of the
+	// binary for an upgrade
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-
-				if ne, ok := err.(net.Error); ok && ne.Temporary() {
-					log.Printf(": %v", err)
-					continue
-				}
-				return
-			}
-
-			remoteAddr := conn.RemoteAddr().String()
-			log.Printf(" %s ", remoteAddr)
-
-			conn.Close()
-		}
-	}()
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	upgradeChan := make(chan os.Signal, 1)
+	signal.Notify(upgradeChan, syscall.SIGUSR2)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go metrics_processing.StartDataPlane(ctx, cfg.Metrics.ServerAddr)
+	go metrics_processing.StartDataPlane(ctx, cfg.dataPlaneConfig())
+	go serveMetrics(cfg.Metrics.ListenAddr)
+	go serveAdmin(cfg.Admin.ListenAddr)
 
 	go func() {
 		time.Sleep(1 * time.Minute)
@@ -98,27 +60,129 @@ func main() {
 
 		for range ticker.C {
 			pathManager := router.GetInstance()
-			paths := pathManager.GetPaths()
+			allPaths := pathManager.GetAllPaths()
 
-			if len(paths) > 0 {
-				log.Printf(":  %d ", len(paths))
+			if len(allPaths) == 0 {
+				log.Printf(": ")
+			}
+			for domain, paths := range allPaths {
+				log.Printf("%s:  %d ", domain, len(paths))
 				for i, p := range paths {
 					log.Printf(" %d: %v (: %d)", i+1, p.IPList, p.Latency)
 				}
-			} else {
-				log.Printf(": ")
 			}
 		}
 	}()
-	go forwarder.AccessProxyfunc()
-	go forwarder.RelayProxyfunc()
+	accessProxy := forwarder.CreateAccessProxy(cfg.accessConfig(), forwarder.DefaultRepositoryConfig)
+	relayProxy := forwarder.CreateRelayProxy(cfg.relayConfig(), forwarder.DefaultRelayRepositoryConfig)
+	go accessProxy.Start()
+	relayProxy.Start()
+	go forwarder.HandoffReady(cfg.Drain.HandoffTimeout)
+	if tunerConfig := cfg.tunerConfig(); tunerConfig.Enabled {
+		go forwarder.NewTuner(tunerConfig).Run(ctx)
+	}
 
-	<-signalChan
-	log.Println("，...")
+	go func() {
+		current := cfg
+		for range reloadChan {
+			next, err := loadConfig(*configFile)
+			if err != nil {
+				log.Printf("[Config-ERROR] Reload failed, keeping the running configuration: %v", err)
+				continue
+			}
+			if err := applyReload(current, next); err != nil {
+				log.Printf("[Config-ERROR] Reload failed: %v", err)
+				continue
+			}
+			current = next
+			log.Printf("[Config-INFO] Reloaded %s", *configFile)
+		}
+	}()
+
+	handedOff := false
+	for waiting := true; waiting; {
+		select {
+		case <-upgradeChan:
+			process, err := forwarder.Handoff(cfg.Drain.HandoffTimeout)
+			if err != nil {
+				log.Printf("[Handoff-ERROR] %v. Still serving.", err)
+				continue
+			}
+			log.Printf("[Handoff-INFO] Process %d took over the sockets, draining this one.", process.Pid)
+			handedOff = true
+			waiting = false
+		case sig := <-signalChan:
+			log.Printf("Received %s, draining...", sig)
+			waiting = false
+		}
+	}
+
+	shutdown(cfg.Drain.Timeout, accessProxy, relayProxy, handedOff)
 	cancel()                    //
 	time.Sleep(1 * time.Second) //  goroutine
 }
 
+// shutdown stops the proxies within timeout. Unless another process took
+// over, the node first drains: it tells the controller, refuses new requests
+// and waits for the ones in flight.
+func shutdown(timeout time.Duration, accessProxy *forwarder.AccessProxy, relayProxy *forwarder.RelayProxy, handedOff bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if !handedOff {
+		forwarder.SetDraining(true)
+		if err := forwarder.WaitForInFlight(ctx); err != nil {
+			log.Printf("Drain deadline reached: %v", err)
+		}
+	}
+	if err := accessProxy.Shutdown(ctx); err != nil {
+		log.Printf("Access proxy shut down with requests in flight: %v", err)
+	}
+	if err := relayProxy.Shutdown(ctx); err != nil {
+		log.Printf("Relay proxy shut down with requests in flight: %v", err)
+	}
+
+	// The drain may have used up ctx; the last spans get a few seconds of their own
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := tracing.Shutdown(flushCtx); err != nil {
+		log.Printf("Tracing shut down: %v", err)
+	}
+	log.Println("Shutdown complete.")
+}
+
+func serveMetrics(addr string) {
+	if addr == "" {
+		addr = ":9100"
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter.Default)
+	listener, err := forwarder.Listen(addr)
+	if err != nil {
+		log.Printf("Metrics endpoint failed to listen on %s: %v", addr, err)
+		return
+	}
+	log.Printf("Serving metrics on %s/metrics", addr)
+	if err := http.Serve(listener, mux); err != nil {
+		log.Printf("Metrics endpoint on %s stopped: %v", addr, err)
+	}
+}
+
+func serveAdmin(addr string) {
+	if addr == "" {
+		addr = "127.0.0.1:9101"
+	}
+	listener, err := forwarder.Listen(addr)
+	if err != nil {
+		log.Printf("Admin API failed to listen on %s: %v", addr, err)
+		return
+	}
+	log.Printf("Serving admin API on %s", addr)
+	if err := http.Serve(listener, forwarder.AdminHandler()); err != nil {
+		log.Printf("Admin API on %s stopped: %v", addr, err)
+	}
+}
+
 func dataPlane() {
 	forwarder.AccessProxyfunc()
 	go forwarder.RelayProxyfunc()
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"forwarding/scheduling_algorithms/k_shortest"
 	"log"
+	"net/netip"
 	"sync"
 )
 
@@ -86,6 +87,13 @@ func (tm *TopologyManager) SetTopology(topology *TopologyGraph) {
 	log.Printf("， %d ，%d ", len(allNodes), topology.LinkCount())
 }
 
+// GetTopology returns the current topology graph, nil before the first SetTopology.
+func (tm *TopologyManager) GetTopology() *TopologyGraph {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+	return tm.Topology
+}
+
 func (tm *TopologyManager) IsInitialized() bool {
 	tm.mutex.RLock()
 	defer tm.mutex.RUnlock()
@@ -129,7 +137,29 @@ func GetTopologyForPath() (k_shortest.Network, map[string]int, map[int]string, e
 	return tm.GetNetworkForKSP()
 }
 
+// CanonicalIP returns the canonical text form of ip, so that differently
+// written IPv6 addresses name the same node. Anything else is returned as is.
+func CanonicalIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	return addr.Unmap().String()
+}
+
+// SameFamily reports whether both addresses are IPv4 or both are IPv6.
+func SameFamily(ip1, ip2 string) bool {
+	a1, err1 := netip.ParseAddr(ip1)
+	a2, err2 := netip.ParseAddr(ip2)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return a1.Unmap().Is4() == a2.Unmap().Is4()
+}
+
 func (t *TopologyGraph) AddLink(sourceIP, targetIP string, weight float32) {
+	sourceIP, targetIP = CanonicalIP(sourceIP), CanonicalIP(targetIP)
+
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
@@ -141,6 +171,8 @@ func (t *TopologyGraph) AddLink(sourceIP, targetIP string, weight float32) {
 }
 
 func (t *TopologyGraph) GetLinkWeight(sourceIP, targetIP string) (float32, bool) {
+	sourceIP, targetIP = CanonicalIP(sourceIP), CanonicalIP(targetIP)
+
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 
@@ -154,6 +186,8 @@ func (t *TopologyGraph) GetLinkWeight(sourceIP, targetIP string) (float32, bool)
 }
 
 func (t *TopologyGraph) GetOutgoingLinks(sourceIP string) map[string]float32 {
+	sourceIP = CanonicalIP(sourceIP)
+
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 
@@ -3,16 +3,22 @@ package forwarder
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"forwarding/forwarder/connection"
+	"forwarding/forwarder/httpcache"
 	packet "forwarding/packet_handler"
 	"forwarding/router"
+	"forwarding/scheduling_algorithms/k_shortest"
+	"forwarding/tracing"
 	"io"
 	"log"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"runtime"
 	"strings"
 	"sync"
@@ -67,6 +73,30 @@ type Repository struct {
 	bufferManager *BufferManager
 
 	stateManager *RequestStateManager
+
+	pathHealth *PathHealthTracker
+
+	hedger *hedger
+
+	rateLimiter *rateLimiter
+
+	certStore *CertStore
+
+	cache *httpcache.Cache // nil if it could not be opened
+
+	compressor *payloadCompressor
+
+	tunnelMu        sync.Mutex
+	tunnelListeners []net.Listener
+
+	udpMu        sync.Mutex
+	udpListeners []*net.UDPConn
+	udpFlows     map[string]*udpFlow // listen port and client address -> flow
+	udpFlowsByID map[uint32]*udpFlow
+
+	serverMu         sync.Mutex
+	servers          []*http.Server // HTTP and HTTPS proxies, closed by Shutdown
+	responseListener net.Listener
 }
 
 type RequestItem struct {
@@ -75,25 +105,60 @@ type RequestItem struct {
 	HeaderBytes      []byte
 	RequestID        uint32
 	ReceivedAt       time.Time
+	QueuedAt         time.Time
 	ResponseReceived chan struct{}
+	AttemptFailed    chan error // receives the error instead of the client when the attempt fails
 	IsLastHop        bool
 	NextHopIP        string
-	HopList          []uint32
+	HopList          []netip.Addr
+	Priority         byte
+	Trace            tracing.TraceContext // the attempt's span
 }
 
 type ResponseItem struct {
 	Data       []byte
 	ReceivedAt time.Time
+	RemoteAddr string // Network address of the sender
 }
 
 type AccessConfig struct {
-	HttpPort     string
-	ResponsePort string
+	HttpPort            string
+	HttpsPort           string // TLS-terminating listener, empty to disable
+	CertDir             string // <name>.crt / <name>.key pairs selected by SNI, see CertStore
+	CertReloadInterval  time.Duration
+	ResponsePort        string
+	RelayPort           string // request port of the first relay, like RelayConfig.RelayPort
+	UnknownDomainStatus int    // returned when the Host header matches no domain mapping (421 or 404)
+	Retry               RetryConfig
+	PathHealth          PathHealthConfig
+	QoS                 QoSConfig
+	Hedge               HedgeConfig        // sending latency-critical requests on two paths, see hedge.go
+	RateLimit           RateLimitConfig    // the node's own limits, the controller adds per-domain ones, see ratelimit.go
+	Tunnels             []TunnelConfig     // TCP ports carried over the relay paths, see tunnel.go
+	UDP                 []UDPForwardConfig // UDP ports carried over the relay paths, see datagram.go
+	UDPFlowIdleTimeout  time.Duration
+	Cache               httpcache.Config // edge response cache, see edge_cache.go
+	Compression         CompressionConfig
+	Buffer              BufferConfig // merge buffers, DefaultBufferConfig if unset
 }
 
 var DefaultAccessConfig = AccessConfig{
-	HttpPort:     "50055",
-	ResponsePort: "50054",
+	HttpPort:            "50055",
+	HttpsPort:           "50443",
+	CertDir:             "../../agent_storage/certs",
+	CertReloadInterval:  30 * time.Second,
+	ResponsePort:        "50054",
+	RelayPort:           "50056",
+	UnknownDomainStatus: http.StatusMisdirectedRequest,
+	Retry:               DefaultRetryConfig(),
+	PathHealth:          DefaultPathHealthConfig(),
+	QoS:                 DefaultQoSConfig(),
+	Hedge:               DefaultHedgeConfig(),
+	RateLimit:           DefaultRateLimitConfig(),
+	UDPFlowIdleTimeout:  DefaultUDPFlowIdleTimeout,
+	Cache:               httpcache.DefaultConfig,
+	Compression:         DefaultCompressionConfig(),
+	Buffer:              DefaultBufferConfig(),
 }
 
 type AccessProxy struct {
@@ -103,7 +168,8 @@ type AccessProxy struct {
 
 func CreateAccessProxy(config AccessConfig, repoConfig RepositoryConfig) *AccessProxy {
 	// Initialize a new request state manager
-	stateManager := NewRequestStateManager(15*time.Minute, 1*time.Minute) // 15 min expiration, 1 min cleanup
+	timeouts := currentTimeouts()
+	stateManager := NewRequestStateManager(timeouts.StateExpiration, timeouts.StateCleanup)
 
 	repo := &Repository{
 		httpRequestChan:  make(chan *RequestItem, repoConfig.RequestBufferSize),
@@ -112,14 +178,33 @@ func CreateAccessProxy(config AccessConfig, repoConfig RepositoryConfig) *Access
 		config:           repoConfig,
 		accessConfig:     config,
 		stateManager:     stateManager,
+		pathHealth:       NewPathHealthTracker(config.PathHealth),
+		hedger:           newHedger(config.Hedge),
+		rateLimiter:      newRateLimiter(config.RateLimit),
+		certStore:        NewCertStore(config.CertDir),
+		udpFlows:         make(map[string]*udpFlow),
+		udpFlowsByID:     make(map[uint32]*udpFlow),
+	}
+	if config.Cache.Enabled {
+		cache, err := httpcache.New(config.Cache)
+		if err != nil {
+			log.Printf("[Access-WARN] Response cache disabled: %v", err)
+		} else {
+			repo.cache = cache
+		}
 	}
 
-	bufferConfig := DefaultBufferConfig()
+	bufferConfig := config.Buffer
+	if bufferConfig.BuffersPerPath == 0 {
+		bufferConfig = DefaultBufferConfig()
+	}
 	repo.bufferManager = NewBufferManager(bufferConfig, stateManager)
+	repo.compressor = newPayloadCompressor(config.Compression, repo.bufferManager.RecordCompression)
+	registerStatsSource("access", repo.bufferManager, stateManager)
 
 	// Set send functions for the buffer manager; nil for forwardResponseToPreviousHop as AccessProxy handles responses differently
 	repo.bufferManager.SetSendFunctions(
-		repo.sendSingleRequest,
+		repo.sendSingleRequestOrFail,
 		repo.sendMergedRequest,
 		nil, // AccessProxy does not forward responses to a previous hop in the same way RelayProxy does
 	)
@@ -131,10 +216,11 @@ func CreateAccessProxy(config AccessConfig, repoConfig RepositoryConfig) *Access
 }
 
 func (r *Repository) StartProcessors() {
-	r.wg.Add(2) // Add count for the two goroutines to be launched
+	r.wg.Add(3) // Add count for the three goroutines to be launched
 
 	go r.processHttpRequests()
 	go r.processSmuxResponses()
+	go r.expireUDPFlows()
 
 	log.Println("[Repository-INFO] Started HTTP request and SMUX response processors.")
 }
@@ -143,6 +229,8 @@ func (r *Repository) Stop() {
 	log.Println("[Repository-INFO] Stopping repository processors...")
 	close(r.done) // Signal all worker goroutines to stop
 	r.wg.Wait()   // Wait for all worker goroutines to complete
+	r.stopTunnelListeners()
+	r.stopUDPListeners()
 
 	if r.bufferManager != nil {
 		log.Println("[Repository-INFO] Stopping BufferManager.")
@@ -183,6 +271,9 @@ func (r *Repository) processHttpRequests() {
 						return
 					}
 					log.Printf("[Access-DEBUG] Worker #%d processing HTTP request ID %d for %s from %s", workerID, req.RequestID, req.Request.URL.Path, req.Request.RemoteAddr)
+					queueSpan := tracing.StartAt(req.Trace, "access.queue", tracing.KindInternal, req.QueuedAt)
+					queueSpan.SetAttribute(attrRequestID, req.RequestID)
+					queueSpan.End()
 
 					reqState := &RequestState{
 						RequestID:        req.RequestID,
@@ -196,9 +287,12 @@ func (r *Repository) processHttpRequests() {
 						NextHopIP:        req.NextHopIP,
 						HopList:          req.HopList,
 						IsLastHop:        req.IsLastHop,
+						Priority:         req.Priority,
 						ResponseReceived: req.ResponseReceived,
+						AttemptFailed:    req.AttemptFailed,
 						BufferID:         "",
 						MergeGroupID:     0,
+						Trace:            req.Trace,
 					}
 
 					r.stateManager.AddState(reqState) // StateManager already logs this addition
@@ -207,6 +301,12 @@ func (r *Repository) processHttpRequests() {
 						log.Printf("[Access-INFO] Request ID %d for %s is the last hop. Handling as direct proxy to %s.", req.RequestID, req.Request.URL.Path, req.NextHopIP)
 						go r.handleDirectProxy(reqState)
 					} else {
+						if shouldStreamBody(req.Request.ContentLength, r.bufferManager.GetMaxBufferSize()) {
+							log.Printf("[Access-INFO] Request ID %d for %s has a large or unknown-length body. Streaming to %s.", req.RequestID, req.Request.URL.Path, req.NextHopIP)
+							go r.sendStreamingRequest(reqState)
+							continue
+						}
+
 						log.Printf("[Access-INFO] Request ID %d for %s is not the last hop. Processing for buffered forwarding to %s.", req.RequestID, req.Request.URL.Path, req.NextHopIP)
 						reqBytes, err := httputil.DumpRequest(req.Request, true)
 						if err != nil {
@@ -252,39 +352,20 @@ func (r *Repository) handleDirectProxy(reqState *RequestState) {
 	log.Printf("[Access-INFO] Request ID %d: Handling direct proxy to %s for URL: %s", requestID, nextHopIP, originalReq.URL.Path)
 	reqState.mu.RUnlock()
 
-	parts := bytes.Split([]byte(nextHopIP), []byte(":"))
-	host := string(parts[0])
-	port := "80" // Default port for direct proxy if not specified
-	if len(parts) > 1 {
-		port = string(parts[1])
-	}
+	host, port := hostPort(nextHopIP, "80") // Default port for direct proxy if not specified
 
-	targetURL := fmt.Sprintf("http://%s:%s%s", host, port, originalReq.URL.Path)
+	targetURL := fmt.Sprintf("http://%s%s", net.JoinHostPort(host, port), originalReq.URL.Path)
 	log.Printf("[Access-DEBUG] Request ID %d: Target URL for direct proxy: %s", requestID, targetURL)
 
-	var bodyBytes []byte
-	var err error
-	if originalReq.Body != nil {
-		bodyBytes, err = io.ReadAll(originalReq.Body)
-		if err != nil {
-			log.Printf("[Access-ERROR] Request ID %d: Failed to read request body for direct proxy: %v", requestID, err)
-			r.stateManager.UpdateStatus(requestID, StatusFailed)
-			r.notifyRequestFailed(reqState, fmt.Errorf("failed to read request body: %w", err))
-			return
-		}
-		originalReq.Body.Close() // Close the original body after reading
-		// Restore the body for the original request in case it's needed elsewhere (though unlikely for direct proxy)
-		originalReq.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-	}
-
-	// Create a new request for the target URL
-	clonedReq, err := http.NewRequest(originalReq.Method, targetURL, io.NopCloser(bytes.NewBuffer(bodyBytes)))
+	// Pass the body through as a stream rather than reading it into memory
+	clonedReq, err := http.NewRequest(originalReq.Method, targetURL, originalReq.Body)
 	if err != nil {
 		log.Printf("[Access-ERROR] Request ID %d: Failed to create new HTTP request for direct proxy to %s: %v", requestID, targetURL, err)
 		r.stateManager.UpdateStatus(requestID, StatusFailed)
 		r.notifyRequestFailed(reqState, fmt.Errorf("failed to create new request: %w", err))
 		return
 	}
+	clonedReq.ContentLength = originalReq.ContentLength
 
 	// Copy headers from the original request
 	for key, values := range originalReq.Header {
@@ -294,12 +375,16 @@ func (r *Repository) handleDirectProxy(reqState *RequestState) {
 	}
 	clonedReq.Host = host // Set the Host header to the target host
 
+	originSpan := startOriginSpan(reqState.Trace, requestID, clonedReq, targetURL)
+	defer originSpan.End()
+
 	client := &http.Client{
-		Timeout: 30 * time.Second, // TODO: Make timeout configurable
+		Timeout: currentTimeouts().DirectProxy,
 	}
 
 	log.Printf("[Access-DEBUG] Request ID %d: Sending request to %s", requestID, targetURL)
 	resp, err := client.Do(clonedReq)
+	endOriginCall(originSpan, resp, err)
 	if err != nil {
 		log.Printf("[Access-ERROR] Request ID %d: Failed to execute direct proxy request to %s: %v", requestID, targetURL, err)
 		r.stateManager.UpdateStatus(requestID, StatusFailed)
@@ -349,6 +434,19 @@ func (r *Repository) notifyRequestFailed(reqState *RequestState, err error) {
 		return
 	}
 
+	reqState.mu.RLock()
+	attemptFailed := reqState.AttemptFailed
+	reqState.mu.RUnlock()
+	if attemptFailed != nil {
+		// The HTTP handler owns the client response and decides whether to retry on another path
+		select {
+		case attemptFailed <- err:
+		default:
+		}
+		log.Printf("[Access-WARN] Request ID %d failed: %v. Handed over to the HTTP handler.", reqState.RequestID, err)
+		return
+	}
+
 	log.Printf("[Access-WARN] Notifying client of failure for Request ID %d. Error: %v", reqState.RequestID, err)
 
 	reqState.mu.RLock()
@@ -374,16 +472,9 @@ func (r *Repository) notifyRequestFailed(reqState *RequestState, err error) {
 		return
 	}
 
-	// Construct a generic error response
 	// Avoid writing to responseWriter if headers have already been sent, though this function is typically called before that.
 	// For simplicity, we assume it's safe here, but in a more complex system, this might need a check.
-	bodyMsg := fmt.Sprintf("Request failed due to an internal error. Request ID: %d. Error: %v", reqState.RequestID, err)
-	responseWriter.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	responseWriter.WriteHeader(http.StatusInternalServerError)
-	_, writeErr := responseWriter.Write([]byte(bodyMsg))
-	if writeErr != nil {
-		log.Printf("[Access-ERROR] Request ID %d: Failed to write error response to client: %v", reqState.RequestID, writeErr)
-	}
+	writeRequestFailure(responseWriter, reqState.RequestID, err)
 
 	// Close the response channel if it's not already closed
 	if !isAlreadyClosed {
@@ -392,6 +483,17 @@ func (r *Repository) notifyRequestFailed(reqState *RequestState, err error) {
 	log.Printf("[Access-INFO] Request ID %d: Client notification of failure sent.", reqState.RequestID)
 }
 
+// writeRequestFailure writes the generic error response for a failed request.
+func writeRequestFailure(w http.ResponseWriter, requestID uint32, err error) {
+	bodyMsg := fmt.Sprintf("Request failed due to an internal error. Request ID: %d. Error: %v", requestID, err)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	_, writeErr := w.Write([]byte(bodyMsg))
+	if writeErr != nil {
+		log.Printf("[Access-ERROR] Request ID %d: Failed to write error response to client: %v", requestID, writeErr)
+	}
+}
+
 func (r *Repository) processSmuxResponses() {
 	defer r.wg.Done() // Decrement the WaitGroup counter when this goroutine exits
 
@@ -443,6 +545,14 @@ func (r *Repository) processSmuxResponses() {
 					}
 
 					log.Printf("[Access-DEBUG] Worker #%d: Unpacked SMUX response header for %d packet(s). Request IDs: %v", workerID, header.PacketCount, header.PacketID)
+					recordLinkLatencies(header, resp.ReceivedAt)
+
+					r.compressor.learn(resp.RemoteAddr, header)
+					responseData, err = decodePayload(header, responseData)
+					if err != nil {
+						log.Printf("[Access-ERROR] Worker #%d: Failed to decode SMUX response payload for Request IDs %v: %v", workerID, header.PacketID, err)
+						continue
+					}
 
 					positions := packet.GetRequestPositions(header, len(responseData))
 
@@ -463,11 +573,14 @@ func (r *Repository) processSmuxResponses() {
 						}
 
 						r.stateManager.UpdateStatus(requestID, StatusResponding) // StateManager logs this
+						respSpan := startRequestSpan(reqState, "access.response", tracing.KindServer, resp.ReceivedAt)
 
 						respReader := bufio.NewReader(bytes.NewReader(respData))
 						httpResp, err := http.ReadResponse(respReader, reqState.OriginalRequest) // Pass original request for context if needed by ReadResponse
 						if err != nil {
 							log.Printf("[Access-ERROR] Worker #%d, Request ID %d: Failed to read HTTP response from SMUX data: %v", workerID, requestID, err)
+							respSpan.SetError(err)
+							respSpan.End()
 							r.stateManager.UpdateStatus(requestID, StatusFailed)
 							// Potentially call notifyRequestFailed if appropriate, but client might have timed out
 							continue
@@ -490,6 +603,14 @@ func (r *Repository) processSmuxResponses() {
 
 						if responseWriter != nil {
 							err = r.sendResponseToClient(responseWriter, httpResp)
+							respSpan.SetAttribute("http.response.status_code", httpResp.StatusCode)
+							respSpan.SetError(err)
+							respSpan.End()
+							if errors.Is(err, errAttemptAbandoned) {
+								log.Printf("[Access-INFO] Worker #%d, Request ID %d: Response discarded, the attempt was abandoned or another copy answered first.", workerID, requestID)
+								r.stateManager.RemoveState(requestID)
+								continue
+							}
 							if err != nil {
 								log.Printf("[Access-ERROR] Worker #%d, Request ID %d: Failed to send response to client: %v", workerID, requestID, err)
 								r.stateManager.UpdateStatus(requestID, StatusFailed)
@@ -505,6 +626,7 @@ func (r *Repository) processSmuxResponses() {
 							log.Printf("[Access-INFO] Worker #%d: Successfully sent SMUX response for Request ID %d to client.", workerID, requestID)
 						} else {
 							log.Printf("[Access-WARN] Worker #%d, Request ID %d: ResponseWriter is nil, cannot send response to client.", workerID, requestID)
+							respSpan.End()
 							// If responseChan is still open, close it
 							if responseChan != nil && !isAlreadyClosed {
 								close(responseChan)
@@ -552,13 +674,8 @@ func (r *Repository) sendResponseToClient(w http.ResponseWriter, resp *http.Resp
 func (r *Repository) sendSingleRequest(data []byte, nextHopIP string, requestID uint32, request *RequestState) error {
 	log.Printf("[Access-INFO] Request ID %d: Preparing to send single request to next hop: %s", requestID, nextHopIP)
 
-	parts := bytes.Split([]byte(nextHopIP), []byte(":"))
-	ip := string(parts[0])
-	port := "50056" // Default port for relay if not specified
-	if len(parts) > 1 {
-		port = string(parts[1])
-	}
-	targetAddr := ip + ":" + port
+	ip, port := hostPort(nextHopIP, r.accessConfig.RelayPort) // Default port for relay if not specified
+	targetAddr := net.JoinHostPort(ip, port)
 
 	log.Printf("[Access-DEBUG] Request ID %d: Sending single request via SMUX to target: %s. Data size (payload only): %d bytes", requestID, targetAddr, len(data))
 
@@ -607,6 +724,7 @@ func (r *Repository) sendSingleRequest(data []byte, nextHopIP string, requestID
 			HopList:     hopList,
 			HopCounts:   0, // HopCounts is 0 when originating from AccessProxy
 		}
+		header.Property, data = r.compressor.encode(header, data, nextHopIP)
 
 		headerBytes, err = header.Pack()
 		if err != nil {
@@ -631,16 +749,22 @@ func (r *Repository) sendSingleRequest(data []byte, nextHopIP string, requestID
 	return nil
 }
 
+// sendSingleRequestOrFail is the buffer manager's per-request sender, whose
+// errors the buffer manager does not report; a failure here ends the attempt.
+func (r *Repository) sendSingleRequestOrFail(data []byte, nextHopIP string, requestID uint32, request *RequestState) error {
+	err := r.sendSingleRequest(data, nextHopIP, requestID, request)
+	if err != nil {
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		r.notifyRequestFailed(request, err)
+	}
+	return err
+}
+
 func (r *Repository) sendMergedRequest(mergedData []byte, nextHopIP string, updatedHeader *packet.Packet) error {
 	log.Printf("[Access-INFO] Preparing to send merged request to next hop: %s. Header PacketCount: %d, Request IDs: %v", nextHopIP, updatedHeader.PacketCount, updatedHeader.PacketID)
 
-	parts := bytes.Split([]byte(nextHopIP), []byte(":"))
-	ip := string(parts[0])
-	port := "50056" // Default port for relay if not specified
-	if len(parts) > 1 {
-		port = string(parts[1])
-	}
-	targetAddr := ip + ":" + port
+	ip, port := hostPort(nextHopIP, r.accessConfig.RelayPort) // Default port for relay if not specified
+	targetAddr := net.JoinHostPort(ip, port)
 
 	log.Printf("[Access-DEBUG] Sending merged request via SMUX to target: %s. Original merged data size: %d bytes", targetAddr, len(mergedData))
 
@@ -654,9 +778,11 @@ func (r *Repository) sendMergedRequest(mergedData []byte, nextHopIP string, upda
 
 		if currentHeaderLen > 0 && currentHeaderLen <= len(mergedData) {
 			requestBytes := mergedData[currentHeaderLen:] // This is the actual payload (one or more HTTP requests)
+			updatedHeader.Property, requestBytes = r.compressor.encode(updatedHeader, requestBytes, nextHopIP)
 
 			log.Printf("[Access-DEBUG] Current header length in mergedData: %d. Payload size: %d. Updating header with HopCounts=%d", currentHeaderLen, len(requestBytes), updatedHeader.HopCounts)
 
+			updatedHeader.StartStamps(time.Now())
 			newHeaderBytes, err = updatedHeader.Pack()
 			if err != nil {
 				log.Printf("[Access-ERROR] Failed to pack updated header for merged request (Request IDs: %v): %v", updatedHeader.PacketID, err)
@@ -715,6 +841,46 @@ func (r *Repository) sendMergedRequest(mergedData []byte, nextHopIP string, upda
 	return nil
 }
 
+// waitForAttempt waits until the attempt has delivered its response, failed or
+// timed out. An attempt that has started writing to the client is always
+// waited for, since it can no longer be replaced by another one.
+func (r *Repository) waitForAttempt(reqItem *RequestItem, attemptWriter *attemptWriter, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for waiting := true; waiting; {
+		select {
+		case <-reqItem.ResponseReceived:
+			return nil
+		case err := <-reqItem.AttemptFailed:
+			return err
+		case <-timer.C:
+		}
+		// A streamed upload gets the full timeout again after its last bytes
+		waiting = false
+		if state, ok := r.stateManager.GetState(reqItem.RequestID); ok {
+			state.mu.RLock()
+			uploadedAt := state.UploadedAt
+			state.mu.RUnlock()
+			if wait := time.Until(uploadedAt.Add(timeout)); !uploadedAt.IsZero() && wait > 0 {
+				timer.Reset(wait)
+				waiting = true
+			}
+		}
+	}
+
+	if attemptWriter.abandon() {
+		return errAttemptTimeout
+	}
+	// A streamed response is being copied to the client; it is bounded by the stream idle timeout instead
+	select {
+	case <-reqItem.ResponseReceived:
+	case err := <-reqItem.AttemptFailed:
+		log.Printf("[Access-ERROR] Request ID %d: Failed after the response to the client had started: %v", reqItem.RequestID, err)
+	}
+	return nil
+}
+
 func (r *Repository) StartHttpProxy() {
 	handler := func(w http.ResponseWriter, req *http.Request) {
 		requestReceivedTime := time.Now()
@@ -723,89 +889,202 @@ func (r *Repository) StartHttpProxy() {
 		requestID := generateUniqueRequestID(req)
 		log.Printf("[Access-DEBUG] Generated Request ID %d for %s %s", requestID, req.Method, req.URL.Path)
 
-		pathManager := router.GetInstance() // Assuming router.GetInstance() is safe and handles its own initialization logging if any.
-		paths := pathManager.GetPaths()
-		if len(paths) == 0 {
-			log.Printf("[Access-ERROR] Request ID %d: No available paths from PathManager for %s %s. Responding with 503.", requestID, req.Method, req.URL.Path)
-			http.Error(w, "Service unavailable: No routing paths found.", http.StatusServiceUnavailable)
+		span := tracing.Start(tracing.Extract(req.Header), "access.request", tracing.KindServer)
+		defer span.End()
+		span.SetAttribute(attrRequestID, requestID)
+		span.SetAttribute("http.request.method", req.Method)
+		span.SetAttribute("server.address", req.Host)
+		span.SetAttribute("url.path", req.URL.Path)
+		span.SetAttribute("client.address", req.RemoteAddr)
+
+		if Draining() {
+			log.Printf("[Access-WARN] Request ID %d: Node is draining. Responding with 503.", requestID)
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Service unavailable: Node is draining.", http.StatusServiceUnavailable)
 			return
 		}
 
-		// Debug log for available paths (can be verbose)
-		// for i, p := range paths {
-		// 	log.Printf("[Access-TRACE] Path %d: %v (Latency: %d ms, Weight: %d)", i, p.IPList, p.Latency, p.Weight)
-		// }
+		domain := router.NormalizeDomain(req.Host)
+		if !router.IsKnownDomain(domain) {
+			status := r.accessConfig.UnknownDomainStatus
+			if status == 0 {
+				status = http.StatusMisdirectedRequest
+			}
+			log.Printf("[Access-WARN] Request ID %d: Host %q is not a served domain. Responding with %d.", requestID, req.Host, status)
+			http.Error(w, fmt.Sprintf("Unknown domain: %s", domain), status)
+			return
+		}
 
-		wrr := router.NewWeightedRoundRobin(paths)
-		nextPath := wrr.Next()
-		if nextPath.IPList == nil || len(nextPath.IPList) == 0 {
-			log.Printf("[Access-ERROR] Request ID %d: WeightedRoundRobin returned no valid next path for %s %s. Responding with 503.", requestID, req.Method, req.URL.Path)
-			http.Error(w, "Service unavailable: Could not determine next hop.", http.StatusServiceUnavailable)
+		if req.TLS != nil {
+			// A connection may be reused for another host only if its certificate covers that host as well
+			if !r.certStore.Covers(req.TLS.ServerName, domain) {
+				log.Printf("[Access-WARN] Request ID %d: Host %q does not match TLS server name %q. Responding with 421.", requestID, req.Host, req.TLS.ServerName)
+				http.Error(w, "Misdirected request: Host does not match TLS server name.", http.StatusMisdirectedRequest)
+				return
+			}
+			req.Header.Set("X-Forwarded-Proto", "https")
+		}
+
+		if ok, wait, scope := r.rateLimiter.allow(domain, clientIP(req), router.GetRateLimit(domain), time.Now()); !ok {
+			log.Printf("[Access-WARN] Request ID %d: %s rate limit for %s hit by %s. Responding with 429.", requestID, scope, domain, req.RemoteAddr)
+			rateLimitedTotal.With(domain, scope).Inc()
+			w.Header().Set("Retry-After", retryAfterSeconds(wait))
+			http.Error(w, "Too many requests: Rate limit exceeded.", http.StatusTooManyRequests)
+			return
+		}
+		if req.Method == "PURGE" && r.cache.Enabled(domain) {
+			r.handlePurge(w, req, domain, requestID)
+			return
+		}
+		if isUpgradeRequest(req) {
+			r.handleUpgrade(w, req, domain, requestID)
 			return
 		}
-		log.Printf("[Access-INFO] Request ID %d: Selected path for %s %s: %v (Latency: %d ms, Weight: %d)", requestID, req.Method, req.URL.Path, nextPath.IPList, nextPath.Latency, nextPath.Weight)
+		if req.ProtoMajor != 1 {
+			// Requests travel between nodes in HTTP/1.1 wire format
+			req = req.Clone(req.Context())
+			req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.1", 1, 1
+		}
 
-		// HopList for the packet should be the selected path from the router
-		currentHopList := nextPath.IPList
+		clientReq := req
+		var cacheWriter *cacheRecorder
+		var staleEntry *httpcache.Entry
+		if r.cache.Cacheable(domain, req) {
+			var served bool
+			if staleEntry, served = r.serveFromCache(w, req, domain, requestID); served {
+				return
+			}
+			if staleEntry != nil && !httpcache.HasConditionals(req) {
+				req = req.Clone(req.Context())
+				staleEntry.AddConditionals(req)
+			} else {
+				staleEntry = nil // the client's own conditionals go through untouched
+			}
+			cacheWriter = &cacheRecorder{w: w, limit: r.cache.MaxObjectBytes(), revalidating: staleEntry != nil}
+			w = cacheWriter
+		}
 
-		header, err := packet.NewPacket(currentHopList, requestID) // Pass the selected path as HopList
-		if err != nil {
-			log.Printf("[Access-ERROR] Request ID %d: Failed to create new packet header: %v. HopList: %v. Responding with 500.", requestID, err, currentHopList)
-			http.Error(w, "Internal server error: Failed to create packet header.", http.StatusInternalServerError)
+		priority := r.accessConfig.QoS.Classify(req, domain)
+
+		pathManager := router.GetInstance() // Assuming router.GetInstance() is safe and handles its own initialization logging if any.
+		paths := pathManager.GetPaths(domain)
+		if len(paths) == 0 {
+			log.Printf("[Access-ERROR] Request ID %d: No available paths from PathManager for domain %s (%s %s). Responding with 503.", requestID, domain, req.Method, req.URL.Path)
+			http.Error(w, "Service unavailable: No routing paths found.", http.StatusServiceUnavailable)
 			return
 		}
 
-		nextHopIP, isLastHop, err := header.GetNextHopIP()
+		policy := r.accessConfig.Retry
+		replayBody, retryable, err := prepareRetry(req, policy)
 		if err != nil {
-			log.Printf("[Access-ERROR] Request ID %d: Failed to get next hop IP from header: %v. Header: %+v. Responding with 500.", requestID, err, header)
-			http.Error(w, "Internal server error: Failed to determine next hop.", http.StatusInternalServerError)
+			log.Printf("[Access-ERROR] Request ID %d: Failed to read request body: %v. Responding with 400.", requestID, err)
+			http.Error(w, "Bad request: Failed to read request body.", http.StatusBadRequest)
 			return
 		}
+		maxAttempts := 1
+		if retryable {
+			maxAttempts = policy.MaxAttempts
+		}
+		deadline := requestReceivedTime.Add(policy.TotalBudget)
+		triedPaths := make(map[string]bool)
 
-		log.Printf("[Access-INFO] Request ID %d: Determined next hop: %s, IsLastHop: %v", requestID, nextHopIP, isLastHop)
+		for attempt := 1; ; attempt++ {
+			var nextPath k_shortest.PathWithIP
+			if attempt == 1 {
+				nextPath = r.pathHealth.Pick(pathManager.DeprioritizeFailed(paths))
+			} else {
+				requestID = generateUniqueRequestID(req) // A fresh ID so late responses of the failed attempt are dropped
+				nextPath = nextRetryPath(r.pathHealth.Available(paths), triedPaths)
+			}
+			if nextPath.IPList == nil || len(nextPath.IPList) == 0 {
+				log.Printf("[Access-ERROR] Request ID %d: WeightedRoundRobin returned no valid next path for %s %s. Responding with 503.", requestID, req.Method, req.URL.Path)
+				http.Error(w, "Service unavailable: Could not determine next hop.", http.StatusServiceUnavailable)
+				return
+			}
+			triedPaths[router.PathKey(nextPath.IPList)] = true
+			log.Printf("[Access-INFO] Request ID %d: Selected path for %s %s%s (attempt %d/%d): %v (Latency: %d ms, Weight: %d)",
+				requestID, req.Method, domain, req.URL.Path, attempt, maxAttempts, nextPath.IPList, nextPath.Latency, nextPath.Weight)
 
-		reqItem := &RequestItem{
-			Request:          req,
-			ResponseWriter:   w,
-			RequestID:        requestID,
-			ReceivedAt:       requestReceivedTime, // Use the time captured at the beginning of the handler
-			ResponseReceived: make(chan struct{}),
-			IsLastHop:        isLastHop,
-			NextHopIP:        nextHopIP,
-			HopList:          header.HopList, // This is the full path selected
-		}
+			wait := time.Until(deadline)
+			if attempt < maxAttempts && policy.AttemptTimeout < wait {
+				wait = policy.AttemptTimeout
+			}
+			var hedgePath k_shortest.PathWithIP
+			var hedgeDelay time.Duration
+			hedging := false
+			if attempt == 1 {
+				hedgePath, hedgeDelay, hedging = r.hedger.plan(domain, priority, retryable, len(replayBody), nextPath, r.pathHealth.Available(paths))
+			}
 
-		if !isLastHop {
-			// If not the last hop, we need to pack the header to be sent with the request data
-			headerBytes, err := header.Pack()
+			attemptWriter := newAttemptWriter(w)
+			var race *hedgeRace
+			if hedging {
+				race = &hedgeRace{}
+				attemptWriter.race = race
+			}
+			current, err := r.startAttempt(req, replayBody, attemptWriter, nextPath, requestID, priority, requestReceivedTime, span, attempt)
+			if errors.Is(err, errRequestQueueTimeout) {
+				log.Printf("[Access-ERROR] Request ID %d: Timeout submitting request to httpRequestChan. Channel may be full or blocked. Responding with 503.", requestID)
+				span.SetError(err)
+				http.Error(w, "Service temporarily unavailable: Request queue timeout.", http.StatusServiceUnavailable)
+				return
+			}
 			if err != nil {
-				log.Printf("[Access-ERROR] Request ID %d: Failed to pack header for forwarding: %v. Header: %+v. Responding with 500.", requestID, err, header)
-				http.Error(w, "Internal server error: Failed to pack forwarding header.", http.StatusInternalServerError)
+				log.Printf("[Access-ERROR] Request ID %d: Failed to prepare request for forwarding: %v. Responding with 500.", requestID, err)
+				http.Error(w, "Internal server error: Failed to prepare forwarding header.", http.StatusInternalServerError)
 				return
 			}
-			reqItem.HeaderBytes = headerBytes
-			log.Printf("[Access-DEBUG] Request ID %d: Header packed for forwarding, size: %d bytes.", requestID, len(headerBytes))
-		}
 
-		select {
-		case r.httpRequestChan <- reqItem:
-			log.Printf("[Access-DEBUG] Request ID %d: Submitted to httpRequestChan for processing.", requestID)
-		case <-time.After(5 * time.Second): // TODO: Make this timeout configurable
-			log.Printf("[Access-ERROR] Request ID %d: Timeout submitting request to httpRequestChan. Channel may be full or blocked. Responding with 503.", requestID)
-			http.Error(w, "Service temporarily unavailable: Request queue timeout.", http.StatusServiceUnavailable)
-			return
-		}
+			var attemptErr error
+			if hedging {
+				startHedge := func() (*forwardAttempt, error) {
+					hedgeWriter := newAttemptWriter(w)
+					hedgeWriter.race = race
+					triedPaths[router.PathKey(hedgePath.IPList)] = true
+					hedge, err := r.startAttempt(req, replayBody, hedgeWriter, hedgePath, generateUniqueRequestID(req), priority, requestReceivedTime, span, attempt)
+					if err == nil {
+						hedge.hedge = true
+						hedge.span.SetAttribute("arcturus.hedge", true)
+					}
+					return hedge, err
+				}
+				current, attemptErr = r.waitForHedged(current, startHedge, domain, hedgeDelay, wait)
+				nextPath, requestID = current.path, current.item.RequestID
+			} else {
+				attemptErr = r.waitForAttempt(current.item, current.writer, wait)
+			}
+			r.finishAttempt(current, domain, attemptErr)
+			if attemptErr == nil {
+				if cacheWriter != nil {
+					r.finishCachedResponse(cacheWriter.w, clientReq, domain, requestID, cacheWriter, staleEntry, current.start)
+				}
+				log.Printf("[Access-INFO] Request ID %d: Response received and processed for %s %s. Total time: %s.", requestID, req.Method, req.URL.Path, time.Since(requestReceivedTime))
+				return
+			}
 
-		// Wait for the response or timeout
-		select {
-		case <-reqItem.ResponseReceived:
-			processingTime := time.Since(requestReceivedTime)
-			log.Printf("[Access-INFO] Request ID %d: Response received and processed for %s %s. Total time: %s.", requestID, req.Method, req.URL.Path, processingTime)
-		case <-time.After(30 * time.Second): // TODO: Make this timeout configurable
-			processingTime := time.Since(requestReceivedTime)
-			log.Printf("[Access-ERROR] Request ID %d: Timeout waiting for response for %s %s. Total time waited: %s. Responding with 504.", requestID, req.Method, req.URL.Path, processingTime)
-			http.Error(w, "Gateway timeout: No response from upstream server.", http.StatusGatewayTimeout)
-			return
+			pathManager.MarkPathFailed(nextPath.IPList, policy.FailedPathPenalty)
+			r.stateManager.RemoveState(requestID)
+
+			if errors.Is(attemptErr, errDrainDeadline) {
+				span.SetError(attemptErr)
+				log.Printf("[Access-WARN] Request ID %d: Node shut down before the response arrived. Responding with 503.", requestID)
+				w.Header().Set("Connection", "close")
+				w.Header().Set("Retry-After", "5")
+				http.Error(w, "Service unavailable: Node is shutting down.", http.StatusServiceUnavailable)
+				return
+			}
+			if attempt >= maxAttempts || time.Until(deadline) <= 0 {
+				span.SetError(attemptErr)
+				if errors.Is(attemptErr, errAttemptTimeout) {
+					log.Printf("[Access-ERROR] Request ID %d: Timeout waiting for response for %s %s after %d attempt(s). Total time waited: %s. Responding with 504.", requestID, req.Method, req.URL.Path, attempt, time.Since(requestReceivedTime))
+					http.Error(w, "Gateway timeout: No response from upstream server.", http.StatusGatewayTimeout)
+					return
+				}
+				writeRequestFailure(w, requestID, attemptErr)
+				return
+			}
+			log.Printf("[Access-WARN] Request ID %d: Attempt %d on path %v failed: %v. Retrying on another path.", requestID, attempt, nextPath.IPList, attemptErr)
 		}
 	}
 
@@ -814,13 +1093,22 @@ func (r *Repository) StartHttpProxy() {
 
 	log.Printf("[Access-INFO] Starting HTTP Proxy on port %s", r.accessConfig.HttpPort)
 
+	if r.accessConfig.HttpsPort != "" {
+		go r.startHttpsProxy(mux)
+	}
+
 	srv := &http.Server{
 		Addr:    ":" + r.accessConfig.HttpPort,
 		Handler: mux,
 		// TODO: Add other server configurations like ReadTimeout, WriteTimeout, IdleTimeout for robustness
 	}
+	listener, err := Listen(srv.Addr)
+	if err != nil {
+		log.Fatalf("[Access-CRITICAL] HTTP Proxy failed to listen on port %s: %v", r.accessConfig.HttpPort, err)
+	}
+	r.trackServer(srv)
 
-	err := srv.ListenAndServe()
+	err = srv.Serve(listener)
 	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("[Access-CRITICAL] HTTP Proxy ListenAndServe on port %s failed: %v", r.accessConfig.HttpPort, err)
 	} else if err == http.ErrServerClosed {
@@ -829,23 +1117,49 @@ func (r *Repository) StartHttpProxy() {
 	log.Printf("[Access-INFO] HTTP Proxy on port %s stopped listening.", r.accessConfig.HttpPort) // This log might be confusing if shutdown was graceful.
 }
 
-func (r *Repository) StartTcpResponseProxy() {
-	listenAddr := ":" + r.accessConfig.ResponsePort
-	// Test if the port is available before attempting to listen indefinitely
-	// This is a common pattern to fail fast if the port is already in use.
-	testListener, err := net.Listen("tcp", listenAddr)
+// startHttpsProxy terminates TLS for the same handler as the plain HTTP proxy.
+// HTTP/2 is negotiated through ALPN; the certificate is chosen by SNI.
+func (r *Repository) startHttpsProxy(handler http.Handler) {
+	if err := r.certStore.Reload(); err != nil {
+		log.Printf("[Access-WARN] %v. HTTPS will fail handshakes until certificates appear.", err)
+	}
+	go r.certStore.Watch(r.accessConfig.CertReloadInterval, r.done)
+
+	log.Printf("[Access-INFO] Starting HTTPS Proxy on port %s with certificates from %s", r.accessConfig.HttpsPort, r.accessConfig.CertDir)
+
+	srv := &http.Server{
+		Addr:    ":" + r.accessConfig.HttpsPort,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: r.certStore.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		},
+	}
+
+	listener, err := Listen(srv.Addr)
 	if err != nil {
-		log.Fatalf("[Access-CRITICAL] Pre-check failed: TCP Response Proxy cannot listen on port %s: %v", r.accessConfig.ResponsePort, err)
-		return // Should not be reached due to Fatalf
+		log.Fatalf("[Access-CRITICAL] HTTPS Proxy failed to listen on port %s: %v", r.accessConfig.HttpsPort, err)
 	}
-	testListener.Close() // Close the test listener immediately
-	log.Printf("[Access-DEBUG] Port %s is available for TCP Response Proxy.", r.accessConfig.ResponsePort)
+	r.trackServer(srv)
+
+	err = srv.ServeTLS(listener, "", "")
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("[Access-CRITICAL] HTTPS Proxy ListenAndServeTLS on port %s failed: %v", r.accessConfig.HttpsPort, err)
+	}
+	log.Printf("[Access-INFO] HTTPS Proxy on port %s stopped listening.", r.accessConfig.HttpsPort)
+}
 
-	listener, err := net.Listen("tcp", listenAddr)
+func (r *Repository) StartTcpResponseProxy() {
+	listenAddr := ":" + r.accessConfig.ResponsePort
+	listener, err := Listen(listenAddr)
 	if err != nil {
 		log.Fatalf("[Access-CRITICAL] TCP Response Proxy failed to listen on port %s: %v", r.accessConfig.ResponsePort, err)
 	}
 	defer listener.Close()
+	r.serverMu.Lock()
+	r.responseListener = listener
+	r.serverMu.Unlock()
 
 	log.Printf("[Access-INFO] TCP Response Proxy started and listening on port %s", r.accessConfig.ResponsePort)
 
@@ -872,8 +1186,8 @@ func (r *Repository) handleTcpResponse(conn net.Conn) {
 	remoteAddr := conn.RemoteAddr().String()
 	log.Printf("[Access-INFO] Handling TCP response connection from %s", remoteAddr)
 
-	// Use default SMUX config if nil is passed; consider making this configurable
-	session, err := smux.Server(conn, connection.DefaultSmuxConfig()) // Using DefaultSmuxConfig from connection package
+	// Performs the mutual TLS handshake (when this node holds an identity) before SMUX
+	session, err := connection.NewServerSession(conn)
 	if err != nil {
 		log.Printf("[Access-ERROR] Failed to establish SMUX server session for %s: %v", remoteAddr, err)
 		conn.Close() // Ensure connection is closed on SMUX setup failure
@@ -912,8 +1226,7 @@ func (r *Repository) handleResponseFromStream(stream *smux.Stream) {
 	log.Printf("[Access-INFO] Handling response from SMUX stream: %s", streamIDInfo)
 
 	// Set a read deadline for the stream to prevent indefinite blocking
-	// TODO: Make this timeout configurable
-	stream.SetReadDeadline(time.Now().Add(30 * time.Second))
+	stream.SetReadDeadline(time.Now().Add(currentTimeouts().ResponseRead))
 
 	// Initial buffer size, can be tuned.
 	// Using bytes.Buffer for easier appending and final []byte conversion.
@@ -921,8 +1234,22 @@ func (r *Repository) handleResponseFromStream(stream *smux.Stream) {
 	readBuf := make([]byte, 8192) // Temporary buffer for each Read call
 	var totalRead int64
 
-	for {
-		n, err := stream.Read(readBuf)
+	// The packet type decides how the rest of the stream is read
+	n, err := io.ReadFull(stream, readBuf[:packet.TypePeekLen])
+	if packet.IsStreamPacket(readBuf[:n]) {
+		// Streamed response: hand the stream over instead of accumulating it
+		r.handleStreamingResponse(stream, readBuf[:n])
+		return
+	}
+	if packet.IsDatagramPacket(readBuf[:n]) {
+		r.receiveDatagramReturns(stream, readBuf[:n])
+		return
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	for ; ; n, err = stream.Read(readBuf) {
 		if n > 0 {
 			if _, writeErr := dataBuffer.Write(readBuf[:n]); writeErr != nil {
 				log.Printf("[Access-ERROR] Failed to write to dataBuffer from SMUX stream %s: %v", streamIDInfo, writeErr)
@@ -959,12 +1286,13 @@ func (r *Repository) handleResponseFromStream(stream *smux.Stream) {
 	respItem := &ResponseItem{
 		Data:       dataBuffer.Bytes(), // Get all collected bytes
 		ReceivedAt: time.Now(),
+		RemoteAddr: stream.RemoteAddr().String(),
 	}
 
 	select {
 	case r.smuxResponseChan <- respItem:
 		log.Printf("[Access-DEBUG] Sent %d bytes from SMUX stream %s to smuxResponseChan.", totalRead, streamIDInfo)
-	case <-time.After(5 * time.Second): // TODO: Make this timeout configurable
+	case <-time.After(currentTimeouts().QueueSubmit):
 		log.Printf("[Access-ERROR] Timeout sending data from SMUX stream %s (size: %d) to smuxResponseChan. Channel full or blocked?", streamIDInfo, totalRead)
 	}
 }
@@ -974,6 +1302,8 @@ func (ap *AccessProxy) Start() {
 	ap.repository.StartProcessors()
 
 	go ap.repository.StartTcpResponseProxy()
+	ap.repository.StartTunnelListeners()
+	ap.repository.StartUDPListeners()
 	// StartHttpProxy is blocking, so it should be called last in this sequence if not run in a goroutine.
 	// Or, if it's intended to be the main blocking call for the AccessProxy's Start():
 	ap.repository.StartHttpProxy()
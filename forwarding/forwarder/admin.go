@@ -0,0 +1,394 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forwarding/common"
+	"forwarding/forwarder/connection"
+	"forwarding/router"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The admin API lets an operator inspect a running node and change it without
+// a restart. It is served on a local address (see cmd/main.go) and speaks
+// JSON:
+//
+//	GET    /topology        links of the current topology in ms
+//	GET    /paths           computed paths, weights and pinned paths per domain
+//	POST   /paths/pin       {"domain": "...", "hops": ["this node", ..., "origin"]}
+//	DELETE /paths/pin       ?domain=...
+//	GET    /sessions        open SMUX sessions and idle pooled connections
+//	GET    /buffers         buffer configuration and statistics per role
+//	PATCH  /buffers/config  ?role=access|relay, fields of bufferConfigPatch
+//	GET    /requests        requests in flight
+//	GET    /drain           whether the node is draining
+//	POST   /drain           stop taking new client requests
+//	DELETE /drain           take client requests again
+
+// AdminHandler returns the handler of the admin API.
+func AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/topology", adminMethods(map[string]http.HandlerFunc{"GET": adminTopology}))
+	mux.HandleFunc("/paths", adminMethods(map[string]http.HandlerFunc{"GET": adminPaths}))
+	mux.HandleFunc("/paths/pin", adminMethods(map[string]http.HandlerFunc{"POST": adminPinPath, "DELETE": adminUnpinPath}))
+	mux.HandleFunc("/sessions", adminMethods(map[string]http.HandlerFunc{"GET": adminSessions}))
+	mux.HandleFunc("/buffers", adminMethods(map[string]http.HandlerFunc{"GET": adminBuffers}))
+	mux.HandleFunc("/buffers/config", adminMethods(map[string]http.HandlerFunc{"PATCH": adminUpdateBufferConfig}))
+	mux.HandleFunc("/requests", adminMethods(map[string]http.HandlerFunc{"GET": adminRequests}))
+	mux.HandleFunc("/drain", adminMethods(map[string]http.HandlerFunc{
+		"GET":    adminDrainStatus,
+		"POST":   func(w http.ResponseWriter, req *http.Request) { SetDraining(true); adminDrainStatus(w, req) },
+		"DELETE": func(w http.ResponseWriter, req *http.Request) { SetDraining(false); adminDrainStatus(w, req) },
+	}))
+	return mux
+}
+
+func adminMethods(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		handler, ok := handlers[req.Method]
+		if !ok {
+			methods := make([]string, 0, len(handlers))
+			for method := range handlers {
+				methods = append(methods, method)
+			}
+			sort.Strings(methods)
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			adminError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+			return
+		}
+		handler(w, req)
+	}
+}
+
+func adminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Printf("[Admin-ERROR] Failed to write response: %v", err)
+	}
+}
+
+func adminError(w http.ResponseWriter, status int, err error) {
+	adminJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func adminTopology(w http.ResponseWriter, req *http.Request) {
+	topology := common.GetInstance().GetTopology()
+	links := make(map[string]map[string]float32)
+	if topology != nil {
+		for _, node := range topology.GetAllNodes() {
+			if outgoing := topology.GetOutgoingLinks(node); len(outgoing) > 0 {
+				links[node] = outgoing
+			}
+		}
+	}
+	adminJSON(w, http.StatusOK, map[string]interface{}{"initialized": topology != nil, "links": links})
+}
+
+type adminPath struct {
+	Hops      []string `json:"hops"`
+	LatencyMs int      `json:"latency_ms"`
+	Weight    int      `json:"weight"`
+	Penalized bool     `json:"penalized,omitempty"` // failed recently, weight 0 while others are healthy
+}
+
+type adminDomainPaths struct {
+	Paths  []adminPath `json:"paths"`
+	Pinned []string    `json:"pinned,omitempty"`
+}
+
+func adminPaths(w http.ResponseWriter, req *http.Request) {
+	pm := router.GetInstance()
+	if pm == nil {
+		adminError(w, http.StatusServiceUnavailable, fmt.Errorf("path manager is not initialized"))
+		return
+	}
+	result := make(map[string]*adminDomainPaths)
+	for domain, paths := range pm.GetAllPaths() {
+		entry := &adminDomainPaths{Paths: []adminPath{}}
+		for i, p := range pm.DeprioritizeFailed(paths) {
+			entry.Paths = append(entry.Paths, adminPath{
+				Hops:      p.IPList,
+				LatencyMs: p.Latency,
+				Weight:    p.Weight,
+				Penalized: p.Weight == 0 && paths[i].Weight > 0,
+			})
+		}
+		result[domain] = entry
+	}
+	for domain, pinned := range pm.PinnedPaths() {
+		if result[domain] == nil {
+			result[domain] = &adminDomainPaths{Paths: []adminPath{}}
+		}
+		result[domain].Pinned = pinned
+	}
+	adminJSON(w, http.StatusOK, result)
+}
+
+func adminPinPath(w http.ResponseWriter, req *http.Request) {
+	var pin struct {
+		Domain string   `json:"domain"`
+		Hops   []string `json:"hops"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&pin); err != nil {
+		adminError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+		return
+	}
+	pm := router.GetInstance()
+	if pm == nil {
+		adminError(w, http.StatusServiceUnavailable, fmt.Errorf("path manager is not initialized"))
+		return
+	}
+	if err := pm.PinPath(pin.Domain, pin.Hops); err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	adminPaths(w, req)
+}
+
+func adminUnpinPath(w http.ResponseWriter, req *http.Request) {
+	domain := req.URL.Query().Get("domain")
+	pm := router.GetInstance()
+	if pm == nil || !pm.UnpinPath(domain) {
+		adminError(w, http.StatusNotFound, fmt.Errorf("no path pinned for %q", domain))
+		return
+	}
+	adminPaths(w, req)
+}
+
+func adminSessions(w http.ResponseWriter, req *http.Request) {
+	sessions := connection.ListSessions()
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Direction != sessions[j].Direction {
+			return sessions[i].Direction > sessions[j].Direction // outbound first
+		}
+		return sessions[i].Peer < sessions[j].Peer
+	})
+	if sessions == nil {
+		sessions = []connection.SessionInfo{}
+	}
+	adminJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions":         sessions,
+		"idle_connections": connection.PoolSizes(),
+	})
+}
+
+type adminBufferConfig struct {
+	BuffersPerPath       int               `json:"buffers_per_path"`
+	MaxRequestsPerBuffer int               `json:"max_requests_per_buffer"`
+	MaxBufferSize        int               `json:"max_buffer_size"`
+	MaxWaitTime          string            `json:"max_wait_time"`
+	ClassMaxWaitTime     map[string]string `json:"class_max_wait_time"`
+	MinIdleTime          string            `json:"min_idle_time"`
+	MaxPathIdleTime      string            `json:"max_path_idle_time"`
+}
+
+func newAdminBufferConfig(config BufferConfig) adminBufferConfig {
+	classes := make(map[string]string, len(config.ClassMaxWaitTime))
+	for class, wait := range config.ClassMaxWaitTime {
+		classes[TrafficClassName(class)] = wait.String()
+	}
+	return adminBufferConfig{
+		BuffersPerPath:       config.BuffersPerPath,
+		MaxRequestsPerBuffer: config.MaxRequestsPerBuffer,
+		MaxBufferSize:        config.MaxBufferSize,
+		MaxWaitTime:          config.MaxWaitTime.String(),
+		ClassMaxWaitTime:     classes,
+		MinIdleTime:          config.MinIdleTime.String(),
+		MaxPathIdleTime:      config.MaxPathIdleTime.String(),
+	}
+}
+
+func adminBuffers(w http.ResponseWriter, req *http.Request) {
+	type roleBuffers struct {
+		Config adminBufferConfig `json:"config"`
+		Stats  BufferStats       `json:"stats"`
+	}
+	result := make(map[string]roleBuffers)
+	statsSources.Lock()
+	for role, bm := range statsSources.buffers {
+		result[role] = roleBuffers{Config: newAdminBufferConfig(bm.currentConfig()), Stats: bm.GetStats()}
+	}
+	statsSources.Unlock()
+	adminJSON(w, http.StatusOK, result)
+}
+
+// bufferConfigPatch holds the BufferConfig fields an operator may change;
+// fields left out keep their value. Durations use time.ParseDuration syntax.
+type bufferConfigPatch struct {
+	BuffersPerPath       *int    `json:"buffers_per_path"`
+	MaxRequestsPerBuffer *int    `json:"max_requests_per_buffer"`
+	MaxBufferSize        *int    `json:"max_buffer_size"`
+	MaxWaitTime          *string `json:"max_wait_time"`
+}
+
+func (p bufferConfigPatch) apply(config BufferConfig) (BufferConfig, error) {
+	if p.BuffersPerPath != nil {
+		config.BuffersPerPath = *p.BuffersPerPath
+	}
+	if p.MaxRequestsPerBuffer != nil {
+		config.MaxRequestsPerBuffer = *p.MaxRequestsPerBuffer
+	}
+	if p.MaxBufferSize != nil {
+		config.MaxBufferSize = *p.MaxBufferSize
+	}
+	if p.MaxWaitTime != nil {
+		wait, err := time.ParseDuration(*p.MaxWaitTime)
+		if err != nil {
+			return config, fmt.Errorf("invalid max_wait_time %q", *p.MaxWaitTime)
+		}
+		config.MaxWaitTime = wait
+	}
+	// The class map is shared with the running config, so it is copied
+	classes := make(map[byte]time.Duration, len(config.ClassMaxWaitTime))
+	for class, wait := range config.ClassMaxWaitTime {
+		classes[class] = wait
+	}
+	config.ClassMaxWaitTime = classes
+	return config, config.Validate()
+}
+
+// sendBufferConfig hands config to bm, which applies it through updateConfiguration.
+func sendBufferConfig(role string, bm *BufferManager, config BufferConfig) error {
+	select {
+	case bm.ConfigUpdateChan <- config:
+		log.Printf("[Config-INFO] Buffer config of %s updated: buffers=%d, maxReqs=%d, maxSize=%d, maxWait=%v",
+			role, config.BuffersPerPath, config.MaxRequestsPerBuffer, config.MaxBufferSize, config.MaxWaitTime)
+		return nil
+	case <-time.After(time.Second):
+		return fmt.Errorf("buffer manager of %s did not take the update", role)
+	}
+}
+
+// ApplyBufferConfig replaces the buffer configuration of the running access
+// and relay proxies. With keepTuned the settings the tuner owns keep their
+// running values, so a reload does not undo the tuner's choice.
+func ApplyBufferConfig(config BufferConfig, keepTuned bool) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	statsSources.Lock()
+	managers := make(map[string]*BufferManager, len(statsSources.buffers))
+	for role, bm := range statsSources.buffers {
+		managers[role] = bm
+	}
+	statsSources.Unlock()
+	var errs []error
+	for role, bm := range managers {
+		config := config
+		if keepTuned {
+			bm.ConfigLock.RLock()
+			config.MaxWaitTime = bm.Config.MaxWaitTime
+			config.MaxRequestsPerBuffer = bm.Config.MaxRequestsPerBuffer
+			config.BuffersPerPath = bm.Config.BuffersPerPath
+			bm.ConfigLock.RUnlock()
+		}
+		if err := sendBufferConfig(role, bm, config); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func adminUpdateBufferConfig(w http.ResponseWriter, req *http.Request) {
+	var patch bufferConfigPatch
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		adminError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+		return
+	}
+	role := req.URL.Query().Get("role")
+
+	statsSources.Lock()
+	managers := make(map[string]*BufferManager)
+	for r, bm := range statsSources.buffers {
+		if role == "" || role == r {
+			managers[r] = bm
+		}
+	}
+	statsSources.Unlock()
+	if len(managers) == 0 {
+		adminError(w, http.StatusNotFound, fmt.Errorf("no buffer manager for role %q", role))
+		return
+	}
+
+	updates := make(map[string]BufferConfig, len(managers))
+	for r, bm := range managers {
+		config, err := patch.apply(bm.currentConfig())
+		if err != nil {
+			adminError(w, http.StatusBadRequest, err)
+			return
+		}
+		updates[r] = config
+	}
+	for r, config := range updates {
+		if err := sendBufferConfig(r, managers[r], config); err != nil {
+			adminError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+	}
+	adminJSON(w, http.StatusAccepted, adminBufferConfigs(updates))
+}
+
+func adminBufferConfigs(updates map[string]BufferConfig) map[string]adminBufferConfig {
+	result := make(map[string]adminBufferConfig, len(updates))
+	for role, config := range updates {
+		result[role] = newAdminBufferConfig(config)
+	}
+	return result
+}
+
+type adminRequest struct {
+	RequestID uint32   `json:"request_id"`
+	Role      string   `json:"role"`
+	Status    string   `json:"status"`
+	Age       string   `json:"age"`
+	Method    string   `json:"method,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	Size      int      `json:"size"`
+	Class     string   `json:"class"`
+	NextHop   string   `json:"next_hop,omitempty"`
+	HopList   []string `json:"hop_list,omitempty"`
+}
+
+func adminRequests(w http.ResponseWriter, req *http.Request) {
+	now := time.Now()
+	requests := []adminRequest{}
+	eachStateManager(func(role string, sm *RequestStateManager) {
+		for _, state := range sm.InFlight() {
+			state.mu.RLock()
+			info := adminRequest{
+				RequestID: state.RequestID,
+				Role:      role,
+				Status:    state.Status.String(),
+				Age:       now.Sub(state.CreatedAt).Truncate(time.Millisecond).String(),
+				Size:      state.Size,
+				Class:     TrafficClassName(state.Priority),
+				NextHop:   state.NextHopIP,
+			}
+			if state.OriginalRequest != nil {
+				info.Method = state.OriginalRequest.Method
+				info.URL = state.OriginalRequest.Host + state.OriginalRequest.URL.RequestURI()
+			}
+			for _, hop := range state.HopList {
+				info.HopList = append(info.HopList, hop.String())
+			}
+			state.mu.RUnlock()
+			requests = append(requests, info)
+		}
+	})
+	sort.Slice(requests, func(i, j int) bool { return requests[i].RequestID < requests[j].RequestID })
+	adminJSON(w, http.StatusOK, requests)
+}
+
+func adminDrainStatus(w http.ResponseWriter, req *http.Request) {
+	adminJSON(w, http.StatusOK, map[string]bool{"draining": Draining()})
+}
@@ -0,0 +1,96 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminCall(t *testing.T, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	AdminHandler().ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func TestAdminBufferConfigAndRequests(t *testing.T) {
+	stateManager := NewRequestStateManager(time.Minute, time.Minute)
+	defer stateManager.Stop()
+	bufferManager := NewBufferManager(DefaultBufferConfig(), stateManager)
+	defer bufferManager.Stop()
+	registerStatsSource("access", bufferManager, stateManager)
+
+	if rec := adminCall(t, "PATCH", "/buffers/config?role=access", `{"max_requests_per_buffer": 0}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid value answered %d", rec.Code)
+	}
+	if rec := adminCall(t, "PATCH", "/buffers/config?role=access", `{"max_wait": "5ms"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown field answered %d", rec.Code)
+	}
+	rec := adminCall(t, "PATCH", "/buffers/config?role=access", `{"max_requests_per_buffer": 8, "max_wait_time": "15ms"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("update answered %d: %s", rec.Code, rec.Body)
+	}
+	deadline := time.Now().Add(time.Second)
+	for bufferManager.currentConfig().MaxRequestsPerBuffer != 8 {
+		if time.Now().After(deadline) {
+			t.Fatal("buffer manager did not apply the update")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if config := bufferManager.currentConfig(); config.MaxWaitTime != 15*time.Millisecond || config.BuffersPerPath != DefaultBufferConfig().BuffersPerPath {
+		t.Fatalf("config after update %+v", config)
+	}
+
+	stateManager.AddState(&RequestState{RequestID: 7, Status: StatusPending, CreatedAt: time.Now(), Size: 120, NextHopIP: "10.0.0.2"})
+	stateManager.AddState(&RequestState{RequestID: 8, Status: StatusCompleted, CreatedAt: time.Now()})
+	var requests []adminRequest
+	if err := json.Unmarshal(adminCall(t, "GET", "/requests", "").Body.Bytes(), &requests); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].RequestID != 7 || requests[0].Status != "Pending" || requests[0].NextHop != "10.0.0.2" {
+		t.Fatalf("in-flight requests %+v", requests)
+	}
+}
+
+func TestApplyBufferConfigKeepsTuned(t *testing.T) {
+	stateManager := NewRequestStateManager(time.Minute, time.Minute)
+	defer stateManager.Stop()
+	tuned := DefaultBufferConfig()
+	tuned.MaxWaitTime = 7 * time.Millisecond
+	tuned.MaxRequestsPerBuffer = 16
+	bufferManager := NewBufferManager(tuned, stateManager)
+	defer bufferManager.Stop()
+	registerStatsSource("access", bufferManager, stateManager)
+
+	reloaded := DefaultBufferConfig()
+	reloaded.MaxBufferSize = tuned.MaxBufferSize * 2
+	if err := ApplyBufferConfig(reloaded, true); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for bufferManager.currentConfig().MaxBufferSize != reloaded.MaxBufferSize {
+		if time.Now().After(deadline) {
+			t.Fatal("buffer manager did not apply the reload")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if config := bufferManager.currentConfig(); config.MaxWaitTime != tuned.MaxWaitTime || config.MaxRequestsPerBuffer != tuned.MaxRequestsPerBuffer {
+		t.Fatalf("reload replaced the tuned settings: %+v", config)
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	defer SetDraining(false)
+	if rec := adminCall(t, "PUT", "/drain", ""); rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "DELETE, GET, POST" {
+		t.Fatalf("PUT /drain answered %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+	if rec := adminCall(t, "POST", "/drain", ""); !strings.Contains(rec.Body.String(), `"draining": true`) || !Draining() {
+		t.Fatalf("POST /drain answered %s", rec.Body)
+	}
+	if rec := adminCall(t, "DELETE", "/drain", ""); !strings.Contains(rec.Body.String(), `"draining": false`) || Draining() {
+		t.Fatalf("DELETE /drain answered %s", rec.Body)
+	}
+}
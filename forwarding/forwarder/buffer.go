@@ -4,21 +4,25 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	packet "forwarding/packet_handler"
+	"forwarding/tracing"
 )
 
 type BufferConfig struct {
-	BuffersPerPath       int           // （InitialBuffersPerPathMaxBuffersPerPath）
-	MinIdleTime          time.Duration // （60s）
-	MaxPathIdleTime      time.Duration // （300s）
-	MaxRequestsPerBuffer int           // （10）
-	MaxBufferSize        int           // （1MB）
-	MaxWaitTime          time.Duration // （100ms）
-	StatisticsInterval   time.Duration // （5s）
-	CleanupInterval      time.Duration // （30s）
+	BuffersPerPath       int                    // （InitialBuffersPerPathMaxBuffersPerPath）
+	MinIdleTime          time.Duration          // （60s）
+	MaxPathIdleTime      time.Duration          // （300s）
+	MaxRequestsPerBuffer int                    // （10）
+	MaxBufferSize        int                    // （1MB）
+	MaxWaitTime          time.Duration          // （100ms）
+	ClassMaxWaitTime     map[byte]time.Duration // merge window per traffic class, MaxWaitTime if unset
+	StatisticsInterval   time.Duration          // （5s）
+	CleanupInterval      time.Duration          // （30s）
 }
 
 func DefaultBufferConfig() BufferConfig {
@@ -29,11 +33,59 @@ func DefaultBufferConfig() BufferConfig {
 		MaxRequestsPerBuffer: 1,
 		MaxBufferSize:        1300, // MTU-tcpheader-ipheader-HEADER
 		MaxWaitTime:          5 * time.Millisecond,
-		StatisticsInterval:   5 * time.Second,
-		CleanupInterval:      30 * time.Second,
+		ClassMaxWaitTime: map[byte]time.Duration{
+			packet.PriorityInteractive: 0, // never held for merging
+			packet.PriorityBulk:        20 * time.Millisecond,
+		},
+		StatisticsInterval: 5 * time.Second,
+		CleanupInterval:    30 * time.Second,
 	}
 }
 
+func (c BufferConfig) Validate() error {
+	for name, v := range map[string]int{
+		"buffers_per_path":        c.BuffersPerPath,
+		"max_requests_per_buffer": c.MaxRequestsPerBuffer,
+		"max_buffer_size":         c.MaxBufferSize,
+	} {
+		if v <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", name, v)
+		}
+	}
+	for name, d := range map[string]time.Duration{
+		"min_idle_time":       c.MinIdleTime,
+		"max_path_idle_time":  c.MaxPathIdleTime,
+		"statistics_interval": c.StatisticsInterval,
+		"cleanup_interval":    c.CleanupInterval,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", name, d)
+		}
+	}
+	if c.MaxWaitTime < 0 {
+		return fmt.Errorf("max_wait_time must not be negative, got %s", c.MaxWaitTime)
+	}
+	for class, wait := range c.ClassMaxWaitTime {
+		if wait < 0 {
+			return fmt.Errorf("max wait time of class %s must not be negative, got %s", TrafficClassName(class), wait)
+		}
+	}
+	return nil
+}
+
+// waitTimeFor returns the merge window of a traffic class.
+func (c *BufferConfig) waitTimeFor(priority byte) time.Duration {
+	if wait, ok := c.ClassMaxWaitTime[packet.NormalizePriority(priority)]; ok {
+		return wait
+	}
+	return c.MaxWaitTime
+}
+
+// bufferKey separates the buffers of different traffic classes on the same path.
+func bufferKey(pathHash string, priority byte) string {
+	return fmt.Sprintf("%s/%s", pathHash, TrafficClassName(priority))
+}
+
 type BufferStats struct {
 	TotalRequests       int64
 	MergedRequests      int64
@@ -43,10 +95,14 @@ type BufferStats struct {
 	ActivePaths         int
 	TotalBuffers        int
 	BufferUtilization   float64
+	CompressedBytes     int64   // payload bytes sent compressed, before compression
+	CompressedWireBytes int64   // the same payloads after compression
+	CompressionRatio    float64 // CompressedBytes / CompressedWireBytes
 }
 
 type RequestBuffer struct {
 	BufferID         string
+	Class            byte
 	Requests         []*RequestState
 	FirstRequestTime time.Time
 	LastAccessTime   time.Time
@@ -58,6 +114,7 @@ type RequestBuffer struct {
 
 type ResponseBuffer struct {
 	BufferID          string
+	Class             byte
 	Responses         []*BufferedResponse
 	FirstResponseTime time.Time
 	LastAccessTime    time.Time
@@ -65,7 +122,7 @@ type ResponseBuffer struct {
 	IsFlushing        bool
 	Mutex             sync.Mutex
 	Config            *BufferConfig
-	CommonHopList     []uint32
+	CommonHopList     []netip.Addr
 }
 
 type BufferedResponse struct {
@@ -73,11 +130,15 @@ type BufferedResponse struct {
 	ResponseData []byte
 	Size         int
 	ReceivedAt   time.Time
-	HopList      []uint32
+	HopList      []netip.Addr
+	Priority     byte
+	Trace        tracing.TraceContext
+	HopStamps    []packet.HopStamp
 }
 
 type RequestPathBuffers struct {
 	PathHash       string
+	Class          byte
 	Buffers        []*RequestBuffer
 	BufferCount    int
 	LastAccessTime time.Time
@@ -91,6 +152,7 @@ type RequestPathBuffers struct {
 
 type ResponsePathBuffers struct {
 	PathHash        string
+	Class           byte
 	Buffers         []*ResponseBuffer
 	BufferCount     int
 	LastAccessTime  time.Time
@@ -118,6 +180,9 @@ type BufferManager struct {
 	SendMergedRequestFunc func([]byte, string, *packet.Packet) error
 
 	SendMergedResponseFunc func(string, []byte, []byte) error // previousHopIP, headerBytes, responseBytes
+
+	compressedBytes     atomic.Int64
+	compressedWireBytes atomic.Int64
 }
 
 func NewBufferManager(config BufferConfig, stateManager *RequestStateManager) *BufferManager {
@@ -200,8 +265,9 @@ func (bm *BufferManager) adjustPathBuffers(pathHash string, targetCount int) {
 	if path.BufferCount < targetCount {
 
 		for i := path.BufferCount; i < targetCount; i++ {
-			path.Buffers = append(path.Buffers, NewRequestBuffer(
-				fmt.Sprintf("%s-%d", pathHash, i), &bm.Config))
+			buffer := NewRequestBuffer(fmt.Sprintf("%s-%d", pathHash, i), &bm.Config)
+			buffer.Class = path.Class
+			path.Buffers = append(path.Buffers, buffer)
 		}
 	} else {
 
@@ -277,8 +343,28 @@ func (bm *BufferManager) GetStats() BufferStats {
 	return bm.Stats
 }
 
+// RecordCompression counts a payload of raw bytes that went out as wire bytes.
+func (bm *BufferManager) RecordCompression(raw, wire int) {
+	bm.compressedBytes.Add(int64(raw))
+	bm.compressedWireBytes.Add(int64(wire))
+}
+
+func (bm *BufferManager) GetMaxBufferSize() int {
+	bm.ConfigLock.RLock()
+	defer bm.ConfigLock.RUnlock()
+	return bm.Config.MaxBufferSize
+}
+
+// currentConfig returns a copy of the configuration, which
+// updateConfiguration may replace at any time.
+func (bm *BufferManager) currentConfig() BufferConfig {
+	bm.ConfigLock.RLock()
+	defer bm.ConfigLock.RUnlock()
+	return bm.Config
+}
+
 func (bm *BufferManager) statisticsCollector() {
-	ticker := time.NewTicker(bm.Config.StatisticsInterval)
+	ticker := time.NewTicker(bm.currentConfig().StatisticsInterval)
 	defer ticker.Stop()
 
 	for {
@@ -299,20 +385,40 @@ func (bm *BufferManager) updateStats() {
 	stats.ActivePaths = len(bm.RequestPaths) + len(bm.ResponsePaths)
 	stats.TotalBuffers = 0
 
+	var requestBuffers []*RequestBuffer
+	var responseBuffers []*ResponseBuffer
 	for _, path := range bm.RequestPaths {
 		stats.TotalRequests += path.TotalRequests
 		stats.MergedRequests += path.MergedRequests
 		stats.TotalBuffers += path.BufferCount
+		requestBuffers = append(requestBuffers, path.Buffers...)
 	}
 
 	for _, path := range bm.ResponsePaths {
 		stats.TotalRequests += path.TotalResponses
 		stats.MergedRequests += path.MergedResponses
 		stats.TotalBuffers += path.BufferCount
+		responseBuffers = append(responseBuffers, path.Buffers...)
 	}
 
 	bm.GlobalMutex.RUnlock()
 
+	// Utilization is the fill level of all buffers relative to MaxBufferSize
+	filled := 0
+	for _, buf := range requestBuffers {
+		buf.Mutex.Lock()
+		filled += buf.CurrentSize
+		buf.Mutex.Unlock()
+	}
+	for _, buf := range responseBuffers {
+		buf.Mutex.Lock()
+		filled += buf.CurrentSize
+		buf.Mutex.Unlock()
+	}
+	if capacity := (len(requestBuffers) + len(responseBuffers)) * bm.GetMaxBufferSize(); capacity > 0 {
+		stats.BufferUtilization = float64(filled) / float64(capacity)
+	}
+
 	if stats.TotalRequests > 0 {
 		stats.MergeRatio = float64(stats.MergedRequests) / float64(stats.TotalRequests)
 	}
@@ -322,13 +428,19 @@ func (bm *BufferManager) updateStats() {
 		stats.AvgRequestsPerMerge = float64(stats.MergedRequests) / float64(stats.MergedRequests/5)
 	}
 
+	stats.CompressedBytes = bm.compressedBytes.Load()
+	stats.CompressedWireBytes = bm.compressedWireBytes.Load()
+	if stats.CompressedWireBytes > 0 {
+		stats.CompressionRatio = float64(stats.CompressedBytes) / float64(stats.CompressedWireBytes)
+	}
+
 	bm.GlobalMutex.Lock()
 	bm.Stats = stats
 	bm.GlobalMutex.Unlock()
 }
 
 func (bm *BufferManager) cleanupRoutine() {
-	ticker := time.NewTicker(bm.Config.CleanupInterval)
+	ticker := time.NewTicker(bm.currentConfig().CleanupInterval)
 	defer ticker.Stop()
 
 	for {
@@ -343,13 +455,14 @@ func (bm *BufferManager) cleanupRoutine() {
 
 func (bm *BufferManager) cleanupBuffers() {
 	now := time.Now()
+	maxIdle := bm.currentConfig().MaxPathIdleTime
 
-	bm.cleanupRequestPaths(now)
+	bm.cleanupRequestPaths(now, maxIdle)
 
-	bm.cleanupResponsePaths(now)
+	bm.cleanupResponsePaths(now, maxIdle)
 }
 
-func (bm *BufferManager) cleanupRequestPaths(now time.Time) {
+func (bm *BufferManager) cleanupRequestPaths(now time.Time, maxIdle time.Duration) {
 
 	bm.GlobalMutex.RLock()
 	pathHashes := make([]string, 0, len(bm.RequestPaths))
@@ -375,7 +488,7 @@ func (bm *BufferManager) cleanupRequestPaths(now time.Time) {
 			continue
 		}
 
-		if now.Sub(path.LastAccessTime) > bm.Config.MaxPathIdleTime {
+		if now.Sub(path.LastAccessTime) > maxIdle {
 
 			pathMutex.Lock()
 
@@ -397,7 +510,7 @@ func (bm *BufferManager) cleanupRequestPaths(now time.Time) {
 	}
 }
 
-func (bm *BufferManager) cleanupResponsePaths(now time.Time) {
+func (bm *BufferManager) cleanupResponsePaths(now time.Time, maxIdle time.Duration) {
 
 	bm.GlobalMutex.RLock()
 	pathHashes := make([]string, 0, len(bm.ResponsePaths))
@@ -423,7 +536,7 @@ func (bm *BufferManager) cleanupResponsePaths(now time.Time) {
 			continue
 		}
 
-		if now.Sub(path.LastAccessTime) > bm.Config.MaxPathIdleTime {
+		if now.Sub(path.LastAccessTime) > maxIdle {
 
 			pathMutex.Lock()
 
@@ -449,9 +562,9 @@ func (bm *BufferManager) ProcessRequest(reqState *RequestState) error {
 
 	bm.stateManager.UpdateStatus(reqState.RequestID, StatusPending)
 
-	pathHash := CalculatePathHash(reqState.HopList)
+	pathHash := bufferKey(CalculatePathHash(reqState.HopList), reqState.Priority)
 
-	buffer := bm.getRequestBuffer(pathHash, reqState.RequestID)
+	buffer := bm.getRequestBuffer(pathHash, reqState.Priority, reqState.RequestID)
 
 	shouldFlush := buffer.AddRequest(reqState)
 
@@ -473,7 +586,7 @@ func (bm *BufferManager) startPathTimeoutTimer(path *RequestPathBuffers) {
 	}
 
 	bm.ConfigLock.RLock()
-	maxWaitTime := bm.Config.MaxWaitTime
+	maxWaitTime := bm.Config.waitTimeFor(path.Class)
 	bm.ConfigLock.RUnlock()
 
 	path.TimerActive = true
@@ -493,7 +606,7 @@ func (bm *BufferManager) startResponsePathTimeoutTimer(path *ResponsePathBuffers
 	}
 
 	bm.ConfigLock.RLock()
-	maxWaitTime := bm.Config.MaxWaitTime
+	maxWaitTime := bm.Config.waitTimeFor(path.Class)
 	bm.ConfigLock.RUnlock()
 
 	path.TimerActive = true
@@ -513,7 +626,7 @@ func (bm *BufferManager) handleResponsePathTimeout(path *ResponsePathBuffers) {
 	now := time.Now()
 
 	bm.ConfigLock.RLock()
-	maxWaitTime := bm.Config.MaxWaitTime
+	maxWaitTime := bm.Config.waitTimeFor(path.Class)
 	bm.ConfigLock.RUnlock()
 
 	for _, buffer := range path.Buffers {
@@ -563,7 +676,7 @@ func (bm *BufferManager) handlePathTimeout(path *RequestPathBuffers) {
 	now := time.Now()
 
 	bm.ConfigLock.RLock()
-	maxWaitTime := bm.Config.MaxWaitTime
+	maxWaitTime := bm.Config.waitTimeFor(path.Class)
 	bm.ConfigLock.RUnlock()
 
 	for _, buffer := range path.Buffers {
@@ -650,7 +763,7 @@ func (bm *BufferManager) getLeastLoadedBufferFromSlice(buffers []*RequestBuffer)
 	return leastLoaded
 }
 
-func (bm *BufferManager) getRequestBuffer(pathHash string, requestID uint32) *RequestBuffer {
+func (bm *BufferManager) getRequestBuffer(pathHash string, class byte, requestID uint32) *RequestBuffer {
 
 	bm.GlobalMutex.RLock()
 	pathMutex, exists := bm.PathMutexes[pathHash]
@@ -681,6 +794,7 @@ func (bm *BufferManager) getRequestBuffer(pathHash string, requestID uint32) *Re
 
 			path = &RequestPathBuffers{
 				PathHash:       pathHash,
+				Class:          class,
 				Buffers:        make([]*RequestBuffer, buffersPerPath),
 				BufferCount:    buffersPerPath,
 				LastAccessTime: time.Now(),
@@ -691,6 +805,7 @@ func (bm *BufferManager) getRequestBuffer(pathHash string, requestID uint32) *Re
 
 			for i := 0; i < buffersPerPath; i++ {
 				path.Buffers[i] = NewRequestBuffer(fmt.Sprintf("%s-%d", pathHash, i), &bm.Config)
+				path.Buffers[i].Class = class
 			}
 
 			bm.GlobalMutex.Lock()
@@ -729,7 +844,7 @@ func (bm *BufferManager) getRequestBuffer(pathHash string, requestID uint32) *Re
 	return buffer
 }
 
-func (bm *BufferManager) getResponseBuffer(pathHash string, requestID uint32) *ResponseBuffer {
+func (bm *BufferManager) getResponseBuffer(pathHash string, class byte, requestID uint32) *ResponseBuffer {
 
 	bm.GlobalMutex.RLock()
 	pathMutex, exists := bm.PathMutexes[pathHash]
@@ -760,6 +875,7 @@ func (bm *BufferManager) getResponseBuffer(pathHash string, requestID uint32) *R
 
 			path = &ResponsePathBuffers{
 				PathHash:        pathHash,
+				Class:           class,
 				Buffers:         make([]*ResponseBuffer, buffersPerPath),
 				BufferCount:     buffersPerPath,
 				LastAccessTime:  time.Now(),
@@ -769,6 +885,7 @@ func (bm *BufferManager) getResponseBuffer(pathHash string, requestID uint32) *R
 
 			for i := 0; i < buffersPerPath; i++ {
 				path.Buffers[i] = NewResponseBuffer(fmt.Sprintf("%s-%d", pathHash, i), &bm.Config)
+				path.Buffers[i].Class = class
 			}
 
 			bm.GlobalMutex.Lock()
@@ -849,6 +966,7 @@ func (b *RequestBuffer) AddRequest(req *RequestState) bool {
 
 	req.mu.Lock()
 	req.BufferID = b.BufferID
+	req.BufferedAt = time.Now()
 	req.mu.Unlock()
 
 	return b.shouldTriggerFlush()
@@ -882,15 +1000,16 @@ func (b *RequestBuffer) shouldTriggerFlush() bool {
 		return true
 	}
 
+	maxWaitTime := b.Config.waitTimeFor(b.Class)
 	if !b.FirstRequestTime.IsZero() &&
-		time.Since(b.FirstRequestTime) >= b.Config.MaxWaitTime {
+		time.Since(b.FirstRequestTime) >= maxWaitTime {
 		return true
 	}
 
 	log.Printf("[BUFFER-DETAIL] =%s : =%d/%d, =%d/%d, =%v/%v",
 		b.BufferID, len(b.Requests), b.Config.MaxRequestsPerBuffer,
 		b.CurrentSize, b.Config.MaxBufferSize,
-		time.Since(b.FirstRequestTime), b.Config.MaxWaitTime)
+		time.Since(b.FirstRequestTime), maxWaitTime)
 
 	return false
 }
@@ -918,12 +1037,12 @@ func (b *RequestBuffer) ProcessBuffer(mergeAndSendFunc func([]*RequestState)) {
 	go mergeAndSendFunc(requests)
 }
 
-func CalculatePathHash(hopList []uint32) string {
+func CalculatePathHash(hopList []netip.Addr) string {
 
 	return fmt.Sprintf("%v", hopList)
 }
 
-func CalculateResponsePathHash(hopList []uint32) string {
+func CalculateResponsePathHash(hopList []netip.Addr) string {
 	if len(hopList) <= 1 {
 		return "empty"
 	}
@@ -967,15 +1086,16 @@ func (b *ResponseBuffer) shouldTriggerFlush() bool {
 		return true
 	}
 
+	maxWaitTime := b.Config.waitTimeFor(b.Class)
 	if !b.FirstResponseTime.IsZero() &&
-		time.Since(b.FirstResponseTime) >= b.Config.MaxWaitTime {
+		time.Since(b.FirstResponseTime) >= maxWaitTime {
 		return true
 	}
 
 	log.Printf("[BUFFER-DETAIL] =%s : =%d/%d, =%d/%d, =%v/%v",
 		b.BufferID, len(b.Responses), b.Config.MaxRequestsPerBuffer,
 		b.CurrentSize, b.Config.MaxBufferSize,
-		time.Since(b.FirstResponseTime), b.Config.MaxWaitTime)
+		time.Since(b.FirstResponseTime), maxWaitTime)
 
 	return false
 }
@@ -996,17 +1116,11 @@ func (b *ResponseBuffer) FlushBuffer(mergeAndSendFunc func([]*BufferedResponse,
 	var previousHopIP string
 	if len(b.CommonHopList) > 0 {
 
-		previousHopIP = fmt.Sprintf("%d.%d.%d.%d",
-			(b.CommonHopList[0]>>24)&0xFF,
-			(b.CommonHopList[0]>>16)&0xFF,
-			(b.CommonHopList[0]>>8)&0xFF,
-			b.CommonHopList[0]&0xFF)
+		previousHopIP = b.CommonHopList[0].String()
 	} else if len(responses) > 0 && len(responses[0].HopList) > 1 {
 
 		hopIndex := len(responses[0].HopList) - 2
-		hop := responses[0].HopList[hopIndex]
-		previousHopIP = fmt.Sprintf("%d.%d.%d.%d",
-			(hop>>24)&0xFF, (hop>>16)&0xFF, (hop>>8)&0xFF, hop&0xFF)
+		previousHopIP = responses[0].HopList[hopIndex].String()
 	} else {
 		previousHopIP = "unknown"
 	}
@@ -1023,9 +1137,9 @@ func (b *ResponseBuffer) FlushBuffer(mergeAndSendFunc func([]*BufferedResponse,
 
 func (bm *BufferManager) ProcessResponse(resp *ResponseData) error {
 
-	pathHash := CalculateResponsePathHash(resp.HopList)
+	pathHash := bufferKey(CalculateResponsePathHash(resp.HopList), resp.Priority)
 
-	buffer := bm.getResponseBuffer(pathHash, resp.RequestID)
+	buffer := bm.getResponseBuffer(pathHash, resp.Priority, resp.RequestID)
 
 	bufferedResp := &BufferedResponse{
 		RequestID:    resp.RequestID,
@@ -1033,6 +1147,9 @@ func (bm *BufferManager) ProcessResponse(resp *ResponseData) error {
 		Size:         len(resp.Data),
 		ReceivedAt:   time.Now(),
 		HopList:      resp.HopList,
+		Priority:     resp.Priority,
+		Trace:        resp.Trace,
+		HopStamps:    resp.HopStamps,
 	}
 
 	shouldFlush := buffer.AddResponse(bufferedResp)
@@ -1065,8 +1182,14 @@ func (bm *BufferManager) mergeAndSendRequests(requests []*RequestState) {
 
 		req.mu.Lock()
 		req.MergeGroupID = mergeGroupID
+		bufferID, bufferedAt := req.BufferID, req.BufferedAt
 		req.mu.Unlock()
 
+		waitSpan := startRequestSpan(req, "buffer.merge_wait", tracing.KindInternal, bufferedAt)
+		waitSpan.SetAttribute("arcturus.buffer_id", bufferID)
+		waitSpan.SetAttribute("arcturus.merged_requests", len(requests))
+		waitSpan.End()
+
 		bm.stateManager.UpdateStatus(req.RequestID, StatusSent)
 	}
 
@@ -1082,6 +1205,7 @@ func (bm *BufferManager) mergeAndSendRequests(requests []*RequestState) {
 	} else {
 
 		headerToUse = packet.NewMergedPacket(packetIDs, requestSizes, firstReq.HopList, 0x01)
+		headerToUse.Priority = firstReq.Priority
 		log.Printf("[BUFFER-WARN] UpdatedHeader，，HopCounts=%d", headerToUse.HopCounts)
 	}
 
@@ -1089,11 +1213,7 @@ func (bm *BufferManager) mergeAndSendRequests(requests []*RequestState) {
 	if err != nil {
 		log.Printf("[BUFFER-ERROR] : %v", err)
 
-		for _, req := range requests {
-			if bm.SendRequestFunc != nil {
-				bm.SendRequestFunc(req.RequestData, req.NextHopIP, req.RequestID, req)
-			}
-		}
+		bm.sendEach(requests)
 		return
 	}
 
@@ -1110,19 +1230,31 @@ func (bm *BufferManager) mergeAndSendRequests(requests []*RequestState) {
 	}
 
 	if bm.SendMergedRequestFunc != nil {
+		sendSpans := startRequestSpans(requests, "hop.send", tracing.KindClient, time.Now())
+		sendSpans.setAttribute("server.address", nextHopIP)
 		err = bm.SendMergedRequestFunc(mergedData, nextHopIP, headerToUse)
+		sendSpans.end(err)
 		if err != nil {
 			log.Printf("[BUFFER-ERROR] : %v", err)
 
-			for _, req := range requests {
-				if bm.SendRequestFunc != nil {
-					bm.SendRequestFunc(req.RequestData, req.NextHopIP, req.RequestID, req)
-				}
-			}
+			bm.sendEach(requests)
 		}
 	}
 }
 
+// sendEach sends the requests one by one, when they cannot be sent merged.
+func (bm *BufferManager) sendEach(requests []*RequestState) {
+	if bm.SendRequestFunc == nil {
+		return
+	}
+	for _, req := range requests {
+		sendSpan := startRequestSpan(req, "hop.send", tracing.KindClient, time.Now())
+		sendSpan.SetAttribute("server.address", req.NextHopIP)
+		sendSpan.SetError(bm.SendRequestFunc(req.RequestData, req.NextHopIP, req.RequestID, req))
+		sendSpan.End()
+	}
+}
+
 func (bm *BufferManager) mergeAndSendBufferedResponses(responses []*BufferedResponse, previousHopIP string) {
 	if len(responses) == 0 {
 		return
@@ -1133,6 +1265,7 @@ func (bm *BufferManager) mergeAndSendBufferedResponses(responses []*BufferedResp
 	commonHopList := responses[0].HopList
 
 	respHeader := &packet.Packet{
+		Priority:    responses[0].Priority,
 		PacketCount: byte(len(responses)),
 		PacketID:    make([]uint32, len(responses)),
 		HopList:     commonHopList,
@@ -1141,18 +1274,29 @@ func (bm *BufferManager) mergeAndSendBufferedResponses(responses []*BufferedResp
 
 	respSizes := make([]int, len(responses))
 	respBodies := make([][]byte, len(responses))
+	sendSpans := make(requestSpans, len(responses))
 
 	for i, resp := range responses {
 		respHeader.PacketID[i] = resp.RequestID
 		respSizes[i] = resp.Size
 		respBodies[i] = resp.ResponseData
+
+		waitSpan := tracing.StartAt(resp.Trace, "buffer.merge_wait", tracing.KindInternal, resp.ReceivedAt)
+		waitSpan.SetAttribute(attrRequestID, resp.RequestID)
+		waitSpan.SetAttribute("arcturus.merged_requests", len(responses))
+		waitSpan.End()
+		sendSpans[i] = tracing.Start(resp.Trace, "hop.send", tracing.KindClient)
+		sendSpans[i].SetAttribute(attrRequestID, resp.RequestID)
+		sendSpans[i].SetAttribute("server.address", previousHopIP)
 	}
 
 	respHeader.Offsets = packet.CalcRelativeOffsets(respSizes)
+	respHeader.HopStamps = responseStamps(responses, respHeader.HopCounts-1)
 
 	headerBytes, err := respHeader.Pack()
 	if err != nil {
 		log.Printf("[BUFFER-ERROR] : %v", err)
+		sendSpans.end(err)
 		return
 	}
 
@@ -1171,6 +1315,7 @@ func (bm *BufferManager) mergeAndSendBufferedResponses(responses []*BufferedResp
 
 	if bm.SendMergedResponseFunc != nil {
 		err := bm.SendMergedResponseFunc(previousHopIP, headerBytes, mergedRespData)
+		sendSpans.end(err)
 		if err != nil {
 			log.Printf("[BUFFER-ERROR] : %v", err)
 		} else {
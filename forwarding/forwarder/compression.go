@@ -0,0 +1,212 @@
+package forwarder
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	packet "forwarding/packet_handler"
+	"io"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// Payloads of request and response packets are compressed per hop. Every
+// packet advertises in its Property byte the codecs its sender accepts, and a
+// node compresses towards a peer only once that peer has advertised the codec
+// in a packet of its own: requests tell a relay what the previous hop accepts,
+// responses tell the previous hop what the relay accepts. Nodes that predate
+// compression send a zero Property and keep getting raw payloads. Payloads
+// that are small or mostly already-compressed content are sent raw.
+
+type CompressionConfig struct {
+	Enabled bool
+	Codec   byte // packet.CodecDeflate
+	MinSize int  // compressible payload bytes below this are sent raw
+	Level   int  // compress/flate level
+}
+
+func DefaultCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		Enabled: true,
+		Codec:   packet.CodecDeflate,
+		MinSize: 1024,
+		Level:   flate.BestSpeed,
+	}
+}
+
+func (c CompressionConfig) Validate() error {
+	if c.MinSize < 0 {
+		return fmt.Errorf("min_size must not be negative")
+	}
+	if c.Level < flate.HuffmanOnly || c.Level > flate.BestCompression {
+		return fmt.Errorf("level must be in [%d, %d], got %d", flate.HuffmanOnly, flate.BestCompression, c.Level)
+	}
+	return nil
+}
+
+// Codecs this node can decode, advertised to its peers.
+var supportedCodecs = []byte{packet.CodecDeflate}
+
+const maxDecodedPayload = 16 << 20
+
+// Content types whose bodies do not shrink any further.
+var precompressedTypePrefixes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2",
+	"application/x-xz", "application/x-7z-compressed", "application/x-rar-compressed",
+	"application/zstd",
+}
+
+type payloadCompressor struct {
+	config  CompressionConfig
+	record  func(raw, wire int) // reports the sizes of each compressed payload
+	peers   sync.Map            // peer IP -> Property of the last packet it sent
+	writers sync.Pool           // *flate.Writer
+}
+
+func newPayloadCompressor(config CompressionConfig, record func(raw, wire int)) *payloadCompressor {
+	return &payloadCompressor{config: config, record: record}
+}
+
+func peerKey(addr string) string {
+	host, _ := hostPort(addr, "")
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.Unmap().String()
+	}
+	return host
+}
+
+// learn records the codecs a peer advertised in a packet it sent.
+func (c *payloadCompressor) learn(remoteAddr string, header *packet.Packet) {
+	if c == nil || remoteAddr == "" {
+		return
+	}
+	c.peers.Store(peerKey(remoteAddr), header.Property&0xf0)
+}
+
+func (c *payloadCompressor) peerAccepts(peer string, codec byte) bool {
+	property, ok := c.peers.Load(peerKey(peer))
+	return ok && (&packet.Packet{Property: property.(byte)}).AcceptsCodec(codec)
+}
+
+// encode prepares payload for the hop to peer. It returns the Property byte
+// for the packet's header and the payload to send after it.
+func (c *payloadCompressor) encode(header *packet.Packet, payload []byte, peer string) (byte, []byte) {
+	if c == nil || !c.config.Enabled {
+		return packet.MakeProperty(packet.CodecNone), payload
+	}
+	advertise := packet.MakeProperty(packet.CodecNone, supportedCodecs...)
+	codec := c.config.Codec
+	if codec != packet.CodecDeflate || !c.peerAccepts(peer, codec) {
+		return advertise, payload
+	}
+	if compressible := compressibleBytes(header, payload); compressible < c.config.MinSize || compressible*2 < len(payload) {
+		return advertise, payload
+	}
+
+	compressed, err := c.deflate(payload)
+	if err != nil || len(compressed) >= len(payload) {
+		return advertise, payload
+	}
+	if c.record != nil {
+		c.record(len(payload), len(compressed))
+	}
+	return packet.MakeProperty(codec, supportedCodecs...), compressed
+}
+
+func (c *payloadCompressor) deflate(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(payload) / 2)
+	writer, _ := c.writers.Get().(*flate.Writer)
+	if writer == nil {
+		var err error
+		if writer, err = flate.NewWriter(&buf, c.config.Level); err != nil {
+			return nil, fmt.Errorf("failed to create deflate writer: %w", err)
+		}
+	} else {
+		writer.Reset(&buf)
+	}
+	defer c.writers.Put(writer)
+
+	if _, err := writer.Write(payload); err != nil {
+		return nil, fmt.Errorf("failed to deflate payload: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to deflate payload: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodePayload undoes the compression the sender applied to payload.
+func decodePayload(header *packet.Packet, payload []byte) ([]byte, error) {
+	switch header.Codec() {
+	case packet.CodecNone:
+		return payload, nil
+	case packet.CodecDeflate:
+		reader := flate.NewReader(bytes.NewReader(payload))
+		defer reader.Close()
+		decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedPayload+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to inflate payload: %w", err)
+		}
+		if len(decoded) > maxDecodedPayload {
+			return nil, fmt.Errorf("inflated payload exceeds %d bytes", maxDecodedPayload)
+		}
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("unsupported payload codec %d", header.Codec())
+	}
+}
+
+// compressibleBytes returns how many payload bytes belong to HTTP messages
+// whose bodies are not compressed already.
+func compressibleBytes(header *packet.Packet, payload []byte) int {
+	if int(header.PacketCount) == 0 || len(header.Offsets) != max(int(header.PacketCount)-1, 0) {
+		return len(payload)
+	}
+	positions := packet.GetRequestPositions(header, len(payload))
+	total := 0
+	for i := 0; i < int(header.PacketCount); i++ {
+		if positions[i] > positions[i+1] || positions[i+1] > len(payload) {
+			return len(payload)
+		}
+		if message := payload[positions[i]:positions[i+1]]; !precompressed(message) {
+			total += len(message)
+		}
+	}
+	return total
+}
+
+// precompressed reports whether an HTTP message carries an encoded body or a
+// content type that is compressed by nature.
+func precompressed(message []byte) bool {
+	end := bytes.Index(message, []byte("\r\n\r\n"))
+	if end < 0 {
+		end = len(message)
+	}
+	lines := bytes.Split(message[:end], []byte("\r\n"))
+	for _, line := range lines[1:] {
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			continue
+		}
+		v := strings.ToLower(strings.TrimSpace(string(value)))
+		switch strings.ToLower(strings.TrimSpace(string(name))) {
+		case "content-encoding":
+			if v != "" && v != "identity" {
+				return true
+			}
+		case "content-type":
+			if strings.Contains(v, "svg") {
+				continue
+			}
+			for _, prefix := range precompressedTypePrefixes {
+				if strings.HasPrefix(v, prefix) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
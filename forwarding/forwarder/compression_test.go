@@ -0,0 +1,89 @@
+package forwarder
+
+import (
+	"bytes"
+	"fmt"
+	packet "forwarding/packet_handler"
+	"strings"
+	"testing"
+)
+
+func mergedTestPayload(t *testing.T, contentType string) (*packet.Packet, []byte) {
+	t.Helper()
+	hops, err := packet.ParseHopList([]string{"10.0.0.1", "10.0.0.2", "192.0.2.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload []byte
+	var sizes []int
+	for i := 0; i < 2; i++ {
+		body := strings.Repeat(fmt.Sprintf("row %d of a very repetitive report\n", i), 60)
+		message := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", contentType, len(body), body)
+		payload = append(payload, message...)
+		sizes = append(sizes, len(message))
+	}
+	return packet.NewMergedPacket([]uint32{1, 2}, sizes, hops, packet.PacketTypeData), payload
+}
+
+func TestPayloadCompressionNegotiation(t *testing.T) {
+	var raw, wire int
+	c := newPayloadCompressor(DefaultCompressionConfig(), func(r, w int) { raw, wire = raw+r, wire+w })
+	header, payload := mergedTestPayload(t, "text/csv")
+
+	// Nothing is known about the peer yet, so only the advertisement goes out
+	property, out := c.encode(header, payload, "10.0.0.2")
+	if !bytes.Equal(out, payload) || (&packet.Packet{Property: property}).Codec() != packet.CodecNone {
+		t.Fatal("payload compressed before the peer advertised a codec")
+	}
+	if !(&packet.Packet{Property: property}).AcceptsCodec(packet.CodecDeflate) {
+		t.Fatalf("Property %08b does not advertise deflate", property)
+	}
+
+	c.learn("10.0.0.2:50057", &packet.Packet{Property: packet.MakeProperty(packet.CodecNone, packet.CodecDeflate)})
+	header.Property, out = c.encode(header, payload, "10.0.0.2")
+	if header.Codec() != packet.CodecDeflate || len(out) >= len(payload) {
+		t.Fatalf("codec %d, %d bytes from %d", header.Codec(), len(out), len(payload))
+	}
+	if raw != len(payload) || wire != len(out) {
+		t.Fatalf("recorded %d -> %d, want %d -> %d", raw, wire, len(payload), len(out))
+	}
+
+	headerBytes, err := header.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := packet.Unpack(headerBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodePayload(received, out)
+	if err != nil || !bytes.Equal(decoded, payload) {
+		t.Fatalf("decoded %d bytes, %v", len(decoded), err)
+	}
+
+	// A peer that stops advertising the codec gets raw payloads again
+	c.learn("10.0.0.2:50057", &packet.Packet{})
+	if property, _ := c.encode(header, payload, "10.0.0.2"); (&packet.Packet{Property: property}).Codec() != packet.CodecNone {
+		t.Fatal("payload compressed for a peer that no longer accepts it")
+	}
+}
+
+func TestPayloadCompressionSkipsCompressedContent(t *testing.T) {
+	c := newPayloadCompressor(DefaultCompressionConfig(), nil)
+	c.learn("10.0.0.2", &packet.Packet{Property: packet.MakeProperty(packet.CodecNone, packet.CodecDeflate)})
+
+	header, payload := mergedTestPayload(t, "image/png")
+	if property, out := c.encode(header, payload, "10.0.0.2"); (&packet.Packet{Property: property}).Codec() != packet.CodecNone || !bytes.Equal(out, payload) {
+		t.Fatal("already-compressed content was compressed again")
+	}
+
+	small := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	single := &packet.Packet{PacketCount: 1, PacketID: []uint32{3}}
+	if property, _ := c.encode(single, small, "10.0.0.2"); (&packet.Packet{Property: property}).Codec() != packet.CodecNone {
+		t.Fatal("payload below MinSize was compressed")
+	}
+
+	if _, err := decodePayload(&packet.Packet{Property: 0x0f}, small); err == nil {
+		t.Fatal("unknown codec was accepted")
+	}
+}
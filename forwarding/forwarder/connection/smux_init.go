@@ -2,8 +2,11 @@ package connection
 
 import (
 	"fmt"
+	"forwarding/metrics_processing/exporter"
+	"forwarding/metrics_processing/fault"
 	"github.com/xtaci/smux"
 	"log"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,11 +26,30 @@ var (
 	}
 
 	sessionCounter uint64 = 0
+
+	sessionsPerPeer atomic.Int32 // outbound sessions requests to one next hop are spread over, 1 if unset
 )
 
+// SetSessionsPerPeer sets how many SMUX sessions are opened to each next hop.
+// Sessions beyond a lowered count stay open but get no new streams.
+func SetSessionsPerPeer(n int) {
+	if n < 1 {
+		n = 1
+	}
+	sessionsPerPeer.Store(int32(n))
+	log.Printf("[Connection-INFO] SMUX sessions per peer set to %d", n)
+}
+
+func SessionsPerPeer() int {
+	if n := sessionsPerPeer.Load(); n > 0 {
+		return int(n)
+	}
+	return 1
+}
+
 func DefaultSmuxConfig() *smux.Config {
 	return &smux.Config{
-		Version:           1,
+		Version:           1, // v2 sessions are refused by nodes running v1; streamed bodies bring their own window, see packet.CreditWriter
 		KeepAliveInterval: 5 * time.Second,
 		KeepAliveTimeout:  30 * time.Second,
 		MaxFrameSize:      65535,
@@ -48,8 +70,8 @@ func GetOrCreateClientSession(targetAddr string, config ...*smux.Config) (*smux.
 		}
 	}
 
-	if len(validSessions) > 0 {
-
+	if perPeer := SessionsPerPeer(); len(validSessions) >= perPeer {
+		validSessions = validSessions[:perPeer]
 		index := atomic.AddUint64(&sessionCounter, 1) % uint64(len(validSessions))
 		session := validSessions[index]
 		clientSessionPool.mu.RUnlock()
@@ -59,11 +81,13 @@ func GetOrCreateClientSession(targetAddr string, config ...*smux.Config) (*smux.
 
 	pool, err := GetOrCreatePool(targetAddr)
 	if err != nil {
+		fault.GetDetector().RecordSessionResult(targetAddr, err)
 		return nil, fmt.Errorf(": %v", err)
 	}
 
 	conn, err := (*pool).Get()
 	if err != nil {
+		fault.GetDetector().RecordSessionResult(targetAddr, err)
 		return nil, fmt.Errorf(": %v", err)
 	}
 
@@ -77,8 +101,10 @@ func GetOrCreateClientSession(targetAddr string, config ...*smux.Config) (*smux.
 	session, err := smux.Client(conn, smuxConfig)
 	if err != nil {
 		conn.Close()
+		fault.GetDetector().RecordSessionResult(targetAddr, err)
 		return nil, fmt.Errorf("SMUX: %v", err)
 	}
+	fault.GetDetector().RecordSessionResult(targetAddr, nil)
 
 	clientSessionPool.mu.Lock()
 
@@ -223,3 +249,44 @@ func CloseAllSessions() {
 
 	log.Println("SMUX")
 }
+
+// SessionInfo describes an open SMUX session.
+type SessionInfo struct {
+	Peer      string `json:"peer"`
+	Direction string `json:"direction"` // outbound to a next hop, inbound from a previous hop
+	Streams   int    `json:"streams"`
+}
+
+// ListSessions returns the open client and server sessions.
+func ListSessions() []SessionInfo {
+	var infos []SessionInfo
+	for _, pool := range []struct {
+		*SmuxSessionPool
+		direction string
+	}{{clientSessionPool, "outbound"}, {serverSessionPool, "inbound"}} {
+		pool.mu.RLock()
+		for addr, sessions := range pool.sessions {
+			for _, session := range sessions {
+				if session != nil && !session.IsClosed() {
+					infos = append(infos, SessionInfo{Peer: addr, Direction: pool.direction, Streams: session.NumStreams()})
+				}
+			}
+		}
+		pool.mu.RUnlock()
+	}
+	return infos
+}
+
+func init() {
+	exporter.Default.GaugeFunc("arcturus_smux_sessions", "Open SMUX sessions per peer, outbound to next hops and inbound from previous hops.",
+		[]string{"peer", "direction"}, func(emit func(float64, ...string)) {
+			// Server sessions are keyed by the remote address, whose port changes per connection
+			for _, session := range ListSessions() {
+				host, _, err := net.SplitHostPort(session.Peer)
+				if err != nil {
+					host = session.Peer
+				}
+				emit(1, host, session.Direction)
+			}
+		})
+}
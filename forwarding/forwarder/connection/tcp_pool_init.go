@@ -22,7 +22,11 @@ var (
 
 var CreateConnectionFactory = func(addr string) Factory {
 	return func() (net.Conn, error) {
-		return net.Dial("tcp", addr)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return secureClientConn(conn, addr)
 	}
 }
 
@@ -60,3 +64,14 @@ func GetOrCreatePool(targetAddr string, config ...PoolConfig) (*Pool, error) {
 
 	return &newPool, nil
 }
+
+// PoolSizes returns the number of idle connections in the pool of each target.
+func PoolSizes() map[string]int {
+	poolMu.RLock()
+	defer poolMu.RUnlock()
+	sizes := make(map[string]int, len(poolMap))
+	for addr, pool := range poolMap {
+		sizes[addr] = (*pool).Len()
+	}
+	return sizes
+}
@@ -0,0 +1,190 @@
+package connection
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// Inter-node links (relay request/response ports and the access response
+// port) run smux over mutual TLS once the node holds an identity issued by
+// the controller: a certificate for its IP signed by the controller CA. Both
+// ends verify the peer's chain against that CA and that the certificate was
+// issued for the IP the connection goes to or comes from.
+//
+// Mutual TLS is optional by default so that nodes can be upgraded while
+// their peers still speak plaintext: a node dials with TLS only once it
+// holds an identity and accepts both kinds of connection. RequireMutualTLS
+// is meant to be turned on once every node holds an identity.
+
+type TransportSecurityConfig struct {
+	RequireMutualTLS bool // refuse plaintext peers, and refuse to dial before an identity is installed
+	HandshakeTimeout time.Duration
+}
+
+var DefaultTransportSecurityConfig = TransportSecurityConfig{
+	RequireMutualTLS: false,
+	HandshakeTimeout: 10 * time.Second,
+}
+
+func (c TransportSecurityConfig) Validate() error {
+	if c.HandshakeTimeout <= 0 {
+		return fmt.Errorf("handshake_timeout must be positive")
+	}
+	return nil
+}
+
+// SetTransportSecurityConfig replaces the settings used by inter-node
+// connections. It is called once at startup, before any connection is made.
+func SetTransportSecurityConfig(config TransportSecurityConfig) {
+	DefaultTransportSecurityConfig = config
+}
+
+const tlsRecordTypeHandshake = 0x16 // first byte of a ClientHello; smux frames start with their version
+
+var errNoNodeIdentity = errors.New("no node identity installed yet")
+
+type nodeIdentity struct {
+	cert  tls.Certificate
+	roots *x509.CertPool
+}
+
+var currentIdentity atomic.Pointer[nodeIdentity]
+
+// SetNodeIdentity installs the certificate this node presents and the CA it
+// trusts. It is called again on renewal; established sessions are kept, new
+// connections use the new certificate.
+func SetNodeIdentity(cert tls.Certificate, roots *x509.CertPool) {
+	currentIdentity.Store(&nodeIdentity{cert: cert, roots: roots})
+}
+
+func HasNodeIdentity() bool {
+	return currentIdentity.Load() != nil
+}
+
+// secureClientConn runs the client side of the handshake on a freshly dialed
+// connection to addr. The server certificate must be issued for addr's IP.
+func secureClientConn(conn net.Conn, addr string) (net.Conn, error) {
+	id := currentIdentity.Load()
+	if id == nil {
+		if DefaultTransportSecurityConfig.RequireMutualTLS {
+			conn.Close()
+			return nil, fmt.Errorf("dial %s: %w", addr, errNoNodeIdentity)
+		}
+		return conn, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("invalid target address %s: %w", addr, err)
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{id.cert},
+		RootCAs:      id.roots,
+		ServerName:   host, // an IP here is verified against the certificate's IP SANs
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTransportSecurityConfig.HandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", addr, err)
+	}
+	return tlsConn, nil
+}
+
+// peekedConn replays the bytes buffered while sniffing the first record.
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// secureServerConn runs the server side of the handshake on an accepted
+// connection. The client must present a certificate issued for its source IP.
+func secureServerConn(conn net.Conn) (net.Conn, error) {
+	config := DefaultTransportSecurityConfig
+	remoteAddr := conn.RemoteAddr().String()
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(config.HandshakeTimeout))
+	first, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from %s: %w", remoteAddr, err)
+	}
+	peeked := &peekedConn{Conn: conn, reader: reader}
+
+	if first[0] != tlsRecordTypeHandshake {
+		if config.RequireMutualTLS {
+			return nil, fmt.Errorf("plaintext connection from %s refused", remoteAddr)
+		}
+		return peeked, nil
+	}
+
+	id := currentIdentity.Load()
+	if id == nil {
+		return nil, fmt.Errorf("TLS connection from %s: %w", remoteAddr, errNoNodeIdentity)
+	}
+	tlsConn := tls.Server(peeked, &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{id.cert},
+		ClientCAs:    id.roots,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), config.HandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", remoteAddr, err)
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote address %s: %w", remoteAddr, err)
+	}
+	if err := verifyPeerIP(tlsConn.ConnectionState(), host); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func verifyPeerIP(state tls.ConnectionState, ip string) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("peer %s presented no certificate", ip)
+	}
+	peerIP := net.ParseIP(ip)
+	for _, certIP := range state.PeerCertificates[0].IPAddresses {
+		if certIP.Equal(peerIP) {
+			return nil
+		}
+	}
+	return fmt.Errorf("peer certificate is not issued for %s", ip)
+}
+
+// NewServerSession secures an accepted inter-node connection and starts a
+// SMUX server session over it. The caller still owns conn on error.
+func NewServerSession(conn net.Conn, config ...*smux.Config) (*smux.Session, error) {
+	secured, err := secureServerConn(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	smuxConfig := DefaultSmuxConfig()
+	if len(config) > 0 && config[0] != nil {
+		smuxConfig = config[0]
+	}
+	return smux.Server(secured, smuxConfig)
+}
@@ -0,0 +1,221 @@
+package connection
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, ip string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: ip},
+		IPAddresses:  []net.IP{net.ParseIP(ip)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// withTransportSecurity restores the package-level identity and config after the test.
+func withTransportSecurity(t *testing.T, config TransportSecurityConfig) {
+	previousConfig := DefaultTransportSecurityConfig
+	previousIdentity := currentIdentity.Load()
+	DefaultTransportSecurityConfig = config
+	t.Cleanup(func() {
+		DefaultTransportSecurityConfig = previousConfig
+		currentIdentity.Store(previousIdentity)
+	})
+}
+
+// acceptSecured accepts one connection on ln and runs secureServerConn on it.
+func acceptSecured(ln net.Listener) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			result <- err
+			return
+		}
+		defer conn.Close()
+		secured, err := secureServerConn(conn)
+		if err == nil {
+			buf := make([]byte, 4)
+			_, err = secured.Read(buf)
+		}
+		result <- err
+	}()
+	return result
+}
+
+func TestMutualTLSHandshake(t *testing.T) {
+	withTransportSecurity(t, TransportSecurityConfig{RequireMutualTLS: true, HandshakeTimeout: 5 * time.Second})
+	ca := newTestCA(t)
+	SetNodeIdentity(ca.issue(t, "127.0.0.1"), ca.pool)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	result := acceptSecured(ln)
+
+	conn, err := CreateConnectionFactory(ln.Addr().String())()
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*tls.Conn); !ok {
+		t.Fatalf("expected a TLS connection, got %T", conn)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("server side failed: %v", err)
+	}
+}
+
+func TestPlaintextPeerRefused(t *testing.T) {
+	withTransportSecurity(t, TransportSecurityConfig{RequireMutualTLS: true, HandshakeTimeout: 5 * time.Second})
+	ca := newTestCA(t)
+	SetNodeIdentity(ca.issue(t, "127.0.0.1"), ca.pool)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	result := acceptSecured(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{2, 0, 0, 0}) // looks like a smux v2 frame
+	if err := <-result; err == nil {
+		t.Fatal("expected plaintext connection to be refused")
+	}
+}
+
+func TestPlaintextPeerAcceptedWhenOptional(t *testing.T) {
+	withTransportSecurity(t, TransportSecurityConfig{RequireMutualTLS: false, HandshakeTimeout: 5 * time.Second})
+	ca := newTestCA(t)
+	SetNodeIdentity(ca.issue(t, "127.0.0.1"), ca.pool)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	result := acceptSecured(ln)
+
+	// A peer that has not been upgraded yet
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{1, 0, 0, 0})
+	if err := <-result; err != nil {
+		t.Fatalf("expected plaintext peer to be accepted while mutual TLS is optional: %v", err)
+	}
+}
+
+func TestPeerCertificateForOtherIPRefused(t *testing.T) {
+	withTransportSecurity(t, TransportSecurityConfig{RequireMutualTLS: true, HandshakeTimeout: 5 * time.Second})
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "127.0.0.1")
+	SetNodeIdentity(serverCert, ca.pool)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	result := acceptSecured(ln)
+
+	// A member's certificate used from an address it was not issued for
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "10.0.0.9")},
+		RootCAs:      ca.pool,
+		ServerName:   "127.0.0.1",
+	})
+	if err == nil {
+		conn.Write([]byte("ping"))
+		defer conn.Close()
+	}
+	if err := <-result; err == nil {
+		t.Fatal("expected certificate for another IP to be refused")
+	}
+}
+
+func TestDialWithoutIdentity(t *testing.T) {
+	withTransportSecurity(t, TransportSecurityConfig{RequireMutualTLS: true, HandshakeTimeout: 5 * time.Second})
+	currentIdentity.Store(nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if _, err := CreateConnectionFactory(ln.Addr().String())(); err == nil {
+		t.Fatal("expected dial to fail without a node identity")
+	}
+
+	DefaultTransportSecurityConfig.RequireMutualTLS = false
+	conn, err := CreateConnectionFactory(ln.Addr().String())()
+	if err != nil {
+		t.Fatalf("expected plaintext dial to succeed when mutual TLS is optional: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,670 @@
+package forwarder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	packet "forwarding/packet_handler"
+	"forwarding/router"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// UDP is forwarded per flow: the access node maps every client address on a
+// UDP listener to a flow ID and a path, and the last relay keeps one
+// connected socket per flow towards the origin. Datagrams travel as
+// PacketTypeDatagram batches built with NewMergedPacket, PacketID holding the
+// flow ID of every datagram and Offsets their sizes. Every node keeps one
+// long-lived stream per neighbour and direction and merges whatever is queued
+// for it while the previous batch was written, so batching adds no delay of
+// its own. Forward datagrams are prefixed with the 2-byte origin port; return
+// datagrams go back along the hop list to the response ports, like streamed
+// responses. A datagram too large for a batch is dropped, and the stream to a
+// neighbour is closed once nothing was sent to it for UDPFlowIdleTimeout.
+
+type UDPForwardConfig struct {
+	ListenPort string // UDP port on the access node
+	Domain     string // domain mapping whose paths and origin IP are used
+	OriginPort int    // port the datagrams are sent to on the origin
+}
+
+const (
+	DefaultUDPFlowIdleTimeout = 60 * time.Second
+
+	datagramQueueSize     = 1024
+	maxDatagramsPerBatch  = 255       // PacketCount is a byte
+	maxDatagramBatchBytes = 60 * 1024 // body of a batch, less for long hop lists, see datagramBodyLimit
+	maxUDPPayload         = 65535
+)
+
+var oversizedDatagrams atomic.Int64 // datagrams dropped because no batch can carry them
+
+type datagramRecord struct {
+	flowID    uint32
+	hopList   []netip.Addr
+	hopCounts byte // HopCounts of the batch header as it goes on the wire
+	data      []byte
+}
+
+func (d datagramRecord) routeKey() string {
+	return CalculatePathHash(d.hopList) + "/" + strconv.Itoa(int(d.hopCounts))
+}
+
+// datagramBodyLimit returns how many body bytes a batch along hopList may
+// carry so that, with the header of a full batch, it fits the 16-bit Length.
+func datagramBodyLimit(hopList []netip.Addr) int {
+	return min(maxDatagramBatchBytes, math.MaxUint16-packet.PackedHeaderLen(maxDatagramsPerBatch, hopList))
+}
+
+// batchDatagrams groups records with the same route into batches within the
+// header limits, keeping the order of each flow. Records larger than a batch
+// may carry are dropped.
+func batchDatagrams(records []datagramRecord) [][]datagramRecord {
+	var batches [][]datagramRecord
+	open := make(map[string]int)   // route -> index of the batch still taking records
+	sizes := make(map[int]int)     // batch index -> body bytes
+	limits := make(map[string]int) // route -> body limit
+	for _, record := range records {
+		key := record.routeKey()
+		limit, ok := limits[key]
+		if !ok {
+			limit = datagramBodyLimit(record.hopList)
+			limits[key] = limit
+		}
+		if len(record.data) > limit {
+			dropped := oversizedDatagrams.Add(1)
+			log.Printf("[DATAGRAM] Dropped %d-byte datagram of flow %d, batches on its path carry at most %d bytes (%d dropped so far)", len(record.data), record.flowID, limit, dropped)
+			continue
+		}
+		if i, ok := open[key]; ok && len(batches[i]) < maxDatagramsPerBatch && sizes[i]+len(record.data) <= limit {
+			batches[i] = append(batches[i], record)
+			sizes[i] += len(record.data)
+			continue
+		}
+		batches = append(batches, []datagramRecord{record})
+		open[key] = len(batches) - 1
+		sizes[len(batches)-1] = len(record.data)
+	}
+	return batches
+}
+
+func encodeDatagramBatch(batch []datagramRecord) ([]byte, error) {
+	ids := make([]uint32, len(batch))
+	sizes := make([]int, len(batch))
+	for i, record := range batch {
+		ids[i] = record.flowID
+		sizes[i] = len(record.data)
+	}
+
+	header := packet.NewMergedPacket(ids, sizes, batch[0].hopList, packet.PacketTypeDatagram)
+	header.HopCounts = batch[0].hopCounts
+	headerBytes, err := header.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack datagram batch header: %w", err)
+	}
+
+	data := headerBytes
+	for _, record := range batch {
+		data = append(data, record.data...)
+	}
+	return data, nil
+}
+
+// readDatagramBatch reads the next batch from r and splits it into datagrams.
+func readDatagramBatch(r *bufio.Reader) (*packet.Packet, [][]byte, error) {
+	header, _, err := packet.ReadHeader(r, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.PacketType != packet.PacketTypeDatagram || header.PacketCount == 0 {
+		return nil, nil, fmt.Errorf("unexpected packet type %d with %d packets on datagram stream", header.PacketType, header.PacketCount)
+	}
+	bodyLen := int(header.Length) - int(header.HeaderLen)
+	if bodyLen < 0 {
+		return nil, nil, fmt.Errorf("invalid datagram batch length %d (header %d)", header.Length, header.HeaderLen)
+	}
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, fmt.Errorf("failed to read datagram batch body: %w", err)
+	}
+
+	positions := packet.GetRequestPositions(header, bodyLen)
+	datagrams := make([][]byte, header.PacketCount)
+	for i := range datagrams {
+		if positions[i+1] < positions[i] || positions[i+1] > bodyLen {
+			return nil, nil, fmt.Errorf("datagram %d of batch %v exceeds the body (%d bytes)", i, header.PacketID, bodyLen)
+		}
+		datagrams[i] = body[positions[i]:positions[i+1]]
+	}
+	return header, datagrams, nil
+}
+
+func withOriginPort(port uint16, payload []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(payload)), port), payload...)
+}
+
+func splitOriginPort(data []byte) (uint16, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, errors.New("datagram too short for the origin port")
+	}
+	port := binary.BigEndian.Uint16(data[:2])
+	if port == 0 {
+		return 0, nil, errors.New("datagram has no origin port")
+	}
+	return port, data[2:], nil
+}
+
+// datagramChannel is the long-lived stream carrying batches to one neighbour.
+// Datagrams are dropped rather than queued without bound, as UDP would.
+type datagramChannel struct {
+	targetAddr  string
+	queue       chan datagramRecord
+	idleTimeout time.Duration
+	stream      *smux.Stream // only used by run
+}
+
+var datagramChannels = struct {
+	sync.Mutex
+	channels map[string]*datagramChannel
+}{channels: make(map[string]*datagramChannel)}
+
+// sendDatagram queues record on the channel to targetAddr, opening one if
+// needed. It returns false if the queue is full. Queueing happens under the
+// lock so a channel that retires finds every record it was given.
+func sendDatagram(targetAddr string, idleTimeout time.Duration, record datagramRecord) bool {
+	datagramChannels.Lock()
+	defer datagramChannels.Unlock()
+	channel, ok := datagramChannels.channels[targetAddr]
+	if !ok {
+		channel = &datagramChannel{
+			targetAddr:  targetAddr,
+			queue:       make(chan datagramRecord, datagramQueueSize),
+			idleTimeout: flowIdleTimeout(idleTimeout),
+		}
+		datagramChannels.channels[targetAddr] = channel
+		go channel.run()
+	}
+	select {
+	case channel.queue <- record:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *datagramChannel) run() {
+	idle := time.NewTimer(c.idleTimeout)
+	defer idle.Stop()
+	for {
+		var first datagramRecord
+		select {
+		case first = <-c.queue:
+		case <-idle.C:
+			if c.retire() {
+				return
+			}
+			idle.Reset(c.idleTimeout)
+			continue
+		}
+
+		pending := []datagramRecord{first}
+	drain:
+		for len(pending) < datagramQueueSize {
+			select {
+			case record := <-c.queue:
+				pending = append(pending, record)
+			default:
+				break drain
+			}
+		}
+
+		for _, batch := range batchDatagrams(pending) {
+			if err := c.write(batch); err != nil {
+				log.Printf("[DATAGRAM] Dropped batch of %d datagram(s) to %s: %v", len(batch), c.targetAddr, err)
+			}
+		}
+		idle.Reset(c.idleTimeout)
+	}
+}
+
+// retire removes the channel and closes its stream unless a record was queued
+// since the channel went idle.
+func (c *datagramChannel) retire() bool {
+	datagramChannels.Lock()
+	defer datagramChannels.Unlock()
+	if len(c.queue) > 0 {
+		return false
+	}
+	delete(datagramChannels.channels, c.targetAddr)
+	if c.stream != nil {
+		c.stream.Close()
+		c.stream = nil
+	}
+	log.Printf("[DATAGRAM] Closed idle datagram channel to %s", c.targetAddr)
+	return true
+}
+
+func (c *datagramChannel) write(batch []datagramRecord) error {
+	data, err := encodeDatagramBatch(batch)
+	if err != nil {
+		return err
+	}
+
+	if c.stream == nil {
+		stream, err := openStreamWithRetry(c.targetAddr)
+		if err != nil {
+			return err
+		}
+		// Nothing comes back on this stream; reading only notices when the peer
+		// closes it, so the next write fails and opens a new one.
+		go func() {
+			io.Copy(io.Discard, stream)
+			stream.Close()
+		}()
+		c.stream = stream
+	}
+
+	out := &idleStream{stream: c.stream, timeout: currentTimeouts().StreamIdle}
+	if _, err := out.Write(data); err != nil {
+		c.stream.Close()
+		c.stream = nil
+		return fmt.Errorf("failed to write datagram batch: %w", err)
+	}
+	return nil
+}
+
+type udpFlow struct {
+	id         uint32
+	hopList    []netip.Addr
+	originPort uint16
+	lastSeen   atomic.Int64 // unix nanoseconds
+
+	client      *net.UDPAddr // access node only
+	listener    *net.UDPConn // access node only
+	nextHopAddr string       // request port of the first relay, empty when origin is used
+
+	origin *net.UDPConn // socket towards the origin on the node in front of it
+}
+
+func (f *udpFlow) touch() {
+	f.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (f *udpFlow) idle(now time.Time, timeout time.Duration) bool {
+	return now.Sub(time.Unix(0, f.lastSeen.Load())) > timeout
+}
+
+func flowIdleTimeout(configured time.Duration) time.Duration {
+	if configured <= 0 {
+		return DefaultUDPFlowIdleTimeout
+	}
+	return configured
+}
+
+func (r *Repository) StartUDPListeners() {
+	for _, forward := range r.accessConfig.UDP {
+		if forward.OriginPort <= 0 || forward.OriginPort > 65535 {
+			log.Printf("[Access-ERROR] UDP forward on port %s for %s has invalid origin port %d, skipping.", forward.ListenPort, forward.Domain, forward.OriginPort)
+			continue
+		}
+		conn, err := ListenPacket(":" + forward.ListenPort)
+		if err != nil {
+			log.Printf("[Access-ERROR] Failed to listen on UDP port %s for %s: %v", forward.ListenPort, forward.Domain, err)
+			continue
+		}
+
+		r.udpMu.Lock()
+		r.udpListeners = append(r.udpListeners, conn)
+		r.udpMu.Unlock()
+
+		log.Printf("[Access-INFO] Forwarding UDP port %s to %s port %d", forward.ListenPort, forward.Domain, forward.OriginPort)
+		go r.readUDPListener(conn, forward)
+	}
+}
+
+func (r *Repository) readUDPListener(conn *net.UDPConn, forward UDPForwardConfig) {
+	buf := make([]byte, maxUDPPayload)
+	for {
+		n, clientAddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[Access-ERROR] UDP listener on port %s: %v", forward.ListenPort, err)
+			continue
+		}
+
+		flow, err := r.udpFlowFor(conn, forward, clientAddr)
+		if err != nil {
+			log.Printf("[Access-ERROR] UDP datagram from %s on port %s: %v", clientAddr, forward.ListenPort, err)
+			continue
+		}
+		flow.touch()
+
+		if flow.origin != nil {
+			if _, err := flow.origin.Write(buf[:n]); err != nil {
+				log.Printf("[Access-ERROR] UDP flow %d: Failed to send datagram to origin: %v", flow.id, err)
+			}
+			continue
+		}
+		record := datagramRecord{
+			flowID:    flow.id,
+			hopList:   flow.hopList,
+			hopCounts: 1,
+			data:      withOriginPort(flow.originPort, buf[:n]),
+		}
+		if !sendDatagram(flow.nextHopAddr, r.accessConfig.UDPFlowIdleTimeout, record) {
+			log.Printf("[Access-WARN] UDP flow %d: Queue to %s is full, dropping datagram.", flow.id, flow.nextHopAddr)
+		}
+	}
+}
+
+// udpFlowFor returns the flow of a client address, choosing a path for new flows.
+func (r *Repository) udpFlowFor(listener *net.UDPConn, forward UDPForwardConfig, clientAddr *net.UDPAddr) (*udpFlow, error) {
+	key := forward.ListenPort + "|" + clientAddr.String()
+
+	r.udpMu.Lock()
+	defer r.udpMu.Unlock()
+	if flow, ok := r.udpFlows[key]; ok {
+		return flow, nil
+	}
+
+	domain := router.NormalizeDomain(forward.Domain)
+	pathManager := router.GetInstance()
+	paths := pathManager.GetPaths(domain)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no available paths for domain %s", domain)
+	}
+	path := r.pathHealth.Pick(pathManager.DeprioritizeFailed(paths))
+	hopList, err := packet.ParseHopList(path.IPList)
+	if err != nil || len(hopList) < 2 {
+		return nil, fmt.Errorf("no valid path for domain %s: %v", domain, path.IPList)
+	}
+
+	flow := &udpFlow{
+		id:         generateUniqueRequestID(nil),
+		hopList:    hopList,
+		originPort: uint16(forward.OriginPort),
+		client:     clientAddr,
+		listener:   listener,
+	}
+	for r.udpFlowsByID[flow.id] != nil {
+		flow.id = generateUniqueRequestID(nil)
+	}
+
+	if len(hopList) == 2 {
+		// No relay in between, send to the origin like the direct HTTP proxy does
+		originAddr := net.JoinHostPort(hopList[1].String(), strconv.Itoa(forward.OriginPort))
+		origin, err := net.Dial("udp", originAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open UDP socket to origin %s: %w", originAddr, err)
+		}
+		flow.origin = origin.(*net.UDPConn)
+		go r.readDirectUDPOrigin(flow)
+	} else {
+		ip, port := hostPort(hopList[1].String(), r.accessConfig.RelayPort)
+		flow.nextHopAddr = net.JoinHostPort(ip, port)
+	}
+
+	r.udpFlows[key] = flow
+	r.udpFlowsByID[flow.id] = flow
+	log.Printf("[Access-INFO] UDP flow %d: %s on port %s to %s port %d via %v", flow.id, clientAddr, forward.ListenPort, domain, forward.OriginPort, path.IPList)
+	return flow, nil
+}
+
+func (r *Repository) readDirectUDPOrigin(flow *udpFlow) {
+	buf := make([]byte, maxUDPPayload)
+	for {
+		n, err := flow.origin.Read(buf)
+		if err != nil {
+			return // closed when the flow expires
+		}
+		flow.touch()
+		if _, err := flow.listener.WriteToUDP(buf[:n], flow.client); err != nil {
+			log.Printf("[Access-ERROR] UDP flow %d: Failed to send datagram to client %s: %v", flow.id, flow.client, err)
+		}
+	}
+}
+
+// receiveDatagramReturns delivers return batches from the first relay to the clients.
+func (r *Repository) receiveDatagramReturns(stream *smux.Stream, initial []byte) {
+	stream.SetReadDeadline(time.Time{}) // the stream stays open while it carries batches
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(initial), stream))
+	for {
+		header, datagrams, err := readDatagramBatch(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("[Access-ERROR] Datagram return stream %d: %v", stream.ID(), err)
+			}
+			return
+		}
+		for i, data := range datagrams {
+			r.udpMu.Lock()
+			flow := r.udpFlowsByID[header.PacketID[i]]
+			r.udpMu.Unlock()
+			if flow == nil {
+				continue // expired
+			}
+			flow.touch()
+			if _, err := flow.listener.WriteToUDP(data, flow.client); err != nil {
+				log.Printf("[Access-ERROR] UDP flow %d: Failed to send datagram to client %s: %v", flow.id, flow.client, err)
+			}
+		}
+	}
+}
+
+func (r *Repository) expireUDPFlows() {
+	defer r.wg.Done()
+	timeout := flowIdleTimeout(r.accessConfig.UDPFlowIdleTimeout)
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			r.udpMu.Lock()
+			for key, flow := range r.udpFlows {
+				if !flow.idle(now, timeout) {
+					continue
+				}
+				delete(r.udpFlows, key)
+				delete(r.udpFlowsByID, flow.id)
+				if flow.origin != nil {
+					flow.origin.Close()
+				}
+				log.Printf("[Access-INFO] UDP flow %d from %s expired.", flow.id, flow.client)
+			}
+			r.udpMu.Unlock()
+		}
+	}
+}
+
+func (r *Repository) stopUDPListeners() {
+	r.udpMu.Lock()
+	defer r.udpMu.Unlock()
+	for _, listener := range r.udpListeners {
+		listener.Close()
+	}
+	r.udpListeners = nil
+	for key, flow := range r.udpFlows {
+		if flow.origin != nil {
+			flow.origin.Close()
+		}
+		delete(r.udpFlows, key)
+		delete(r.udpFlowsByID, flow.id)
+	}
+}
+
+type relayFlowKey struct {
+	access netip.Addr
+	flowID uint32
+}
+
+// relayDatagrams reads forward batches from the previous hop.
+func (r *RelayRepository) relayDatagrams(stream *smux.Stream, initial []byte, remoteAddr string) {
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(initial), stream))
+	for {
+		header, datagrams, err := readDatagramBatch(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("[Relay-ERROR] Datagram stream from %s: %v", remoteAddr, err)
+			}
+			return
+		}
+		if err := router.ValidateHopList(header.HopList); err != nil {
+			log.Printf("[Relay-ERROR] Refusing datagram batch %v from %s: %v", header.PacketID, remoteAddr, err)
+			continue
+		}
+
+		header.IncrementHopCounts()
+		nextHopIP, isLastHop, err := header.GetNextHopIP()
+		if err != nil || nextHopIP == "" {
+			log.Printf("[Relay-ERROR] Failed to determine next hop for datagram batch %v from %s: %v", header.PacketID, remoteAddr, err)
+			continue
+		}
+
+		if isLastHop {
+			for i, data := range datagrams {
+				r.sendDatagramToOrigin(header.HopList, header.PacketID[i], nextHopIP, data)
+			}
+			continue
+		}
+
+		ip, port := hostPort(nextHopIP, r.relayConfig.RelayPort)
+		targetAddr := net.JoinHostPort(ip, port)
+		for i, data := range datagrams {
+			record := datagramRecord{flowID: header.PacketID[i], hopList: header.HopList, hopCounts: header.HopCounts, data: data}
+			if !sendDatagram(targetAddr, r.relayConfig.UDPFlowIdleTimeout, record) {
+				log.Printf("[Relay-WARN] UDP flow %d: Queue to %s is full, dropping datagram.", record.flowID, targetAddr)
+			}
+		}
+	}
+}
+
+func (r *RelayRepository) sendDatagramToOrigin(hopList []netip.Addr, flowID uint32, originIP string, data []byte) {
+	port, payload, err := splitOriginPort(data)
+	if err != nil {
+		log.Printf("[Relay-ERROR] UDP flow %d: %v", flowID, err)
+		return
+	}
+
+	key := relayFlowKey{access: hopList[0], flowID: flowID}
+	r.udpMu.Lock()
+	flow, ok := r.udpFlows[key]
+	if !ok {
+		originAddr := net.JoinHostPort(originIP, strconv.Itoa(int(port)))
+		origin, err := net.Dial("udp", originAddr)
+		if err != nil {
+			r.udpMu.Unlock()
+			log.Printf("[Relay-ERROR] UDP flow %d: Failed to open UDP socket to origin %s: %v", flowID, originAddr, err)
+			return
+		}
+		flow = &udpFlow{id: flowID, hopList: hopList, originPort: port, origin: origin.(*net.UDPConn)}
+		r.udpFlows[key] = flow
+		go r.readUDPOrigin(flow)
+		log.Printf("[Relay-INFO] UDP flow %d: Opened socket to origin %s for access %s.", flowID, originAddr, hopList[0])
+	}
+	r.udpMu.Unlock()
+
+	flow.touch()
+	if _, err := flow.origin.Write(payload); err != nil {
+		log.Printf("[Relay-ERROR] UDP flow %d: Failed to send datagram to origin: %v", flowID, err)
+	}
+}
+
+func (r *RelayRepository) readUDPOrigin(flow *udpFlow) {
+	buf := make([]byte, maxUDPPayload)
+	for {
+		n, err := flow.origin.Read(buf)
+		if err != nil {
+			return // closed when the flow expires
+		}
+		flow.touch()
+		// The header names this node, the one in front of the origin, like streamed responses
+		r.sendDatagramBack(flow.id, flow.hopList, byte(len(flow.hopList)-2), append([]byte(nil), buf[:n]...))
+	}
+}
+
+// sendDatagramBack moves a return datagram one hop closer to the access node.
+// hopCounts is the index of this node in hopList.
+func (r *RelayRepository) sendDatagramBack(flowID uint32, hopList []netip.Addr, hopCounts byte, data []byte) {
+	if hopCounts == 0 || int(hopCounts) >= len(hopList) {
+		log.Printf("[Relay-ERROR] UDP flow %d: Invalid HopCounts %d for return datagram (HopList=%d)", flowID, hopCounts, len(hopList))
+		return
+	}
+	hopCounts--
+	port := r.relayConfig.RelayResponsePort
+	if hopCounts == 0 {
+		port = r.relayConfig.AccessResponsePort
+	}
+
+	targetAddr := net.JoinHostPort(hopList[hopCounts].String(), port)
+	record := datagramRecord{flowID: flowID, hopList: hopList, hopCounts: hopCounts, data: data}
+	if !sendDatagram(targetAddr, r.relayConfig.UDPFlowIdleTimeout, record) {
+		log.Printf("[Relay-WARN] UDP flow %d: Queue to %s is full, dropping return datagram.", flowID, targetAddr)
+	}
+}
+
+// relayDatagramReturns reads return batches from the next hop.
+func (r *RelayRepository) relayDatagramReturns(stream *smux.Stream, initial []byte, remoteAddr string) {
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(initial), stream))
+	for {
+		header, datagrams, err := readDatagramBatch(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("[Relay-ERROR] Datagram return stream from %s: %v", remoteAddr, err)
+			}
+			return
+		}
+		if err := router.ValidateHopList(header.HopList); err != nil {
+			log.Printf("[Relay-ERROR] Refusing return datagram batch %v from %s: %v", header.PacketID, remoteAddr, err)
+			continue
+		}
+		for i, data := range datagrams {
+			r.sendDatagramBack(header.PacketID[i], header.HopList, header.HopCounts, data)
+		}
+	}
+}
+
+func (r *RelayRepository) expireUDPFlows() {
+	defer r.wg.Done()
+	timeout := flowIdleTimeout(r.relayConfig.UDPFlowIdleTimeout)
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			r.udpMu.Lock()
+			for key, flow := range r.udpFlows {
+				flow.origin.Close()
+				delete(r.udpFlows, key)
+			}
+			r.udpMu.Unlock()
+			return
+		case now := <-ticker.C:
+			r.udpMu.Lock()
+			for key, flow := range r.udpFlows {
+				if flow.idle(now, timeout) {
+					flow.origin.Close()
+					delete(r.udpFlows, key)
+					log.Printf("[Relay-INFO] UDP flow %d of access %s expired.", flow.id, key.access)
+				}
+			}
+			r.udpMu.Unlock()
+		}
+	}
+}
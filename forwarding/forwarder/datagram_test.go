@@ -0,0 +1,199 @@
+package forwarder
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	packet "forwarding/packet_handler"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDatagramBatchRoundTrip(t *testing.T) {
+	pathA, _ := packet.ParseHopList([]string{"10.0.0.1", "10.0.0.2", "192.0.2.10"})
+	pathB, _ := packet.ParseHopList([]string{"10.0.0.1", "10.0.0.3", "192.0.2.10"})
+
+	var records []datagramRecord
+	for i := 0; i < 600; i++ {
+		path := pathA
+		if i%3 == 0 {
+			path = pathB
+		}
+		records = append(records, datagramRecord{flowID: uint32(i), hopList: path, hopCounts: 1, data: []byte(fmt.Sprintf("datagram %d", i))})
+	}
+	records = append(records, datagramRecord{flowID: 999, hopList: pathA, hopCounts: 1, data: nil}) // empty datagrams are valid UDP
+
+	batches := batchDatagrams(records)
+	// 401 datagrams on path A need two batches, the 200 on path B fit one
+	if len(batches) != 3 {
+		t.Fatalf("got %d batches, want 3", len(batches))
+	}
+
+	var stream bytes.Buffer
+	for _, batch := range batches {
+		data, err := encodeDatagramBatch(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Write(data)
+	}
+
+	reader := bufio.NewReader(&stream)
+	received := make(map[uint32]string)
+	var lastA uint32
+	for range batches {
+		header, datagrams, err := readDatagramBatch(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.HopCounts != 1 || len(datagrams) != int(header.PacketCount) {
+			t.Fatalf("unexpected batch header %+v", header)
+		}
+		for i, data := range datagrams {
+			id := header.PacketID[i]
+			received[id] = string(data)
+			if header.HopList[1] == pathA[1] && id != 999 {
+				if id < lastA {
+					t.Fatalf("datagram %d arrived after %d on the same path", id, lastA)
+				}
+				lastA = id
+			}
+		}
+	}
+	if len(received) != len(records) {
+		t.Fatalf("received %d datagrams, want %d", len(received), len(records))
+	}
+	for _, record := range records {
+		if received[record.flowID] != string(record.data) {
+			t.Errorf("datagram %d = %q, want %q", record.flowID, received[record.flowID], record.data)
+		}
+	}
+}
+
+func TestBatchDatagramsDropsOversized(t *testing.T) {
+	path, _ := packet.ParseHopList([]string{"10.0.0.1", "10.0.0.2", "192.0.2.10"})
+	limit := datagramBodyLimit(path)
+	records := []datagramRecord{
+		{flowID: 1, hopList: path, hopCounts: 1, data: make([]byte, limit+1)},
+		{flowID: 2, hopList: path, hopCounts: 1, data: make([]byte, limit)},
+		{flowID: 3, hopList: path, hopCounts: 1, data: []byte("small")},
+	}
+	before := oversizedDatagrams.Load()
+
+	batches := batchDatagrams(records)
+	if len(batches) != 2 || batches[0][0].flowID != 2 || batches[1][0].flowID != 3 {
+		t.Fatalf("unexpected batches %v", batches)
+	}
+	if dropped := oversizedDatagrams.Load() - before; dropped != 1 {
+		t.Errorf("counted %d dropped datagrams, want 1", dropped)
+	}
+	for _, batch := range batches {
+		if _, err := encodeDatagramBatch(batch); err != nil {
+			t.Errorf("batch of flow %d: %v", batch[0].flowID, err)
+		}
+	}
+}
+
+func TestDatagramChannelRetiresWhenIdle(t *testing.T) {
+	targetAddr := "192.0.2.99:50056"
+	channel := &datagramChannel{targetAddr: targetAddr, queue: make(chan datagramRecord, 1), idleTimeout: 20 * time.Millisecond}
+	datagramChannels.Lock()
+	datagramChannels.channels[targetAddr] = channel
+	datagramChannels.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		channel.run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle channel did not stop")
+	}
+	datagramChannels.Lock()
+	_, ok := datagramChannels.channels[targetAddr]
+	datagramChannels.Unlock()
+	if ok {
+		t.Error("idle channel is still registered")
+	}
+}
+
+func TestRelayUDPFlowToOriginAndBack(t *testing.T) {
+	origin, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := origin.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			origin.WriteTo(append([]byte("echo: "), buf[:n]...), addr)
+		}
+	}()
+	originPort := origin.LocalAddr().(*net.UDPAddr).Port
+
+	config := DefaultRelayConfig
+	config.UDPFlowIdleTimeout = 100 * time.Millisecond
+	r := &RelayRepository{relayConfig: config, udpFlows: make(map[relayFlowKey]*udpFlow), done: make(chan struct{})}
+
+	// Capture what goes back to the access node instead of opening a stream
+	accessAddr := net.JoinHostPort("192.0.2.1", config.AccessResponsePort)
+	returns := &datagramChannel{targetAddr: accessAddr, queue: make(chan datagramRecord, 4)}
+	datagramChannels.Lock()
+	datagramChannels.channels[accessAddr] = returns
+	datagramChannels.Unlock()
+	defer func() {
+		datagramChannels.Lock()
+		delete(datagramChannels.channels, accessAddr)
+		datagramChannels.Unlock()
+	}()
+
+	hops := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("127.0.0.1")}
+	r.sendDatagramToOrigin(hops, 7, "127.0.0.1", withOriginPort(uint16(originPort), []byte("ping")))
+	r.sendDatagramToOrigin(hops, 7, "127.0.0.1", withOriginPort(uint16(originPort), []byte("pong")))
+
+	for _, want := range []string{"echo: ping", "echo: pong"} {
+		select {
+		case record := <-returns.queue:
+			if record.flowID != 7 || record.hopCounts != 0 || string(record.data) != want {
+				t.Fatalf("return record %+v (%q), want flow 7 to the access node with %q", record, record.data, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no reply %q from origin port %s", want, strconv.Itoa(originPort))
+		}
+	}
+	r.udpMu.Lock()
+	flows := len(r.udpFlows)
+	r.udpMu.Unlock()
+	if flows != 1 {
+		t.Fatalf("expected both datagrams to share one flow, got %d", flows)
+	}
+
+	r.wg.Add(1)
+	go r.expireUDPFlows()
+	defer func() {
+		close(r.done)
+		r.wg.Wait()
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.udpMu.Lock()
+		remaining := len(r.udpFlows)
+		r.udpMu.Unlock()
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("idle flow was not expired")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
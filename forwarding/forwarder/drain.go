@@ -0,0 +1,170 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A draining node refuses new client requests and tunnel connections with 503
+// and tells the controller, through the notifier set by SetDrainNotifier, so
+// that it is left out of paths and BPR. The requests already in flight get
+// until the deadline of Shutdown to complete; the remaining ones are failed
+// with 503 before the servers are closed.
+
+var errDrainDeadline = errors.New("node shut down before the request completed")
+
+var draining atomic.Bool
+
+var drainNotifier struct {
+	sync.Mutex
+	notify func(draining bool)
+}
+
+// SetDrainNotifier sets the function SetDraining reports changes to, e.g. the
+// controller sync of the data plane.
+func SetDrainNotifier(notify func(draining bool)) {
+	drainNotifier.Lock()
+	drainNotifier.notify = notify
+	drainNotifier.Unlock()
+}
+
+// SetDraining makes the access node refuse new client requests and tunnel
+// connections, or take them again.
+func SetDraining(on bool) {
+	if draining.Swap(on) == on {
+		return
+	}
+	if on {
+		log.Printf("[Access-INFO] Draining: new client requests are refused.")
+	} else {
+		log.Printf("[Access-INFO] Draining stopped: taking client requests again.")
+	}
+
+	drainNotifier.Lock()
+	notify := drainNotifier.notify
+	drainNotifier.Unlock()
+	if notify != nil {
+		notify(on)
+	}
+}
+
+func Draining() bool {
+	return draining.Load()
+}
+
+func inFlightRequests() int {
+	count := 0
+	eachStateManager(func(role string, sm *RequestStateManager) {
+		count += len(sm.InFlight())
+	})
+	return count
+}
+
+// WaitForInFlight returns once no request is in flight on any role of this
+// node, or with an error when ctx is done first.
+func WaitForInFlight(ctx context.Context) error {
+	return waitForStates(ctx, "request(s)", inFlightRequests)
+}
+
+func waitForStates(ctx context.Context, what string, count func() int) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		n := count()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d %s still in flight: %w", n, what, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// failInFlight fails the requests the access node is still waiting for, which
+// answers their clients with 503.
+func (r *Repository) failInFlight() int {
+	states := r.stateManager.InFlight()
+	for _, state := range states {
+		r.notifyRequestFailed(state, errDrainDeadline)
+	}
+	return len(states)
+}
+
+func (r *Repository) trackServer(srv *http.Server) {
+	r.serverMu.Lock()
+	r.servers = append(r.servers, srv)
+	r.serverMu.Unlock()
+}
+
+func (r *Repository) shutdownServers(ctx context.Context) error {
+	r.serverMu.Lock()
+	servers := append([]*http.Server(nil), r.servers...)
+	r.serverMu.Unlock()
+
+	var errs []error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", srv.Addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown stops the access proxy from accepting connections and waits for
+// the requests in flight until ctx is done. Those still waiting then are
+// answered with 503, before the servers and processors are stopped.
+func (ap *AccessProxy) Shutdown(ctx context.Context) error {
+	r := ap.repository
+	log.Println("[Access-INFO] Shutting down AccessProxy...")
+	r.stopTunnelListeners()
+	r.stopUDPListeners()
+
+	err := r.shutdownServers(ctx)
+	if err == nil {
+		err = waitForStates(ctx, "request(s)", func() int { return len(r.stateManager.InFlight()) })
+	}
+	if err != nil {
+		log.Printf("[Access-WARN] Drain deadline reached: %v. Failing %d request(s) in flight.", err, r.failInFlight())
+		// Gives the handlers a moment to answer their clients
+		graceCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		r.shutdownServers(graceCtx)
+		cancel()
+		r.serverMu.Lock()
+		for _, srv := range r.servers {
+			srv.Close()
+		}
+		r.serverMu.Unlock()
+	}
+
+	r.serverMu.Lock()
+	if r.responseListener != nil {
+		r.responseListener.Close()
+	}
+	r.serverMu.Unlock()
+	r.Stop()
+	log.Println("[Access-INFO] AccessProxy shut down.")
+	return err
+}
+
+// Shutdown stops the relay from accepting connections and waits for the
+// requests it relays until ctx is done before stopping it.
+func (rp *RelayProxy) Shutdown(ctx context.Context) error {
+	r := rp.repository
+	log.Println("[Relay-INFO] Shutting down RelayProxy...")
+	r.closeListeners()
+	err := waitForStates(ctx, "relayed request(s)", func() int { return len(r.stateManager.InFlight()) })
+	if err != nil {
+		log.Printf("[Relay-WARN] Drain deadline reached: %v", err)
+	}
+	r.Stop()
+	log.Println("[Relay-INFO] RelayProxy shut down.")
+	return err
+}
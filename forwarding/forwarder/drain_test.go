@@ -0,0 +1,50 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDrainFailsInFlightRequests(t *testing.T) {
+	stateManager := NewRequestStateManager(time.Minute, time.Minute)
+	defer stateManager.Stop()
+	r := &Repository{stateManager: stateManager}
+
+	attemptFailed := make(chan error, 1)
+	stateManager.AddState(&RequestState{RequestID: 1, Status: StatusSent, AttemptFailed: attemptFailed})
+	stateManager.AddState(&RequestState{RequestID: 2, Status: StatusCompleted})
+	inFlight := func() int { return len(stateManager.InFlight()) }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	if err := waitForStates(ctx, "request(s)", inFlight); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait with a request in flight returned %v", err)
+	}
+	if n := r.failInFlight(); n != 1 {
+		t.Fatalf("failed %d requests", n)
+	}
+	if err := <-attemptFailed; !errors.Is(err, errDrainDeadline) {
+		t.Fatalf("handler got %v", err)
+	}
+
+	stateManager.UpdateStatus(1, StatusFailed)
+	if err := waitForStates(context.Background(), "request(s)", inFlight); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetDrainingNotifiesOnChange(t *testing.T) {
+	var notified []bool
+	SetDrainNotifier(func(on bool) { notified = append(notified, on) })
+	defer SetDrainNotifier(nil)
+	defer SetDraining(false)
+
+	SetDraining(true)
+	SetDraining(true)
+	SetDraining(false)
+	if len(notified) != 2 || !notified[0] || notified[1] {
+		t.Fatalf("notified %v", notified)
+	}
+}
@@ -0,0 +1,199 @@
+package forwarder
+
+import (
+	"bytes"
+	"fmt"
+	"forwarding/forwarder/httpcache"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cacheable GET responses are kept at the access node (see httpcache) and
+// served without crossing the relay chain while they are fresh. A stale entry
+// with an ETag or Last-Modified turns the forwarded request into a conditional
+// one; a 304 from the origin refreshes the entry and the client gets the stored
+// body. Only responses whose body matched their Content-Length are stored, so
+// a response cut short on the way is never cached. PURGE requests from the
+// node itself remove a URL, or the whole domain for "/*".
+
+const cacheStatusHeader = "X-Cache"
+
+// cacheRecorder sits between the attempts and the client. It keeps a copy of
+// the response for the cache and holds back a 304 that answers the cache's
+// own revalidation.
+type cacheRecorder struct {
+	w            http.ResponseWriter
+	limit        int64
+	revalidating bool
+
+	mu       sync.Mutex
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+	failed   bool
+}
+
+func (c *cacheRecorder) Header() http.Header {
+	return c.w.Header()
+}
+
+func (c *cacheRecorder) WriteHeader(statusCode int) {
+	c.mu.Lock()
+	if c.status != 0 {
+		c.mu.Unlock()
+		return
+	}
+	c.status = statusCode
+	c.header = c.w.Header().Clone()
+	suppress := c.suppressed()
+	c.mu.Unlock()
+	if !suppress {
+		c.w.Header().Set(cacheStatusHeader, "MISS")
+		c.w.WriteHeader(statusCode)
+	}
+}
+
+func (c *cacheRecorder) Write(p []byte) (int, error) {
+	c.WriteHeader(http.StatusOK)
+
+	c.mu.Lock()
+	if c.suppressed() {
+		c.mu.Unlock()
+		return len(p), nil
+	}
+	if !c.overflow {
+		if int64(c.body.Len()+len(p)) > c.limit {
+			c.overflow = true
+			c.body = bytes.Buffer{}
+		} else {
+			c.body.Write(p)
+		}
+	}
+	c.mu.Unlock()
+
+	n, err := c.w.Write(p)
+	if err != nil {
+		c.mu.Lock()
+		c.failed = true
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *cacheRecorder) Flush() {
+	c.mu.Lock()
+	suppress := c.suppressed()
+	c.mu.Unlock()
+	if flusher, ok := c.w.(http.Flusher); ok && !suppress {
+		flusher.Flush()
+	}
+}
+
+// suppressed must be called with c.mu held.
+func (c *cacheRecorder) suppressed() bool {
+	return c.revalidating && c.status == http.StatusNotModified
+}
+
+// complete returns the recorded response if the client got all of it.
+func (c *cacheRecorder) complete() (int, http.Header, []byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status == 0 || c.overflow || c.failed {
+		return 0, nil, nil, false
+	}
+	body := c.body.Bytes()
+	if c.status != http.StatusNoContent {
+		length, err := strconv.Atoi(c.header.Get("Content-Length"))
+		if err != nil || length != len(body) {
+			return 0, nil, nil, false
+		}
+	}
+	return c.status, c.header, body, true
+}
+
+// serveCached writes a stored response, or 304 if the client's validators match it.
+func serveCached(w http.ResponseWriter, req *http.Request, entry *httpcache.Entry, cacheStatus string) {
+	header := w.Header()
+	for name := range header {
+		delete(header, name)
+	}
+	for name, values := range entry.Header {
+		header[name] = values
+	}
+	header.Set("Age", strconv.FormatInt(int64(entry.Age(time.Now())/time.Second), 10))
+	header.Set(cacheStatusHeader, cacheStatus)
+
+	if httpcache.HasConditionals(req) && entry.NotModified(req) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(entry.StatusCode)
+	w.Write(entry.Body)
+}
+
+// serveFromCache answers req from the cache when a fresh entry exists. It
+// otherwise returns the stale entry worth revalidating, if any.
+func (r *Repository) serveFromCache(w http.ResponseWriter, req *http.Request, domain string, requestID uint32) (*httpcache.Entry, bool) {
+	entry := r.cache.Lookup(domain, req)
+	if entry == nil {
+		return nil, false
+	}
+	if entry.Fresh(time.Now()) && !httpcache.RequestWantsRevalidation(req) {
+		log.Printf("[Access-INFO] Request ID %d: Served %s%s from cache (age %s).", requestID, domain, req.URL.RequestURI(), entry.Age(time.Now()).Truncate(time.Second))
+		serveCached(w, req, entry, "HIT")
+		return nil, true
+	}
+	if !entry.HasValidators() {
+		return nil, false
+	}
+	return entry, false
+}
+
+// finishCachedResponse stores the response of a successful attempt, or serves
+// the stale entry refreshed by a 304.
+func (r *Repository) finishCachedResponse(w http.ResponseWriter, req *http.Request, domain string, requestID uint32, recorder *cacheRecorder, stale *httpcache.Entry, sentAt time.Time) {
+	recorder.mu.Lock()
+	notModified := recorder.suppressed()
+	header := recorder.header
+	recorder.mu.Unlock()
+
+	if notModified {
+		entry := r.cache.Refresh(stale, header, sentAt, time.Now())
+		log.Printf("[Access-INFO] Request ID %d: Cached %s%s revalidated by origin.", requestID, domain, req.URL.RequestURI())
+		serveCached(w, req, entry, "REVALIDATED")
+		return
+	}
+	status, header, body, ok := recorder.complete()
+	if ok && r.cache.Store(domain, req, status, header, body, sentAt, time.Now()) {
+		log.Printf("[Access-DEBUG] Request ID %d: Stored %d response for %s%s in cache, %d bytes.", requestID, status, domain, req.URL.RequestURI(), len(body))
+	}
+}
+
+// handlePurge removes cached responses on behalf of a client on this node.
+func (r *Repository) handlePurge(w http.ResponseWriter, req *http.Request, domain string, requestID uint32) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+		log.Printf("[Access-WARN] Request ID %d: PURGE from %s refused.", requestID, req.RemoteAddr)
+		http.Error(w, "Forbidden: PURGE is only accepted from this node.", http.StatusForbidden)
+		return
+	}
+
+	var purged int
+	if req.URL.Path == "/*" {
+		purged = r.cache.PurgeDomain(domain)
+	} else if r.cache.Purge(httpcache.Key(domain, req)) {
+		purged = 1
+	}
+	log.Printf("[Access-INFO] Request ID %d: Purged %d cached response(s) for %s%s.", requestID, purged, domain, req.URL.RequestURI())
+	if purged == 0 {
+		http.Error(w, "Not cached.", http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "Purged %d cached response(s).\n", purged)
+}
@@ -0,0 +1,101 @@
+package forwarder
+
+import (
+	"forwarding/forwarder/httpcache"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEdgeCacheStoreRevalidateAndPurge(t *testing.T) {
+	config := httpcache.DefaultConfig
+	config.Enabled = true
+	cache, err := httpcache.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Repository{cache: cache}
+	const domain = "example.com"
+	newRequest := func() *http.Request { return httptest.NewRequest("GET", "http://example.com/logo.png", nil) }
+
+	// Miss: the attempt's response reaches the client and is stored
+	client := httptest.NewRecorder()
+	req := newRequest()
+	if entry, served := r.serveFromCache(client, req, domain, 1); entry != nil || served {
+		t.Fatal("empty cache answered the request")
+	}
+	recorder := &cacheRecorder{w: client, limit: cache.MaxObjectBytes()}
+	attempt := newAttemptWriter(recorder)
+	past := time.Now().Add(-time.Hour)
+	attempt.Header().Set("Cache-Control", "max-age=60")
+	attempt.Header().Set("ETag", `"v1"`)
+	attempt.Header().Set("Date", past.Format(http.TimeFormat))
+	attempt.Header().Set("Content-Length", "5")
+	attempt.WriteHeader(http.StatusOK)
+	attempt.Write([]byte("image"))
+	r.finishCachedResponse(client, req, domain, 1, recorder, nil, past)
+	if client.Body.String() != "image" || client.Header().Get(cacheStatusHeader) != "MISS" {
+		t.Fatalf("client got %q with X-Cache %q", client.Body.String(), client.Header().Get(cacheStatusHeader))
+	}
+
+	// The stored response went stale an hour ago, so it is revalidated
+	client = httptest.NewRecorder()
+	req = newRequest()
+	stale, served := r.serveFromCache(client, req, domain, 2)
+	if stale == nil || served {
+		t.Fatal("stale entry with an ETag should be revalidated")
+	}
+	conditional := req.Clone(req.Context())
+	stale.AddConditionals(conditional)
+	if conditional.Header.Get("If-None-Match") != `"v1"` {
+		t.Fatalf("conditional request headers %v", conditional.Header)
+	}
+	recorder = &cacheRecorder{w: client, limit: cache.MaxObjectBytes(), revalidating: true}
+	attempt = newAttemptWriter(recorder)
+	attempt.Header().Set("Cache-Control", "max-age=60")
+	attempt.Header().Set("Date", time.Now().Format(http.TimeFormat))
+	attempt.WriteHeader(http.StatusNotModified)
+	r.finishCachedResponse(client, req, domain, 2, recorder, stale, time.Now())
+	if client.Code != http.StatusOK || client.Body.String() != "image" || client.Header().Get(cacheStatusHeader) != "REVALIDATED" {
+		t.Fatalf("revalidated response %d %q, X-Cache %q", client.Code, client.Body.String(), client.Header().Get(cacheStatusHeader))
+	}
+
+	// Now fresh again: a hit, and a 304 for a client that has it already
+	client = httptest.NewRecorder()
+	req = newRequest()
+	req.Header.Set("If-None-Match", `"v1"`)
+	if _, served := r.serveFromCache(client, req, domain, 3); !served {
+		t.Fatal("fresh entry was not served")
+	}
+	if client.Code != http.StatusNotModified || client.Header().Get(cacheStatusHeader) != "HIT" {
+		t.Fatalf("conditional hit answered %d, X-Cache %q", client.Code, client.Header().Get(cacheStatusHeader))
+	}
+
+	// Truncated bodies are not stored
+	client = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "http://example.com/cut", nil)
+	recorder = &cacheRecorder{w: client, limit: cache.MaxObjectBytes()}
+	recorder.Header().Set("Cache-Control", "max-age=60")
+	recorder.Header().Set("Content-Length", "10")
+	recorder.Write([]byte("short"))
+	r.finishCachedResponse(client, req, domain, 4, recorder, nil, time.Now())
+	if cache.Lookup(domain, req) != nil {
+		t.Fatal("truncated response was cached")
+	}
+
+	// PURGE is only taken from the node itself
+	purge := httptest.NewRequest("PURGE", "http://example.com/*", nil)
+	purge.RemoteAddr = "203.0.113.5:4000"
+	client = httptest.NewRecorder()
+	r.handlePurge(client, purge, domain, 5)
+	if client.Code != http.StatusForbidden {
+		t.Fatalf("remote PURGE answered %d", client.Code)
+	}
+	purge.RemoteAddr = "127.0.0.1:4000"
+	client = httptest.NewRecorder()
+	r.handlePurge(client, purge, domain, 6)
+	if client.Code != http.StatusOK || cache.Lookup(domain, newRequest()) != nil {
+		t.Fatalf("local PURGE answered %d and left the entry", client.Code)
+	}
+}
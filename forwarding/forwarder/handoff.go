@@ -0,0 +1,248 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// For upgrades a running node hands its listening sockets to a new process of
+// the same binary. Handoff starts the new process with the sockets as extra
+// files, named in ARCTURUS_LISTENERS, and waits until it has taken all of them
+// over in Listen and ListenPacket and reported ready through a pipe. The
+// kernel queues connection attempts meanwhile, so none is refused; the old
+// process then stops accepting and drains.
+
+const (
+	listenersEnv    = "ARCTURUS_LISTENERS"     // comma-separated keys of the sockets passed from fd 3 on
+	handoffReadyEnv = "ARCTURUS_HANDOFF_READY" // fd of the pipe the new process reports readiness on
+)
+
+var handoff = struct {
+	sync.Mutex
+	inherited map[string]*os.File // sockets from the previous process not yet taken over
+	active    map[string]fileConn // sockets of this process, by listenerKey
+	ready     *os.File
+}{
+	inherited: make(map[string]*os.File),
+	active:    make(map[string]fileConn),
+}
+
+type fileConn interface {
+	File() (*os.File, error)
+}
+
+func init() {
+	keys := os.Getenv(listenersEnv)
+	if keys == "" {
+		return
+	}
+	for i, key := range strings.Split(keys, ",") {
+		handoff.inherited[key] = os.NewFile(uintptr(3+i), key)
+	}
+	if fd, err := strconv.Atoi(os.Getenv(handoffReadyEnv)); err == nil {
+		handoff.ready = os.NewFile(uintptr(fd), "handoff-ready")
+	}
+	os.Unsetenv(listenersEnv)
+	os.Unsetenv(handoffReadyEnv)
+	log.Printf("[Handoff-INFO] Inherited %d listening socket(s) from the previous process.", len(handoff.inherited))
+}
+
+func listenerKey(network, addr string) string {
+	return network + "/" + addr
+}
+
+func takeInherited(key string) *os.File {
+	handoff.Lock()
+	defer handoff.Unlock()
+	file := handoff.inherited[key]
+	delete(handoff.inherited, key)
+	return file
+}
+
+func trackListener(key string, conn fileConn) {
+	handoff.Lock()
+	handoff.active[key] = conn
+	handoff.Unlock()
+}
+
+// Listen listens for TCP connections on addr, taking over the socket of the
+// previous process after a handoff.
+func Listen(addr string) (net.Listener, error) {
+	key := listenerKey("tcp", addr)
+	if file := takeInherited(key); file != nil {
+		defer file.Close()
+		listener, err := net.FileListener(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to take over inherited listener %s: %w", addr, err)
+		}
+		log.Printf("[Handoff-INFO] Took over TCP listener %s.", addr)
+		trackListener(key, listener.(*net.TCPListener))
+		return listener, nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	trackListener(key, listener.(*net.TCPListener))
+	return listener, nil
+}
+
+// ListenPacket is Listen for UDP sockets.
+func ListenPacket(addr string) (*net.UDPConn, error) {
+	key := listenerKey("udp", addr)
+	if file := takeInherited(key); file != nil {
+		defer file.Close()
+		conn, err := net.FilePacketConn(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to take over inherited UDP socket %s: %w", addr, err)
+		}
+		udpConn, ok := conn.(*net.UDPConn)
+		if !ok {
+			conn.Close()
+			return nil, fmt.Errorf("inherited socket %s is not a UDP socket", addr)
+		}
+		log.Printf("[Handoff-INFO] Took over UDP socket %s.", addr)
+		trackListener(key, udpConn)
+		return udpConn, nil
+	}
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn := conn.(*net.UDPConn)
+	trackListener(key, udpConn)
+	return udpConn, nil
+}
+
+// HandoffReady tells the previous process that this one has taken over its
+// sockets. It waits up to timeout for them to be claimed by Listen and
+// ListenPacket; sockets the configuration no longer uses are closed then.
+// Without a previous process it does nothing.
+func HandoffReady(timeout time.Duration) {
+	handoff.Lock()
+	ready := handoff.ready
+	handoff.ready = nil
+	handoff.Unlock()
+	if ready == nil {
+		return
+	}
+	defer ready.Close()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		handoff.Lock()
+		pending := len(handoff.inherited)
+		if pending == 0 || time.Now().After(deadline) {
+			for key, file := range handoff.inherited {
+				log.Printf("[Handoff-WARN] Inherited socket %s is not used by this configuration, closing it.", key)
+				file.Close()
+				delete(handoff.inherited, key)
+			}
+			handoff.Unlock()
+			break
+		}
+		handoff.Unlock()
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if _, err := ready.Write([]byte{1}); err != nil {
+		log.Printf("[Handoff-ERROR] Failed to report readiness to the previous process: %v", err)
+		return
+	}
+	log.Printf("[Handoff-INFO] Took over from the previous process.")
+}
+
+// Handoff starts a new process of this binary with the same arguments and
+// passes it the listening sockets. It returns once the new process is ready,
+// or an error if it exits or does not get ready within timeout; in that case
+// this process keeps serving.
+func Handoff(timeout time.Duration) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the executable: %w", err)
+	}
+
+	handoff.Lock()
+	keys := make([]string, 0, len(handoff.active))
+	files := make([]*os.File, 0, len(handoff.active))
+	for key := range handoff.active {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	passed := keys[:0]
+	for _, key := range keys {
+		file, err := handoff.active[key].File()
+		if err != nil {
+			// Closed since it was opened
+			delete(handoff.active, key)
+			continue
+		}
+		passed = append(passed, key)
+		files = append(files, file)
+	}
+	handoff.Unlock()
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the readiness pipe: %w", err)
+	}
+	defer readyReader.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyWriter)
+	cmd.Env = append(os.Environ(),
+		listenersEnv+"="+strings.Join(passed, ","),
+		handoffReadyEnv+"="+strconv.Itoa(3+len(files)))
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", executable, err)
+	}
+	log.Printf("[Handoff-INFO] Started process %d with %d listening socket(s), waiting for it to take over.", cmd.Process.Pid, len(files))
+
+	readyErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyReader.Read(buf)
+		if errors.Is(err, io.EOF) {
+			err = errors.New("it exited before it was ready")
+		}
+		readyErr <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-readyErr:
+	case <-timer.C:
+		err = fmt.Errorf("it was not ready within %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return nil, fmt.Errorf("handoff to process %d failed: %w", cmd.Process.Pid, err)
+	}
+	// The new process outlives this one; reap it in case it exits first
+	go cmd.Wait()
+	return cmd.Process, nil
+}
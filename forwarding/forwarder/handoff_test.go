@@ -0,0 +1,86 @@
+package forwarder
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+const handoffChildEnv = "ARCTURUS_TEST_HANDOFF_CHILD"
+
+// runAsChild makes Handoff start this test binary with args, keeping the
+// output of the child out of the test's.
+func runAsChild(t *testing.T, args ...string) {
+	osArgs, stdout := os.Args, os.Stdout
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Args, os.Stdout = append([]string{osArgs[0]}, args...), devNull
+	t.Cleanup(func() {
+		os.Args, os.Stdout = osArgs, stdout
+		devNull.Close()
+	})
+}
+
+// TestHandoffChild is the new process of TestHandoff.
+func TestHandoffChild(t *testing.T) {
+	if os.Getenv(handoffChildEnv) == "" {
+		t.Skip("only run by TestHandoff")
+	}
+	listener, err := Listen("127.0.0.1:0")
+	if err != nil {
+		os.Exit(2)
+	}
+	HandoffReady(time.Second)
+	conn, err := listener.Accept()
+	if err != nil {
+		os.Exit(3)
+	}
+	conn.Write([]byte("new process"))
+	conn.Close()
+	os.Exit(0)
+}
+
+func TestHandoff(t *testing.T) {
+	listener, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+
+	runAsChild(t, "-test.run=^TestHandoffChild$")
+	t.Setenv(handoffChildEnv, "1")
+
+	process, err := Handoff(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Kill()
+	// The old process stops accepting; the socket stays open in the new one
+	listener.Close()
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("socket closed with the old process: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	got, _ := io.ReadAll(conn)
+	if string(got) != "new process" {
+		t.Fatalf("connection answered %q", got)
+	}
+}
+
+func TestHandoffFailsWhenChildExits(t *testing.T) {
+	if _, err := Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	runAsChild(t, "-test.run=^TestHandoffChild$") // skips without the env and exits without taking over
+
+	if _, err := Handoff(10 * time.Second); err == nil {
+		t.Fatal("handoff to an exiting process succeeded")
+	}
+}
@@ -0,0 +1,487 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	packet "forwarding/packet_handler"
+	"forwarding/router"
+	"forwarding/scheduling_algorithms/k_shortest"
+	"forwarding/tracing"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Small idempotent requests of the hedged traffic classes may be sent twice:
+// on the picked path and, if no answer has come once the request is slower
+// than a percentile of the domain's recent responses, on the best path that
+// shares no relay with it. Both copies write through attemptWriters of one
+// hedgeRace, so the first response to reach processSmuxResponses goes to the
+// client and the other copy is dropped along with its request state. Hedges
+// are limited to a share of all requests per budget window.
+
+type HedgeConfig struct {
+	Enabled         bool
+	Classes         []byte        // traffic classes that are hedged
+	MaxBodySize     int64         // requests with larger bodies are sent once
+	DelayPercentile float64       // percentile of the domain's recent response times to wait before hedging, 0 to send both copies at once
+	MinDelay        time.Duration // lower bound of the delay, and the delay while there are too few samples
+	BudgetPercent   float64       // hedged requests as a share of all requests
+	BudgetWindow    time.Duration
+}
+
+func DefaultHedgeConfig() HedgeConfig {
+	return HedgeConfig{
+		Classes:         []byte{packet.PriorityInteractive},
+		MaxBodySize:     64 << 10,
+		DelayPercentile: 95,
+		MinDelay:        10 * time.Millisecond,
+		BudgetPercent:   5,
+		BudgetWindow:    10 * time.Second,
+	}
+}
+
+func (c HedgeConfig) Validate() error {
+	if c.Enabled && len(c.Classes) == 0 {
+		return fmt.Errorf("classes must not be empty while hedging is enabled")
+	}
+	for _, class := range c.Classes {
+		if _, ok := trafficClassNames[class]; !ok {
+			return fmt.Errorf("classes: unknown traffic class %d", class)
+		}
+	}
+	if c.MaxBodySize < 0 {
+		return fmt.Errorf("max_body_size must not be negative")
+	}
+	if c.DelayPercentile < 0 || c.DelayPercentile >= 100 {
+		return fmt.Errorf("delay_percentile must be in [0, 100), got %v", c.DelayPercentile)
+	}
+	if c.MinDelay < 0 {
+		return fmt.Errorf("min_delay must not be negative")
+	}
+	if c.BudgetPercent < 0 || c.BudgetPercent > 100 {
+		return fmt.Errorf("budget_percent must be in [0, 100], got %v", c.BudgetPercent)
+	}
+	if c.BudgetWindow <= 0 {
+		return fmt.Errorf("budget_window must be positive")
+	}
+	return nil
+}
+
+const (
+	hedgeLatencySamples    = 128 // recent response times kept per domain
+	hedgeMinLatencySamples = 20  // before this many, MinDelay is used
+)
+
+type hedger struct {
+	config HedgeConfig
+
+	mu          sync.Mutex
+	latencies   map[string]*latencyRing // domain -> recent times to first byte
+	windowStart time.Time
+	requests    int
+	hedges      int
+}
+
+func newHedger(config HedgeConfig) *hedger {
+	return &hedger{config: config, latencies: make(map[string]*latencyRing)}
+}
+
+// plan counts a request towards the budget and reports whether it should be
+// hedged, with the path for the second copy and how long to wait for the
+// first before sending it.
+func (h *hedger) plan(domain string, priority byte, replayable bool, bodySize int, primary k_shortest.PathWithIP, paths []k_shortest.PathWithIP) (k_shortest.PathWithIP, time.Duration, bool) {
+	if h == nil || !h.config.Enabled {
+		return k_shortest.PathWithIP{}, 0, false
+	}
+	h.mu.Lock()
+	h.rollWindow(time.Now())
+	h.requests++
+	h.mu.Unlock()
+
+	if !h.config.Qualifies(priority, replayable, bodySize) {
+		return k_shortest.PathWithIP{}, 0, false
+	}
+	second, ok := disjointPath(primary, paths)
+	if !ok {
+		return k_shortest.PathWithIP{}, 0, false
+	}
+	return second, h.delay(domain), true
+}
+
+// Qualifies reports whether a request of the given traffic class may be
+// hedged at all: it must be replayable, small enough and of a hedged class.
+// Whether it is depends on the budget and on a disjoint path, see plan.
+func (c HedgeConfig) Qualifies(priority byte, replayable bool, bodySize int) bool {
+	if !c.Enabled || !replayable || int64(bodySize) > c.MaxBodySize {
+		return false
+	}
+	priority = packet.NormalizePriority(priority)
+	for _, class := range c.Classes {
+		if packet.NormalizePriority(class) == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// takeBudget reserves one hedge if hedged requests stay within
+// BudgetPercent of the requests of the current window.
+func (h *hedger) takeBudget() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rollWindow(time.Now())
+	if float64(h.hedges+1) > float64(h.requests)*h.config.BudgetPercent/100 {
+		return false
+	}
+	h.hedges++
+	return true
+}
+
+// rollWindow must be called with h.mu held.
+func (h *hedger) rollWindow(now time.Time) {
+	if now.Sub(h.windowStart) >= h.config.BudgetWindow {
+		h.windowStart = now
+		h.requests = 0
+		h.hedges = 0
+	}
+}
+
+func (h *hedger) delay(domain string) time.Duration {
+	if h.config.DelayPercentile == 0 {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ring := h.latencies[domain]
+	if ring == nil || ring.len() < hedgeMinLatencySamples {
+		return h.config.MinDelay
+	}
+	if delay := ring.percentile(h.config.DelayPercentile); delay > h.config.MinDelay {
+		return delay
+	}
+	return h.config.MinDelay
+}
+
+// observe adds the time to first byte of a successful request to domain.
+func (h *hedger) observe(domain string, latency time.Duration) {
+	if h == nil || !h.config.Enabled {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ring := h.latencies[domain]
+	if ring == nil {
+		ring = &latencyRing{}
+		h.latencies[domain] = ring
+	}
+	ring.add(latency)
+}
+
+type latencyRing struct {
+	samples [hedgeLatencySamples]time.Duration
+	next    int
+	full    bool
+}
+
+func (l *latencyRing) add(d time.Duration) {
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % len(l.samples)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+func (l *latencyRing) len() int {
+	if l.full {
+		return len(l.samples)
+	}
+	return l.next
+}
+
+func (l *latencyRing) percentile(p float64) time.Duration {
+	sorted := append([]time.Duration(nil), l.samples[:l.len()]...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(float64(len(sorted)-1) * p / 100)
+	return sorted[index]
+}
+
+// disjointPath returns the lowest-latency path of paths whose relays are all
+// different from those of primary. Direct paths have no relays to avoid, so
+// they are never hedged.
+func disjointPath(primary k_shortest.PathWithIP, paths []k_shortest.PathWithIP) (k_shortest.PathWithIP, bool) {
+	if len(primary.IPList) < 3 {
+		return k_shortest.PathWithIP{}, false
+	}
+	used := make(map[string]bool, len(primary.IPList)-2)
+	for _, relay := range primary.IPList[1 : len(primary.IPList)-1] {
+		used[relay] = true
+	}
+
+	var best k_shortest.PathWithIP
+	found := false
+	for _, path := range paths {
+		if len(path.IPList) < 3 || (found && path.Latency >= best.Latency) {
+			continue
+		}
+		disjoint := true
+		for _, relay := range path.IPList[1 : len(path.IPList)-1] {
+			if used[relay] {
+				disjoint = false
+				break
+			}
+		}
+		if disjoint {
+			best, found = path, true
+		}
+	}
+	return best, found
+}
+
+// hedgeRace lets the first attemptWriter of a hedged request to start
+// writing have the client; the others are treated as abandoned.
+type hedgeRace struct {
+	mu     sync.Mutex
+	winner *attemptWriter
+}
+
+func (h *hedgeRace) claim(a *attemptWriter) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.winner == nil {
+		h.winner = a
+	}
+	return h.winner == a
+}
+
+type attemptOutcome struct {
+	attempt *forwardAttempt
+	err     error
+}
+
+// waitForHedged waits like waitForAttempt for primary, but sends a copy of
+// the request with startHedge once primary has gone delay without an answer
+// and the budget allows. It returns the attempt that answered, or the last
+// one to fail; the other attempt is finished and recorded here.
+func (r *Repository) waitForHedged(primary *forwardAttempt, startHedge func() (*forwardAttempt, error), domain string, delay, timeout time.Duration) (*forwardAttempt, error) {
+	stop := make(chan struct{})
+	defer close(stop)
+	outcomes := make(chan attemptOutcome, 2)
+	watch := func(a *forwardAttempt) {
+		go func() {
+			select {
+			case <-a.item.ResponseReceived:
+				outcomes <- attemptOutcome{a, nil}
+			case err := <-a.item.AttemptFailed:
+				outcomes <- attemptOutcome{a, err}
+			case <-stop:
+			}
+		}()
+	}
+	watch(primary)
+	running := []*forwardAttempt{primary}
+
+	hedgeTimer := time.NewTimer(delay)
+	defer hedgeTimer.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	hedgeDue, deadlineC := hedgeTimer.C, deadline.C
+	hedged, streaming := false, false
+
+	for {
+		select {
+		case <-hedgeDue:
+			hedgeDue = nil
+			if !r.hedger.takeBudget() {
+				hedgesTotal.With(domain, "over_budget").Inc()
+				continue
+			}
+			hedge, err := startHedge()
+			if err != nil {
+				log.Printf("[Access-WARN] Request ID %d: Failed to send hedged copy: %v", primary.item.RequestID, err)
+				continue
+			}
+			log.Printf("[Access-INFO] Request ID %d: No response after %s, sent hedged copy as Request ID %d on path %v.",
+				primary.item.RequestID, delay, hedge.item.RequestID, hedge.path.IPList)
+			hedged = true
+			watch(hedge)
+			running = append(running, hedge)
+
+		case outcome := <-outcomes:
+			a := outcome.attempt
+			if streaming {
+				if a.writer.firstByteAt().IsZero() {
+					continue // an abandoned copy
+				}
+				if outcome.err != nil {
+					log.Printf("[Access-ERROR] Request ID %d: Failed after the response to the client had started: %v", a.item.RequestID, outcome.err)
+				}
+				r.finishHedge(domain, a, running, hedged)
+				return a, nil
+			}
+			if outcome.err == nil && a.writer.firstByteAt().IsZero() {
+				// An empty response of the copy that lost the race; the winner's is on its way
+				running = removeAttempt(running, a)
+				r.discardHedge(a)
+				continue
+			}
+			if outcome.err == nil {
+				r.finishHedge(domain, a, running, hedged)
+				return a, nil
+			}
+			if len(running) == 1 {
+				if hedged {
+					hedgesTotal.With(domain, "failed").Inc()
+				}
+				return a, outcome.err // the retry loop takes over
+			}
+			// The other copy may still answer
+			running = removeAttempt(running, a)
+			r.finishAttempt(a, domain, outcome.err)
+			router.GetInstance().MarkPathFailed(a.path.IPList, r.accessConfig.Retry.FailedPathPenalty)
+			r.stateManager.RemoveState(a.item.RequestID)
+
+		case <-deadlineC:
+			deadlineC, hedgeDue = nil, nil
+			var started *forwardAttempt
+			for _, a := range running {
+				if !a.writer.abandon() {
+					started = a
+				}
+			}
+			if started == nil {
+				for _, a := range running[1:] {
+					r.finishAttempt(a, domain, errAttemptTimeout)
+					r.stateManager.RemoveState(a.item.RequestID)
+				}
+				if hedged {
+					hedgesTotal.With(domain, "failed").Inc()
+				}
+				return running[0], errAttemptTimeout
+			}
+			// A streamed response is being copied to the client; it is bounded by the stream idle timeout instead
+			streaming = true
+		}
+	}
+}
+
+// finishHedge drops the copies of a hedged request other than winner and
+// counts which copy answered.
+func (r *Repository) finishHedge(domain string, winner *forwardAttempt, running []*forwardAttempt, hedged bool) {
+	for _, a := range running {
+		if a != winner {
+			r.discardHedge(a)
+		}
+	}
+	if winner.hedge {
+		hedgesTotal.With(domain, "hedge").Inc()
+	} else if hedged {
+		hedgesTotal.With(domain, "primary").Inc()
+	}
+}
+
+// discardHedge drops a copy of a hedged request that lost the race.
+func (r *Repository) discardHedge(a *forwardAttempt) {
+	a.writer.abandon()
+	r.stateManager.RemoveState(a.item.RequestID) // a late response finds no state and is dropped
+	a.span.SetAttribute("arcturus.hedge.discarded", true)
+	a.span.End()
+}
+
+func removeAttempt(attempts []*forwardAttempt, a *forwardAttempt) []*forwardAttempt {
+	kept := attempts[:0:0]
+	for _, other := range attempts {
+		if other != a {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
+
+// forwardAttempt is one copy of a client request sent on one path.
+type forwardAttempt struct {
+	item   *RequestItem
+	writer *attemptWriter
+	span   *tracing.Span
+	path   k_shortest.PathWithIP
+	start  time.Time
+	hedge  bool // the second copy of a hedged request
+}
+
+var errRequestQueueTimeout = errors.New("request queue timeout")
+
+// startAttempt sends req on path as request requestID, writing to the client
+// through writer. It fails with errRequestQueueTimeout if the request queue
+// stays full.
+func (r *Repository) startAttempt(req *http.Request, replayBody []byte, writer *attemptWriter, path k_shortest.PathWithIP,
+	requestID uint32, priority byte, receivedAt time.Time, parent *tracing.Span, number int) (*forwardAttempt, error) {
+	header, err := packet.NewPacket(path.IPList, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create packet header for %v: %w", path.IPList, err)
+	}
+	header.Priority = priority
+
+	nextHopIP, isLastHop, err := header.GetNextHopIP()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine next hop: %w", err)
+	}
+	log.Printf("[Access-INFO] Request ID %d: Determined next hop: %s, IsLastHop: %v", requestID, nextHopIP, isLastHop)
+
+	reqItem := &RequestItem{
+		Request:          attemptRequest(req, replayBody),
+		ResponseWriter:   writer,
+		RequestID:        requestID,
+		ReceivedAt:       receivedAt,
+		ResponseReceived: make(chan struct{}),
+		AttemptFailed:    make(chan error, 1),
+		IsLastHop:        isLastHop,
+		NextHopIP:        nextHopIP,
+		HopList:          header.HopList, // This is the full path selected
+		Priority:         priority,
+	}
+	if !isLastHop {
+		// If not the last hop, we need to pack the header to be sent with the request data
+		headerBytes, err := header.Pack()
+		if err != nil {
+			return nil, fmt.Errorf("failed to pack forwarding header: %w", err)
+		}
+		reqItem.HeaderBytes = headerBytes
+		log.Printf("[Access-DEBUG] Request ID %d: Header packed for forwarding, size: %d bytes.", requestID, len(headerBytes))
+	}
+
+	span := tracing.Start(parent.Context(), "access.attempt", tracing.KindClient)
+	span.SetAttribute(attrRequestID, requestID)
+	span.SetAttribute(attrHopList, router.PathKey(path.IPList))
+	span.SetAttribute("arcturus.attempt", number)
+	reqItem.Trace = span.Context()
+	reqItem.Request = tracedRequest(reqItem.Request, reqItem.Trace)
+	reqItem.QueuedAt = time.Now()
+
+	select {
+	case r.httpRequestChan <- reqItem:
+		log.Printf("[Access-DEBUG] Request ID %d: Submitted to httpRequestChan for processing.", requestID)
+	case <-time.After(currentTimeouts().QueueSubmit):
+		span.SetError(errRequestQueueTimeout)
+		span.End()
+		return nil, errRequestQueueTimeout
+	}
+	return &forwardAttempt{item: reqItem, writer: writer, span: span, path: path, start: time.Now()}, nil
+}
+
+// finishAttempt ends the attempt's span and records its outcome in the path
+// health, the hedging delay and the request metrics.
+func (r *Repository) finishAttempt(a *forwardAttempt, domain string, err error) {
+	a.span.SetError(err)
+	a.span.End()
+	latency := time.Since(a.start)
+	if firstByte := a.writer.firstByteAt(); !firstByte.IsZero() {
+		latency = firstByte.Sub(a.start) // time to first byte, so large bodies don't count as slow paths
+	}
+	r.pathHealth.Record(CalculatePathHash(a.item.HopList), latency, err)
+	recordAttempt(domain, a.path.IPList, latency, err)
+	if err == nil {
+		r.hedger.observe(domain, latency)
+	}
+}
@@ -0,0 +1,211 @@
+package forwarder
+
+import (
+	packet "forwarding/packet_handler"
+	"forwarding/scheduling_algorithms/k_shortest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDisjointPath(t *testing.T) {
+	primary := k_shortest.PathWithIP{IPList: []string{"a", "r1", "r2", "z"}, Latency: 10}
+	paths := []k_shortest.PathWithIP{
+		primary,
+		{IPList: []string{"a", "r3", "r2", "z"}, Latency: 12},
+		{IPList: []string{"a", "r4", "z"}, Latency: 30},
+		{IPList: []string{"a", "r3", "z"}, Latency: 20},
+		{IPList: []string{"a", "z"}, Latency: 5},
+	}
+	second, ok := disjointPath(primary, paths)
+	if !ok || second.Latency != 20 {
+		t.Errorf("expected the best path through other relays, got %v", second.IPList)
+	}
+	if _, ok := disjointPath(paths[4], paths); ok {
+		t.Errorf("a direct path should not be hedged")
+	}
+	if _, ok := disjointPath(primary, paths[:2]); ok {
+		t.Errorf("paths sharing a relay should not be used for the hedge")
+	}
+}
+
+func TestHedgerPlanAndBudget(t *testing.T) {
+	config := DefaultHedgeConfig()
+	config.Enabled = true
+	config.BudgetPercent = 10
+	h := newHedger(config)
+	primary := k_shortest.PathWithIP{IPList: []string{"a", "r1", "z"}}
+	paths := []k_shortest.PathWithIP{primary, {IPList: []string{"a", "r2", "z"}}}
+
+	if _, _, ok := h.plan("example.com", packet.PriorityStandard, true, 0, primary, paths); ok {
+		t.Errorf("standard traffic should not be hedged")
+	}
+	if _, _, ok := h.plan("example.com", packet.PriorityInteractive, false, 0, primary, paths); ok {
+		t.Errorf("requests that can't be replayed should not be hedged")
+	}
+	if _, _, ok := h.plan("example.com", packet.PriorityInteractive, true, 1<<20, primary, paths); ok {
+		t.Errorf("large requests should not be hedged")
+	}
+	second, delay, ok := h.plan("example.com", packet.PriorityInteractive, true, 0, primary, paths)
+	if !ok || second.IPList[1] != "r2" || delay != config.MinDelay {
+		t.Fatalf("plan = %v, %v, %v", second.IPList, delay, ok)
+	}
+
+	// 4 requests so far: none may be hedged at 10%
+	if h.takeBudget() {
+		t.Errorf("hedge allowed above the budget")
+	}
+	for i := 0; i < 6; i++ {
+		h.plan("example.com", packet.PriorityStandard, true, 0, primary, paths)
+	}
+	if !h.takeBudget() || h.takeBudget() {
+		t.Errorf("expected exactly one hedge in 10 requests")
+	}
+
+	for i := 1; i <= 100; i++ {
+		h.observe("example.com", time.Duration(i)*time.Millisecond)
+	}
+	if _, delay, _ := h.plan("example.com", packet.PriorityInteractive, true, 0, primary, paths); delay != 95*time.Millisecond {
+		t.Errorf("expected the 95th percentile as delay, got %v", delay)
+	}
+}
+
+func TestHedgerPlanConfiguredClasses(t *testing.T) {
+	config := DefaultHedgeConfig()
+	config.Enabled = true
+	config.Classes = []byte{packet.PriorityStandard, packet.PriorityBulk}
+	h := newHedger(config)
+	primary := k_shortest.PathWithIP{IPList: []string{"a", "r1", "z"}}
+	paths := []k_shortest.PathWithIP{primary, {IPList: []string{"a", "r2", "z"}}}
+
+	if _, _, ok := h.plan("example.com", packet.PriorityBulk, true, 0, primary, paths); !ok {
+		t.Errorf("bulk traffic is configured to be hedged")
+	}
+	if _, _, ok := h.plan("example.com", packet.PriorityInteractive, true, 0, primary, paths); ok {
+		t.Errorf("interactive traffic is no longer a hedged class")
+	}
+
+	config.Classes = nil
+	if err := config.Validate(); err == nil {
+		t.Errorf("enabled hedging without classes accepted")
+	}
+}
+
+func TestHedgeRaceFirstWriterWins(t *testing.T) {
+	rec := httptest.NewRecorder()
+	race := &hedgeRace{}
+	first, second := newAttemptWriter(rec), newAttemptWriter(rec)
+	first.race, second.race = race, race
+
+	second.Header().Set("X-Copy", "2")
+	second.WriteHeader(http.StatusOK)
+	first.WriteHeader(http.StatusBadGateway)
+	if _, err := first.Write([]byte("late")); err != errAttemptAbandoned {
+		t.Errorf("expected errAttemptAbandoned for the slower copy, got %v", err)
+	}
+	second.Write([]byte("ok"))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" || rec.Header().Get("X-Copy") != "2" {
+		t.Errorf("client got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWaitForHedgedTakesFirstResponse(t *testing.T) {
+	stateManager := NewRequestStateManager(time.Minute, time.Minute)
+	defer stateManager.Stop()
+	config := DefaultHedgeConfig()
+	config.Enabled = true
+	config.BudgetPercent = 100
+	r := &Repository{stateManager: stateManager, hedger: newHedger(config), pathHealth: NewPathHealthTracker(DefaultPathHealthConfig())}
+	r.hedger.windowStart, r.hedger.requests = time.Now(), 1
+
+	rec := httptest.NewRecorder()
+	race := &hedgeRace{}
+	newAttempt := func(id uint32, hedge bool) *forwardAttempt {
+		writer := newAttemptWriter(rec)
+		writer.race = race
+		return &forwardAttempt{
+			item:   &RequestItem{RequestID: id, ResponseReceived: make(chan struct{}), AttemptFailed: make(chan error, 1)},
+			writer: writer,
+			path:   k_shortest.PathWithIP{IPList: []string{"a", "r", "z"}},
+			start:  time.Now(),
+			hedge:  hedge,
+		}
+	}
+	primary := newAttempt(1, false)
+	hedgeSent := make(chan *forwardAttempt, 1)
+	startHedge := func() (*forwardAttempt, error) {
+		hedge := newAttempt(2, true)
+		hedgeSent <- hedge
+		return hedge, nil
+	}
+	go func() {
+		hedge := <-hedgeSent
+		hedge.writer.WriteHeader(http.StatusNoContent)
+		close(hedge.item.ResponseReceived)
+	}()
+
+	winner, err := r.waitForHedged(primary, startHedge, "example.com", 10*time.Millisecond, time.Second)
+	if err != nil || !winner.hedge {
+		t.Fatalf("expected the hedged copy to win, got request %d, err %v", winner.item.RequestID, err)
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("client got %d", rec.Code)
+	}
+	if _, err := primary.writer.Write([]byte("late")); err != errAttemptAbandoned {
+		t.Errorf("the slower copy should be discarded, got %v", err)
+	}
+}
+
+func TestWaitForHedgedDiscardsEmptyLoser(t *testing.T) {
+	stateManager := NewRequestStateManager(time.Minute, time.Minute)
+	defer stateManager.Stop()
+	config := DefaultHedgeConfig()
+	config.Enabled = true
+	config.BudgetPercent = 100
+	r := &Repository{stateManager: stateManager, hedger: newHedger(config), pathHealth: NewPathHealthTracker(DefaultPathHealthConfig())}
+	r.hedger.windowStart, r.hedger.requests = time.Now(), 1
+
+	rec := httptest.NewRecorder()
+	race := &hedgeRace{}
+	newAttempt := func(id uint32, hedge bool) *forwardAttempt {
+		writer := newAttemptWriter(rec)
+		writer.race = race
+		stateManager.AddState(&RequestState{RequestID: id, Status: StatusPending, CreatedAt: time.Now()})
+		return &forwardAttempt{
+			item:   &RequestItem{RequestID: id, ResponseReceived: make(chan struct{}), AttemptFailed: make(chan error, 1)},
+			writer: writer,
+			path:   k_shortest.PathWithIP{IPList: []string{"a", "r", "z"}},
+			start:  time.Now(),
+			hedge:  hedge,
+		}
+	}
+	primary := newAttempt(1, false)
+	hedgeSent := make(chan *forwardAttempt, 1)
+	startHedge := func() (*forwardAttempt, error) {
+		hedge := newAttempt(2, true)
+		hedgeSent <- hedge
+		return hedge, nil
+	}
+	go func() {
+		hedge := <-hedgeSent
+		primary.writer.WriteHeader(http.StatusOK)
+		// The copy that lost the race reports success without having written anything
+		close(hedge.item.ResponseReceived)
+		for {
+			if _, ok := stateManager.GetState(2); !ok {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		close(primary.item.ResponseReceived)
+	}()
+
+	winner, err := r.waitForHedged(primary, startHedge, "example.com", 10*time.Millisecond, time.Second)
+	if err != nil || winner != primary {
+		t.Fatalf("expected the primary to win, got request %d, err %v", winner.item.RequestID, err)
+	}
+	if _, ok := stateManager.GetState(2); ok {
+		t.Error("the state of the losing copy was kept")
+	}
+}
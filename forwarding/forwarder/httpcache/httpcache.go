@@ -0,0 +1,372 @@
+// Package httpcache is the shared response cache of the access proxy. It
+// follows RFC 9111 for what may be stored and for how long, keys entries by
+// domain and request URI with Vary selecting among variants, and keeps them in
+// a memory LRU that spills into an optional disk LRU. Stale entries that carry
+// validators are kept so the caller can revalidate them with a conditional
+// request instead of fetching the whole body again.
+package httpcache
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Enabled         bool
+	Domains         []string // cache only these domains, empty means all
+	DisabledDomains []string
+	MemoryBytes     int64
+	DiskDir         string // empty keeps the cache in memory only
+	DiskBytes       int64
+	MaxObjectBytes  int64
+}
+
+var DefaultConfig = Config{
+	Enabled:        false,
+	MemoryBytes:    64 << 20,
+	DiskBytes:      1 << 30,
+	MaxObjectBytes: 8 << 20,
+}
+
+// Entry is a stored response together with what is needed to judge its freshness.
+type Entry struct {
+	Key          string
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	VaryValues   map[string]string // request header values the response was selected by
+	RequestTime  time.Time
+	ResponseTime time.Time
+	Lifetime     time.Duration
+	NoCache      bool
+}
+
+// Age is the current age of the entry (RFC 9111, section 4.2.3).
+func (e *Entry) Age(now time.Time) time.Duration {
+	var apparent time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil && e.ResponseTime.After(date) {
+		apparent = e.ResponseTime.Sub(date)
+	}
+	var ageValue time.Duration
+	if seconds, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	corrected := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	return max(apparent, corrected) + now.Sub(e.ResponseTime)
+}
+
+func (e *Entry) Fresh(now time.Time) bool {
+	return !e.NoCache && e.Age(now) < e.Lifetime
+}
+
+func (e *Entry) HasValidators() bool {
+	return hasValidators(e.Header)
+}
+
+// AddConditionals turns req into a revalidation of the entry.
+func (e *Entry) AddConditionals(req *http.Request) {
+	if etag := e.Header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := e.Header.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// NotModified reports whether the client's own validators match the entry.
+func (e *Entry) NotModified(req *http.Request) bool {
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		etag := strings.TrimPrefix(e.Header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(e.Header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(since)
+}
+
+func (e *Entry) matches(req *http.Request) bool {
+	for name, value := range e.VaryValues {
+		if strings.Join(req.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Entry) size() int64 {
+	size := int64(len(e.Key)+len(e.Body)) + 256
+	for name, values := range e.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
+
+// Key identifies the cached response for a request to domain.
+func Key(domain string, req *http.Request) string {
+	return strings.ToLower(domain) + req.URL.RequestURI()
+}
+
+type Cache struct {
+	config   Config
+	domains  map[string]bool
+	disabled map[string]bool
+
+	mu      sync.Mutex
+	memory  *memoryTier
+	disk    *diskTier // nil without DiskDir
+	purgeID uint64    // bumped by every purge, so a concurrent demotion does not resurrect what it removed
+}
+
+func New(config Config) (*Cache, error) {
+	c := &Cache{
+		config:   config,
+		domains:  make(map[string]bool),
+		disabled: make(map[string]bool),
+		memory:   newMemoryTier(config.MemoryBytes),
+	}
+	for _, domain := range config.Domains {
+		c.domains[strings.ToLower(domain)] = true
+	}
+	for _, domain := range config.DisabledDomains {
+		c.disabled[strings.ToLower(domain)] = true
+	}
+	if config.DiskDir != "" && config.DiskBytes > 0 {
+		disk, err := newDiskTier(config.DiskDir, config.DiskBytes)
+		if err != nil {
+			return nil, err
+		}
+		c.disk = disk
+	}
+	return c, nil
+}
+
+// Enabled reports whether responses for domain are cached.
+func (c *Cache) Enabled(domain string) bool {
+	if c == nil || !c.config.Enabled {
+		return false
+	}
+	domain = strings.ToLower(domain)
+	if c.disabled[domain] {
+		return false
+	}
+	return len(c.domains) == 0 || c.domains[domain]
+}
+
+// Cacheable reports whether req may be answered from the cache at all.
+func (c *Cache) Cacheable(domain string, req *http.Request) bool {
+	return c.Enabled(domain) && requestCacheable(req)
+}
+
+// MaxObjectBytes is the largest body that is stored.
+func (c *Cache) MaxObjectBytes() int64 {
+	return min(c.config.MaxObjectBytes, c.config.MemoryBytes)
+}
+
+// Lookup returns the stored response for req, fresh or stale, or nil.
+func (c *Cache) Lookup(domain string, req *http.Request) *Entry {
+	key := Key(domain, req)
+
+	c.mu.Lock()
+	if entry := c.memory.get(key); entry != nil {
+		c.mu.Unlock()
+		if !entry.matches(req) {
+			return nil
+		}
+		return entry
+	}
+	var path string
+	var onDisk bool
+	if c.disk != nil {
+		path, onDisk = c.disk.lookup(key)
+	}
+	c.mu.Unlock()
+	if !onDisk {
+		return nil
+	}
+
+	entry, err := readEntryFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[Cache-WARN] %v", err)
+		}
+		return nil
+	}
+	if entry.Key != key {
+		return nil
+	}
+	c.put(entry) // promote, the disk copy stays until evicted
+	if !entry.matches(req) {
+		return nil
+	}
+	return entry
+}
+
+// Store caches a complete response to req if its headers allow it.
+func (c *Cache) Store(domain string, req *http.Request, status int, header http.Header, body []byte, requestTime, responseTime time.Time) bool {
+	if !c.Cacheable(domain, req) || int64(len(body)) > c.MaxObjectBytes() {
+		return false
+	}
+	lifetime, noCache, ok := freshness(status, header, responseTime)
+	if !ok {
+		return false
+	}
+	entry := &Entry{
+		Key:          Key(domain, req),
+		StatusCode:   status,
+		Header:       header.Clone(),
+		Body:         body,
+		VaryValues:   make(map[string]string),
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		Lifetime:     lifetime,
+		NoCache:      noCache,
+	}
+	for _, name := range varyNames(header) {
+		entry.VaryValues[name] = strings.Join(req.Header.Values(name), ", ")
+	}
+	c.put(entry)
+	return true
+}
+
+// Refresh applies the headers of a 304 answer to a revalidated entry and
+// returns the updated entry (RFC 9111, section 4.3.4). If the new headers
+// forbid caching, the entry is dropped but still returned for this response.
+func (c *Cache) Refresh(entry *Entry, notModified http.Header, requestTime, responseTime time.Time) *Entry {
+	header := entry.Header.Clone()
+	for name, values := range notModified {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range":
+			continue
+		}
+		header[name] = values
+	}
+	header.Del("Age")
+	refreshed := *entry
+	refreshed.Header = header
+	refreshed.RequestTime = requestTime
+	refreshed.ResponseTime = responseTime
+
+	lifetime, noCache, ok := freshness(entry.StatusCode, header, responseTime)
+	if !ok {
+		c.Purge(entry.Key)
+		return &refreshed
+	}
+	refreshed.Lifetime = lifetime
+	refreshed.NoCache = noCache
+	c.put(&refreshed)
+	return &refreshed
+}
+
+func (c *Cache) put(entry *Entry) {
+	c.mu.Lock()
+	evicted := c.memory.put(entry)
+	purgeID := c.purgeID
+	c.mu.Unlock()
+
+	if c.disk == nil {
+		return
+	}
+	for _, victim := range evicted {
+		c.demote(victim, purgeID)
+	}
+}
+
+// demote moves an entry evicted from memory to disk.
+func (c *Cache) demote(entry *Entry, purgeID uint64) {
+	path := c.disk.path(entry.Key)
+	size, err := writeEntryFile(path, entry)
+	if err != nil {
+		log.Printf("[Cache-WARN] Failed to move %s to disk: %v", entry.Key, err)
+		return
+	}
+
+	c.mu.Lock()
+	var stale []string
+	if c.purgeID != purgeID {
+		if _, indexed := c.disk.items[entry.Key]; !indexed {
+			stale = []string{path}
+		}
+	} else {
+		stale = c.disk.add(entry.Key, size)
+	}
+	c.mu.Unlock()
+	for _, path := range stale {
+		os.Remove(path)
+	}
+}
+
+// Purge removes the entry stored under key (see Key) and reports whether there was one.
+func (c *Cache) Purge(key string) bool {
+	return c.purge(func(k string) bool { return k == key }) > 0
+}
+
+// PurgeDomain removes every entry of domain and returns how many there were.
+func (c *Cache) PurgeDomain(domain string) int {
+	prefix := strings.ToLower(domain) + "/"
+	return c.purge(func(key string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+func (c *Cache) purge(match func(key string) bool) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	c.purgeID++
+	removed := make(map[string]bool)
+	for _, key := range c.memory.keys() {
+		if match(key) && c.memory.remove(key) {
+			removed[key] = true
+		}
+	}
+	var paths []string
+	if c.disk != nil {
+		for _, key := range c.disk.keys() {
+			if !match(key) {
+				continue
+			}
+			if path, ok := c.disk.remove(key); ok {
+				paths = append(paths, path)
+				removed[key] = true
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[Cache-WARN] Failed to remove %s: %v", path, err)
+		}
+	}
+	return len(removed)
+}
+
+// Stats returns the number of entries and bytes held by each tier.
+func (c *Cache) Stats() (memoryEntries int, memoryBytes int64, diskEntries int, diskBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	memoryEntries, memoryBytes = len(c.memory.items), c.memory.used
+	if c.disk != nil {
+		diskEntries, diskBytes = len(c.disk.items), c.disk.used
+	}
+	return
+}
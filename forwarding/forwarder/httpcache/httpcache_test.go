@@ -0,0 +1,172 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		status   int
+		header   map[string]string
+		lifetime time.Duration
+		noCache  bool
+		ok       bool
+	}{
+		{"max-age", 200, map[string]string{"Cache-Control": "public, max-age=60"}, time.Minute, false, true},
+		{"s-maxage wins", 200, map[string]string{"Cache-Control": "max-age=60, s-maxage=300"}, 5 * time.Minute, false, true},
+		{"expires", 200, map[string]string{"Date": now.Format(http.TimeFormat), "Expires": now.Add(time.Hour).Format(http.TimeFormat)}, time.Hour, false, true},
+		{"heuristic", 200, map[string]string{"Date": now.Format(http.TimeFormat), "Last-Modified": now.Add(-100 * time.Minute).Format(http.TimeFormat)}, 10 * time.Minute, false, true},
+		{"heuristic capped", 200, map[string]string{"Date": now.Format(http.TimeFormat), "Last-Modified": now.AddDate(-1, 0, 0).Format(http.TimeFormat)}, 24 * time.Hour, false, true},
+		{"no-cache with etag", 200, map[string]string{"Cache-Control": "no-cache", "ETag": `"v1"`}, 0, true, true},
+		{"no-cache without validators", 200, map[string]string{"Cache-Control": "no-cache"}, 0, false, false},
+		{"no explicit lifetime", 200, map[string]string{}, 0, false, false},
+		{"no-store", 200, map[string]string{"Cache-Control": "no-store, max-age=60"}, 0, false, false},
+		{"private", 200, map[string]string{"Cache-Control": "private, max-age=60"}, 0, false, false},
+		{"set-cookie", 200, map[string]string{"Cache-Control": "max-age=60", "Set-Cookie": "a=b"}, 0, false, false},
+		{"vary star", 200, map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}, 0, false, false},
+		{"uncacheable status", 500, map[string]string{"Cache-Control": "max-age=60"}, 0, false, false},
+		{"cacheable 404", 404, map[string]string{"Cache-Control": "max-age=30"}, 30 * time.Second, false, true},
+	}
+	for _, c := range cases {
+		header := make(http.Header)
+		for name, value := range c.header {
+			header.Set(name, value)
+		}
+		lifetime, noCache, ok := freshness(c.status, header, now)
+		if lifetime != c.lifetime || noCache != c.noCache || ok != c.ok {
+			t.Errorf("%s: got (%v, %v, %v), want (%v, %v, %v)", c.name, lifetime, noCache, ok, c.lifetime, c.noCache, c.ok)
+		}
+	}
+}
+
+func newTestCache(t *testing.T, config Config) *Cache {
+	t.Helper()
+	config.Enabled = true
+	c, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func store(c *Cache, domain, url string, header http.Header, body string) bool {
+	req := httptest.NewRequest("GET", url, nil)
+	now := time.Now()
+	return c.Store(domain, req, http.StatusOK, header, []byte(body), now, now)
+}
+
+func TestStoreAndLookup(t *testing.T) {
+	c := newTestCache(t, DefaultConfig)
+	header := http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Encoding"}}
+	if !store(c, "example.com", "http://example.com/a?x=1", header, "plain") {
+		t.Fatal("response was not stored")
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com/a?x=1", nil)
+	entry := c.Lookup("example.com", req)
+	if entry == nil || string(entry.Body) != "plain" || !entry.Fresh(time.Now()) {
+		t.Fatalf("lookup returned %+v", entry)
+	}
+	if c.Lookup("example.com", httptest.NewRequest("GET", "http://example.com/a?x=2", nil)) != nil {
+		t.Fatal("different query string should miss")
+	}
+	gzipReq := httptest.NewRequest("GET", "http://example.com/a?x=1", nil)
+	gzipReq.Header.Set("Accept-Encoding", "gzip")
+	if c.Lookup("example.com", gzipReq) != nil {
+		t.Fatal("request with another Accept-Encoding should miss")
+	}
+
+	authorized := httptest.NewRequest("GET", "http://example.com/a?x=1", nil)
+	authorized.Header.Set("Authorization", "Bearer x")
+	if c.Cacheable("example.com", authorized) {
+		t.Fatal("requests with Authorization must bypass the cache")
+	}
+}
+
+func TestDomainSelection(t *testing.T) {
+	c := newTestCache(t, Config{Domains: []string{"a.com", "b.com"}, DisabledDomains: []string{"b.com"}, MemoryBytes: 1 << 20, MaxObjectBytes: 1 << 20})
+	if !c.Enabled("a.com") || c.Enabled("b.com") || c.Enabled("c.com") {
+		t.Fatal("domain selection not applied")
+	}
+	var disabled *Cache
+	if disabled.Enabled("a.com") {
+		t.Fatal("nil cache must be disabled")
+	}
+}
+
+func TestRevalidation(t *testing.T) {
+	c := newTestCache(t, DefaultConfig)
+	past := time.Now().Add(-time.Hour)
+	req := httptest.NewRequest("GET", "http://example.com/doc", nil)
+	header := http.Header{"Cache-Control": {"max-age=10"}, "Etag": {`"v1"`}, "Date": {past.Format(http.TimeFormat)}}
+	if !c.Store("example.com", req, http.StatusOK, header, []byte("body"), past, past) {
+		t.Fatal("response was not stored")
+	}
+	entry := c.Lookup("example.com", req)
+	if entry == nil || entry.Fresh(time.Now()) {
+		t.Fatal("expected a stale entry")
+	}
+
+	conditional := httptest.NewRequest("GET", "http://example.com/doc", nil)
+	entry.AddConditionals(conditional)
+	if conditional.Header.Get("If-None-Match") != `"v1"` || !entry.NotModified(conditional) {
+		t.Fatalf("conditional headers %v", conditional.Header)
+	}
+
+	now := time.Now()
+	refreshed := c.Refresh(entry, http.Header{"Cache-Control": {"max-age=300"}, "Date": {now.Format(http.TimeFormat)}}, now, now)
+	if !refreshed.Fresh(now) || string(refreshed.Body) != "body" || refreshed.Header.Get("ETag") != `"v1"` {
+		t.Fatalf("refreshed entry %+v", refreshed)
+	}
+	if again := c.Lookup("example.com", req); again == nil || !again.Fresh(now) {
+		t.Fatal("refreshed entry was not stored")
+	}
+}
+
+func TestMemoryEvictionSpillsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{MemoryBytes: 3000, DiskDir: dir, DiskBytes: 4000, MaxObjectBytes: 1000}
+	c := newTestCache(t, config)
+	header := http.Header{"Cache-Control": {"max-age=60"}}
+	body := strings.Repeat("x", 900)
+	for i := 0; i < 6; i++ {
+		if !store(c, "example.com", fmt.Sprintf("http://example.com/%d", i), header, body) {
+			t.Fatalf("object %d was not stored", i)
+		}
+	}
+	memoryEntries, memoryBytes, diskEntries, diskBytes := c.Stats()
+	if memoryBytes > config.MemoryBytes || diskBytes > config.DiskBytes {
+		t.Fatalf("tiers over their limits: memory %d, disk %d", memoryBytes, diskBytes)
+	}
+	if memoryEntries+diskEntries != 5 {
+		// the oldest object falls off the disk tier as well
+		t.Fatalf("%d entries in memory and %d on disk, want 5 in total", memoryEntries, diskEntries)
+	}
+	if c.Lookup("example.com", httptest.NewRequest("GET", "http://example.com/0", nil)) != nil {
+		t.Fatal("least recently used object should have been evicted")
+	}
+	if entry := c.Lookup("example.com", httptest.NewRequest("GET", "http://example.com/1", nil)); entry == nil || string(entry.Body) != body {
+		t.Fatal("object demoted to disk was lost")
+	}
+
+	// A new cache over the same directory picks up the disk tier
+	reopened := newTestCache(t, config)
+	if _, _, diskEntries, _ := reopened.Stats(); diskEntries == 0 {
+		t.Fatal("disk entries were not reloaded")
+	}
+
+	removed := reopened.PurgeDomain("example.com") + c.PurgeDomain("example.com")
+	if removed == 0 {
+		t.Fatal("nothing purged")
+	}
+	if c.Lookup("example.com", httptest.NewRequest("GET", "http://example.com/5", nil)) != nil {
+		t.Fatal("purged object still served")
+	}
+}
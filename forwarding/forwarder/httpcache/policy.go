@@ -0,0 +1,143 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status codes that are cacheable by default (RFC 9111, section 4.2.2).
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+const maxHeuristicLifetime = 24 * time.Hour
+
+// parseCacheControl returns the directives of all Cache-Control values with lower-case names.
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return directives
+}
+
+func directiveSeconds(directives map[string]string, name string) (time.Duration, bool) {
+	value, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// requestCacheable reports whether a response to req may come from or go into the cache.
+func requestCacheable(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" || req.Header.Get("Range") != "" {
+		return false
+	}
+	_, noStore := parseCacheControl(req.Header)["no-store"]
+	return !noStore
+}
+
+// RequestWantsRevalidation reports whether the client asked not to be served
+// a stored response without checking it with the origin first.
+func RequestWantsRevalidation(req *http.Request) bool {
+	directives := parseCacheControl(req.Header)
+	if _, ok := directives["no-cache"]; ok {
+		return true
+	}
+	if maxAge, ok := directiveSeconds(directives, "max-age"); ok && maxAge == 0 {
+		return true
+	}
+	return len(directives) == 0 && strings.Contains(strings.ToLower(req.Header.Get("Pragma")), "no-cache")
+}
+
+// HasConditionals reports whether the client sent its own validators.
+func HasConditionals(req *http.Request) bool {
+	return req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+}
+
+func hasValidators(header http.Header) bool {
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+}
+
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// freshness decides whether a response may be stored and for how long it is
+// fresh. noCache responses are stored but revalidated before every use.
+func freshness(status int, header http.Header, responseTime time.Time) (lifetime time.Duration, noCache bool, ok bool) {
+	if !cacheableStatus[status] || header.Get("Set-Cookie") != "" {
+		return 0, false, false
+	}
+	directives := parseCacheControl(header)
+	if _, found := directives["no-store"]; found {
+		return 0, false, false
+	}
+	if _, found := directives["private"]; found {
+		return 0, false, false
+	}
+	for _, name := range varyNames(header) {
+		if name == "*" {
+			return 0, false, false
+		}
+	}
+	_, noCache = directives["no-cache"]
+
+	date := responseTime
+	if parsed, err := http.ParseTime(header.Get("Date")); err == nil {
+		date = parsed
+	}
+
+	explicit := true
+	if sMaxAge, found := directiveSeconds(directives, "s-maxage"); found {
+		lifetime = sMaxAge
+	} else if maxAge, found := directiveSeconds(directives, "max-age"); found {
+		lifetime = maxAge
+	} else if expires := header.Get("Expires"); expires != "" {
+		if parsed, err := http.ParseTime(expires); err == nil && parsed.After(date) {
+			lifetime = parsed.Sub(date) // an invalid Expires means already expired
+		}
+	} else if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && date.After(lastModified) {
+		lifetime = min(date.Sub(lastModified)/10, maxHeuristicLifetime)
+	} else {
+		explicit = false
+	}
+
+	if (!explicit || lifetime == 0 || noCache) && !hasValidators(header) {
+		return 0, false, false // could never be used without a full fetch
+	}
+	return lifetime, noCache, true
+}
@@ -0,0 +1,256 @@
+package httpcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const entryFileSuffix = ".entry"
+
+// memoryTier is an LRU of entries bounded by their approximate size in bytes.
+type memoryTier struct {
+	limit int64
+	used  int64
+	lru   *list.List // front is most recently used, values are *Entry
+	items map[string]*list.Element
+}
+
+func newMemoryTier(limit int64) *memoryTier {
+	return &memoryTier{limit: limit, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (m *memoryTier) get(key string) *Entry {
+	elem, ok := m.items[key]
+	if !ok {
+		return nil
+	}
+	m.lru.MoveToFront(elem)
+	return elem.Value.(*Entry)
+}
+
+// put stores entry and returns the least recently used entries it pushed out.
+func (m *memoryTier) put(entry *Entry) []*Entry {
+	m.remove(entry.Key)
+	m.items[entry.Key] = m.lru.PushFront(entry)
+	m.used += entry.size()
+
+	var evicted []*Entry
+	for m.used > m.limit && m.lru.Len() > 0 {
+		oldest := m.lru.Back()
+		victim := oldest.Value.(*Entry)
+		m.lru.Remove(oldest)
+		delete(m.items, victim.Key)
+		m.used -= victim.size()
+		evicted = append(evicted, victim)
+	}
+	return evicted
+}
+
+func (m *memoryTier) remove(key string) bool {
+	elem, ok := m.items[key]
+	if !ok {
+		return false
+	}
+	m.lru.Remove(elem)
+	delete(m.items, key)
+	m.used -= elem.Value.(*Entry).size()
+	return true
+}
+
+func (m *memoryTier) keys() []string {
+	keys := make([]string, 0, len(m.items))
+	for key := range m.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+type diskItem struct {
+	key  string
+	size int64
+}
+
+// diskTier keeps entries as gob files named by the hash of their key. Only the
+// index lives in memory; it is rebuilt from the files on startup.
+type diskTier struct {
+	dir   string
+	limit int64
+	used  int64
+	lru   *list.List // values are *diskItem
+	items map[string]*list.Element
+}
+
+func newDiskTier(dir string, limit int64) (*diskTier, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory %s: %w", dir, err)
+	}
+	d := &diskTier{dir: dir, limit: limit, lru: list.New(), items: make(map[string]*list.Element)}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory %s: %w", dir, err)
+	}
+	type existing struct {
+		path string
+		item *diskItem
+		used int64
+	}
+	var found []existing
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), entryFileSuffix) {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		key, err := readEntryKey(path)
+		if err != nil || d.path(key) != path {
+			os.Remove(path) // unreadable or left over from an interrupted write
+			continue
+		}
+		found = append(found, existing{path: path, item: &diskItem{key: key, size: info.Size()}, used: info.ModTime().UnixNano()})
+	}
+	// Files are rewritten when promoted or demoted, so modification time approximates recency
+	sort.Slice(found, func(i, j int) bool { return found[i].used < found[j].used })
+	for _, f := range found {
+		d.items[f.item.key] = d.lru.PushFront(f.item)
+		d.used += f.item.size
+	}
+	for _, path := range d.evict() {
+		os.Remove(path)
+	}
+	return d, nil
+}
+
+func (d *diskTier) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+entryFileSuffix)
+}
+
+// lookup returns the file holding key, if any, and marks it as recently used.
+func (d *diskTier) lookup(key string) (string, bool) {
+	elem, ok := d.items[key]
+	if !ok {
+		return "", false
+	}
+	d.lru.MoveToFront(elem)
+	return d.path(key), true
+}
+
+// add indexes a file written by writeEntryFile and returns the paths of the
+// files evicted to make room, which the caller deletes.
+func (d *diskTier) add(key string, size int64) []string {
+	if elem, ok := d.items[key]; ok {
+		d.used -= elem.Value.(*diskItem).size
+		d.lru.Remove(elem)
+	}
+	d.items[key] = d.lru.PushFront(&diskItem{key: key, size: size})
+	d.used += size
+	return d.evict()
+}
+
+func (d *diskTier) evict() []string {
+	var paths []string
+	for d.used > d.limit && d.lru.Len() > 0 {
+		oldest := d.lru.Back()
+		item := oldest.Value.(*diskItem)
+		d.lru.Remove(oldest)
+		delete(d.items, item.key)
+		d.used -= item.size
+		paths = append(paths, d.path(item.key))
+	}
+	return paths
+}
+
+// remove drops key from the index and returns its file path.
+func (d *diskTier) remove(key string) (string, bool) {
+	elem, ok := d.items[key]
+	if !ok {
+		return "", false
+	}
+	d.lru.Remove(elem)
+	delete(d.items, key)
+	d.used -= elem.Value.(*diskItem).size
+	return d.path(key), true
+}
+
+func (d *diskTier) keys() []string {
+	keys := make([]string, 0, len(d.items))
+	for key := range d.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// writeEntryFile stores the key followed by the entry, so the index can be
+// rebuilt without decoding bodies. It writes to a temporary file first so a
+// crash never leaves a truncated entry behind.
+func writeEntryFile(path string, entry *Entry) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "tmp-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create cache file: %w", err)
+	}
+	encoder := gob.NewEncoder(tmp)
+	if err := encoder.Encode(entry.Key); err == nil {
+		err = encoder.Encode(entry)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return 0, fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+	info, err := tmp.Stat()
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return 0, fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, fmt.Errorf("failed to stat cache file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return 0, fmt.Errorf("failed to move cache file into place: %w", err)
+	}
+	return info.Size(), nil
+}
+
+func readEntryFile(path string) (*Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decoder := gob.NewDecoder(file)
+	var key string
+	var entry Entry
+	if err := decoder.Decode(&key); err != nil {
+		return nil, fmt.Errorf("failed to decode cache key from %s: %w", path, err)
+	}
+	if err := decoder.Decode(&entry); err != nil {
+		return nil, fmt.Errorf("failed to decode cache entry from %s: %w", path, err)
+	}
+	return &entry, nil
+}
+
+func readEntryKey(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	var key string
+	if err := gob.NewDecoder(file).Decode(&key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
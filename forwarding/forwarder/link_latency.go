@@ -0,0 +1,29 @@
+package forwarder
+
+import (
+	"forwarding/metrics_processing/linklatency"
+	packet "forwarding/packet_handler"
+	"time"
+)
+
+// Requests sent by the access node carry hop stamps (see packet.HopStamp):
+// relays add theirs on the way out and back, the last relay copies the stamps
+// of a request into the header of the response packet it is merged into, and
+// the access node turns the stamps of every response packet into per-link
+// round trip times for the controller.
+
+// recordLinkLatencies records the link round trip times of a response packet
+// that reached the access node at receivedAt.
+func recordLinkLatencies(header *packet.Packet, receivedAt time.Time) {
+	for _, link := range header.LinkRTTs(receivedAt) {
+		linklatency.GetRecorder().Record(link.From.String(), link.To.String(), link.RTT)
+	}
+}
+
+// responseStamps returns the stamps of the response packet the last relay
+// sends back for responses: those of the first request, then its own.
+func responseStamps(responses []*BufferedResponse, lastRelay byte) []packet.HopStamp {
+	header := packet.Packet{HopStamps: append([]packet.HopStamp(nil), responses[0].HopStamps...)}
+	header.AddStamp(packet.StampResponse, lastRelay, time.Time{}, time.Now())
+	return header.HopStamps
+}
@@ -0,0 +1,62 @@
+package forwarder
+
+import (
+	"forwarding/metrics_processing/linklatency"
+	packet "forwarding/packet_handler"
+	"testing"
+	"time"
+)
+
+func TestResponseCarriesRequestStampsBack(t *testing.T) {
+	bufferConfig := DefaultBufferConfig()
+	bufferConfig.MaxRequestsPerBuffer = 1
+	stateManager := NewRequestStateManager(time.Minute, time.Minute)
+	defer stateManager.Stop()
+	bm := NewBufferManager(bufferConfig, stateManager)
+	defer bm.Stop()
+	sent := make(chan []byte, 1)
+	bm.SetSendFunctions(nil, nil, func(previousHop string, header, payload []byte) error {
+		sent <- header
+		return nil
+	})
+
+	// The request left the access node 15ms ago and reached this last relay
+	// 10ms ago by its clock; the origin took the 10ms since.
+	now := time.Now()
+	request, _ := packet.NewPacket([]string{"10.0.0.1", "10.0.0.2", "192.0.2.10"}, 9)
+	request.StartStamps(now.Add(-15 * time.Millisecond))
+	request.AddStamp(packet.StampRequest, 1, now.Add(-10*time.Millisecond), time.Time{})
+	err := bm.ProcessResponse(&ResponseData{RequestID: 9, Data: []byte("HTTP/1.1 204 No Content\r\n\r\n"),
+		HopList: request.HopList, HopStamps: request.HopStamps})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header *packet.Packet
+	select {
+	case raw := <-sent:
+		if header, err = packet.Unpack(raw); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("response not sent")
+	}
+	if len(header.HopStamps) != 3 {
+		t.Fatalf("stamps %+v", header.HopStamps)
+	}
+	if last := header.HopStamps[2]; last.Hop != 1 || last.Direction != packet.StampResponse || last.Sent == 0 {
+		t.Errorf("last relay stamp %+v", last)
+	}
+
+	// Back at the access node 5ms after the relay sent it: 15+5 in total,
+	// of which the relay held it 10.
+	linklatency.GetRecorder().Collect()
+	recordLinkLatencies(header, now.Add(5*time.Millisecond))
+	latencies := linklatency.GetRecorder().Collect()
+	if len(latencies) != 1 || latencies[0].SourceIp != "10.0.0.1" || latencies[0].TargetIp != "10.0.0.2" {
+		t.Fatalf("latencies %v", latencies)
+	}
+	if rtt := latencies[0].RttMs; rtt < 9 || rtt > 11 {
+		t.Errorf("round trip %vms, want about 10ms", rtt)
+	}
+}
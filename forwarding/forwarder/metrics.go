@@ -0,0 +1,114 @@
+package forwarder
+
+import (
+	"errors"
+	"forwarding/metrics_processing/exporter"
+	"forwarding/router"
+	"sync"
+	"time"
+)
+
+// Request metrics are recorded per attempt by the access handler, labelled by
+// domain and relay path. Buffer and request state statistics are read from the
+// access and relay repositories when /metrics is scraped.
+
+var (
+	requestsTotal = exporter.Default.Counter("arcturus_requests_total",
+		"Forwarding attempts made by the access node, by domain, relay path and result.", "domain", "path", "result")
+	requestDuration = exporter.Default.Histogram("arcturus_request_duration_seconds",
+		"Time from forwarding an attempt to the first response byte.", exporter.DefaultBuckets, "domain", "path")
+	hedgesTotal = exporter.Default.Counter("arcturus_hedged_requests_total",
+		"Requests the access node sent a second copy of, or would have but for the budget, by domain and outcome.", "domain", "outcome")
+	rateLimitedTotal = exporter.Default.Counter("arcturus_rate_limited_requests_total",
+		"Requests the access node refused with 429, by domain and the limit that was hit (client, domain or global).", "domain", "scope")
+)
+
+// statsSources holds the BufferManager and RequestStateManager of the access
+// and relay repositories of this process.
+var statsSources = struct {
+	sync.Mutex
+	buffers map[string]*BufferManager
+	states  map[string]*RequestStateManager
+}{buffers: make(map[string]*BufferManager), states: make(map[string]*RequestStateManager)}
+
+func registerStatsSource(role string, bm *BufferManager, sm *RequestStateManager) {
+	statsSources.Lock()
+	statsSources.buffers[role] = bm
+	statsSources.states[role] = sm
+	statsSources.Unlock()
+}
+
+func eachBufferManager(fn func(role string, stats BufferStats)) {
+	statsSources.Lock()
+	defer statsSources.Unlock()
+	for role, bm := range statsSources.buffers {
+		fn(role, bm.GetStats())
+	}
+}
+
+func eachStateManager(fn func(role string, sm *RequestStateManager)) {
+	statsSources.Lock()
+	defer statsSources.Unlock()
+	for role, sm := range statsSources.states {
+		fn(role, sm)
+	}
+}
+
+// recordAttempt counts one forwarding attempt of the access node.
+func recordAttempt(domain string, path []string, latency time.Duration, err error) {
+	pathLabel := router.PathKey(path)
+	result := "ok"
+	switch {
+	case errors.Is(err, errAttemptTimeout):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	}
+	requestsTotal.With(domain, pathLabel, result).Inc()
+	if err == nil {
+		requestDuration.With(domain, pathLabel).Observe(latency.Seconds())
+	}
+}
+
+func init() {
+	role := []string{"role"}
+	bufferGauge := func(name, help string, value func(BufferStats) float64) {
+		exporter.Default.GaugeFunc(name, help, role, func(emit func(float64, ...string)) {
+			eachBufferManager(func(role string, stats BufferStats) { emit(value(stats), role) })
+		})
+	}
+	bufferGauge("arcturus_merge_ratio", "Share of packets that were sent merged with others.",
+		func(s BufferStats) float64 { return s.MergeRatio })
+	bufferGauge("arcturus_buffer_utilization", "Average fill level of the merge buffers.",
+		func(s BufferStats) float64 { return s.BufferUtilization })
+	bufferGauge("arcturus_buffers", "Merge buffers currently allocated.",
+		func(s BufferStats) float64 { return float64(s.TotalBuffers) })
+	bufferGauge("arcturus_buffer_paths", "Paths with merge buffers.",
+		func(s BufferStats) float64 { return float64(s.ActivePaths) })
+	bufferGauge("arcturus_compression_ratio", "Payload bytes before compression per byte sent, for compressed payloads.",
+		func(s BufferStats) float64 { return s.CompressionRatio })
+	exporter.Default.CounterFunc("arcturus_compressed_payload_bytes_total", "Payload bytes sent compressed, by size before and after compression.",
+		[]string{"role", "stage"}, func(emit func(float64, ...string)) {
+			eachBufferManager(func(role string, stats BufferStats) {
+				emit(float64(stats.CompressedBytes), role, "raw")
+				emit(float64(stats.CompressedWireBytes), role, "wire")
+			})
+		})
+
+	exporter.Default.GaugeFunc("arcturus_request_states_active", "Requests currently tracked by the state manager.", role,
+		func(emit func(float64, ...string)) {
+			eachStateManager(func(role string, sm *RequestStateManager) {
+				_, active, _, _ := sm.GetStats()
+				emit(float64(active), role)
+			})
+		})
+	exporter.Default.CounterFunc("arcturus_request_states_total", "Requests tracked by the state manager, by final status.",
+		[]string{"role", "status"}, func(emit func(float64, ...string)) {
+			eachStateManager(func(role string, sm *RequestStateManager) {
+				total, _, completed, failed := sm.GetStats()
+				emit(float64(total), role, "created")
+				emit(float64(completed), role, "completed")
+				emit(float64(failed), role, "failed")
+			})
+		})
+}
@@ -0,0 +1,40 @@
+package forwarder
+
+import (
+	"errors"
+	"forwarding/metrics_processing/exporter"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	stateManager := NewRequestStateManager(time.Minute, time.Minute)
+	defer stateManager.Stop()
+	bufferManager := NewBufferManager(DefaultBufferConfig(), stateManager)
+	defer bufferManager.Stop()
+	registerStatsSource("access", bufferManager, stateManager)
+
+	path := []string{"10.0.0.1", "10.0.0.2", "192.0.2.10"}
+	recordAttempt("metrics.example.com", path, 30*time.Millisecond, nil)
+	recordAttempt("metrics.example.com", path, time.Second, errAttemptTimeout)
+	recordAttempt("metrics.example.com", path, time.Second, errors.New("stream reset"))
+
+	rec := httptest.NewRecorder()
+	exporter.Default.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	got := rec.Body.String()
+	for _, want := range []string{
+		`arcturus_requests_total{domain="metrics.example.com",path="10.0.0.1>10.0.0.2>192.0.2.10",result="ok"} 1`,
+		`arcturus_requests_total{domain="metrics.example.com",path="10.0.0.1>10.0.0.2>192.0.2.10",result="timeout"} 1`,
+		`arcturus_requests_total{domain="metrics.example.com",path="10.0.0.1>10.0.0.2>192.0.2.10",result="error"} 1`,
+		`arcturus_request_duration_seconds_bucket{domain="metrics.example.com",path="10.0.0.1>10.0.0.2>192.0.2.10",le="0.05"} 1`,
+		`arcturus_request_duration_seconds_count{domain="metrics.example.com",path="10.0.0.1>10.0.0.2>192.0.2.10"} 1`,
+		`arcturus_merge_ratio{role="access"} 0`,
+		`arcturus_request_states_active{role="access"} 0`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package forwarder
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"forwarding/metrics_processing/protocol"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The last relay reaches every origin through its own pooled, keep-alive
+// transport, built from the OriginConfig distributed with the domain mapping.
+// Domains without one keep the plain HTTP behaviour on RelayConfig.SourcePort.
+
+type originTarget struct {
+	scheme             string
+	addr               string // host:port dialed
+	hostHeader         string
+	serverName         string
+	caPEM              []byte
+	insecureSkipVerify bool
+}
+
+// resolveOriginTarget decides where and how to send a request for domain
+// whose origin is nextHopIP.
+func resolveOriginTarget(domain, nextHopIP, sourcePort string, origin *protocol.OriginConfig) (originTarget, error) {
+	if origin == nil {
+		host, port := hostPort(nextHopIP, sourcePort)
+		hostHeader := host
+		if strings.Contains(host, ":") {
+			hostHeader = "[" + host + "]"
+		}
+		return originTarget{scheme: "http", addr: net.JoinHostPort(host, port), hostHeader: hostHeader}, nil
+	}
+
+	scheme := strings.ToLower(origin.Scheme)
+	if scheme == "" {
+		scheme = "http"
+	}
+	if scheme != "http" && scheme != "https" {
+		return originTarget{}, fmt.Errorf("unsupported origin scheme %q for %s", origin.Scheme, domain)
+	}
+
+	host, port := hostPort(nextHopIP, "")
+	switch {
+	case origin.Port > 0:
+		port = strconv.Itoa(int(origin.Port))
+	case port != "":
+	case scheme == "https":
+		port = "443"
+	default:
+		port = sourcePort
+	}
+
+	hostHeader := origin.HostHeader
+	if hostHeader == "" {
+		hostHeader = domain
+	}
+	serverName := origin.ServerName
+	if serverName == "" {
+		serverName = hostHeader
+		if h, _, err := net.SplitHostPort(hostHeader); err == nil {
+			serverName = h
+		}
+	}
+
+	return originTarget{
+		scheme:             scheme,
+		addr:               net.JoinHostPort(host, port),
+		hostHeader:         hostHeader,
+		serverName:         serverName,
+		caPEM:              origin.CaPem,
+		insecureSkipVerify: origin.InsecureSkipVerify,
+	}, nil
+}
+
+// key identifies the transport a target needs; targets with equal keys share connections.
+func (t originTarget) key() string {
+	if t.scheme != "https" {
+		return "http|" + t.addr
+	}
+	caSum := sha256.Sum256(t.caPEM)
+	return fmt.Sprintf("https|%s|%s|%s|%t", t.addr, t.serverName, hex.EncodeToString(caSum[:8]), t.insecureSkipVerify)
+}
+
+type originClientPool struct {
+	mu      sync.Mutex
+	clients map[string]*http.Client // originTarget.key -> client
+}
+
+var originClients = &originClientPool{clients: make(map[string]*http.Client)}
+
+func (p *originClientPool) get(t originTarget) (*http.Client, error) {
+	key := t.key()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if client, ok := p.clients[key]; ok {
+		return client, nil
+	}
+
+	// No overall client timeout so streamed bodies are not cut off
+	transport := &http.Transport{
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   32,
+		TLSHandshakeTimeout:   10 * time.Second,
+	}
+	if t.scheme == "https" {
+		tlsConfig, err := originTLSConfig(t)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	client := &http.Client{Transport: transport}
+	p.clients[key] = client
+	return client, nil
+}
+
+// dialOrigin opens a plain connection to the target for requests that leave
+// HTTP after an upgrade.
+func dialOrigin(t originTarget) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: tunnelDialTimeout}
+	if t.scheme != "https" {
+		return dialer.Dial("tcp", t.addr)
+	}
+	tlsConfig, err := originTLSConfig(t)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.NextProtos = []string{"http/1.1"} // upgrades only exist in HTTP/1.1
+	return tls.DialWithDialer(dialer, "tcp", t.addr, tlsConfig)
+}
+
+func originTLSConfig(t originTarget) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.serverName,
+		InsecureSkipVerify: t.insecureSkipVerify,
+	}
+	if len(t.caPEM) > 0 {
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(t.caPEM) {
+			return nil, errors.New("origin CA bundle contains no certificates")
+		}
+		tlsConfig.RootCAs = roots
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,85 @@
+package forwarder
+
+import (
+	"encoding/pem"
+	"forwarding/metrics_processing/protocol"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveOriginTarget(t *testing.T) {
+	cases := []struct {
+		name       string
+		nextHop    string
+		origin     *protocol.OriginConfig
+		scheme     string
+		addr       string
+		hostHeader string
+		serverName string
+	}{
+		{"legacy", "10.0.1.5", nil, "http", "10.0.1.5:8080", "10.0.1.5", ""},
+		{"legacy IPv6", "2001:db8::5", nil, "http", "[2001:db8::5]:8080", "[2001:db8::5]", ""},
+		{"https IPv6", "[2001:db8::5]:9443", &protocol.OriginConfig{Scheme: "https"}, "https", "[2001:db8::5]:9443", "example.com", "example.com"},
+		{"http default port", "10.0.1.5", &protocol.OriginConfig{Scheme: "http"}, "http", "10.0.1.5:8080", "example.com", "example.com"},
+		{"https default port", "10.0.1.5", &protocol.OriginConfig{Scheme: "HTTPS"}, "https", "10.0.1.5:443", "example.com", "example.com"},
+		{"explicit port", "10.0.1.5:9000", &protocol.OriginConfig{Scheme: "https", Port: 8443}, "https", "10.0.1.5:8443", "example.com", "example.com"},
+		{"port from mapping", "10.0.1.5:9000", &protocol.OriginConfig{Scheme: "https"}, "https", "10.0.1.5:9000", "example.com", "example.com"},
+		{"host header overrides", "10.0.1.5", &protocol.OriginConfig{Scheme: "https", HostHeader: "www.example.com:8443"}, "https", "10.0.1.5:443", "www.example.com:8443", "www.example.com"},
+		{"server name overrides", "10.0.1.5", &protocol.OriginConfig{Scheme: "https", ServerName: "origin.internal"}, "https", "10.0.1.5:443", "example.com", "origin.internal"},
+	}
+	for _, c := range cases {
+		target, err := resolveOriginTarget("example.com", c.nextHop, "8080", c.origin)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if target.scheme != c.scheme || target.addr != c.addr || target.hostHeader != c.hostHeader || target.serverName != c.serverName {
+			t.Errorf("%s: got %+v", c.name, target)
+		}
+	}
+
+	if _, err := resolveOriginTarget("example.com", "10.0.1.5", "8080", &protocol.OriginConfig{Scheme: "ftp"}); err == nil {
+		t.Error("expected an unsupported scheme to be rejected")
+	}
+}
+
+func TestOriginClientVerifiesOriginCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	// httptest certificates are issued for example.com
+	do := func(origin *protocol.OriginConfig) error {
+		target, err := resolveOriginTarget("example.com", "127.0.0.1:"+port, "", origin)
+		if err != nil {
+			return err
+		}
+		client, err := (&originClientPool{clients: make(map[string]*http.Client)}).get(target)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Get("https://" + target.addr + "/")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := do(&protocol.OriginConfig{Scheme: "https", CaPem: caPEM}); err != nil {
+		t.Errorf("expected the pinned CA to verify the origin: %v", err)
+	}
+	if err := do(&protocol.OriginConfig{Scheme: "https"}); err == nil {
+		t.Error("expected an origin signed by an unknown CA to be rejected")
+	}
+	if err := do(&protocol.OriginConfig{Scheme: "https", InsecureSkipVerify: true}); err != nil {
+		t.Errorf("expected insecure_skip_verify to accept the origin: %v", err)
+	}
+	if err := do(&protocol.OriginConfig{Scheme: "https", CaPem: []byte("not a certificate")}); err == nil {
+		t.Error("expected an invalid CA bundle to be rejected")
+	}
+}
@@ -0,0 +1,256 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	packet "forwarding/packet_handler"
+	"forwarding/router"
+	"forwarding/scheduling_algorithms/k_shortest"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The access proxy scores every path it uses from what it observes itself:
+// end-to-end latency and the share of attempts that failed or timed out,
+// both smoothed with an EWMA. Effective weights are proportional to
+// (1 - error rate) / latency, falling back to the controller's latency for
+// paths without samples. A path whose breaker is open gets no traffic until
+// its cooldown has passed and a single half-open probe succeeds.
+
+type PathHealthConfig struct {
+	Alpha              float64       // EWMA smoothing factor for latency, error and timeout rates
+	FailureThreshold   int           // consecutive failed attempts that open the breaker
+	ErrorRateThreshold float64       // error rate EWMA that opens the breaker once MinSamples are reached
+	MinSamples         int           // samples before the error rate may open the breaker
+	OpenDuration       time.Duration // ejection time before a half-open probe, also the probe's own timeout
+	IdleExpiry         time.Duration // stats of paths not used for this long are dropped
+}
+
+func DefaultPathHealthConfig() PathHealthConfig {
+	return PathHealthConfig{
+		Alpha:              0.2,
+		FailureThreshold:   5,
+		ErrorRateThreshold: 0.5,
+		MinSamples:         10,
+		OpenDuration:       30 * time.Second,
+		IdleExpiry:         10 * time.Minute,
+	}
+}
+
+func (c PathHealthConfig) Validate() error {
+	if c.Alpha <= 0 || c.Alpha > 1 {
+		return fmt.Errorf("alpha must be in (0, 1], got %v", c.Alpha)
+	}
+	if c.FailureThreshold < 1 || c.MinSamples < 1 {
+		return fmt.Errorf("failure_threshold and min_samples must be at least 1")
+	}
+	if c.ErrorRateThreshold <= 0 || c.ErrorRateThreshold > 1 {
+		return fmt.Errorf("error_rate_threshold must be in (0, 1], got %v", c.ErrorRateThreshold)
+	}
+	if c.OpenDuration <= 0 || c.IdleExpiry <= 0 {
+		return fmt.Errorf("open_duration and idle_expiry must be positive")
+	}
+	return nil
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type pathHealth struct {
+	latencyEWMA         float64 // ms, successful attempts only
+	errorEWMA           float64 // failed or timed out attempts, 0..1
+	timeoutEWMA         float64 // timed out attempts, 0..1
+	samples             int
+	consecutiveFailures int
+	state               breakerState
+	stateSince          time.Time
+	lastUpdated         time.Time
+}
+
+type PathHealthTracker struct {
+	config    PathHealthConfig
+	mu        sync.Mutex
+	paths     map[string]*pathHealth // CalculatePathHash -> stats
+	picks     uint32
+	lastPrune time.Time
+}
+
+func NewPathHealthTracker(config PathHealthConfig) *PathHealthTracker {
+	return &PathHealthTracker{
+		config:    config,
+		paths:     make(map[string]*pathHealth),
+		lastPrune: time.Now(),
+	}
+}
+
+// pathHashForIPs returns CalculatePathHash of the HopList a packet for ipList would carry.
+func pathHashForIPs(ipList []string) string {
+	hopList, err := packet.ParseHopList(ipList)
+	if err != nil {
+		return strings.Join(ipList, ",")
+	}
+	return CalculatePathHash(hopList)
+}
+
+// Record feeds the outcome of one attempt on the path with the given hash.
+func (t *PathHealthTracker) Record(pathHash string, latency time.Duration, err error) {
+	now := time.Now()
+	alpha := t.config.Alpha
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.paths[pathHash]
+	if !ok {
+		h = &pathHealth{state: breakerClosed, stateSince: now}
+		t.paths[pathHash] = h
+	}
+	h.samples++
+	h.lastUpdated = now
+	oldState := h.state
+
+	if err == nil {
+		ms := float64(latency.Microseconds()) / 1000
+		if h.latencyEWMA == 0 {
+			h.latencyEWMA = ms
+		} else {
+			h.latencyEWMA = alpha*ms + (1-alpha)*h.latencyEWMA
+		}
+		h.errorEWMA *= 1 - alpha
+		h.timeoutEWMA *= 1 - alpha
+		h.consecutiveFailures = 0
+		if h.state == breakerHalfOpen {
+			h.state = breakerClosed
+			h.stateSince = now
+		}
+	} else {
+		h.errorEWMA = alpha + (1-alpha)*h.errorEWMA
+		timedOut := 0.0
+		if errors.Is(err, errAttemptTimeout) {
+			timedOut = 1
+		}
+		h.timeoutEWMA = alpha*timedOut + (1-alpha)*h.timeoutEWMA
+		h.consecutiveFailures++
+
+		switch h.state {
+		case breakerHalfOpen:
+			h.state = breakerOpen
+			h.stateSince = now
+		case breakerClosed:
+			if h.consecutiveFailures >= t.config.FailureThreshold ||
+				(h.samples >= t.config.MinSamples && h.errorEWMA >= t.config.ErrorRateThreshold) {
+				h.state = breakerOpen
+				h.stateSince = now
+			}
+		}
+	}
+
+	if h.state != oldState {
+		log.Printf("[Access-INFO] Path %s breaker %s -> %s (latency %.1fms, error rate %.2f, timeout rate %.2f)",
+			pathHash, oldState, h.state, h.latencyEWMA, h.errorEWMA, h.timeoutEWMA)
+	}
+
+	if now.Sub(t.lastPrune) > t.config.IdleExpiry {
+		for hash, stats := range t.paths {
+			if now.Sub(stats.lastUpdated) > t.config.IdleExpiry {
+				delete(t.paths, hash)
+			}
+		}
+		t.lastPrune = now
+	}
+}
+
+// Pick chooses the path for a new request. A path whose breaker cooldown has
+// passed is handed out once as the half-open probe; otherwise the choice is a
+// weighted round robin over the effective weights.
+func (t *PathHealthTracker) Pick(paths []k_shortest.PathWithIP) k_shortest.PathWithIP {
+	now := time.Now()
+
+	t.mu.Lock()
+	weighted := make([]k_shortest.PathWithIP, len(paths))
+	copy(weighted, paths)
+	scores := make([]float64, len(paths))
+	maxScore := 0.0
+	for i, p := range weighted {
+		if p.Weight <= 0 {
+			continue
+		}
+		h := t.paths[pathHashForIPs(p.IPList)]
+		if h != nil && h.state != breakerClosed {
+			if now.Sub(h.stateSince) >= t.config.OpenDuration {
+				// Cooldown over (or the previous probe never reported back): probe this path
+				h.state = breakerHalfOpen
+				h.stateSince = now
+				t.mu.Unlock()
+				log.Printf("[Access-INFO] Sending half-open probe over path %v", p.IPList)
+				return p
+			}
+			continue
+		}
+
+		latency := float64(p.Latency)
+		errorRate := 0.0
+		if h != nil {
+			errorRate = h.errorEWMA
+			if h.latencyEWMA > 0 {
+				latency = h.latencyEWMA
+			}
+		}
+		scores[i] = (1 - errorRate) / math.Max(latency, 1)
+		maxScore = math.Max(maxScore, scores[i])
+	}
+	t.mu.Unlock()
+
+	if maxScore == 0 {
+		// Every path is ejected: keep serving on the controller's weights rather than failing outright
+		return router.NewWeightedRoundRobin(paths).NextAt(atomic.AddUint32(&t.picks, 1))
+	}
+	for i := range weighted {
+		if scores[i] == 0 {
+			weighted[i].Weight = 0
+			continue
+		}
+		weighted[i].Weight = int(math.Max(1, math.Round(100*scores[i]/maxScore)))
+	}
+	return router.NewWeightedRoundRobin(weighted).NextAt(atomic.AddUint32(&t.picks, 1))
+}
+
+// Available filters out paths whose breaker is not closed, keeping all paths
+// if that would leave none.
+func (t *PathHealthTracker) Available(paths []k_shortest.PathWithIP) []k_shortest.PathWithIP {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	available := make([]k_shortest.PathWithIP, 0, len(paths))
+	for _, p := range paths {
+		if h := t.paths[pathHashForIPs(p.IPList)]; h == nil || h.state == breakerClosed {
+			available = append(available, p)
+		}
+	}
+	if len(available) == 0 {
+		return paths
+	}
+	return available
+}
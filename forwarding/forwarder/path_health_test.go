@@ -0,0 +1,88 @@
+package forwarder
+
+import (
+	"errors"
+	"forwarding/scheduling_algorithms/k_shortest"
+	"testing"
+	"time"
+)
+
+func testPaths() []k_shortest.PathWithIP {
+	return []k_shortest.PathWithIP{
+		{IPList: []string{"10.0.0.1", "10.0.0.2", "10.0.0.9"}, Latency: 10, Weight: 2},
+		{IPList: []string{"10.0.0.1", "10.0.0.3", "10.0.0.9"}, Latency: 20, Weight: 1},
+	}
+}
+
+func countPicks(tracker *PathHealthTracker, paths []k_shortest.PathWithIP, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[pathHashForIPs(tracker.Pick(paths).IPList)]++
+	}
+	return counts
+}
+
+func TestPathHealthPrefersObservedFasterPath(t *testing.T) {
+	tracker := NewPathHealthTracker(DefaultPathHealthConfig())
+	paths := testPaths()
+	fast, slow := pathHashForIPs(paths[0].IPList), pathHashForIPs(paths[1].IPList)
+
+	// The controller thinks paths[0] is faster, observations say otherwise
+	for i := 0; i < 20; i++ {
+		tracker.Record(fast, 200*time.Millisecond, nil)
+		tracker.Record(slow, 20*time.Millisecond, nil)
+	}
+	counts := countPicks(tracker, paths, 110)
+	if counts[slow] <= counts[fast] {
+		t.Errorf("expected the path observed faster to get more traffic, got %v", counts)
+	}
+}
+
+func TestPathHealthBreakerEjectsAndProbes(t *testing.T) {
+	config := DefaultPathHealthConfig()
+	config.OpenDuration = 20 * time.Millisecond
+	tracker := NewPathHealthTracker(config)
+	paths := testPaths()
+	bad := pathHashForIPs(paths[0].IPList)
+
+	for i := 0; i < config.FailureThreshold; i++ {
+		tracker.Record(bad, time.Second, errAttemptTimeout)
+	}
+	if counts := countPicks(tracker, paths, 50); counts[bad] != 0 {
+		t.Fatalf("expected an open breaker to eject the path, got %v", counts)
+	}
+	if got := tracker.Available(paths); len(got) != 1 {
+		t.Errorf("expected only the healthy path to be available for retries, got %v", got)
+	}
+
+	time.Sleep(config.OpenDuration)
+	if probe := tracker.Pick(paths); pathHashForIPs(probe.IPList) != bad {
+		t.Fatalf("expected the half-open probe to use the ejected path, got %v", probe.IPList)
+	}
+	if counts := countPicks(tracker, paths, 20); counts[bad] != 0 {
+		t.Errorf("expected no traffic besides the single probe while half-open, got %v", counts)
+	}
+
+	tracker.Record(bad, 10*time.Millisecond, nil)
+	if counts := countPicks(tracker, paths, 50); counts[bad] == 0 {
+		t.Errorf("expected a successful probe to close the breaker, got %v", counts)
+	}
+}
+
+func TestPathHealthFailedProbeReopens(t *testing.T) {
+	config := DefaultPathHealthConfig()
+	config.FailureThreshold = 1
+	config.OpenDuration = 10 * time.Millisecond
+	tracker := NewPathHealthTracker(config)
+	paths := testPaths()
+	bad := pathHashForIPs(paths[0].IPList)
+
+	tracker.Record(bad, 0, errors.New("stream reset"))
+	time.Sleep(config.OpenDuration)
+	tracker.Pick(paths) // half-open probe
+	tracker.Record(bad, 0, errors.New("stream reset"))
+
+	if counts := countPicks(tracker, paths, 20); counts[bad] != 0 {
+		t.Errorf("expected a failed probe to reopen the breaker, got %v", counts)
+	}
+}
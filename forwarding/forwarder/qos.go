@@ -0,0 +1,197 @@
+package forwarder
+
+import (
+	"fmt"
+	packet "forwarding/packet_handler"
+	"forwarding/router"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Requests are put into a traffic class on the access node and the class
+// travels in the header's Priority byte. Every class has its own merge
+// buffers and merge window (BufferConfig.ClassMaxWaitTime), and relays hand
+// out their send slots to interactive traffic first and share the rest
+// between standard and bulk by weight, so latency-sensitive calls are never
+// held behind bulk merges.
+
+type QoSRule struct {
+	Domain     string // empty matches every domain
+	PathPrefix string // empty matches every path
+	Class      string // interactive, standard or bulk
+}
+
+type QoSConfig struct {
+	ClassHeader  string    // request header clients may use to pick a class, empty to ignore it
+	Rules        []QoSRule // first match wins
+	DefaultClass string
+}
+
+func DefaultQoSConfig() QoSConfig {
+	return QoSConfig{
+		DefaultClass: "standard",
+	}
+}
+
+func (c QoSConfig) Validate() error {
+	if _, err := ParseTrafficClass(c.DefaultClass); err != nil {
+		return fmt.Errorf("default_class: %w", err)
+	}
+	for i, rule := range c.Rules {
+		if _, err := ParseTrafficClass(rule.Class); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+var trafficClassNames = map[byte]string{
+	packet.PriorityStandard:    "standard",
+	packet.PriorityInteractive: "interactive",
+	packet.PriorityBulk:        "bulk",
+}
+
+// ParseTrafficClass returns the header Priority for a class name.
+func ParseTrafficClass(name string) (byte, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for priority, className := range trafficClassNames {
+		if className == name {
+			return priority, nil
+		}
+	}
+	return packet.PriorityStandard, fmt.Errorf("unknown traffic class %q", name)
+}
+
+func TrafficClassName(priority byte) string {
+	return trafficClassNames[packet.NormalizePriority(priority)]
+}
+
+// Classify returns the Priority for a request to domain. The class header is
+// removed so it does not reach the origin.
+func (c QoSConfig) Classify(req *http.Request, domain string) byte {
+	if c.ClassHeader != "" {
+		value := req.Header.Get(c.ClassHeader)
+		req.Header.Del(c.ClassHeader)
+		if class, err := ParseTrafficClass(value); err == nil {
+			return class
+		}
+	}
+	for _, rule := range c.Rules {
+		if rule.Domain != "" && router.NormalizeDomain(rule.Domain) != domain {
+			continue
+		}
+		if !strings.HasPrefix(req.URL.Path, rule.PathPrefix) {
+			continue
+		}
+		if class, err := ParseTrafficClass(rule.Class); err == nil {
+			return class
+		}
+	}
+	class, _ := ParseTrafficClass(c.DefaultClass)
+	return class
+}
+
+type EgressConfig struct {
+	Slots          int // concurrent sends to the next hop, shared by all classes; 0 for no limit
+	StandardWeight int
+	BulkWeight     int
+}
+
+func DefaultEgressConfig() EgressConfig {
+	return EgressConfig{
+		Slots:          64,
+		StandardWeight: 4,
+		BulkWeight:     1,
+	}
+}
+
+func (c EgressConfig) Validate() error {
+	if c.Slots < 0 {
+		return fmt.Errorf("slots must not be negative")
+	}
+	if c.StandardWeight < 1 || c.BulkWeight < 1 {
+		return fmt.Errorf("standard_weight and bulk_weight must be at least 1")
+	}
+	return nil
+}
+
+// egressScheduler limits concurrent sends and decides who gets a freed slot:
+// interactive traffic strictly first, then standard and bulk by smooth
+// weighted round robin. A nil scheduler does not limit anything.
+type egressScheduler struct {
+	mu      sync.Mutex
+	free    int
+	waiting [3][]chan struct{} // by Priority
+	weights [3]int
+	current [3]int
+}
+
+func newEgressScheduler(config EgressConfig) *egressScheduler {
+	if config.Slots <= 0 {
+		return nil
+	}
+	s := &egressScheduler{free: config.Slots}
+	s.weights[packet.PriorityStandard] = max(config.StandardWeight, 1)
+	s.weights[packet.PriorityBulk] = max(config.BulkWeight, 1)
+	return s
+}
+
+// acquire blocks until traffic of the given priority may be sent and returns
+// the function that gives the slot back.
+func (s *egressScheduler) acquire(priority byte) func() {
+	if s == nil {
+		return func() {}
+	}
+	class := packet.NormalizePriority(priority)
+
+	s.mu.Lock()
+	if s.free > 0 {
+		s.free--
+		s.mu.Unlock()
+		return s.release
+	}
+	ready := make(chan struct{})
+	s.waiting[class] = append(s.waiting[class], ready)
+	s.mu.Unlock()
+
+	<-ready // the slot is handed over by release
+	return s.release
+}
+
+func (s *egressScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	class, ok := s.next()
+	if !ok {
+		s.free++
+		return
+	}
+	ready := s.waiting[class][0]
+	s.waiting[class] = s.waiting[class][1:]
+	close(ready)
+}
+
+func (s *egressScheduler) next() (byte, bool) {
+	if len(s.waiting[packet.PriorityInteractive]) > 0 {
+		return packet.PriorityInteractive, true
+	}
+
+	best, total := -1, 0
+	for _, class := range []byte{packet.PriorityStandard, packet.PriorityBulk} {
+		if len(s.waiting[class]) == 0 {
+			continue
+		}
+		s.current[class] += s.weights[class]
+		total += s.weights[class]
+		if best < 0 || s.current[class] > s.current[best] {
+			best = int(class)
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	s.current[best] -= total
+	return byte(best), true
+}
@@ -0,0 +1,148 @@
+package forwarder
+
+import (
+	packet "forwarding/packet_handler"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQoSClassify(t *testing.T) {
+	config := QoSConfig{
+		ClassHeader: "X-Traffic-Class",
+		Rules: []QoSRule{
+			{Domain: "api.example.com", Class: "interactive"},
+			{PathPrefix: "/downloads/", Class: "bulk"},
+		},
+		DefaultClass: "standard",
+	}
+
+	cases := []struct {
+		domain, path, header string
+		want                 byte
+	}{
+		{"api.example.com", "/v1/users", "", packet.PriorityInteractive},
+		{"www.example.com", "/downloads/big.iso", "", packet.PriorityBulk},
+		{"www.example.com", "/index.html", "", packet.PriorityStandard},
+		{"www.example.com", "/index.html", "Interactive", packet.PriorityInteractive},
+		{"api.example.com", "/v1/export", "bulk", packet.PriorityBulk},
+		{"api.example.com", "/v1/users", "urgent", packet.PriorityInteractive}, // unknown header values fall through to the rules
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "http://"+c.domain+c.path, nil)
+		if c.header != "" {
+			req.Header.Set(config.ClassHeader, c.header)
+		}
+		if got := config.Classify(req, c.domain); got != c.want {
+			t.Errorf("%s%s (header %q): class %s, want %s", c.domain, c.path, c.header, TrafficClassName(got), TrafficClassName(c.want))
+		}
+		if req.Header.Get(config.ClassHeader) != "" {
+			t.Errorf("class header was not removed")
+		}
+	}
+}
+
+func TestEgressSchedulerOrder(t *testing.T) {
+	s := newEgressScheduler(EgressConfig{Slots: 1, StandardWeight: 2, BulkWeight: 1})
+	hold := s.acquire(packet.PriorityStandard)
+
+	var mu sync.Mutex
+	var order []byte
+	var wg sync.WaitGroup
+	enqueue := func(priority byte) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := s.acquire(priority)
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+			release()
+		}()
+		// Wait until the waiter is queued so the arrival order is known
+		for {
+			s.mu.Lock()
+			queued := len(s.waiting[priority])
+			s.mu.Unlock()
+			if queued > 0 {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		enqueue(packet.PriorityBulk)
+	}
+	for i := 0; i < 4; i++ {
+		enqueue(packet.PriorityStandard)
+	}
+	enqueue(packet.PriorityInteractive)
+
+	hold()
+	wg.Wait()
+
+	if order[0] != packet.PriorityInteractive {
+		t.Fatalf("interactive traffic must go first, got order %v", order)
+	}
+	// Standard gets two slots for every bulk one while both are waiting
+	want := []byte{packet.PriorityStandard, packet.PriorityBulk, packet.PriorityStandard, packet.PriorityStandard, packet.PriorityBulk, packet.PriorityStandard, packet.PriorityBulk}
+	for i, priority := range want {
+		if order[i+1] != priority {
+			t.Fatalf("unexpected order %v", order)
+		}
+	}
+	if s.free != 1 {
+		t.Errorf("expected the slot to be returned, free=%d", s.free)
+	}
+}
+
+func TestBufferClassMergeWindows(t *testing.T) {
+	config := DefaultBufferConfig()
+	config.MaxRequestsPerBuffer = 10
+	config.MaxBufferSize = 1 << 20
+	config.ClassMaxWaitTime = map[byte]time.Duration{
+		packet.PriorityInteractive: 0,
+		packet.PriorityBulk:        300 * time.Millisecond,
+	}
+	stateManager := NewRequestStateManager(time.Minute, time.Minute)
+	defer stateManager.Stop()
+	bm := NewBufferManager(config, stateManager)
+	defer bm.Stop()
+
+	sent := make(chan *packet.Packet, 4)
+	bm.SetSendFunctions(nil, func(data []byte, nextHop string, header *packet.Packet) error {
+		sent <- header
+		return nil
+	}, nil)
+
+	hops, _ := packet.ParseHopList([]string{"10.0.0.1", "10.0.0.2", "192.0.2.10"})
+	submit := func(id uint32, priority byte) {
+		state := &RequestState{RequestID: id, RequestData: []byte("GET / HTTP/1.1\r\n\r\n"), Size: 18,
+			NextHopIP: "10.0.0.2", HopList: hops, Priority: priority}
+		stateManager.AddState(state)
+		if err := bm.ProcessRequest(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	submit(1, packet.PriorityBulk)
+	submit(2, packet.PriorityInteractive)
+
+	first := <-sent
+	if first.Priority != packet.PriorityInteractive || len(first.PacketID) != 1 || first.PacketID[0] != 2 {
+		t.Fatalf("expected the interactive request to be sent on its own first, got %+v", first)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("interactive request was held for %v", elapsed)
+	}
+
+	second := <-sent
+	if second.Priority != packet.PriorityBulk || second.PacketID[0] != 1 {
+		t.Fatalf("expected the bulk request next, got %+v", second)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("bulk request left its merge window after %v", elapsed)
+	}
+}
@@ -0,0 +1,206 @@
+package forwarder
+
+import (
+	"fmt"
+	"forwarding/metrics_processing/protocol"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Requests are admitted by up to three token buckets: one per client IP and
+// domain, one per domain and one for the whole access node. The domain and
+// per-client rates come from the controller with the domain mapping, the
+// global rate and the per-client default from the node's own config; a rate
+// of 0 leaves that bucket out. A request takes a token from each bucket only
+// if all of them have one, so a client that is turned away does not use up
+// its domain's share. Buckets that have not been used for IdleTimeout are
+// full again and are dropped.
+
+type RateLimitConfig struct {
+	RequestsPerSecond       float64 // all requests of the node, 0 for no limit
+	Burst                   int     // 0: one second's worth of requests
+	ClientRequestsPerSecond float64 // each client IP per domain unless the controller sets a rate
+	ClientBurst             int
+	IdleTimeout             time.Duration
+}
+
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{IdleTimeout: 5 * time.Minute}
+}
+
+func (c RateLimitConfig) Validate() error {
+	if c.RequestsPerSecond < 0 || c.ClientRequestsPerSecond < 0 {
+		return fmt.Errorf("rates must not be negative")
+	}
+	if c.Burst < 0 || c.ClientBurst < 0 {
+		return fmt.Errorf("bursts must not be negative")
+	}
+	if c.IdleTimeout <= 0 {
+		return fmt.Errorf("idle_timeout must be positive")
+	}
+	return nil
+}
+
+const (
+	rateLimitClient = "client"
+	rateLimitDomain = "domain"
+	rateLimitGlobal = "global"
+)
+
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	b := &tokenBucket{last: now}
+	b.setLimit(rate, burst)
+	b.tokens = b.burst
+	return b
+}
+
+// setLimit changes the rate, e.g. after the controller sent new limits.
+func (b *tokenBucket) setLimit(rate float64, burst int) {
+	b.rate, b.burst = rate, float64(burst)
+	if burst <= 0 {
+		b.burst = math.Max(math.Ceil(rate), 1)
+	}
+	b.tokens = math.Min(b.tokens, b.burst)
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// wait returns how long it takes until the bucket holds a whole token.
+func (b *tokenBucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+type bucketKey struct {
+	domain string
+	client string // empty for the bucket of the whole domain
+}
+
+type rateLimiter struct {
+	config RateLimitConfig
+
+	mu        sync.Mutex
+	global    *tokenBucket
+	buckets   map[bucketKey]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultRateLimitConfig().IdleTimeout
+	}
+	l := &rateLimiter{config: config, buckets: make(map[bucketKey]*tokenBucket)}
+	if config.RequestsPerSecond > 0 {
+		l.global = newTokenBucket(config.RequestsPerSecond, config.Burst, time.Now())
+	}
+	return l
+}
+
+// allow takes a token for a request of client to domain under the
+// controller's limits for the domain. If the request is refused it returns
+// the limit that was hit and when a token will be there again.
+func (l *rateLimiter) allow(domain, client string, limit *protocol.RateLimit, now time.Time) (ok bool, retryAfter time.Duration, scope string) {
+	if l == nil {
+		return true, 0, ""
+	}
+	domainRate, domainBurst := 0.0, 0
+	clientRate, clientBurst := l.config.ClientRequestsPerSecond, l.config.ClientBurst
+	if limit != nil {
+		domainRate, domainBurst = limit.GetRequestsPerSecond(), int(limit.GetBurst())
+		if limit.GetClientRequestsPerSecond() > 0 {
+			clientRate, clientBurst = limit.GetClientRequestsPerSecond(), int(limit.GetClientBurst())
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	type scoped struct {
+		bucket *tokenBucket
+		scope  string
+	}
+	var buckets []scoped
+	if clientRate > 0 {
+		buckets = append(buckets, scoped{l.bucket(bucketKey{domain, client}, clientRate, clientBurst, now), rateLimitClient})
+	}
+	if domainRate > 0 {
+		buckets = append(buckets, scoped{l.bucket(bucketKey{domain, ""}, domainRate, domainBurst, now), rateLimitDomain})
+	}
+	if l.global != nil {
+		l.global.refill(now)
+		buckets = append(buckets, scoped{l.global, rateLimitGlobal})
+	}
+
+	for _, b := range buckets {
+		if wait := b.bucket.wait(); wait > retryAfter {
+			if scope == "" {
+				scope = b.scope
+			}
+			retryAfter = wait
+		}
+	}
+	if scope != "" {
+		return false, retryAfter, scope
+	}
+	for _, b := range buckets {
+		b.bucket.tokens--
+	}
+	return true, 0, ""
+}
+
+func (l *rateLimiter) bucket(key bucketKey, rate float64, burst int, now time.Time) *tokenBucket {
+	b := l.buckets[key]
+	if b == nil {
+		b = newTokenBucket(rate, burst, now)
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	b.setLimit(rate, burst)
+	return b
+}
+
+// sweep drops the buckets that have been idle for IdleTimeout.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.config.IdleTimeout {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.config.IdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientIP returns the address a request came from, without the port.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// retryAfterSeconds formats wait for a Retry-After header, rounded up to whole seconds.
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(math.Ceil(wait.Seconds()), 1)))
+}
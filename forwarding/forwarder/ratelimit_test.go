@@ -0,0 +1,102 @@
+package forwarder
+
+import (
+	"forwarding/metrics_processing/protocol"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterClientBucket(t *testing.T) {
+	config := DefaultRateLimitConfig()
+	config.ClientRequestsPerSecond = 2
+	config.ClientBurst = 3
+	l := newRateLimiter(config)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := l.allow("example.com", "10.0.0.1", nil, now); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	ok, wait, scope := l.allow("example.com", "10.0.0.1", nil, now)
+	if ok || scope != rateLimitClient || wait != 500*time.Millisecond {
+		t.Errorf("4th request: ok %v, wait %v, scope %q", ok, wait, scope)
+	}
+	if ok, _, _ := l.allow("example.com", "10.0.0.2", nil, now); !ok {
+		t.Errorf("another client was refused")
+	}
+	if ok, _, _ := l.allow("other.com", "10.0.0.1", nil, now); !ok {
+		t.Errorf("the client was refused for another domain")
+	}
+	if ok, _, _ := l.allow("example.com", "10.0.0.1", nil, now.Add(500*time.Millisecond)); !ok {
+		t.Errorf("no token refilled after 500ms")
+	}
+
+	// The controller's rate replaces the default
+	limit := &protocol.RateLimit{ClientRequestsPerSecond: 100}
+	for i := 0; i < 5; i++ {
+		if ok, _, _ := l.allow("example.com", "10.0.0.3", limit, now); !ok {
+			t.Errorf("the controller's client rate was not applied")
+		}
+	}
+}
+
+func TestRateLimiterDomainAndGlobal(t *testing.T) {
+	config := DefaultRateLimitConfig()
+	config.RequestsPerSecond = 10
+	config.Burst = 4
+	l := newRateLimiter(config)
+	now := time.Now()
+	limit := &protocol.RateLimit{RequestsPerSecond: 1, Burst: 2, ClientRequestsPerSecond: 1, ClientBurst: 1}
+
+	if ok, _, _ := l.allow("example.com", "10.0.0.1", limit, now); !ok {
+		t.Fatal("first request refused")
+	}
+	// A client over its own limit takes nothing from the domain
+	if ok, _, scope := l.allow("example.com", "10.0.0.1", limit, now); ok || scope != rateLimitClient {
+		t.Errorf("expected the client limit, got ok %v scope %q", ok, scope)
+	}
+	if ok, _, _ := l.allow("example.com", "10.0.0.2", limit, now); !ok {
+		t.Errorf("second client refused within the domain burst")
+	}
+	ok, wait, scope := l.allow("example.com", "10.0.0.3", limit, now)
+	if ok || scope != rateLimitDomain || wait != time.Second {
+		t.Errorf("expected the domain limit, got ok %v wait %v scope %q", ok, wait, scope)
+	}
+
+	for _, domain := range []string{"a.com", "b.com"} {
+		if ok, _, _ := l.allow(domain, "10.0.0.1", nil, now); !ok {
+			t.Errorf("%s refused within the global burst", domain)
+		}
+	}
+	if ok, _, scope := l.allow("c.com", "10.0.0.1", nil, now); ok || scope != rateLimitGlobal {
+		t.Errorf("expected the global limit, got ok %v scope %q", ok, scope)
+	}
+}
+
+func TestRateLimiterDropsIdleBuckets(t *testing.T) {
+	config := DefaultRateLimitConfig()
+	config.ClientRequestsPerSecond = 1
+	l := newRateLimiter(config)
+	now := time.Now()
+	l.allow("example.com", "10.0.0.1", nil, now)
+	l.allow("example.com", "10.0.0.2", nil, now.Add(config.IdleTimeout))
+	if len(l.buckets) != 1 {
+		t.Errorf("expected the idle bucket to be dropped, have %d", len(l.buckets))
+	}
+}
+
+func TestRetryAfterAndClientIP(t *testing.T) {
+	if s := retryAfterSeconds(200 * time.Millisecond); s != "1" {
+		t.Errorf("Retry-After for 200ms = %s", s)
+	}
+	if s := retryAfterSeconds(2100 * time.Millisecond); s != "3" {
+		t.Errorf("Retry-After for 2.1s = %s", s)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "[2001:db8::1]:4321"
+	if ip := clientIP(req); ip != "2001:db8::1" {
+		t.Errorf("clientIP = %s", ip)
+	}
+}
@@ -3,16 +3,20 @@ package forwarder
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"forwarding/forwarder/connection"
+	"forwarding/metrics_processing/fault"
 	packet "forwarding/packet_handler"
+	"forwarding/router"
+	"forwarding/tracing"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"runtime"
-	"strings"
 	"sync"
 	"time"
 
@@ -22,7 +26,10 @@ import (
 type ResponseData struct {
 	RequestID uint32
 	Data      []byte
-	HopList   []uint32 // HopList of the original request, used to route response back
+	HopList   []netip.Addr // HopList of the original request, used to route response back
+	Priority  byte
+	Trace     tracing.TraceContext
+	HopStamps []packet.HopStamp // of the request, up to its arrival at this last relay
 }
 
 type RelayRepositoryConfig struct {
@@ -49,6 +56,14 @@ type RelayRepository struct {
 
 	bufferManager *BufferManager
 	stateManager  *RequestStateManager
+	egress        *egressScheduler
+	compressor    *payloadCompressor
+
+	udpMu    sync.Mutex
+	udpFlows map[relayFlowKey]*udpFlow // flows this node sends to their origin
+
+	listenerMu sync.Mutex
+	listeners  []net.Listener
 }
 
 type RelayRequestItem struct {
@@ -73,6 +88,14 @@ type RelayConfig struct {
 
 	AccessResponsePort string // Port used by Access points to listen for responses (e.g., "50054")
 	RelayResponsePort  string // Port used by Relay points to listen for responses (e.g., "50057")
+
+	Egress EgressConfig // Send slots shared by the traffic classes, see qos.go
+
+	UDPFlowIdleTimeout time.Duration // origin sockets of UDP flows are closed after this long without traffic
+
+	Compression CompressionConfig // per-hop payload compression, see compression.go
+
+	Buffer BufferConfig // merge buffers, DefaultBufferConfig if unset
 }
 
 var DefaultRelayConfig = RelayConfig{
@@ -82,6 +105,10 @@ var DefaultRelayConfig = RelayConfig{
 	SourcePort:         "8080",
 	AccessResponsePort: "50054",
 	RelayResponsePort:  "50057",
+	Egress:             DefaultEgressConfig(),
+	UDPFlowIdleTimeout: DefaultUDPFlowIdleTimeout,
+	Compression:        DefaultCompressionConfig(),
+	Buffer:             DefaultBufferConfig(),
 }
 
 type RelayProxy struct {
@@ -93,7 +120,8 @@ func CreateRelayProxy(relayConfig RelayConfig, repoConfig RelayRepositoryConfig)
 	log.Printf("[Relay-INFO] Creating RelayProxy with RelayConfig ports: Req=%s, Resp=%s, NextRelay=%s, Source=%s; RepoConfig: ReqBuf=%d, RespBuf=%d",
 		relayConfig.RequestPort, relayConfig.ResponsePort, relayConfig.RelayPort, relayConfig.SourcePort, repoConfig.RequestBufferSize, repoConfig.ResponseBufferSize)
 
-	stateManager := NewRequestStateManager(15*time.Minute, 1*time.Minute) // TODO: Make these configurable
+	timeouts := currentTimeouts()
+	stateManager := NewRequestStateManager(timeouts.StateExpiration, timeouts.StateCleanup)
 
 	repo := &RelayRepository{
 		requestChan:  make(chan *RelayRequestItem, repoConfig.RequestBufferSize),
@@ -102,10 +130,17 @@ func CreateRelayProxy(relayConfig RelayConfig, repoConfig RelayRepositoryConfig)
 		config:       repoConfig,
 		relayConfig:  relayConfig,
 		stateManager: stateManager,
+		egress:       newEgressScheduler(relayConfig.Egress),
+		udpFlows:     make(map[relayFlowKey]*udpFlow),
 	}
 
-	bufferConfig := DefaultBufferConfig() // Assuming DefaultBufferConfig is suitable
+	bufferConfig := relayConfig.Buffer
+	if bufferConfig.BuffersPerPath == 0 {
+		bufferConfig = DefaultBufferConfig()
+	}
 	repo.bufferManager = NewBufferManager(bufferConfig, stateManager)
+	repo.compressor = newPayloadCompressor(relayConfig.Compression, repo.bufferManager.RecordCompression)
+	registerStatsSource("relay", repo.bufferManager, stateManager)
 
 	// Set send functions for the buffer manager
 	repo.bufferManager.SetSendFunctions(
@@ -122,18 +157,20 @@ func CreateRelayProxy(relayConfig RelayConfig, repoConfig RelayRepositoryConfig)
 }
 
 func (r *RelayRepository) StartProcessors() {
-	r.wg.Add(2) // For processRequests and processResponses goroutines
+	r.wg.Add(3) // For processRequests, processResponses and expireUDPFlows goroutines
 
 	go r.processRequests()
 	go r.processResponses()
+	go r.expireUDPFlows()
 
 	log.Println("[RelayRepository-INFO] Started request and response processors.")
 }
 
 func (r *RelayRepository) Stop() {
 	log.Println("[RelayRepository-INFO] Stopping repository processors...")
-	close(r.done) // Signal goroutines to stop
-	r.wg.Wait()   // Wait for processor goroutines to finish
+	close(r.done)      // Signal goroutines to stop
+	r.closeListeners() // Unblocks the accept loops
+	r.wg.Wait()        // Wait for processor goroutines to finish
 
 	if r.bufferManager != nil {
 		log.Println("[RelayRepository-INFO] Stopping BufferManager.")
@@ -148,6 +185,22 @@ func (r *RelayRepository) Stop() {
 	log.Println("[RelayRepository-INFO] All repository processors stopped.")
 }
 
+func (r *RelayRepository) trackListener(listener net.Listener) {
+	r.listenerMu.Lock()
+	r.listeners = append(r.listeners, listener)
+	r.listenerMu.Unlock()
+}
+
+// closeListeners stops accepting connections from other nodes; open sessions stay up.
+func (r *RelayRepository) closeListeners() {
+	r.listenerMu.Lock()
+	defer r.listenerMu.Unlock()
+	for _, listener := range r.listeners {
+		listener.Close()
+	}
+	r.listeners = nil
+}
+
 func (r *RelayRepository) processRequests() {
 	defer r.wg.Done()
 
@@ -184,6 +237,7 @@ func (r *RelayRepository) processRequests() {
 }
 
 func (r *RelayRepository) processRequestWithTargetRouting(data []byte, responseStream *smux.Stream, remoteAddr string) {
+	receivedAt := time.Now()
 	log.Printf("[Relay-DEBUG] Processing request from %s, data size: %d bytes.", remoteAddr, len(data))
 
 	if len(data) < 4 { // Assuming a minimum header size of 4 bytes if packet.MinHeaderSize is not defined
@@ -207,6 +261,16 @@ func (r *RelayRepository) processRequestWithTargetRouting(data []byte, responseS
 		return
 	}
 	log.Printf("[Relay-INFO] Received request from %s. Header: PacketCount=%d, IDs=%v, HopCounts=%d, Current HopList: %v", remoteAddr, header.PacketCount, header.PacketID, header.HopCounts, header.HopList)
+	if err := router.ValidateHopList(header.HopList); err != nil {
+		log.Printf("[Relay-ERROR] Refusing request(s) %v from %s: %v", header.PacketID, remoteAddr, err)
+		return
+	}
+	r.compressor.learn(remoteAddr, header)
+	requestPayloadBytes, err = decodePayload(header, requestPayloadBytes)
+	if err != nil {
+		log.Printf("[Relay-ERROR] Failed to decode payload of request(s) %v from %s: %v", header.PacketID, remoteAddr, err)
+		return
+	}
 
 	header.IncrementHopCounts()
 	log.Printf("[Relay-INFO] Incremented HopCounts to %d for request(s) %v.", header.HopCounts, header.PacketID)
@@ -218,8 +282,12 @@ func (r *RelayRepository) processRequestWithTargetRouting(data []byte, responseS
 		return
 	}
 	log.Printf("[Relay-INFO] Determined next hop for request(s) %v: %s. IsLastHop: %v", header.PacketID, nextHopIP, isLastHop)
+	if isLastHop {
+		header.AddStamp(packet.StampRequest, header.HopCounts-1, receivedAt, time.Time{})
+	}
 
 	var requestStates []*RequestState
+	var hopSpans requestSpans
 
 	if header.PacketCount > 1 {
 		log.Printf("[Relay-DEBUG] Processing %d merged requests from %s. Request IDs: %v", header.PacketCount, remoteAddr, header.PacketID)
@@ -247,11 +315,14 @@ func (r *RelayRepository) processRequestWithTargetRouting(data []byte, responseS
 				NextHopIP:        nextHopIP,
 				HopList:          header.HopList, // The full hop list from the received packet
 				IsLastHop:        isLastHop,
+				Priority:         header.Priority,
 				ResponseReceived: make(chan struct{}), // Channel to signal response arrival for this specific request ID
 				BufferID:         "",                  // Will be set by BufferManager if used
 				MergeGroupID:     0,                   // Will be set by BufferManager if used
 				UpdatedHeader:    header,              // Store the potentially modified header (e.g., incremented HopCounts)
+				Trace:            tracing.ExtractFromRequest(reqData),
 			}
+			hopSpans = append(hopSpans, startHopSpan(reqState, header.HopCounts, remoteAddr, receivedAt))
 
 			r.stateManager.AddState(reqState) // StateManager logs this addition
 			requestStates = append(requestStates, reqState)
@@ -271,11 +342,14 @@ func (r *RelayRepository) processRequestWithTargetRouting(data []byte, responseS
 			NextHopIP:        nextHopIP,
 			HopList:          header.HopList,
 			IsLastHop:        isLastHop,
+			Priority:         header.Priority,
 			ResponseReceived: make(chan struct{}),
 			BufferID:         "",
 			MergeGroupID:     0,
 			UpdatedHeader:    header,
+			Trace:            tracing.ExtractFromRequest(requestPayloadBytes),
 		}
+		hopSpans = append(hopSpans, startHopSpan(reqState, header.HopCounts, remoteAddr, receivedAt))
 		r.stateManager.AddState(reqState)
 		requestStates = append(requestStates, reqState)
 	} else {
@@ -298,12 +372,18 @@ func (r *RelayRepository) processRequestWithTargetRouting(data []byte, responseS
 
 				// This assumes handleSingleDirectRequest makes an HTTP request to the final destination
 				respData, err := r.handleSingleDirectRequest(state)
+				defer hopSpans[idx].End()
+				hopSpans[idx].SetError(err)
 				if err != nil {
 					// Error already logged in handleSingleDirectRequest, stateManager status also updated there.
 					// No need to call r.stateManager.UpdateStatus here again if handleSingleDirectRequest does it.
 					log.Printf("[Relay-ERROR] Last hop: Error handling direct request for ID %d: %v", state.RequestID, err)
 					return // Error response should have been handled or logged by handleSingleDirectRequest or state manager.
 				}
+				if respData == nil {
+					log.Printf("[Relay-INFO] Last hop: Response for Request ID %d was streamed back directly.", state.RequestID)
+					return
+				}
 
 				// If handleSingleDirectRequest is successful, it returns response data to be sent back.
 				// This response data needs to be processed by the buffer manager to be sent to the previous hop.
@@ -325,6 +405,8 @@ func (r *RelayRepository) processRequestWithTargetRouting(data []byte, responseS
 		log.Printf("[Relay-INFO] Request(s) %v from %s: Not the last hop. Forwarding to next hop: %s. PacketCount: %d",
 			header.PacketID, remoteAddr, nextHopIP, header.PacketCount)
 
+		header.Property, requestPayloadBytes = r.compressor.encode(header, requestPayloadBytes, nextHopIP)
+		header.AddStamp(packet.StampRequest, header.HopCounts-1, receivedAt, time.Now())
 		updatedHeaderBytes, err := header.Pack() // Header has HopCounts incremented
 		if err != nil {
 			log.Printf("[Relay-ERROR] Failed to pack updated header for forwarding (Request IDs: %v from %s): %v", header.PacketID, remoteAddr, err)
@@ -332,6 +414,7 @@ func (r *RelayRepository) processRequestWithTargetRouting(data []byte, responseS
 			for _, rs := range requestStates {
 				r.stateManager.UpdateStatus(rs.RequestID, StatusFailed)
 			}
+			hopSpans.end(err)
 			return
 		}
 
@@ -340,7 +423,11 @@ func (r *RelayRepository) processRequestWithTargetRouting(data []byte, responseS
 		mergedForwardData := append(updatedHeaderBytes, requestPayloadBytes...)
 		log.Printf("[Relay-DEBUG] Forwarding data to %s. Header size: %d, Payload size: %d, Total size: %d.", nextHopIP, len(updatedHeaderBytes), len(requestPayloadBytes), len(mergedForwardData))
 
+		sendSpans := startRequestSpans(requestStates, "hop.send", tracing.KindClient, time.Now())
+		sendSpans.setAttribute("server.address", nextHopIP)
 		err = r.forwardToNextHop(mergedForwardData, nextHopIP, header, isLastHop)
+		sendSpans.end(err)
+		hopSpans.end(err)
 		if err != nil {
 			log.Printf("[Relay-ERROR] Failed to forward request(s) %v from %s to next hop %s: %v", header.PacketID, remoteAddr, nextHopIP, err)
 			// Mark states as failed if forwarding fails
@@ -363,13 +450,10 @@ func (r *RelayRepository) processRequestWithTargetRouting(data []byte, responseS
 func (r *RelayRepository) forwardToNextHop(dataToSend []byte, nextHopIP string, updatedHeader *packet.Packet, isNextHopTheActualLastHop bool) error {
 	log.Printf("[Relay-DEBUG] Forwarding data. NextHopIP: %s, IsFinalDest: %v, Data size: %d bytes.", nextHopIP, isNextHopTheActualLastHop, len(dataToSend))
 
-	parts := strings.Split(nextHopIP, ":")
-	ip := parts[0]
-	var port string
+	ip, port := hostPort(nextHopIP, "")
 	var targetDescription string
 
-	if len(parts) > 1 {
-		port = parts[1]
+	if port != "" {
 		targetDescription = "Relay (specific port)"
 	} else {
 		// If port is not in nextHopIP, determine if the *ultimate* destination is a source or another relay
@@ -404,10 +488,13 @@ func (r *RelayRepository) forwardToNextHop(dataToSend []byte, nextHopIP string,
 		}
 	}
 
-	targetAddr := ip + ":" + port
+	targetAddr := net.JoinHostPort(ip, port)
 	log.Printf("[Relay-INFO] Determined target address for next hop %s: %s (%s). Updated HopCounts: %d, IsNextTheLastInChain: %v",
 		nextHopIP, targetAddr, targetDescription, updatedHeader.HopCounts, isNextHopTheActualLastHop)
 
+	release := r.egress.acquire(updatedHeader.Priority)
+	defer release()
+
 	session, err := connection.GetOrCreateClientSession(targetAddr)
 	if err != nil {
 		log.Printf("[Relay-ERROR] Failed to get/create SMUX client session for %s target %s: %v", targetDescription, targetAddr, err)
@@ -449,6 +536,7 @@ func (r *RelayRepository) forwardToNextHop(dataToSend []byte, nextHopIP string,
 
 // handleSingleDirectRequest processes a request that is at its last hop (i.e., this relay is to send it to the actual target server).
 // It assumes the requestData is a full HTTP request.
+// A nil *ResponseData with a nil error means the response body was streamed back on its own stream.
 func (r *RelayRepository) handleSingleDirectRequest(reqState *RequestState) (*ResponseData, error) {
 	// StateManager.UpdateStatus is called by the caller or here, ensure consistency.
 	// Here, it implies an attempt to send has started.
@@ -459,6 +547,11 @@ func (r *RelayRepository) handleSingleDirectRequest(reqState *RequestState) (*Re
 	requestData := reqState.RequestData    // This is the HTTP request bytes
 	nextHopIP := reqState.NextHopIP        // This should be the target server IP:Port
 	hopListForResponse := reqState.HopList // Original hop list to send back with the response
+	priority := reqState.Priority
+	var hopStamps []packet.HopStamp
+	if reqState.UpdatedHeader != nil {
+		hopStamps = reqState.UpdatedHeader.HopStamps
+	}
 	reqState.mu.RUnlock()
 
 	log.Printf("[Relay-INFO] Request ID %d: Handling direct request to target %s. Payload size: %d bytes.",
@@ -474,44 +567,21 @@ func (r *RelayRepository) handleSingleDirectRequest(reqState *RequestState) (*Re
 	}
 	log.Printf("[Relay-DEBUG] Request ID %d: Parsed HTTP request: %s %s %s", requestID, httpReq.Method, httpReq.Host, httpReq.URL.Path)
 
-	// Determine target host and port from nextHopIP (which is the target server)
-	parts := strings.Split(nextHopIP, ":")
-	host := parts[0]
-	var port string
-	if len(parts) > 1 {
-		port = parts[1]
-	} else {
-		// If no port specified in nextHopIP for the target, use the configured SourcePort (e.g., 80 or 8080 for HTTP)
-		port = r.relayConfig.SourcePort
-		log.Printf("[Relay-DEBUG] Request ID %d: No port in target %s, using default SourcePort: %s", requestID, nextHopIP, port)
-	}
-
-	// Construct the target URL for the HTTP client
-	// httpReq.URL.Path already contains the path and query params from the original request
-	targetURLStr := fmt.Sprintf("http://%s:%s%s", host, port, httpReq.URL.String()) // Use httpReq.URL.String() to preserve query params
-	log.Printf("[Relay-INFO] Request ID %d: Constructed target URL for direct request: %s", requestID, targetURLStr)
-
-	destURL, err := url.Parse(targetURLStr)
+	targetURLStr, client, err := r.prepareOriginRequest(httpReq, nextHopIP)
 	if err != nil {
-		log.Printf("[Relay-ERROR] Request ID %d: Failed to parse target URL '%s': %v", requestID, targetURLStr, err)
+		log.Printf("[Relay-ERROR] Request ID %d: %v", requestID, err)
 		r.stateManager.UpdateStatus(requestID, StatusFailed)
-		return nil, fmt.Errorf("failed to parse target URL '%s' for ID %d: %w", targetURLStr, requestID, err)
+		return nil, fmt.Errorf("failed to prepare origin request for ID %d: %w", requestID, err)
 	}
+	log.Printf("[Relay-INFO] Request ID %d: Constructed target URL for direct request: %s", requestID, targetURLStr)
 
-	// Prepare the request for the client.Do call
-	httpReq.URL = destURL   // Set the URL to the absolute target URL
-	httpReq.RequestURI = "" // Must be empty for client requests
-	httpReq.Host = host     // Set the Host header explicitly for the target
-
-	// TODO: Make client timeout configurable
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		// Potentially configure transport for connection pooling, keep-alives etc.
-		// Transport: &http.Transport{...},
-	}
+	originSpan := startOriginSpan(reqState.Trace, requestID, httpReq, targetURLStr)
+	defer originSpan.End()
 
 	log.Printf("[Relay-DEBUG] Request ID %d: Sending HTTP %s request to %s", requestID, httpReq.Method, targetURLStr)
 	httpResp, err := client.Do(httpReq)
+	recordOriginResult(httpReq.URL.Host, httpResp, err)
+	endOriginCall(originSpan, httpResp, err)
 	if err != nil {
 		log.Printf("[Relay-ERROR] Request ID %d: HTTP client failed to execute request to %s: %v", requestID, targetURLStr, err)
 		r.stateManager.UpdateStatus(requestID, StatusFailed)
@@ -522,7 +592,19 @@ func (r *RelayRepository) handleSingleDirectRequest(reqState *RequestState) (*Re
 
 	r.stateManager.UpdateStatus(requestID, StatusResponding) // Logged by StateManager
 
-	respBodyBytes, err := io.ReadAll(httpResp.Body) // Read the entire response body
+	if shouldStreamBody(httpResp.ContentLength, r.bufferManager.GetMaxBufferSize()) {
+		// Large or unknown-length body: stream it back on a dedicated stream instead of buffering it
+		log.Printf("[Relay-INFO] Request ID %d: Response Content-Length %d exceeds buffer limit, streaming it back.", requestID, httpResp.ContentLength)
+		if err := r.streamResponseToPreviousHop(requestID, hopListForResponse, httpReq, httpResp); err != nil {
+			log.Printf("[Relay-ERROR] Request ID %d: %v", requestID, err)
+			r.stateManager.UpdateStatus(requestID, StatusFailed)
+			return nil, fmt.Errorf("failed to stream response for ID %d: %w", requestID, err)
+		}
+		r.stateManager.UpdateStatus(requestID, StatusCompleted)
+		return nil, nil
+	}
+
+	respBodyBytes, err := io.ReadAll(httpResp.Body) // Body is bounded by MaxBufferSize here
 	if err != nil {
 		log.Printf("[Relay-ERROR] Request ID %d: Failed to read response body from %s: %v", requestID, targetURLStr, err)
 		r.stateManager.UpdateStatus(requestID, StatusFailed)
@@ -563,19 +645,53 @@ func (r *RelayRepository) handleSingleDirectRequest(reqState *RequestState) (*Re
 		RequestID: requestID,
 		Data:      rawResponseBuffer.Bytes(),
 		HopList:   hopListForResponse, // Use the HopList stored in reqState
+		Priority:  priority,
+		Trace:     reqState.Trace,
+		HopStamps: hopStamps,
 	}, nil
 }
 
+// prepareOriginRequest rewrites a parsed relay request so it can be sent to the
+// origin at nextHopIP, and returns the client pooled for that origin.
+func (r *RelayRepository) prepareOriginRequest(httpReq *http.Request, nextHopIP string) (string, *http.Client, error) {
+	domain := router.NormalizeDomain(httpReq.Host)
+	target, err := resolveOriginTarget(domain, nextHopIP, r.relayConfig.SourcePort, router.GetOriginConfig(domain))
+	if err != nil {
+		return "", nil, err
+	}
+	client, err := originClients.get(target)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to set up transport to origin %s of %s: %w", target.addr, domain, err)
+	}
+
+	// httpReq.URL.String() preserves the path and query params from the original request
+	targetURLStr := fmt.Sprintf("%s://%s%s", target.scheme, target.addr, httpReq.URL.String())
+	destURL, err := url.Parse(targetURLStr)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse target URL '%s': %w", targetURLStr, err)
+	}
+
+	httpReq.URL = destURL            // Set the URL to the absolute target URL
+	httpReq.RequestURI = ""          // Must be empty for client requests
+	httpReq.Host = target.hostHeader // Set the Host header explicitly for the target
+	return targetURLStr, client, nil
+}
+
+// recordOriginResult feeds the fault detector; transport errors and 5xx responses count as origin failures.
+func recordOriginResult(origin string, httpResp *http.Response, err error) {
+	if err == nil && httpResp.StatusCode >= http.StatusInternalServerError {
+		err = fmt.Errorf("origin returned %s", httpResp.Status)
+	}
+	fault.GetDetector().RecordOriginResult(origin, err)
+}
+
 func (r *RelayRepository) sendSingleRequest(data []byte, nextHopIP string, requestID uint32, request *RequestState) error {
 	log.Printf("[Relay-sendSingleRequest-DEBUG] Attempting to send single request ID %d to NextHopIP: %s. Payload data size: %d bytes.", requestID, nextHopIP, len(data))
 
-	parts := bytes.Split([]byte(nextHopIP), []byte(":"))
-	ip := string(parts[0])
+	ip, port := hostPort(nextHopIP, "")
 
-	var port string
 	var portResolutionReason string
-	if len(parts) > 1 {
-		port = string(parts[1])
+	if port != "" {
 		portResolutionReason = "specified in NextHopIP"
 	} else {
 		var isLastHop bool
@@ -595,7 +711,7 @@ func (r *RelayRepository) sendSingleRequest(data []byte, nextHopIP string, reque
 		}
 	}
 
-	targetAddr := ip + ":" + port
+	targetAddr := net.JoinHostPort(ip, port)
 	log.Printf("[Relay-sendSingleRequest-INFO] Determined target address for request ID %d: %s. Port resolved via: %s.", requestID, targetAddr, portResolutionReason)
 
 	session, err := connection.GetOrCreateClientSession(targetAddr)
@@ -633,11 +749,13 @@ func (r *RelayRepository) sendSingleRequest(data []byte, nextHopIP string, reque
 		// for this forwarding attempt (e.g., HopCounts already incremented).
 		originalHopList := request.UpdatedHeader.HopList
 		currentHopCounts := request.UpdatedHeader.HopCounts
+		currentPriority := request.UpdatedHeader.Priority
 		request.mu.RUnlock()
 
 		// Construct a new header for this single packet.
 		// 'data' is the payload for this specific requestID.
 		newHeader := &packet.Packet{
+			Priority:    currentPriority,
 			PacketCount: 1,
 			PacketID:    []uint32{requestID},
 			HopList:     originalHopList,
@@ -694,13 +812,10 @@ func (r *RelayRepository) sendMergedRequest(mergedDataPayload []byte, nextHopIP
 	log.Printf("[Relay-sendMergedRequest-DEBUG] Attempting to send merged request to NextHopIP: %s. Request IDs: %v, HopCounts: %d. Merged payload size: %d bytes.",
 		nextHopIP, headerForNextHop.PacketID, headerForNextHop.HopCounts, len(mergedDataPayload))
 
-	parts := bytes.Split([]byte(nextHopIP), []byte(":"))
-	ip := string(parts[0])
+	ip, port := hostPort(nextHopIP, "")
 
-	var port string
 	var portResolutionReason string
-	if len(parts) > 1 {
-		port = string(parts[1])
+	if port != "" {
 		portResolutionReason = "specified in NextHopIP"
 	} else {
 		// Use GetNextHopIP on the provided 'headerForNextHop' to determine if the *current* target 'nextHopIP' is the final one in the chain.
@@ -721,7 +836,7 @@ func (r *RelayRepository) sendMergedRequest(mergedDataPayload []byte, nextHopIP
 		}
 	}
 
-	targetAddr := ip + ":" + port
+	targetAddr := net.JoinHostPort(ip, port)
 	log.Printf("[Relay-sendMergedRequest-INFO] Determined target address for merged request (IDs: %v): %s. Port resolved via: %s.",
 		headerForNextHop.PacketID, targetAddr, portResolutionReason)
 
@@ -794,16 +909,16 @@ func (r *RelayRepository) forwardResponseToPreviousHop(previousHopIP string, upd
 		return fmt.Errorf("failed to unpack response header for forwarding: %w", err)
 	}
 
+	updatedResponseHeaderBytes = append([]byte(nil), updatedResponseHeaderBytes...)
+	updatedResponseHeaderBytes[packet.PropertyOffset], responsePayloadBytes = r.compressor.encode(header, responsePayloadBytes, previousHopIP)
+
 	log.Printf("[Relay-forwardResponse-DEBUG] Attempting to forward response to PreviousHopIP: %s. Request IDs: %v, HopCounts in header: %d. Header size: %d, Payload size: %d.",
 		previousHopIP, header.PacketID, header.HopCounts, len(updatedResponseHeaderBytes), len(responsePayloadBytes))
 
-	parts := strings.Split(previousHopIP, ":")
-	ip := parts[0]
+	ip, port := hostPort(previousHopIP, "")
 
-	var port string
 	var targetType string // To specify "Access" or "Relay" for logging clarity
-	if len(parts) > 1 {
-		port = parts[1]
+	if port != "" {
 		// If previousHopIP includes a port, it implies a specific port was used by that relay for receiving responses.
 		targetType = fmt.Sprintf("Relay Node (specific port %s from PreviousHopIP)", port)
 	} else if header.HopCounts == 0 {
@@ -816,10 +931,13 @@ func (r *RelayRepository) forwardResponseToPreviousHop(previousHopIP string, upd
 		targetType = fmt.Sprintf("Relay Node (HopCounts is %d, using RelayResponsePort: %s)", header.HopCounts, port)
 	}
 
-	targetAddr := ip + ":" + port
+	targetAddr := net.JoinHostPort(ip, port)
 	log.Printf("[Relay-forwardResponse-INFO] Determined target for response: %s (%s). Original Request IDs: %v.",
 		targetAddr, targetType, header.PacketID)
 
+	release := r.egress.acquire(header.Priority)
+	defer release()
+
 	session, err := connection.GetOrCreateClientSession(targetAddr)
 	if err != nil {
 		log.Printf("[Relay-forwardResponse-ERROR] Failed to get/create SMUX client session for %s target %s (Request IDs: %v): %v", targetType, targetAddr, header.PacketID, err)
@@ -895,6 +1013,7 @@ func (r *RelayRepository) processResponses() {
 }
 
 func (r *RelayRepository) handleResponse(data []byte, remoteAddr string) {
+	receivedAt := time.Now()
 
 	if len(data) < 4 {
 
@@ -918,12 +1037,23 @@ func (r *RelayRepository) handleResponse(data []byte, remoteAddr string) {
 		log.Printf("[Relay-ERROR] : %v", err)
 		return
 	}
+	if err := router.ValidateHopList(header.HopList); err != nil {
+		log.Printf("[Relay-ERROR] Refusing response(s) %v from %s: %v", header.PacketID, remoteAddr, err)
+		return
+	}
+	r.compressor.learn(remoteAddr, header)
+	responseBytes, err = decodePayload(header, responseBytes)
+	if err != nil {
+		log.Printf("[Relay-ERROR] Failed to decode payload of response(s) %v from %s: %v", header.PacketID, remoteAddr, err)
+		return
+	}
 
 	log.Printf("[Relay] ，HopCounts=%d", header.HopCounts)
 
 	header.DecrementHopCounts()
 	log.Printf("[Relay] ，HopCounts=%d", header.HopCounts)
 
+	header.AddStamp(packet.StampResponse, header.HopCounts-1, receivedAt, time.Now())
 	updatedHeaderBytes, err := header.Pack()
 	if err != nil {
 		log.Printf("[Relay-ERROR] header: %v", err)
@@ -939,7 +1069,10 @@ func (r *RelayRepository) handleResponse(data []byte, remoteAddr string) {
 	log.Printf("[Relay] : %d，: %s",
 		header.PacketCount, previousHopIP)
 
+	spans := startPacketSpans(r.stateManager, header.PacketID, "relay.response", tracing.KindServer, receivedAt)
+	spans.setAttribute(attrHopIndex, int(header.HopCounts)+1)
 	err = r.forwardResponseToPreviousHop(previousHopIP, updatedHeaderBytes, responseBytes)
+	spans.end(err)
 	if err != nil {
 		log.Printf("[Relay-ERROR] : %v", err)
 	}
@@ -948,7 +1081,7 @@ func (r *RelayRepository) handleResponse(data []byte, remoteAddr string) {
 }
 
 func (r *RelayRepository) getResponseBuffer(pathHash string, requestID uint32) *ResponseBuffer {
-	return r.bufferManager.getResponseBuffer(pathHash, requestID)
+	return r.bufferManager.getResponseBuffer(pathHash, packet.PriorityStandard, requestID)
 }
 
 func (r *RelayRepository) mergeAndSendBufferedResponses(responses []*BufferedResponse, previousHopIP string) {
@@ -961,6 +1094,7 @@ func (r *RelayRepository) mergeAndSendBufferedResponses(responses []*BufferedRes
 	commonHopList := responses[0].HopList
 
 	respHeader := &packet.Packet{
+		Priority:    responses[0].Priority,
 		PacketCount: byte(len(responses)),
 		PacketID:    make([]uint32, len(responses)),
 		HopList:     commonHopList,
@@ -999,16 +1133,17 @@ func (r *RelayRepository) mergeAndSendBufferedResponses(responses []*BufferedRes
 
 func (r *RelayRepository) StartRequestListener() {
 
-	listenAddr := fmt.Sprintf("0.0.0.0:%s", r.relayConfig.RequestPort)
+	listenAddr := ":" + r.relayConfig.RequestPort
 	log.Printf("[Relay]  %s ", listenAddr)
 
-	listener, err := net.Listen("tcp", listenAddr)
+	listener, err := Listen(listenAddr)
 	if err != nil {
 		log.Fatalf("[Relay-FATAL] : %v", err)
 		return
 
 	}
 	defer listener.Close()
+	r.trackListener(listener)
 
 	for {
 		select {
@@ -1017,6 +1152,10 @@ func (r *RelayRepository) StartRequestListener() {
 			return
 		default:
 			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				log.Printf("[Relay-INFO] Request listener on %s closed.", listenAddr)
+				return
+			}
 			if err != nil {
 				log.Printf("[Relay-ERROR] : %v", err)
 				continue
@@ -1034,7 +1173,7 @@ func (r *RelayRepository) handleRequestConnection(conn net.Conn) {
 	remoteAddr := conn.RemoteAddr().String()
 	log.Printf("[Relay]  %s ", remoteAddr)
 
-	session, err := smux.Server(conn, connection.DefaultSmuxConfig())
+	session, err := connection.NewServerSession(conn)
 	if err != nil {
 
 		log.Printf("[Relay-ERROR] SMUX: %v", err)
@@ -1071,7 +1210,7 @@ func (r *RelayRepository) handleRequestStream(stream *smux.Stream, remoteAddr st
 	defer stream.Close()
 
 	buffer := make([]byte, 16384)
-	n, err := stream.Read(buffer)
+	n, err := readPacketStart(stream, buffer)
 	if err != nil {
 
 		if err == io.EOF {
@@ -1085,6 +1224,19 @@ func (r *RelayRepository) handleRequestStream(stream *smux.Stream, remoteAddr st
 
 	log.Printf("[Relay] : %d ", n)
 
+	if packet.IsStreamPacket(buffer[:n]) {
+		r.relayRequestStream(stream, buffer[:n], remoteAddr)
+		return
+	}
+	if packet.IsTunnelPacket(buffer[:n]) || packet.IsUpgradePacket(buffer[:n]) {
+		r.relayTunnel(stream, buffer[:n], remoteAddr)
+		return
+	}
+	if packet.IsDatagramPacket(buffer[:n]) {
+		r.relayDatagrams(stream, buffer[:n], remoteAddr)
+		return
+	}
+
 	r.requestChan <- &RelayRequestItem{
 		Data:       buffer[:n],
 		Stream:     stream,
@@ -1095,15 +1247,16 @@ func (r *RelayRepository) handleRequestStream(stream *smux.Stream, remoteAddr st
 
 func (r *RelayRepository) StartResponseListener() {
 
-	listenAddr := fmt.Sprintf("0.0.0.0:%s", r.relayConfig.ResponsePort)
+	listenAddr := ":" + r.relayConfig.ResponsePort
 	log.Printf("[Relay]  %s ", listenAddr)
 
-	listener, err := net.Listen("tcp", listenAddr)
+	listener, err := Listen(listenAddr)
 	if err != nil {
 
 		log.Fatalf("[Relay-FATAL] : %v", err)
 		return
 	}
+	r.trackListener(listener)
 
 	r.wg.Add(1)
 	go func() {
@@ -1117,6 +1270,10 @@ func (r *RelayRepository) StartResponseListener() {
 				return
 			default:
 				conn, err := listener.Accept()
+				if errors.Is(err, net.ErrClosed) {
+					log.Printf("[Relay-INFO] Response listener on %s closed.", listenAddr)
+					return
+				}
 				if err != nil {
 					log.Printf("[Relay-ERROR] : %v", err)
 					continue
@@ -1134,7 +1291,7 @@ func (r *RelayRepository) handleResponseConnection(conn net.Conn) {
 	remoteAddr := conn.RemoteAddr().String()
 	log.Printf("[Relay]  %s ", remoteAddr)
 
-	session, err := smux.Server(conn, connection.DefaultSmuxConfig())
+	session, err := connection.NewServerSession(conn)
 	if err != nil {
 		log.Printf("[Relay-ERROR] SMUX: %v", err)
 		conn.Close()
@@ -1163,7 +1320,7 @@ func (r *RelayRepository) handleResponseStream(stream *smux.Stream, remoteAddr s
 	defer stream.Close()
 
 	buffer := make([]byte, 65536)
-	n, err := stream.Read(buffer)
+	n, err := readPacketStart(stream, buffer)
 	if err != nil {
 		if err == io.EOF {
 			log.Printf("[Relay] : %s", remoteAddr)
@@ -1179,6 +1336,15 @@ func (r *RelayRepository) handleResponseStream(stream *smux.Stream, remoteAddr s
 	log.Printf("[RESP-FLOW] : =%d, =%v",
 		n, time.Now().Format("15:04:05.000"))
 
+	if packet.IsStreamPacket(buffer[:n]) {
+		r.relayResponseStream(stream, buffer[:n], remoteAddr)
+		return
+	}
+	if packet.IsDatagramPacket(buffer[:n]) {
+		r.relayDatagramReturns(stream, buffer[:n], remoteAddr)
+		return
+	}
+
 	r.responseChan <- &RelayResponseItem{
 		Data:       buffer[:n],
 		ReceivedAt: time.Now(),
@@ -0,0 +1,193 @@
+package forwarder
+
+import (
+	"bytes"
+	"errors"
+	"forwarding/router"
+	"forwarding/scheduling_algorithms/k_shortest"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Idempotent requests that fail or time out on one path are re-issued with a
+// fresh request ID on the next-best path. Every attempt writes through its own
+// attemptWriter, so a late answer from an abandoned attempt can never reach
+// the client once the next attempt has been issued.
+
+type RetryConfig struct {
+	MaxAttempts       int           // including the first attempt
+	AttemptTimeout    time.Duration // wait per attempt before trying another path
+	TotalBudget       time.Duration // overall wait for a response, across all attempts
+	MaxReplayBodySize int64         // larger bodies are not buffered, so such requests are sent once
+	FailedPathPenalty time.Duration // how long a failed path is de-prioritized by the round robin
+	IdempotentMethods []string
+}
+
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:       3,
+		AttemptTimeout:    10 * time.Second,
+		TotalBudget:       30 * time.Second,
+		MaxReplayBodySize: 1 << 20,
+		FailedPathPenalty: 30 * time.Second,
+		IdempotentMethods: []string{
+			http.MethodGet, http.MethodHead, http.MethodOptions,
+			http.MethodTrace, http.MethodPut, http.MethodDelete,
+		},
+	}
+}
+
+var (
+	errAttemptTimeout   = errors.New("timed out waiting for upstream response")
+	errAttemptAbandoned = errors.New("request attempt was abandoned")
+)
+
+// prepareRetry reports whether req may be re-issued under policy and, if it
+// has a body, reads it so every attempt can replay it.
+func prepareRetry(req *http.Request, policy RetryConfig) ([]byte, bool, error) {
+	if policy.MaxAttempts <= 1 {
+		return nil, false, nil
+	}
+	idempotent := false
+	for _, method := range policy.IdempotentMethods {
+		if req.Method == method {
+			idempotent = true
+			break
+		}
+	}
+	if !idempotent {
+		return nil, false, nil
+	}
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0 {
+		return nil, true, nil
+	}
+	if req.ContentLength < 0 || req.ContentLength > policy.MaxReplayBodySize {
+		return nil, false, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, req.ContentLength))
+	if err != nil {
+		return nil, false, err
+	}
+	return body, true, nil
+}
+
+// attemptRequest returns the request to hand to one attempt.
+func attemptRequest(req *http.Request, replayBody []byte) *http.Request {
+	if replayBody == nil {
+		return req
+	}
+	attemptReq := req.Clone(req.Context())
+	attemptReq.Body = io.NopCloser(bytes.NewReader(replayBody))
+	attemptReq.ContentLength = int64(len(replayBody))
+	return attemptReq
+}
+
+// nextRetryPath picks the lowest-latency path not tried yet for this request,
+// falling back to the lowest-latency path overall.
+func nextRetryPath(paths []k_shortest.PathWithIP, tried map[string]bool) k_shortest.PathWithIP {
+	if len(paths) == 0 {
+		return k_shortest.PathWithIP{}
+	}
+	sorted := make([]k_shortest.PathWithIP, len(paths))
+	copy(sorted, paths)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Latency < sorted[j].Latency })
+
+	for _, p := range sorted {
+		if !tried[router.PathKey(p.IPList)] {
+			return p
+		}
+	}
+	return sorted[0]
+}
+
+// attemptWriter isolates one attempt from the client connection. Headers are
+// kept aside until the attempt starts writing; after abandon, writes are
+// discarded, and once writing has started the attempt can't be abandoned.
+type attemptWriter struct {
+	w         http.ResponseWriter
+	header    http.Header
+	mu        sync.Mutex
+	started   bool
+	startedAt time.Time
+	abandoned bool
+	race      *hedgeRace // shared with the other copy of a hedged request
+}
+
+func newAttemptWriter(w http.ResponseWriter) *attemptWriter {
+	return &attemptWriter{w: w, header: make(http.Header)}
+}
+
+func (a *attemptWriter) Header() http.Header {
+	return a.header
+}
+
+// start must be called with a.mu held.
+func (a *attemptWriter) start() bool {
+	if a.abandoned {
+		return false
+	}
+	if a.race != nil && !a.race.claim(a) {
+		a.abandoned = true // the other copy answered first
+		return false
+	}
+	if !a.started {
+		a.started = true
+		a.startedAt = time.Now()
+		for key, values := range a.header {
+			a.w.Header()[key] = values
+		}
+	}
+	return true
+}
+
+func (a *attemptWriter) WriteHeader(statusCode int) {
+	a.mu.Lock()
+	if a.started || !a.start() {
+		a.mu.Unlock()
+		return
+	}
+	a.mu.Unlock()
+	a.w.WriteHeader(statusCode)
+}
+
+func (a *attemptWriter) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	ok := a.start()
+	a.mu.Unlock()
+	if !ok {
+		return 0, errAttemptAbandoned
+	}
+	return a.w.Write(p)
+}
+
+func (a *attemptWriter) Flush() {
+	a.mu.Lock()
+	started := a.started && !a.abandoned
+	a.mu.Unlock()
+	if flusher, ok := a.w.(http.Flusher); ok && started {
+		flusher.Flush()
+	}
+}
+
+// firstByteAt returns when the attempt started writing the response, zero if it has not.
+func (a *attemptWriter) firstByteAt() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.startedAt
+}
+
+// abandon detaches the attempt from the client. It returns false if the
+// attempt has already started writing the response.
+func (a *attemptWriter) abandon() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.started {
+		return false
+	}
+	a.abandoned = true
+	return true
+}
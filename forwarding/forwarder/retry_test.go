@@ -0,0 +1,114 @@
+package forwarder
+
+import (
+	"forwarding/router"
+	"forwarding/scheduling_algorithms/k_shortest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrepareRetry(t *testing.T) {
+	policy := DefaultRetryConfig()
+
+	get := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok, _ := prepareRetry(get, policy); !ok {
+		t.Errorf("GET without body should be retryable")
+	}
+
+	post := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("x"))
+	if _, ok, _ := prepareRetry(post, policy); ok {
+		t.Errorf("POST should not be retryable")
+	}
+
+	put := httptest.NewRequest(http.MethodPut, "/", strings.NewReader("payload"))
+	body, ok, err := prepareRetry(put, policy)
+	if err != nil || !ok || string(body) != "payload" {
+		t.Fatalf("PUT with small body: body=%q ok=%v err=%v", body, ok, err)
+	}
+	for i := 0; i < 2; i++ {
+		replayed, _ := io.ReadAll(attemptRequest(put, body).Body)
+		if string(replayed) != "payload" {
+			t.Errorf("attempt %d replayed %q", i, replayed)
+		}
+	}
+
+	policy.MaxReplayBodySize = 3
+	large := httptest.NewRequest(http.MethodPut, "/", strings.NewReader("payload"))
+	if _, ok, _ := prepareRetry(large, policy); ok {
+		t.Errorf("PUT with a body above MaxReplayBodySize should not be retryable")
+	}
+}
+
+func TestNextRetryPath(t *testing.T) {
+	paths := []k_shortest.PathWithIP{
+		{IPList: []string{"a", "c", "z"}, Latency: 30},
+		{IPList: []string{"a", "b", "z"}, Latency: 10},
+	}
+	tried := map[string]bool{router.PathKey(paths[1].IPList): true}
+	if got := nextRetryPath(paths, tried); got.Latency != 30 {
+		t.Errorf("expected the untried path, got %v", got.IPList)
+	}
+	tried[router.PathKey(paths[0].IPList)] = true
+	if got := nextRetryPath(paths, tried); got.Latency != 10 {
+		t.Errorf("expected the best path once all were tried, got %v", got.IPList)
+	}
+}
+
+func TestAttemptWriterAbandon(t *testing.T) {
+	rec := httptest.NewRecorder()
+	abandoned := newAttemptWriter(rec)
+	abandoned.Header().Set("X-Attempt", "1")
+	if !abandoned.abandon() {
+		t.Fatalf("an attempt that has not written should be abandonable")
+	}
+	abandoned.WriteHeader(http.StatusBadGateway)
+	if _, err := abandoned.Write([]byte("late")); err != errAttemptAbandoned {
+		t.Errorf("expected errAttemptAbandoned, got %v", err)
+	}
+
+	next := newAttemptWriter(rec)
+	next.Header().Set("X-Attempt", "2")
+	next.WriteHeader(http.StatusOK)
+	next.Write([]byte("ok"))
+	if next.abandon() {
+		t.Errorf("an attempt that has started writing must not be abandonable")
+	}
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" || rec.Header().Get("X-Attempt") != "2" {
+		t.Errorf("client saw code=%d body=%q X-Attempt=%q", rec.Code, rec.Body.String(), rec.Header().Get("X-Attempt"))
+	}
+}
+
+func TestWaitForAttemptWhileUploading(t *testing.T) {
+	stateManager := NewRequestStateManager(time.Minute, time.Minute)
+	defer stateManager.Stop()
+	r := &Repository{stateManager: stateManager}
+
+	item := &RequestItem{RequestID: 7, ResponseReceived: make(chan struct{}), AttemptFailed: make(chan error, 1)}
+	state := &RequestState{RequestID: 7, Status: StatusCreated}
+	stateManager.AddState(state)
+	done := make(chan struct{})
+	go func() {
+		// Body bytes keep flowing for longer than the attempt timeout
+		for i := 0; i < 6; i++ {
+			state.RecordUpload()
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(item.ResponseReceived)
+		close(done)
+	}()
+	if err := r.waitForAttempt(item, newAttemptWriter(httptest.NewRecorder()), 25*time.Millisecond); err != nil {
+		t.Errorf("attempt timed out during the upload: %v", err)
+	}
+	<-done
+
+	stalled := &RequestItem{RequestID: 8, ResponseReceived: make(chan struct{}), AttemptFailed: make(chan error, 1)}
+	stateManager.AddState(&RequestState{RequestID: 8, Status: StatusSent})
+	if err := r.waitForAttempt(stalled, newAttemptWriter(httptest.NewRecorder()), 10*time.Millisecond); err != errAttemptTimeout {
+		t.Errorf("expected errAttemptTimeout without an upload, got %v", err)
+	}
+}
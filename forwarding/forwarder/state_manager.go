@@ -2,8 +2,10 @@ package forwarder
 
 import (
 	packet "forwarding/packet_handler"
+	"forwarding/tracing"
 	"log"
 	"net/http"
+	"net/netip"
 	"sync"
 	"time"
 )
@@ -54,16 +56,23 @@ type RequestState struct {
 	LastUpdatedAt time.Time
 
 	NextHopIP string
-	HopList   []uint32
+	HopList   []netip.Addr
 	IsLastHop bool
+	Priority  byte // traffic class, see qos.go
 
 	ResponseReceived chan struct{}
+	AttemptFailed    chan error // set on the access node, see RequestItem.AttemptFailed
 
 	BufferID     string
 	MergeGroupID uint32
+	BufferedAt   time.Time // when the request entered its merge buffer
 
 	UpdatedHeader *packet.Packet
 
+	Trace tracing.TraceContext // span the request's next operations belong to, see tracing.go
+
+	UploadedAt time.Time // last progress of a streamed request body, see streaming.go
+
 	mu sync.RWMutex
 }
 
@@ -75,6 +84,22 @@ func (s *RequestState) UpdateStatus(status RequestStatus) {
 	s.LastUpdatedAt = time.Now()
 }
 
+// RecordUpload notes that request body bytes have just been sent.
+func (s *RequestState) RecordUpload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.UploadedAt = time.Now()
+	s.LastUpdatedAt = s.UploadedAt
+}
+
+func (s *RequestState) GetStatus() RequestStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.Status
+}
+
 func (s *RequestState) SetRequestData(data []byte) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -98,6 +123,7 @@ type RequestStateManager struct {
 	totalRequests     int64
 	completedRequests int64
 	failedRequests    int64
+	completedLatency  time.Duration // summed from CreatedAt to completion
 	mu                sync.RWMutex
 	stopCleanup       chan struct{}
 }
@@ -157,6 +183,7 @@ func (m *RequestStateManager) UpdateStatus(requestID uint32, newStatus RequestSt
 	if newStatus == StatusCompleted {
 		m.mu.Lock()
 		m.completedRequests++
+		m.completedLatency += time.Since(state.CreatedAt)
 		m.mu.Unlock()
 	} else if newStatus == StatusFailed {
 		m.mu.Lock()
@@ -231,6 +258,33 @@ func (m *RequestStateManager) GetStats() (total, active, completed, failed int64
 	return m.totalRequests, m.activeRequests, m.completedRequests, m.failedRequests
 }
 
+// CompletedLatency returns the time completed requests took from creation to
+// completion, summed over all of them.
+func (m *RequestStateManager) CompletedLatency() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.completedLatency
+}
+
+// InFlight returns the states of the requests that have not completed or failed yet.
+func (m *RequestStateManager) InFlight() []*RequestState {
+	m.mu.RLock()
+	states := make([]*RequestState, 0, len(m.states))
+	for _, state := range m.states {
+		states = append(states, state)
+	}
+	m.mu.RUnlock()
+
+	inFlight := states[:0]
+	for _, state := range states {
+		if !state.IsFinished() {
+			inFlight = append(inFlight, state)
+		}
+	}
+	return inFlight
+}
+
 func (m *RequestStateManager) GetStateCount() int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
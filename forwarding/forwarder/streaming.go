@@ -0,0 +1,419 @@
+package forwarder
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"forwarding/forwarder/connection"
+	packet "forwarding/packet_handler"
+	"forwarding/router"
+	"forwarding/tracing"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// Bodies above BufferConfig.MaxBufferSize (or of unknown length) skip the
+// merge buffers and travel as chunk frames over a dedicated smux stream per
+// hop. Every hop copies at most one chunk at a time, and the receiver of each
+// hop grants the sender credit on the reverse direction of the stream as it
+// consumes the body (packet.CreditWriter), so a slow client or origin
+// throttles the whole chain without stalling other streams of the session.
+
+func shouldStreamBody(contentLength int64, maxBufferSize int) bool {
+	return contentLength < 0 || contentLength > int64(maxBufferSize)
+}
+
+// idleStream refreshes the stream deadlines on every call so that a streamed
+// body is only cut off when it stalls, not when it is merely large.
+type idleStream struct {
+	stream  *smux.Stream
+	timeout time.Duration
+}
+
+func (s *idleStream) Read(p []byte) (int, error) {
+	s.stream.SetReadDeadline(time.Now().Add(s.timeout))
+	return s.stream.Read(p)
+}
+
+func (s *idleStream) Write(p []byte) (int, error) {
+	s.stream.SetWriteDeadline(time.Now().Add(s.timeout))
+	return s.stream.Write(p)
+}
+
+func openStreamWithRetry(targetAddr string) (*smux.Stream, error) {
+	session, err := connection.GetOrCreateClientSession(targetAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get/create SMUX client session to %s: %w", targetAddr, err)
+	}
+
+	stream, err := session.OpenStream()
+	if err == nil {
+		return stream, nil
+	}
+
+	connection.RemoveClientSession(targetAddr, session)
+	session, err = connection.GetOrCreateClientSession(targetAddr)
+	if err != nil {
+		return nil, fmt.Errorf("retry failed to get/create SMUX client session to %s: %w", targetAddr, err)
+	}
+	stream, err = session.OpenStream()
+	if err != nil {
+		return nil, fmt.Errorf("retry failed to open SMUX stream to %s: %w", targetAddr, err)
+	}
+	return stream, nil
+}
+
+// pipeStream sends header followed by everything read from src to targetAddr.
+func pipeStream(targetAddr string, header *packet.Packet, src io.Reader) (int64, error) {
+	stream, err := openStreamWithRetry(targetAddr)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	headerBytes, err := header.Pack()
+	if err != nil {
+		return 0, fmt.Errorf("failed to pack stream header: %w", err)
+	}
+
+	out := &idleStream{stream: stream, timeout: currentTimeouts().StreamIdle}
+	if _, err := out.Write(headerBytes); err != nil {
+		return 0, fmt.Errorf("failed to write stream header to %s: %w", targetAddr, err)
+	}
+
+	n, err := io.CopyBuffer(packet.NewCreditWriter(out, stream, out.timeout), src, make([]byte, packet.MaxChunkSize))
+	if err != nil {
+		return n, fmt.Errorf("failed to relay stream to %s after %d bytes: %w", targetAddr, n, err)
+	}
+	return n, nil
+}
+
+// readPacketStart reads at least enough of a packet to tell its type, and
+// whatever else the stream has ready, into buf.
+func readPacketStart(r io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(r, buf[:packet.TypePeekLen])
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return n, err
+	}
+	m, err := r.Read(buf[n:])
+	if err == io.EOF {
+		err = nil // the whole packet was in the first read
+	}
+	return n + m, err
+}
+
+func hostPort(addr, defaultPort string) (string, string) {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		return host, port
+	}
+	// A bare address; IPv6 literals may still carry brackets
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), defaultPort
+}
+
+func (r *Repository) sendStreamingRequest(reqState *RequestState) {
+	reqState.mu.RLock()
+	requestID := reqState.RequestID
+	originalReq := reqState.OriginalRequest
+	nextHopIP := reqState.NextHopIP
+	hopList := reqState.HopList
+	priority := reqState.Priority
+	reqState.mu.RUnlock()
+
+	ip, port := hostPort(nextHopIP, r.accessConfig.RelayPort)
+	targetAddr := net.JoinHostPort(ip, port)
+	log.Printf("[Access-INFO] Request ID %d: Streaming request body (Content-Length: %d) to %s.", requestID, originalReq.ContentLength, targetAddr)
+
+	stream, err := openStreamWithRetry(targetAddr)
+	if err != nil {
+		log.Printf("[Access-ERROR] Request ID %d: %v", requestID, err)
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		r.notifyRequestFailed(reqState, err)
+		return
+	}
+	defer stream.Close()
+
+	header := packet.NewMergedPacket([]uint32{requestID}, []int{0}, hopList, packet.PacketTypeStream)
+	header.Priority = priority
+	headerBytes, err := header.Pack()
+	if err != nil {
+		log.Printf("[Access-ERROR] Request ID %d: Failed to pack stream header: %v", requestID, err)
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		r.notifyRequestFailed(reqState, fmt.Errorf("failed to pack stream header: %w", err))
+		return
+	}
+
+	out := &idleStream{stream: stream, timeout: currentTimeouts().StreamIdle}
+	if _, err := out.Write(headerBytes); err != nil {
+		log.Printf("[Access-ERROR] Request ID %d: Failed to write stream header to %s: %v", requestID, targetAddr, err)
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		r.notifyRequestFailed(reqState, fmt.Errorf("failed to write stream header: %w", err))
+		return
+	}
+
+	progress := &uploadProgress{w: packet.NewCreditWriter(out, stream, out.timeout), state: reqState}
+	chunkWriter := packet.NewChunkWriter(progress)
+	if err := originalReq.Write(chunkWriter); err != nil {
+		log.Printf("[Access-ERROR] Request ID %d: Failed to stream request to %s: %v", requestID, targetAddr, err)
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		r.notifyRequestFailed(reqState, fmt.Errorf("failed to stream request: %w", err))
+		return
+	}
+	if err := chunkWriter.Close(); err != nil {
+		log.Printf("[Access-ERROR] Request ID %d: Failed to terminate request stream to %s: %v", requestID, targetAddr, err)
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		r.notifyRequestFailed(reqState, fmt.Errorf("failed to terminate request stream: %w", err))
+		return
+	}
+
+	reqState.RecordUpload()
+	r.stateManager.UpdateStatus(requestID, StatusSent)
+	log.Printf("[Access-INFO] Request ID %d: Request stream to %s completed.", requestID, targetAddr)
+}
+
+// uploadProgress notes on the request state that body bytes are flowing, so
+// the attempt timeout only counts from the end of a long upload.
+type uploadProgress struct {
+	w     io.Writer
+	state *RequestState
+}
+
+func (u *uploadProgress) Write(p []byte) (int, error) {
+	n, err := u.w.Write(p)
+	if n > 0 {
+		u.state.RecordUpload()
+	}
+	return n, err
+}
+
+func (r *Repository) handleStreamingResponse(stream *smux.Stream, initial []byte) {
+	src := &idleStream{stream: stream, timeout: currentTimeouts().StreamIdle}
+	header, rest, err := packet.ReadHeader(src, initial)
+	if err != nil {
+		log.Printf("[Access-ERROR] Failed to read streamed response header: %v", err)
+		return
+	}
+	if header.PacketCount != 1 {
+		log.Printf("[Access-ERROR] Streamed response carries %d packets, expected 1. Request IDs: %v", header.PacketCount, header.PacketID)
+		return
+	}
+
+	requestID := header.PacketID[0]
+	reqState, exists := r.stateManager.GetState(requestID)
+	if !exists {
+		log.Printf("[Access-WARN] No state found for streamed response of Request ID %d. Response might be late or ID is invalid.", requestID)
+		return
+	}
+
+	r.stateManager.UpdateStatus(requestID, StatusResponding)
+
+	body := packet.NewChunkReader(packet.NewCreditReader(io.MultiReader(bytes.NewReader(rest), src), src))
+	httpResp, err := http.ReadResponse(bufio.NewReader(body), reqState.OriginalRequest)
+	if err != nil {
+		log.Printf("[Access-ERROR] Request ID %d: Failed to read streamed HTTP response: %v", requestID, err)
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		r.notifyRequestFailed(reqState, fmt.Errorf("failed to read streamed response: %w", err))
+		return
+	}
+
+	reqState.mu.RLock()
+	responseWriter := reqState.ResponseWriter
+	responseChan := reqState.ResponseReceived
+	reqState.mu.RUnlock()
+
+	if responseWriter == nil {
+		log.Printf("[Access-WARN] Request ID %d: ResponseWriter is nil, cannot stream response to client.", requestID)
+		httpResp.Body.Close()
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		close(responseChan)
+		return
+	}
+
+	if err := r.sendResponseToClient(responseWriter, httpResp); err != nil {
+		log.Printf("[Access-ERROR] Request ID %d: Failed to stream response to client: %v", requestID, err)
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+	} else {
+		r.stateManager.UpdateStatus(requestID, StatusCompleted)
+		log.Printf("[Access-INFO] Request ID %d: Streamed response delivered to client.", requestID)
+	}
+	close(responseChan)
+}
+
+func (r *RelayRepository) relayRequestStream(stream *smux.Stream, initial []byte, remoteAddr string) {
+	src := &idleStream{stream: stream, timeout: currentTimeouts().StreamIdle}
+	header, rest, err := packet.ReadHeader(src, initial)
+	if err != nil {
+		log.Printf("[Relay-ERROR] Failed to read request stream header from %s: %v", remoteAddr, err)
+		return
+	}
+	if err := router.ValidateHopList(header.HopList); err != nil {
+		log.Printf("[Relay-ERROR] Refusing request stream %v from %s: %v", header.PacketID, remoteAddr, err)
+		return
+	}
+	if header.PacketCount != 1 {
+		log.Printf("[Relay-ERROR] Request stream from %s carries %d packets, expected 1. Request IDs: %v", remoteAddr, header.PacketCount, header.PacketID)
+		return
+	}
+	body := packet.NewCreditReader(io.MultiReader(bytes.NewReader(rest), src), src)
+
+	header.IncrementHopCounts()
+	nextHopIP, isLastHop, err := header.GetNextHopIP()
+	if err != nil || nextHopIP == "" {
+		log.Printf("[Relay-ERROR] Failed to determine next hop for request stream %v from %s: %v", header.PacketID, remoteAddr, err)
+		return
+	}
+
+	if isLastHop {
+		r.handleStreamingDirectRequest(header, nextHopIP, body)
+		return
+	}
+
+	ip, port := hostPort(nextHopIP, r.relayConfig.RelayPort)
+	targetAddr := net.JoinHostPort(ip, port)
+	n, err := pipeStream(targetAddr, header, body)
+	if err != nil {
+		log.Printf("[Relay-ERROR] Request stream %v from %s: %v", header.PacketID, remoteAddr, err)
+		return
+	}
+	log.Printf("[Relay-INFO] Request stream %v relayed from %s to %s, %d bytes.", header.PacketID, remoteAddr, targetAddr, n)
+}
+
+func (r *RelayRepository) handleStreamingDirectRequest(header *packet.Packet, nextHopIP string, body io.Reader) {
+	requestID := header.PacketID[0]
+	reqState := &RequestState{
+		RequestID:        requestID,
+		Status:           StatusCreated,
+		CreatedAt:        time.Now(),
+		LastUpdatedAt:    time.Now(),
+		NextHopIP:        nextHopIP,
+		HopList:          header.HopList,
+		IsLastHop:        true,
+		ResponseReceived: make(chan struct{}),
+		UpdatedHeader:    header,
+	}
+	r.stateManager.AddState(reqState)
+	r.stateManager.UpdateStatus(requestID, StatusSent)
+
+	httpReq, err := http.ReadRequest(bufio.NewReader(packet.NewChunkReader(body)))
+	if err != nil {
+		log.Printf("[Relay-ERROR] Request ID %d: Failed to parse streamed HTTP request: %v", requestID, err)
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		return
+	}
+
+	targetURLStr, client, err := r.prepareOriginRequest(httpReq, nextHopIP)
+	if err != nil {
+		log.Printf("[Relay-ERROR] Request ID %d: %v", requestID, err)
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		return
+	}
+
+	originSpan := startOriginSpan(tracing.Extract(httpReq.Header), requestID, httpReq, targetURLStr)
+	defer originSpan.End()
+
+	httpResp, err := client.Do(httpReq)
+	recordOriginResult(httpReq.URL.Host, httpResp, err)
+	endOriginCall(originSpan, httpResp, err)
+	if err != nil {
+		log.Printf("[Relay-ERROR] Request ID %d: HTTP client failed to execute streamed request to %s: %v", requestID, targetURLStr, err)
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		return
+	}
+	defer httpResp.Body.Close()
+
+	r.stateManager.UpdateStatus(requestID, StatusResponding)
+
+	if err := r.streamResponseToPreviousHop(requestID, header.HopList, httpReq, httpResp); err != nil {
+		log.Printf("[Relay-ERROR] Request ID %d: %v", requestID, err)
+		r.stateManager.UpdateStatus(requestID, StatusFailed)
+		return
+	}
+	r.stateManager.UpdateStatus(requestID, StatusCompleted)
+}
+
+// streamResponseToPreviousHop writes the origin response as chunk frames on a
+// new stream towards the access node. The header HopCounts is the index of
+// the receiving node in HopList, the same convention used for requests.
+func (r *RelayRepository) streamResponseToPreviousHop(requestID uint32, hopList []netip.Addr, httpReq *http.Request, httpResp *http.Response) error {
+	if len(hopList) < 2 {
+		return fmt.Errorf("hop list too short to stream response back: %v", hopList)
+	}
+
+	header := packet.NewMergedPacket([]uint32{requestID}, []int{0}, hopList, packet.PacketTypeStream)
+	header.HopCounts = byte(len(hopList) - 2) // this node, the one in front of the origin
+
+	clonedResp := &http.Response{
+		Status:        httpResp.Status,
+		StatusCode:    httpResp.StatusCode,
+		Proto:         httpReq.Proto,
+		ProtoMajor:    httpReq.ProtoMajor,
+		ProtoMinor:    httpReq.ProtoMinor,
+		Header:        httpResp.Header.Clone(),
+		Body:          httpResp.Body,
+		ContentLength: httpResp.ContentLength,
+		Request:       httpReq,
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		chunkWriter := packet.NewChunkWriter(pw)
+		err := clonedResp.Write(chunkWriter)
+		if err == nil {
+			err = chunkWriter.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	n, err := r.forwardResponseStream(header, pr)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	log.Printf("[Relay-INFO] Request ID %d: Streamed response (status %d) towards access, %d bytes.", requestID, httpResp.StatusCode, n)
+	return nil
+}
+
+// forwardResponseStream moves a streamed response one hop closer to the access node.
+func (r *RelayRepository) forwardResponseStream(header *packet.Packet, src io.Reader) (int64, error) {
+	if header.HopCounts == 0 || int(header.HopCounts) >= len(header.HopList) {
+		return 0, fmt.Errorf("invalid HopCounts %d for response stream (HopList=%d)", header.HopCounts, len(header.HopList))
+	}
+
+	header.DecrementHopCounts()
+	previousHopIP := header.HopList[header.HopCounts].String()
+	port := r.relayConfig.RelayResponsePort
+	if header.HopCounts == 0 {
+		port = r.relayConfig.AccessResponsePort
+	}
+
+	return pipeStream(net.JoinHostPort(previousHopIP, port), header, src)
+}
+
+func (r *RelayRepository) relayResponseStream(stream *smux.Stream, initial []byte, remoteAddr string) {
+	src := &idleStream{stream: stream, timeout: currentTimeouts().StreamIdle}
+	header, rest, err := packet.ReadHeader(src, initial)
+	if err != nil {
+		log.Printf("[Relay-ERROR] Failed to read response stream header from %s: %v", remoteAddr, err)
+		return
+	}
+	if err := router.ValidateHopList(header.HopList); err != nil {
+		log.Printf("[Relay-ERROR] Refusing response stream %v from %s: %v", header.PacketID, remoteAddr, err)
+		return
+	}
+
+	n, err := r.forwardResponseStream(header, packet.NewCreditReader(io.MultiReader(bytes.NewReader(rest), src), src))
+	if err != nil {
+		log.Printf("[Relay-ERROR] Response stream %v from %s: %v", header.PacketID, remoteAddr, err)
+		return
+	}
+	log.Printf("[Relay-INFO] Response stream %v relayed from %s, %d bytes.", header.PacketID, remoteAddr, n)
+}
@@ -0,0 +1,60 @@
+package forwarder
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// TimeoutConfig holds the timeouts of the access and relay proxies that are
+// not part of a feature's own config. SetTimeouts replaces them at run time;
+// the request state timings only apply to proxies created afterwards.
+type TimeoutConfig struct {
+	QueueSubmit     time.Duration // handing a request or response to the processing channels
+	ResponseRead    time.Duration // reading a response from a relay's SMUX stream
+	DirectProxy     time.Duration // requests the access node sends to the origin itself
+	StreamIdle      time.Duration // streamed bodies and tunnels without traffic
+	StateExpiration time.Duration // finished RequestStates are dropped after this long
+	StateCleanup    time.Duration // interval of the RequestState cleanup
+}
+
+func DefaultTimeoutConfig() TimeoutConfig {
+	return TimeoutConfig{
+		QueueSubmit:     5 * time.Second,
+		ResponseRead:    30 * time.Second,
+		DirectProxy:     30 * time.Second,
+		StreamIdle:      30 * time.Second,
+		StateExpiration: 15 * time.Minute,
+		StateCleanup:    1 * time.Minute,
+	}
+}
+
+func (c TimeoutConfig) Validate() error {
+	for name, d := range map[string]time.Duration{
+		"queue_submit":     c.QueueSubmit,
+		"response_read":    c.ResponseRead,
+		"direct_proxy":     c.DirectProxy,
+		"stream_idle":      c.StreamIdle,
+		"state_expiration": c.StateExpiration,
+		"state_cleanup":    c.StateCleanup,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("timeout %s must be positive, got %s", name, d)
+		}
+	}
+	return nil
+}
+
+var timeouts atomic.Pointer[TimeoutConfig]
+
+func init() {
+	SetTimeouts(DefaultTimeoutConfig())
+}
+
+func SetTimeouts(config TimeoutConfig) {
+	timeouts.Store(&config)
+}
+
+func currentTimeouts() TimeoutConfig {
+	return *timeouts.Load()
+}
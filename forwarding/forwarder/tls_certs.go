@@ -0,0 +1,158 @@
+package forwarder
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertStore holds the certificates used for TLS termination on the access
+// proxy. CertDir contains <name>.crt / <name>.key PEM pairs; each pair is
+// served for every DNS name its leaf certificate covers, so the file name is
+// free-form and wildcard certificates work. The directory is polled and
+// reloaded when anything in it changes.
+type CertStore struct {
+	dir         string
+	mu          sync.RWMutex
+	byName      map[string]*tls.Certificate // lower-case DNS name, may start with "*."
+	fingerprint string
+}
+
+func NewCertStore(dir string) *CertStore {
+	return &CertStore{
+		dir:    dir,
+		byName: make(map[string]*tls.Certificate),
+	}
+}
+
+// dirFingerprint summarizes names, sizes and modification times of the key pairs in dir.
+func (cs *CertStore) dirFingerprint() (string, error) {
+	entries, err := os.ReadDir(cs.dir)
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || (!strings.HasSuffix(name, ".crt") && !strings.HasSuffix(name, ".key")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", name, info.Size(), info.ModTime().UnixNano()))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ";"), nil
+}
+
+// Reload re-reads the directory if it changed since the last load. Pairs that
+// fail to load are skipped, the others replace the previous set as a whole.
+func (cs *CertStore) Reload() error {
+	fingerprint, err := cs.dirFingerprint()
+	if err != nil {
+		return fmt.Errorf("failed to read certificate directory %s: %w", cs.dir, err)
+	}
+	cs.mu.RLock()
+	unchanged := fingerprint == cs.fingerprint
+	cs.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	certFiles, err := filepath.Glob(filepath.Join(cs.dir, "*.crt"))
+	if err != nil {
+		return fmt.Errorf("failed to list certificates in %s: %w", cs.dir, err)
+	}
+
+	byName := make(map[string]*tls.Certificate)
+	for _, certFile := range certFiles {
+		keyFile := strings.TrimSuffix(certFile, ".crt") + ".key"
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Printf("[Access-ERROR] Failed to load certificate pair %s: %v", certFile, err)
+			continue
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			log.Printf("[Access-ERROR] Failed to parse certificate %s: %v", certFile, err)
+			continue
+		}
+		cert.Leaf = leaf
+
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			byName[strings.ToLower(name)] = &cert
+		}
+		log.Printf("[Access-INFO] Loaded certificate %s for %v (expires %s)", filepath.Base(certFile), names, leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	cs.mu.Lock()
+	cs.byName = byName
+	cs.fingerprint = fingerprint
+	cs.mu.Unlock()
+	log.Printf("[Access-INFO] Certificate store %s reloaded, serving %d name(s).", cs.dir, len(byName))
+	return nil
+}
+
+// Watch reloads the store every interval until done is closed.
+func (cs *CertStore) Watch(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := cs.Reload(); err != nil {
+				log.Printf("[Access-ERROR] %v", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (cs *CertStore) lookup(serverName string) *tls.Certificate {
+	name := strings.TrimSuffix(strings.ToLower(serverName), ".")
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	if cert, ok := cs.byName[name]; ok {
+		return cert
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := cs.byName["*"+name[i:]]; ok {
+			return cert
+		}
+	}
+	return nil
+}
+
+// GetCertificate selects the certificate by SNI, for tls.Config.GetCertificate.
+func (cs *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName == "" {
+		return nil, fmt.Errorf("client did not send SNI")
+	}
+	cert := cs.lookup(hello.ServerName)
+	if cert == nil {
+		return nil, fmt.Errorf("no certificate for %s", hello.ServerName)
+	}
+	return cert, nil
+}
+
+// Covers reports whether the certificate selected for serverName is also valid
+// for host, i.e. whether a request for host may arrive on that connection.
+func (cs *CertStore) Covers(serverName, host string) bool {
+	cert := cs.lookup(serverName)
+	return cert != nil && cert.Leaf != nil && cert.Leaf.VerifyHostname(host) == nil
+}
@@ -0,0 +1,111 @@
+package forwarder
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T, dir, name string, dnsNames ...string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedName(t *testing.T, cs *CertStore, serverName string) string {
+	t.Helper()
+	cert, err := cs.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		return ""
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertStoreSNISelection(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "shop", "shop.example.com")
+	writeTestCert(t, dir, "wildcard", "*.example.org", "example.org")
+
+	cs := NewCertStore(dir)
+	if err := cs.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	if got := servedName(t, cs, "Shop.Example.com"); got != "shop.example.com" {
+		t.Errorf("exact match served %q", got)
+	}
+	if got := servedName(t, cs, "api.example.org"); got != "*.example.org" {
+		t.Errorf("wildcard match served %q", got)
+	}
+	if got := servedName(t, cs, "unknown.example.net"); got != "" {
+		t.Errorf("unknown name served %q", got)
+	}
+	if !cs.Covers("api.example.org", "www.example.org") {
+		t.Errorf("wildcard certificate should cover a sibling host")
+	}
+	if cs.Covers("shop.example.com", "www.example.org") {
+		t.Errorf("certificate must not cover an unrelated host")
+	}
+}
+
+func TestCertStoreHotReload(t *testing.T) {
+	dir := t.TempDir()
+	cs := NewCertStore(dir)
+	if err := cs.Reload(); err != nil {
+		t.Fatalf("reload of empty dir: %v", err)
+	}
+	if got := servedName(t, cs, "new.example.com"); got != "" {
+		t.Fatalf("unexpected certificate %q before it was written", got)
+	}
+
+	writeTestCert(t, dir, "new", "new.example.com")
+	if err := cs.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := servedName(t, cs, "new.example.com"); got != "new.example.com" {
+		t.Errorf("expected the new certificate after reload, got %q", got)
+	}
+
+	os.Remove(filepath.Join(dir, "new.crt"))
+	os.Remove(filepath.Join(dir, "new.key"))
+	if err := cs.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := servedName(t, cs, "new.example.com"); got != "" {
+		t.Errorf("expected the removed certificate to be dropped, got %q", got)
+	}
+}
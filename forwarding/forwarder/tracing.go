@@ -0,0 +1,125 @@
+package forwarder
+
+import (
+	"forwarding/tracing"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Spans of a request, in the order they occur:
+//
+//	access.request    the client request on the access node, all attempts included
+//	access.attempt    one attempt over one path; its context is sent on in Traceparent
+//	access.queue      waiting for a request worker
+//	buffer.merge_wait waiting in a merge buffer, for requests and responses
+//	hop.send          writing a packet to the next node
+//	relay.hop         a relay from receiving a request to passing it on
+//	origin.request    the request to the origin, from the last hop
+//	relay.response    a relay from receiving a response to passing it back
+//	access.response   writing the response to the client
+//
+// Relays forward payloads unchanged, so the relay.hop spans of all relays are
+// children of the access.attempt span and ordered by arcturus.hop_index.
+
+const (
+	attrRequestID = "arcturus.request_id"
+	attrHopList   = "arcturus.hop_list"
+	attrHopIndex  = "arcturus.hop_index"
+)
+
+func hopListAttr(hops []netip.Addr) string {
+	parts := make([]string, len(hops))
+	for i, hop := range hops {
+		parts[i] = hop.String()
+	}
+	return strings.Join(parts, ">")
+}
+
+// tracedRequest returns a shallow copy of req that carries c in its
+// Traceparent header, or req itself when c is invalid.
+func tracedRequest(req *http.Request, c tracing.TraceContext) *http.Request {
+	if !c.IsValid() {
+		return req
+	}
+	traced := req.WithContext(req.Context())
+	traced.Header = req.Header.Clone()
+	tracing.Inject(traced.Header, c)
+	return traced
+}
+
+// startRequestSpan starts a span of state's trace, tagged with its request ID
+// and path.
+func startRequestSpan(state *RequestState, name string, kind tracing.SpanKind, start time.Time) *tracing.Span {
+	span := tracing.StartAt(state.Trace, name, kind, start)
+	span.SetAttribute(attrRequestID, state.RequestID)
+	span.SetAttribute(attrHopList, hopListAttr(state.HopList))
+	return span
+}
+
+// startHopSpan starts the relay.hop span of a request received by a relay and
+// makes it the parent of the request's further spans on this node.
+func startHopSpan(state *RequestState, hopIndex byte, remoteAddr string, receivedAt time.Time) *tracing.Span {
+	span := startRequestSpan(state, "relay.hop", tracing.KindServer, receivedAt)
+	span.SetAttribute(attrHopIndex, int(hopIndex))
+	span.SetAttribute("client.address", remoteAddr)
+	if span != nil {
+		state.Trace = span.Context()
+	}
+	return span
+}
+
+// startOriginSpan starts the origin.request span of a request about to be sent
+// to targetURL, and passes its context on to the origin.
+func startOriginSpan(parent tracing.TraceContext, requestID uint32, req *http.Request, targetURL string) *tracing.Span {
+	span := tracing.Start(parent, "origin.request", tracing.KindClient)
+	span.SetAttribute(attrRequestID, requestID)
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("url.full", targetURL)
+	tracing.Inject(req.Header, span.Context())
+	return span
+}
+
+func endOriginCall(span *tracing.Span, resp *http.Response, err error) {
+	if err != nil {
+		span.SetError(err)
+		return
+	}
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+}
+
+// requestSpans are the spans of the requests of one packet.
+type requestSpans []*tracing.Span
+
+func startRequestSpans(states []*RequestState, name string, kind tracing.SpanKind, start time.Time) requestSpans {
+	spans := make(requestSpans, len(states))
+	for i, state := range states {
+		spans[i] = startRequestSpan(state, name, kind, start)
+	}
+	return spans
+}
+
+// startPacketSpans starts spans for the requests of a packet that have a state in sm.
+func startPacketSpans(sm *RequestStateManager, ids []uint32, name string, kind tracing.SpanKind, start time.Time) requestSpans {
+	states := make([]*RequestState, 0, len(ids))
+	for _, id := range ids {
+		if state, ok := sm.GetState(id); ok {
+			states = append(states, state)
+		}
+	}
+	return startRequestSpans(states, name, kind, start)
+}
+
+func (s requestSpans) setAttribute(key string, value any) {
+	for _, span := range s {
+		span.SetAttribute(key, value)
+	}
+}
+
+func (s requestSpans) end(err error) {
+	for _, span := range s {
+		span.SetError(err)
+		span.End()
+	}
+}
@@ -0,0 +1,119 @@
+package forwarder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	packet "forwarding/packet_handler"
+	"forwarding/tracing"
+	"net/http/httptest"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTracedRequestCarriesContext(t *testing.T) {
+	parent, _ := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req := httptest.NewRequest("GET", "http://example.com/a", nil)
+	req.Header.Set("Traceparent", "00-11111111111111111111111111111111-2222222222222222-01")
+
+	traced := tracedRequest(req, parent)
+	if req.Header.Get("Traceparent") != "00-11111111111111111111111111111111-2222222222222222-01" {
+		t.Error("the client's request was changed")
+	}
+	dump, err := httputil.DumpRequest(traced, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tracing.ExtractFromRequest(dump); got != parent {
+		t.Errorf("relay reads %s, want %s", got.Traceparent(), parent.Traceparent())
+	}
+	if tracedRequest(req, tracing.TraceContext{}) != req {
+		t.Error("request copied without a trace context")
+	}
+}
+
+func TestMergedRequestSpans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	config := tracing.DefaultConfig()
+	config.Enabled = true
+	config.Exporter = "file"
+	config.FilePath = path
+	if err := tracing.Setup(config); err != nil {
+		t.Fatal(err)
+	}
+	defer tracing.Shutdown(context.Background())
+
+	bufferConfig := DefaultBufferConfig()
+	bufferConfig.MaxRequestsPerBuffer = 2
+	stateManager := NewRequestStateManager(time.Minute, time.Minute)
+	defer stateManager.Stop()
+	bm := NewBufferManager(bufferConfig, stateManager)
+	defer bm.Stop()
+	sent := make(chan struct{}, 1)
+	bm.SetSendFunctions(nil, func(data []byte, nextHop string, header *packet.Packet) error {
+		sent <- struct{}{}
+		return nil
+	}, nil)
+
+	attempt := tracing.Start(tracing.TraceContext{}, "access.attempt", tracing.KindClient)
+	hops, _ := packet.ParseHopList([]string{"10.0.0.1", "10.0.0.2", "192.0.2.10"})
+	for id := uint32(1); id <= 2; id++ {
+		state := &RequestState{RequestID: id, RequestData: []byte("GET / HTTP/1.1\r\n\r\n"), Size: 18,
+			NextHopIP: "10.0.0.2", HopList: hops, Trace: attempt.Context()}
+		stateManager.AddState(state)
+		if err := bm.ProcessRequest(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-sent
+	time.Sleep(50 * time.Millisecond) // the send spans end after the send function returns
+	if err := tracing.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name         string `json:"name"`
+						TraceID      string `json:"traceId"`
+						ParentSpanID string `json:"parentSpanId"`
+						Attributes   []struct {
+							Key string `json:"key"`
+						} `json:"attributes"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Fatal(err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					if parent := attempt.Context().Traceparent()[36:52]; span.ParentSpanID != parent {
+						t.Errorf("%s span has parent %s, want the attempt %s", span.Name, span.ParentSpanID, parent)
+					}
+					if len(span.Attributes) < 2 || span.Attributes[0].Key != attrRequestID || span.Attributes[1].Key != attrHopList {
+						t.Errorf("%s span attributes %+v", span.Name, span.Attributes)
+					}
+					counts[span.Name]++
+				}
+			}
+		}
+	}
+	if counts["buffer.merge_wait"] != 2 || counts["hop.send"] != 2 {
+		t.Errorf("expected a merge_wait and a hop.send span per request, got %v", counts)
+	}
+}
@@ -0,0 +1,374 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forwarding/forwarder/connection"
+	"forwarding/scheduling_algorithms/linucb"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// The tuner picks the merge window, requests per merge, buffers per path and
+// SMUX sessions per next hop with a LinUCB contextual bandit. Every Interval
+// it reads the request rate, completions, failures, in-flight requests and
+// mean latency from the request state managers, plus the CPU utilization.
+// The arm used during the interval is rewarded with ThroughputWeight times
+// the completions per second and the rest times the inverse latency, both
+// scaled to the lowest and highest values seen, less the share of failed
+// requests. Then the arm for the current context is applied through
+// BufferManager.ConfigUpdateChan. MaxWaitTime only moves classes without a
+// ClassMaxWaitTime of their own. The model is saved to ModelFile after every
+// update and picked up again on start, unless the arms have changed.
+
+type TunerConfig struct {
+	Enabled              bool
+	Interval             time.Duration
+	Alpha                float64 // exploration weight of the confidence bound
+	ThroughputWeight     float64 // share of the reward given to throughput, the rest goes to latency
+	ModelFile            string
+	MaxWaitTimes         []time.Duration
+	MaxRequestsPerBuffer []int
+	BuffersPerPath       []int
+	SessionsPerPeer      []int
+}
+
+func DefaultTunerConfig() TunerConfig {
+	return TunerConfig{
+		Interval:         time.Minute,
+		Alpha:            0.5,
+		ThroughputWeight: 0.5,
+		ModelFile:        "../../agent_storage/tuner_model.json",
+		// 16 arms; the bandit learns an arm only by running it for an Interval,
+		// so every added value multiplies the time spent exploring
+		MaxWaitTimes:         []time.Duration{2 * time.Millisecond, 10 * time.Millisecond},
+		MaxRequestsPerBuffer: []int{4, 16},
+		BuffersPerPath:       []int{1, 2},
+		SessionsPerPeer:      []int{1, 2},
+	}
+}
+
+func (c TunerConfig) Validate() error {
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	if c.Alpha < 0 {
+		return fmt.Errorf("alpha must not be negative")
+	}
+	if c.ThroughputWeight < 0 || c.ThroughputWeight > 1 {
+		return fmt.Errorf("throughput_weight must be in [0, 1], got %v", c.ThroughputWeight)
+	}
+	if c.ModelFile == "" {
+		return fmt.Errorf("model_file must not be empty")
+	}
+	if len(c.MaxWaitTimes) == 0 {
+		return fmt.Errorf("max_wait_times must not be empty")
+	}
+	for _, wait := range c.MaxWaitTimes {
+		if wait < 0 {
+			return fmt.Errorf("max_wait_times must not be negative, got %s", wait)
+		}
+	}
+	for name, values := range map[string][]int{
+		"max_requests_per_buffer": c.MaxRequestsPerBuffer,
+		"buffers_per_path":        c.BuffersPerPath,
+		"sessions_per_peer":       c.SessionsPerPeer,
+	} {
+		if len(values) == 0 {
+			return fmt.Errorf("%s must not be empty", name)
+		}
+		for _, v := range values {
+			if v <= 0 {
+				return fmt.Errorf("%s must be positive, got %d", name, v)
+			}
+		}
+	}
+	return nil
+}
+
+// TunerArm is one combination of the tuned settings.
+type TunerArm struct {
+	MaxWaitTime          time.Duration `json:"max_wait_time"`
+	MaxRequestsPerBuffer int           `json:"max_requests_per_buffer"`
+	BuffersPerPath       int           `json:"buffers_per_path"`
+	SessionsPerPeer      int           `json:"sessions_per_peer"`
+}
+
+func (c TunerConfig) arms() []TunerArm {
+	var arms []TunerArm
+	for _, wait := range c.MaxWaitTimes {
+		for _, requests := range c.MaxRequestsPerBuffer {
+			for _, buffers := range c.BuffersPerPath {
+				for _, sessions := range c.SessionsPerPeer {
+					arms = append(arms, TunerArm{wait, requests, buffers, sessions})
+				}
+			}
+		}
+	}
+	return arms
+}
+
+const tunerFeatures = 5
+
+// tunerSample holds the counters of all request state managers at one time.
+type tunerSample struct {
+	at        time.Time
+	total     int64
+	completed int64
+	failed    int64
+	latency   time.Duration // summed over the completed requests
+	inFlight  int
+	cpu       float64 // 0..1
+}
+
+// tunerObservation describes the interval between two samples.
+type tunerObservation struct {
+	rps        float64 // requests started per second
+	throughput float64 // requests completed per second
+	latencyMs  float64 // mean latency of the completed requests
+	failures   float64 // failed requests as a share of those finished
+	inFlight   float64
+	cpu        float64
+}
+
+func observeInterval(prev, cur tunerSample) tunerObservation {
+	seconds := cur.at.Sub(prev.at).Seconds()
+	if seconds <= 0 {
+		return tunerObservation{}
+	}
+	completed := float64(cur.completed - prev.completed)
+	failed := float64(cur.failed - prev.failed)
+	o := tunerObservation{
+		rps:        float64(cur.total-prev.total) / seconds,
+		throughput: completed / seconds,
+		inFlight:   float64(cur.inFlight),
+		cpu:        cur.cpu,
+	}
+	if completed > 0 {
+		o.latencyMs = float64(cur.latency-prev.latency) / float64(time.Millisecond) / completed
+	}
+	if completed+failed > 0 {
+		o.failures = failed / (completed + failed)
+	}
+	return o
+}
+
+// features returns the context of the bandit: a bias term, CPU utilization
+// and the logarithms of request rate, in-flight requests and latency.
+func (o tunerObservation) features() []float64 {
+	return []float64{1, o.cpu, math.Log1p(o.rps) / 10, math.Log1p(o.inFlight) / 10, math.Log1p(o.latencyMs) / 10}
+}
+
+// tunerModel is what the tuner saves to ModelFile.
+type tunerModel struct {
+	Arms       []TunerArm    `json:"arms"`
+	Bandit     *linucb.Model `json:"bandit"`
+	Throughput valueRange    `json:"throughput"`
+	LatencyMs  valueRange    `json:"latency_ms"`
+}
+
+type valueRange struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Seen bool    `json:"seen"`
+}
+
+func (r *valueRange) add(v float64) {
+	if !r.Seen || v < r.Min {
+		r.Min = v
+	}
+	if !r.Seen || v > r.Max {
+		r.Max = v
+	}
+	r.Seen = true
+}
+
+// scale maps v to 0..1 between the lowest and highest value seen.
+func (r valueRange) scale(v float64) float64 {
+	if r.Max <= r.Min {
+		return 0.5
+	}
+	return math.Min(math.Max((v-r.Min)/(r.Max-r.Min), 0), 1)
+}
+
+type Tuner struct {
+	config TunerConfig
+	model  tunerModel
+
+	lastSample   tunerSample
+	lastArm      int // -1 until the first arm is applied
+	lastFeatures []float64
+
+	sample func() tunerSample
+	apply  func(TunerArm) error
+}
+
+// NewTuner creates a tuner with the model saved in config.ModelFile, or a
+// fresh one if there is none or it was made for other arms.
+func NewTuner(config TunerConfig) *Tuner {
+	t := &Tuner{config: config, lastArm: -1, sample: sampleRequestStates, apply: applyTunerArm}
+	arms := config.arms()
+	model, err := loadTunerModel(config.ModelFile, arms)
+	switch {
+	case err == nil:
+		log.Printf("[Tuner-INFO] Loaded model with %d arms from %s", len(arms), config.ModelFile)
+		t.model = *model
+	case errors.Is(err, os.ErrNotExist):
+		t.model = tunerModel{Arms: arms, Bandit: linucb.New(len(arms), tunerFeatures, config.Alpha)}
+	default:
+		log.Printf("[Tuner-WARN] Starting with a fresh model: %v", err)
+		t.model = tunerModel{Arms: arms, Bandit: linucb.New(len(arms), tunerFeatures, config.Alpha)}
+	}
+	t.model.Bandit.Alpha = config.Alpha
+	return t
+}
+
+// Run tunes every Interval until ctx is done.
+func (t *Tuner) Run(ctx context.Context) {
+	log.Printf("[Tuner-INFO] Tuning %d arms every %s", len(t.model.Arms), t.config.Interval)
+	t.lastSample = t.sample()
+	ticker := time.NewTicker(t.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.step(t.sample())
+		}
+	}
+}
+
+// step rewards the arm of the interval that ended with sample and applies
+// the arm for the context observed in it.
+func (t *Tuner) step(sample tunerSample) {
+	obs := observeInterval(t.lastSample, sample)
+	t.lastSample = sample
+	if obs.throughput == 0 && obs.failures == 0 {
+		return // nothing was finished, so there is nothing to learn
+	}
+
+	t.model.Throughput.add(obs.throughput)
+	if obs.latencyMs > 0 {
+		t.model.LatencyMs.add(obs.latencyMs)
+	}
+	if t.lastArm >= 0 {
+		reward := t.reward(obs)
+		t.model.Bandit.Update(t.lastArm, t.lastFeatures, reward)
+		log.Printf("[Tuner-DEBUG] Arm %+v: %.0f req/s, %.1f ms, %.1f%% failed, reward %.3f",
+			t.model.Arms[t.lastArm], obs.throughput, obs.latencyMs, obs.failures*100, reward)
+		if err := saveTunerModel(t.config.ModelFile, &t.model); err != nil {
+			log.Printf("[Tuner-WARN] Failed to save model: %v", err)
+		}
+	}
+
+	features := obs.features()
+	arm := t.model.Bandit.Select(features)
+	if arm != t.lastArm {
+		if err := t.apply(t.model.Arms[arm]); err != nil {
+			log.Printf("[Tuner-ERROR] Failed to apply %+v: %v", t.model.Arms[arm], err)
+			return
+		}
+		log.Printf("[Tuner-INFO] Applied %+v", t.model.Arms[arm])
+	}
+	t.lastArm, t.lastFeatures = arm, features
+}
+
+func (t *Tuner) reward(obs tunerObservation) float64 {
+	latency := 0.5
+	if obs.latencyMs > 0 {
+		latency = t.model.LatencyMs.scale(obs.latencyMs)
+	}
+	w := t.config.ThroughputWeight
+	return w*t.model.Throughput.scale(obs.throughput) + (1-w)*(1-latency) - obs.failures
+}
+
+func sampleRequestStates() tunerSample {
+	sample := tunerSample{at: time.Now()}
+	eachStateManager(func(role string, sm *RequestStateManager) {
+		total, _, completed, failed := sm.GetStats()
+		sample.total += total
+		sample.completed += completed
+		sample.failed += failed
+		sample.latency += sm.CompletedLatency()
+		sample.inFlight += len(sm.InFlight())
+	})
+	if usage, err := cpu.Percent(0, false); err == nil && len(usage) > 0 {
+		sample.cpu = usage[0] / 100
+	}
+	return sample
+}
+
+// applyTunerArm hands the arm's buffer settings to the access and relay
+// buffer managers and sets the SMUX sessions per next hop.
+func applyTunerArm(arm TunerArm) error {
+	statsSources.Lock()
+	managers := make(map[string]*BufferManager, len(statsSources.buffers))
+	for role, bm := range statsSources.buffers {
+		managers[role] = bm
+	}
+	statsSources.Unlock()
+
+	var errs []error
+	for role, bm := range managers {
+		bm.ConfigLock.RLock()
+		config := bm.Config
+		bm.ConfigLock.RUnlock()
+		config.MaxWaitTime = arm.MaxWaitTime
+		config.MaxRequestsPerBuffer = arm.MaxRequestsPerBuffer
+		config.BuffersPerPath = arm.BuffersPerPath
+		if err := sendBufferConfig(role, bm, config); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	connection.SetSessionsPerPeer(arm.SessionsPerPeer)
+	return errors.Join(errs...)
+}
+
+func loadTunerModel(path string, arms []TunerArm) (*tunerModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var model tunerModel
+	if err := json.Unmarshal(data, &model); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if !reflect.DeepEqual(model.Arms, arms) {
+		return nil, fmt.Errorf("model in %s was made for other arms", path)
+	}
+	if model.Bandit == nil {
+		return nil, fmt.Errorf("model in %s has no bandit", path)
+	}
+	if err := model.Bandit.Validate(len(arms), tunerFeatures); err != nil {
+		return nil, fmt.Errorf("model in %s: %w", path, err)
+	}
+	return &model, nil
+}
+
+// saveTunerModel writes the model to a temporary file first, so a crash
+// never leaves a truncated one behind.
+func saveTunerModel(path string, model *tunerModel) error {
+	data, err := json.Marshal(model)
+	if err != nil {
+		return fmt.Errorf("failed to encode model: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create model directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write model: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace model: %w", err)
+	}
+	return nil
+}
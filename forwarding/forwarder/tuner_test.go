@@ -0,0 +1,79 @@
+package forwarder
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestObserveInterval(t *testing.T) {
+	start := time.Now()
+	prev := tunerSample{at: start, total: 100, completed: 90, failed: 5, latency: 900 * time.Millisecond}
+	cur := tunerSample{at: start.Add(10 * time.Second), total: 300, completed: 280, failed: 15, latency: 4700 * time.Millisecond, inFlight: 7, cpu: 0.4}
+	o := observeInterval(prev, cur)
+	if o.rps != 20 || o.throughput != 19 || o.latencyMs != 20 || o.failures != 0.05 || o.inFlight != 7 {
+		t.Errorf("observation %+v", o)
+	}
+	if x := o.features(); len(x) != tunerFeatures || x[0] != 1 || x[1] != 0.4 {
+		t.Errorf("features %v", x)
+	}
+}
+
+func TestDefaultTunerArms(t *testing.T) {
+	config := DefaultTunerConfig()
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	arms := config.arms()
+	if len(arms) != 16 {
+		t.Errorf("default config has %d arms, each one costs exploration time", len(arms))
+	}
+	for _, arm := range arms {
+		if arm.MaxRequestsPerBuffer < 2 {
+			t.Errorf("arm %+v does not merge requests", arm)
+		}
+	}
+}
+
+func TestTunerPrefersFasterArmAndPersists(t *testing.T) {
+	config := DefaultTunerConfig()
+	config.ModelFile = filepath.Join(t.TempDir(), "model.json")
+	config.MaxWaitTimes = []time.Duration{time.Millisecond, 20 * time.Millisecond}
+	config.MaxRequestsPerBuffer = []int{1}
+	config.BuffersPerPath = []int{1}
+	config.SessionsPerPeer = []int{1}
+	config.Alpha = 0.1
+
+	// The 1ms merge window answers in 10ms, the 20ms one in 30ms
+	tuner := NewTuner(config)
+	var applied TunerArm
+	tuner.apply = func(arm TunerArm) error {
+		applied = arm
+		return nil
+	}
+	sample := tunerSample{at: time.Now()}
+	tuner.lastSample = sample
+	for i := 0; i < 50; i++ {
+		latency := 30 * time.Millisecond
+		if tuner.lastArm >= 0 && applied.MaxWaitTime == time.Millisecond {
+			latency = 10 * time.Millisecond
+		}
+		sample.at = sample.at.Add(time.Minute)
+		sample.total += 600
+		sample.completed += 600
+		sample.latency += 600 * latency
+		tuner.step(sample)
+	}
+	if applied.MaxWaitTime != time.Millisecond {
+		t.Errorf("tuner settled on %+v", applied)
+	}
+
+	restored := NewTuner(config)
+	if pulls := restored.model.Bandit.Arms[0].Pulls + restored.model.Bandit.Arms[1].Pulls; pulls == 0 {
+		t.Errorf("model not restored from %s", config.ModelFile)
+	}
+	config.SessionsPerPeer = []int{1, 2}
+	if fresh := NewTuner(config); len(fresh.model.Arms) != 4 || fresh.model.Bandit.Arms[0].Pulls != 0 {
+		t.Errorf("model for other arms was reused")
+	}
+}
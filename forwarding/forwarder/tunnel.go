@@ -0,0 +1,338 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	packet "forwarding/packet_handler"
+	"forwarding/router"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// Tunnels carry plain TCP connections over the same relay paths as HTTP. The
+// access node opens one smux stream per client connection and writes a
+// PacketTypeTunnel header followed by the origin port; every relay splices
+// the stream to the next hop and the last relay splices it to the origin.
+// Both directions are chunk framed end to end, so a half-close on either side
+// reaches the other side as a terminator frame.
+
+type TunnelConfig struct {
+	ListenPort string // port accepting client connections on the access node
+	Domain     string // domain mapping whose paths and origin IP are used
+	OriginPort int    // port dialed on the origin by the last relay
+}
+
+const tunnelDialTimeout = 10 * time.Second
+
+const tunnelPortLen = 2
+
+func writeTunnelHeader(w io.Writer, header *packet.Packet, originPort uint16) error {
+	headerBytes, err := header.Pack()
+	if err != nil {
+		return fmt.Errorf("failed to pack tunnel header: %w", err)
+	}
+	headerBytes = binary.BigEndian.AppendUint16(headerBytes, originPort)
+	if _, err := w.Write(headerBytes); err != nil {
+		return fmt.Errorf("failed to write tunnel header: %w", err)
+	}
+	return nil
+}
+
+// readTunnelHeader returns the tunnel header, the origin port and a reader
+// for the data that follows them. Upgraded HTTP connections (upgrade.go) use
+// the same header with port 0.
+func readTunnelHeader(r io.Reader, initial []byte) (*packet.Packet, uint16, io.Reader, error) {
+	header, rest, err := packet.ReadHeader(r, initial)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if header.PacketCount != 1 {
+		return nil, 0, nil, fmt.Errorf("tunnel header carries %d packets, expected 1", header.PacketCount)
+	}
+
+	body := io.MultiReader(bytes.NewReader(rest), r)
+	var port [tunnelPortLen]byte
+	if _, err := io.ReadFull(body, port[:]); err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to read tunnel origin port: %w", err)
+	}
+	originPort := binary.BigEndian.Uint16(port[:])
+	if originPort == 0 && header.PacketType != packet.PacketTypeUpgrade { // upgrades use the domain's origin settings
+		return nil, 0, nil, fmt.Errorf("tunnel %v has no origin port", header.PacketID)
+	}
+	return header, originPort, body, nil
+}
+
+// tunnelEnd is one side of a splice. closeWrite tells the side that no more
+// data follows; nil means the end of either direction ends the whole tunnel.
+type tunnelEnd struct {
+	io.Reader
+	io.Writer
+	closeWrite func() error
+	close      func() error
+}
+
+func connEnd(conn net.Conn) tunnelEnd {
+	end := tunnelEnd{Reader: conn, Writer: conn, closeWrite: conn.Close, close: conn.Close}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		end.closeWrite = tcpConn.CloseWrite
+	}
+	return end
+}
+
+// framedStreamEnd is the stream at either end of the relay chain, where the
+// chunk framing is added and removed.
+func framedStreamEnd(stream *smux.Stream, r io.Reader) tunnelEnd {
+	chunkWriter := packet.NewChunkWriter(stream)
+	return tunnelEnd{Reader: packet.NewChunkReader(r), Writer: chunkWriter, closeWrite: chunkWriter.Close, close: stream.Close}
+}
+
+// rawStreamEnd is a stream on an intermediate relay. The frames pass through
+// untouched and the ends of the chain only close their streams once both
+// terminators went through, so the first stream to close ends the tunnel.
+func rawStreamEnd(stream *smux.Stream, r io.Reader) tunnelEnd {
+	return tunnelEnd{Reader: r, Writer: stream, close: stream.Close}
+}
+
+// splice copies a to b and b to a until both directions have finished. A
+// direction that ends cleanly is passed on with closeWrite; an error in
+// either direction tears down both ends.
+func splice(a, b tunnelEnd) (aToB, bToA int64, err error) {
+	var closeOnce sync.Once
+	teardown := func() {
+		closeOnce.Do(func() {
+			a.close()
+			b.close()
+		})
+	}
+
+	var mu sync.Mutex
+	finished := false
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src tunnelEnd, n *int64) {
+		defer wg.Done()
+		copied, copyErr := io.CopyBuffer(dst, src, make([]byte, packet.MaxChunkSize))
+		*n = copied
+		if copyErr == nil && dst.closeWrite != nil {
+			if copyErr = dst.closeWrite(); copyErr == nil {
+				return
+			}
+		}
+
+		mu.Lock()
+		if copyErr == nil {
+			finished = true // the other direction is cut short by the teardown, not by a failure
+		} else if !finished && err == nil {
+			err = copyErr
+		}
+		mu.Unlock()
+		teardown()
+	}
+
+	wg.Add(2)
+	go copyHalf(b, a, &aToB)
+	go copyHalf(a, b, &bToA)
+	wg.Wait()
+	teardown()
+	return aToB, bToA, err
+}
+
+func (r *Repository) StartTunnelListeners() {
+	for _, tunnel := range r.accessConfig.Tunnels {
+		if tunnel.OriginPort <= 0 || tunnel.OriginPort > 65535 {
+			log.Printf("[Access-ERROR] Tunnel on port %s for %s has invalid origin port %d, skipping.", tunnel.ListenPort, tunnel.Domain, tunnel.OriginPort)
+			continue
+		}
+		listener, err := Listen(":" + tunnel.ListenPort)
+		if err != nil {
+			log.Printf("[Access-ERROR] Failed to listen on tunnel port %s for %s: %v", tunnel.ListenPort, tunnel.Domain, err)
+			continue
+		}
+
+		r.tunnelMu.Lock()
+		r.tunnelListeners = append(r.tunnelListeners, listener)
+		r.tunnelMu.Unlock()
+
+		log.Printf("[Access-INFO] Tunnelling TCP port %s to %s port %d", tunnel.ListenPort, tunnel.Domain, tunnel.OriginPort)
+		go r.acceptTunnelConnections(listener, tunnel)
+	}
+}
+
+func (r *Repository) acceptTunnelConnections(listener net.Listener, tunnel TunnelConfig) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-r.done:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			log.Printf("[Access-ERROR] Tunnel listener on port %s stopped: %v", tunnel.ListenPort, err)
+			return
+		}
+		if Draining() {
+			log.Printf("[Access-WARN] Tunnel connection from %s on port %s refused, node is draining.", conn.RemoteAddr(), tunnel.ListenPort)
+			conn.Close()
+			continue
+		}
+		go r.handleTunnelConnection(conn, tunnel)
+	}
+}
+
+func (r *Repository) stopTunnelListeners() {
+	r.tunnelMu.Lock()
+	defer r.tunnelMu.Unlock()
+	for _, listener := range r.tunnelListeners {
+		listener.Close()
+	}
+	r.tunnelListeners = nil
+}
+
+// pickTunnelPath chooses a path to domain and returns the header of a stream
+// of packetType along it together with the path.
+func (r *Repository) pickTunnelPath(domain string, id uint32, packetType byte) (*packet.Packet, []string, error) {
+	pathManager := router.GetInstance()
+	paths := pathManager.GetPaths(domain)
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("no available paths for domain %s", domain)
+	}
+	path := r.pathHealth.Pick(pathManager.DeprioritizeFailed(paths))
+	if len(path.IPList) < 2 {
+		return nil, nil, fmt.Errorf("no valid path for domain %s", domain)
+	}
+
+	header, err := packet.NewPacket(path.IPList, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create header for path %v: %w", path.IPList, err)
+	}
+	header.PacketType = packetType
+	return header, path.IPList, nil
+}
+
+func (r *Repository) handleTunnelConnection(conn net.Conn, tunnel TunnelConfig) {
+	defer conn.Close()
+	tunnelID := generateUniqueRequestID(nil)
+	clientAddr := conn.RemoteAddr().String()
+
+	domain := router.NormalizeDomain(tunnel.Domain)
+	header, path, err := r.pickTunnelPath(domain, tunnelID, packet.PacketTypeTunnel)
+	if err != nil {
+		log.Printf("[Access-ERROR] Tunnel %d: %v. Closing connection from %s.", tunnelID, err, clientAddr)
+		return
+	}
+
+	nextHopIP, isLastHop, err := header.GetNextHopIP()
+	if err != nil || nextHopIP == "" {
+		log.Printf("[Access-ERROR] Tunnel %d: Failed to determine next hop for path %v: %v", tunnelID, path, err)
+		return
+	}
+	log.Printf("[Access-INFO] Tunnel %d: Connection from %s to %s port %d via %v", tunnelID, clientAddr, domain, tunnel.OriginPort, path)
+
+	var peer tunnelEnd
+	if isLastHop {
+		// No relay in between, dial the origin like the direct HTTP proxy does
+		originAddr := net.JoinHostPort(nextHopIP, strconv.Itoa(tunnel.OriginPort))
+		origin, err := net.DialTimeout("tcp", originAddr, tunnelDialTimeout)
+		if err != nil {
+			log.Printf("[Access-ERROR] Tunnel %d: Failed to dial origin %s: %v", tunnelID, originAddr, err)
+			return
+		}
+		peer = connEnd(origin)
+	} else {
+		ip, port := hostPort(nextHopIP, r.accessConfig.RelayPort)
+		targetAddr := net.JoinHostPort(ip, port)
+		stream, err := openStreamWithRetry(targetAddr)
+		if err != nil {
+			log.Printf("[Access-ERROR] Tunnel %d: %v", tunnelID, err)
+			router.GetInstance().MarkPathFailed(path, r.accessConfig.Retry.FailedPathPenalty)
+			return
+		}
+		if err := writeTunnelHeader(stream, header, uint16(tunnel.OriginPort)); err != nil {
+			log.Printf("[Access-ERROR] Tunnel %d: %v", tunnelID, err)
+			stream.Close()
+			return
+		}
+		peer = framedStreamEnd(stream, stream)
+	}
+
+	sent, received, err := splice(connEnd(conn), peer)
+	if err != nil {
+		log.Printf("[Access-WARN] Tunnel %d: Closed with error after %d bytes sent, %d received: %v", tunnelID, sent, received, err)
+		return
+	}
+	log.Printf("[Access-INFO] Tunnel %d: Closed, %d bytes sent, %d received.", tunnelID, sent, received)
+}
+
+func (r *RelayRepository) relayTunnel(stream *smux.Stream, initial []byte, remoteAddr string) {
+	header, originPort, upstream, err := readTunnelHeader(stream, initial)
+	if err != nil {
+		log.Printf("[Relay-ERROR] Failed to read tunnel header from %s: %v", remoteAddr, err)
+		return
+	}
+	if err := router.ValidateHopList(header.HopList); err != nil {
+		log.Printf("[Relay-ERROR] Refusing tunnel %v from %s: %v", header.PacketID, remoteAddr, err)
+		return
+	}
+	tunnelID := header.PacketID[0]
+
+	header.IncrementHopCounts()
+	nextHopIP, isLastHop, err := header.GetNextHopIP()
+	if err != nil || nextHopIP == "" {
+		log.Printf("[Relay-ERROR] Tunnel %d: Failed to determine next hop: %v", tunnelID, err)
+		return
+	}
+	if isLastHop && header.PacketType == packet.PacketTypeUpgrade {
+		r.upgradeAtOrigin(stream, upstream, tunnelID, nextHopIP)
+		return
+	}
+
+	var peer tunnelEnd
+	var targetAddr string
+	if isLastHop {
+		targetAddr = net.JoinHostPort(nextHopIP, strconv.Itoa(int(originPort)))
+		origin, err := net.DialTimeout("tcp", targetAddr, tunnelDialTimeout)
+		if err != nil {
+			log.Printf("[Relay-ERROR] Tunnel %d: Failed to dial origin %s: %v", tunnelID, targetAddr, err)
+			return
+		}
+		peer = connEnd(origin)
+	} else {
+		ip, port := hostPort(nextHopIP, r.relayConfig.RelayPort)
+		targetAddr = net.JoinHostPort(ip, port)
+		next, err := openStreamWithRetry(targetAddr)
+		if err != nil {
+			log.Printf("[Relay-ERROR] Tunnel %d: %v", tunnelID, err)
+			return
+		}
+		if err := writeTunnelHeader(next, header, originPort); err != nil {
+			log.Printf("[Relay-ERROR] Tunnel %d: %v", tunnelID, err)
+			next.Close()
+			return
+		}
+		peer = rawStreamEnd(next, next)
+	}
+
+	var local tunnelEnd
+	if isLastHop {
+		local = framedStreamEnd(stream, upstream)
+	} else {
+		local = rawStreamEnd(stream, upstream)
+	}
+
+	log.Printf("[Relay-INFO] Tunnel %d: Splicing %s to %s.", tunnelID, remoteAddr, targetAddr)
+	sent, received, err := splice(local, peer)
+	if err != nil {
+		log.Printf("[Relay-WARN] Tunnel %d: Closed with error after %d bytes towards %s, %d back: %v", tunnelID, sent, targetAddr, received, err)
+		return
+	}
+	log.Printf("[Relay-INFO] Tunnel %d: Closed, %d bytes towards %s, %d back.", tunnelID, sent, targetAddr, received)
+}
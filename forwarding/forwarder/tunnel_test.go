@@ -0,0 +1,152 @@
+package forwarder
+
+import (
+	"bytes"
+	"fmt"
+	packet "forwarding/packet_handler"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/xtaci/smux"
+)
+
+func TestTunnelHeaderRoundTrip(t *testing.T) {
+	hops, _ := packet.ParseHopList([]string{"10.0.0.1", "10.0.0.2", "192.0.2.10"})
+	header := packet.NewMergedPacket([]uint32{42}, []int{0}, hops, packet.PacketTypeTunnel)
+
+	var buf bytes.Buffer
+	if err := writeTunnelHeader(&buf, header, 3306); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("payload")
+	data := buf.Bytes()
+	if !packet.IsTunnelPacket(data) || packet.IsStreamPacket(data) {
+		t.Fatalf("tunnel header not recognised")
+	}
+
+	// The first read of a relay may end anywhere, even inside the header
+	got, port, body, err := readTunnelHeader(bytes.NewReader(data[10:]), data[:10])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 3306 || got.PacketID[0] != 42 || len(got.HopList) != 3 {
+		t.Fatalf("unexpected header %+v, port %d", got, port)
+	}
+	rest, _ := io.ReadAll(body)
+	if string(rest) != "payload" {
+		t.Fatalf("data after the header = %q", rest)
+	}
+}
+
+// smuxPair returns both sides of one smux stream.
+func smuxPair(t *testing.T) (*smux.Stream, *smux.Stream) {
+	clientConn, serverConn := net.Pipe()
+	client, err := smux.Client(clientConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := smux.Server(serverConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	opened, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// smux only announces a stream with its first frame
+	if _, err := opened.Write([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(accepted, make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	return opened, accepted
+}
+
+// tcpPair returns both sides of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		dialed.Close()
+		conn.Close()
+	})
+	return dialed, conn
+}
+
+func TestSpliceTunnelChain(t *testing.T) {
+	client, accessConn := tcpPair(t)
+	relayConn, origin := tcpPair(t)
+	accessOut, middleIn := smuxPair(t)
+	middleOut, lastIn := smuxPair(t)
+
+	done := make(chan error, 3)
+	go func() {
+		_, _, err := splice(connEnd(accessConn), framedStreamEnd(accessOut, accessOut))
+		done <- err
+	}()
+	go func() {
+		_, _, err := splice(rawStreamEnd(middleIn, middleIn), rawStreamEnd(middleOut, middleOut))
+		done <- err
+	}()
+	go func() {
+		_, _, err := splice(framedStreamEnd(lastIn, lastIn), connEnd(relayConn))
+		done <- err
+	}()
+
+	// The origin answers only after the client has finished sending, which
+	// needs the half-close to travel the whole chain.
+	go func() {
+		request, _ := io.ReadAll(origin)
+		fmt.Fprintf(origin, "received %d bytes", len(request))
+		origin.Close()
+	}()
+
+	request := bytes.Repeat([]byte("x"), 3*packet.MaxChunkSize+17)
+	if _, err := client.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	client.(*net.TCPConn).CloseWrite()
+
+	reply, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("received %d bytes", len(request)); string(reply) != want {
+		t.Fatalf("reply %q, want %q", reply, want)
+	}
+	client.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("splice ended with error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package forwarder
+
+import (
+	"bufio"
+	"fmt"
+	packet "forwarding/packet_handler"
+	"forwarding/router"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/xtaci/smux"
+)
+
+// Requests with Connection: Upgrade (WebSockets, h2c) cannot be answered as
+// one response blob. The access node hijacks the client connection and sends
+// the request as the start of a PacketTypeUpgrade stream, which the relays
+// splice like a TCP tunnel. The last relay writes the request to the origin
+// and from then on copies bytes both ways, so the origin's 101 response and
+// everything after it reach the client unchanged.
+
+func isUpgradeRequest(req *http.Request) bool {
+	if req.ProtoMajor != 1 || req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Repository) handleUpgrade(w http.ResponseWriter, req *http.Request, domain string, requestID uint32) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		log.Printf("[Access-ERROR] Request ID %d: Connection cannot be hijacked for %s upgrade. Responding with 500.", requestID, req.Header.Get("Upgrade"))
+		http.Error(w, "Internal server error: Upgrade not supported on this connection.", http.StatusInternalServerError)
+		return
+	}
+
+	header, path, err := r.pickTunnelPath(domain, requestID, packet.PacketTypeUpgrade)
+	if err != nil {
+		log.Printf("[Access-ERROR] Request ID %d: %v. Responding with 503.", requestID, err)
+		http.Error(w, "Service unavailable: No routing paths found.", http.StatusServiceUnavailable)
+		return
+	}
+	nextHopIP, isLastHop, err := header.GetNextHopIP()
+	if err != nil || nextHopIP == "" {
+		log.Printf("[Access-ERROR] Request ID %d: Failed to determine next hop for path %v: %v. Responding with 500.", requestID, path, err)
+		http.Error(w, "Internal server error: Failed to determine next hop.", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[Access-INFO] Request ID %d: %s upgrade for %s%s via %v", requestID, req.Header.Get("Upgrade"), domain, req.URL.Path, path)
+
+	var peer tunnelEnd
+	var requestWriter io.Writer
+	if isLastHop {
+		// No relay in between, connect to the origin the way the last relay would,
+		// with port 80 as the default like the direct HTTP proxy
+		target, err := resolveOriginTarget(domain, nextHopIP, "80", router.GetOriginConfig(domain))
+		if err != nil {
+			log.Printf("[Access-ERROR] Request ID %d: %v. Responding with 502.", requestID, err)
+			http.Error(w, "Bad gateway: Failed to reach origin.", http.StatusBadGateway)
+			return
+		}
+		origin, err := dialOrigin(target)
+		if err != nil {
+			recordOriginResult(target.addr, nil, err)
+			log.Printf("[Access-ERROR] Request ID %d: Failed to connect to origin %s for upgrade: %v. Responding with 502.", requestID, target.addr, err)
+			http.Error(w, "Bad gateway: Failed to reach origin.", http.StatusBadGateway)
+			return
+		}
+		req.Host = target.hostHeader
+		peer = connEnd(origin)
+		requestWriter = origin
+	} else {
+		ip, port := hostPort(nextHopIP, r.accessConfig.RelayPort)
+		stream, err := openStreamWithRetry(net.JoinHostPort(ip, port))
+		if err != nil {
+			log.Printf("[Access-ERROR] Request ID %d: %v. Responding with 502.", requestID, err)
+			router.GetInstance().MarkPathFailed(path, r.accessConfig.Retry.FailedPathPenalty)
+			http.Error(w, "Bad gateway: Failed to reach next hop.", http.StatusBadGateway)
+			return
+		}
+		if err := writeTunnelHeader(stream, header, 0); err != nil {
+			stream.Close()
+			log.Printf("[Access-ERROR] Request ID %d: %v. Responding with 502.", requestID, err)
+			http.Error(w, "Bad gateway: Failed to reach next hop.", http.StatusBadGateway)
+			return
+		}
+		peer = framedStreamEnd(stream, stream)
+		requestWriter = peer.Writer
+	}
+
+	if err := req.Write(requestWriter); err != nil {
+		peer.close()
+		log.Printf("[Access-ERROR] Request ID %d: Failed to send upgrade request: %v. Responding with 502.", requestID, err)
+		http.Error(w, "Bad gateway: Failed to send upgrade request.", http.StatusBadGateway)
+		return
+	}
+
+	conn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		peer.close()
+		log.Printf("[Access-ERROR] Request ID %d: Failed to hijack client connection: %v", requestID, err)
+		return
+	}
+	defer conn.Close()
+
+	client := connEnd(conn)
+	client.Reader = clientBuf.Reader // may already hold the client's first frames
+	sent, received, err := splice(client, peer)
+	if err != nil {
+		log.Printf("[Access-WARN] Request ID %d: Upgraded connection closed with error after %d bytes sent, %d received: %v", requestID, sent, received, err)
+		return
+	}
+	log.Printf("[Access-INFO] Request ID %d: Upgraded connection closed, %d bytes sent, %d received.", requestID, sent, received)
+}
+
+// upgradeAtOrigin reads the upgrade request from the stream, sends it to the
+// origin and splices the two.
+func (r *RelayRepository) upgradeAtOrigin(stream *smux.Stream, upstream io.Reader, requestID uint32, originIP string) {
+	local := framedStreamEnd(stream, upstream)
+	reader := bufio.NewReader(local.Reader)
+	local.Reader = reader // keeps whatever the client sent right after the request
+
+	httpReq, err := http.ReadRequest(reader)
+	if err != nil {
+		log.Printf("[Relay-ERROR] Request ID %d: Failed to parse upgrade request: %v", requestID, err)
+		return
+	}
+
+	domain := router.NormalizeDomain(httpReq.Host)
+	target, err := resolveOriginTarget(domain, originIP, r.relayConfig.SourcePort, router.GetOriginConfig(domain))
+	if err != nil {
+		log.Printf("[Relay-ERROR] Request ID %d: %v", requestID, err)
+		return
+	}
+	origin, err := dialOrigin(target)
+	if err != nil {
+		recordOriginResult(target.addr, nil, err)
+		log.Printf("[Relay-ERROR] Request ID %d: Failed to connect to origin %s for upgrade: %v", requestID, target.addr, err)
+		writeUpgradeFailure(local)
+		return
+	}
+
+	httpReq.Host = target.hostHeader
+	if err := httpReq.Write(origin); err != nil {
+		origin.Close()
+		log.Printf("[Relay-ERROR] Request ID %d: Failed to send upgrade request to origin %s: %v", requestID, target.addr, err)
+		writeUpgradeFailure(local)
+		return
+	}
+
+	log.Printf("[Relay-INFO] Request ID %d: %s upgrade sent to origin %s, splicing.", requestID, httpReq.Header.Get("Upgrade"), target.addr)
+	sent, received, err := splice(local, connEnd(origin))
+	if err != nil {
+		log.Printf("[Relay-WARN] Request ID %d: Upgraded connection to %s closed with error after %d bytes sent, %d received: %v", requestID, target.addr, sent, received, err)
+		return
+	}
+	log.Printf("[Relay-INFO] Request ID %d: Upgraded connection to %s closed, %d bytes sent, %d received.", requestID, target.addr, sent, received)
+}
+
+// writeUpgradeFailure answers the client with 502 when the origin cannot be reached.
+func writeUpgradeFailure(local tunnelEnd) {
+	fmt.Fprintf(local, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", http.StatusBadGateway, http.StatusText(http.StatusBadGateway))
+	local.closeWrite()
+}
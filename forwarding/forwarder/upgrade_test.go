@@ -0,0 +1,98 @@
+package forwarder
+
+import (
+	"bufio"
+	"forwarding/metrics_processing/storage"
+	packet "forwarding/packet_handler"
+	"forwarding/router"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsUpgradeRequest(t *testing.T) {
+	cases := []struct {
+		connection, upgrade string
+		want                bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, Upgrade", "websocket", true},
+		{"upgrade", "h2c", true},
+		{"keep-alive", "websocket", false},
+		{"Upgrade", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "http://example.com/chat", nil)
+		if c.connection != "" {
+			req.Header.Set("Connection", c.connection)
+		}
+		if c.upgrade != "" {
+			req.Header.Set("Upgrade", c.upgrade)
+		}
+		if got := isUpgradeRequest(req); got != c.want {
+			t.Errorf("Connection %q, Upgrade %q: got %v, want %v", c.connection, c.upgrade, got, c.want)
+		}
+	}
+}
+
+func TestUpgradeAtOrigin(t *testing.T) {
+	fm, err := storage.NewFileManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.SetFileManager(fm) // no origin settings, so the plain HTTP defaults apply
+
+	// The origin switches to an echo protocol after the handshake
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isUpgradeRequest(req) || req.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+		io.Copy(conn, buf)
+	}))
+	defer origin.Close()
+	originAddr := strings.TrimPrefix(origin.URL, "http://")
+
+	accessOut, lastIn := smuxPair(t)
+	r := &RelayRepository{relayConfig: DefaultRelayConfig}
+	done := make(chan struct{})
+	go func() {
+		r.upgradeAtOrigin(lastIn, lastIn, 1, originAddr)
+		close(done)
+	}()
+
+	// The client's first frame follows the request without waiting for the 101
+	chunkWriter := packet.NewChunkWriter(accessOut)
+	io.WriteString(chunkWriter, "GET /chat HTTP/1.1\r\nHost: app.example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nhello")
+
+	reader := bufio.NewReader(packet.NewChunkReader(accessOut))
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want 101", resp.StatusCode)
+	}
+	echoed := make([]byte, 5)
+	if _, err := io.ReadFull(reader, echoed); err != nil || string(echoed) != "hello" {
+		t.Fatalf("echoed %q, %v", echoed, err)
+	}
+
+	io.WriteString(chunkWriter, " again")
+	chunkWriter.Close()
+	rest, err := io.ReadAll(reader)
+	if err != nil || string(rest) != " again" {
+		t.Fatalf("after half-close got %q, %v", rest, err)
+	}
+	<-done
+}
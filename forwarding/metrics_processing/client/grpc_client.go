@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"fmt"
+	"forwarding/metrics_processing/exporter"
 	protocol2 "forwarding/metrics_processing/protocol"
 	"forwarding/metrics_processing/storage"
 	"log"
@@ -12,12 +13,20 @@ import (
 	"google.golang.org/grpc"
 )
 
+var (
+	syncsTotal = exporter.Default.Counter("arcturus_controller_syncs_total",
+		"Metric syncs with the controller by result.", "result")
+	lastSyncSuccess = exporter.Default.Gauge("arcturus_controller_last_sync_success_timestamp_seconds",
+		"Unix time of the last successful metric sync with the controller.")
+)
+
 type GrpcClient struct {
-	metricsClient protocol2.MetricsServiceClient
-	configClient  protocol2.ConfigServiceClient
-	faultClient   protocol2.FaultServiceClient
-	conn          *grpc.ClientConn
-	fileManager   *storage.FileManager
+	metricsClient  protocol2.MetricsServiceClient
+	configClient   protocol2.ConfigServiceClient
+	faultClient    protocol2.FaultServiceClient
+	identityClient protocol2.IdentityServiceClient
+	conn           *grpc.ClientConn
+	fileManager    *storage.FileManager
 }
 
 type UpdateStatus struct {
@@ -51,13 +60,15 @@ func NewGrpcClient(address string, fileManager *storage.FileManager) (*GrpcClien
 	metricsClient := protocol2.NewMetricsServiceClient(conn)
 	configClient := protocol2.NewConfigServiceClient(conn)
 	faultClient := protocol2.NewFaultServiceClient(conn)
+	identityClient := protocol2.NewIdentityServiceClient(conn)
 
 	return &GrpcClient{
-		metricsClient: metricsClient,
-		configClient:  configClient,
-		faultClient:   faultClient,
-		conn:          conn,
-		fileManager:   fileManager,
+		metricsClient:  metricsClient,
+		configClient:   configClient,
+		faultClient:    faultClient,
+		identityClient: identityClient,
+		conn:           conn,
+		fileManager:    fileManager,
 	}, nil
 }
 
@@ -77,7 +88,7 @@ func (g *GrpcClient) InitDataPlane(ctx context.Context, metrics *protocol2.Metri
 	return nil
 }
 
-func (g *GrpcClient) SyncMetrics(ctx context.Context, metrics *protocol2.Metrics, regionProbeResults []*protocol2.RegionProbeResult) (*protocol2.SyncResponse, error) {
+func (g *GrpcClient) SyncMetrics(ctx context.Context, metrics *protocol2.Metrics, regionProbeResults []*protocol2.RegionProbeResult, linkLatencies []*protocol2.LinkLatency, draining bool) (*protocol2.SyncResponse, error) {
 
 	nodeListHash, probeTasksHash, domainIPMappingsHash, err := g.fileManager.GetConfigHashes()
 	if err != nil {
@@ -94,12 +105,17 @@ func (g *GrpcClient) SyncMetrics(ctx context.Context, metrics *protocol2.Metrics
 		ProbeTasksHash:       probeTasksHash,
 		DomainIpMappingsHash: domainIPMappingsHash,
 		RegionProbeResults:   regionProbeResults,
+		LinkLatencies:        linkLatencies,
+		Draining:             draining,
 	}
 
 	resp, err := g.metricsClient.SyncMetrics(ctx, req)
 	if err != nil {
+		syncsTotal.With("failure").Inc()
 		return nil, fmt.Errorf("failed to sync metrics_processing: %v", err)
 	}
+	syncsTotal.With("success").Inc()
+	lastSyncSuccess.With().Set(float64(time.Now().Unix()))
 
 	log.Printf("Server returned status: %s, message: %s", resp.Status, resp.Message)
 
@@ -210,6 +226,27 @@ func (g *GrpcClient) ReportFault(ctx context.Context, faultInfo *protocol2.Fault
 	return nil
 }
 
+// IssueNodeCertificate asks the controller to sign csrPEM for nodeIP. It returns
+// the node certificate and the CA certificate, both PEM encoded.
+func (g *GrpcClient) IssueNodeCertificate(ctx context.Context, nodeIP string, csrPEM []byte) ([]byte, []byte, error) {
+
+	req := &protocol2.NodeCertificateRequest{
+		NodeIp: nodeIP,
+		CsrPem: csrPEM,
+	}
+
+	resp, err := g.identityClient.IssueNodeCertificate(ctx, req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to request node certificate: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return nil, nil, fmt.Errorf("controller error: %s", resp.Message)
+	}
+
+	return resp.CertificatePem, resp.CaCertificatePem, nil
+}
+
 func (us *UpdateStatus) HasUpdates() bool {
 	return us.NodeListUpdated || us.ProbeTasksUpdated || us.DomainIPMappingsUpdated
 }
@@ -5,6 +5,9 @@ import (
 	t "forwarding/common"
 	"forwarding/metrics_processing/client"
 	collector2 "forwarding/metrics_processing/collector"
+	"forwarding/metrics_processing/fault"
+	"forwarding/metrics_processing/identity"
+	"forwarding/metrics_processing/linklatency"
 	"forwarding/metrics_processing/probe"
 	"forwarding/metrics_processing/protocol"
 	"forwarding/metrics_processing/storage"
@@ -13,19 +16,67 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 )
 
+// Config sets up the data plane's sync with the controller.
+type Config struct {
+	ServerAddr     string
+	DataDir        string // node list, probe tasks, domain mappings and the node identity
+	ReportInterval time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		DataDir:        "../../agent_storage",
+		ReportInterval: 5 * time.Second,
+	}
+}
+
+var reportIntervalUpdates = make(chan time.Duration, 1)
+
+// SetReportInterval changes the interval of the controller sync of the running data plane.
+func SetReportInterval(interval time.Duration) {
+	select {
+	case <-reportIntervalUpdates:
+	default:
+	}
+	reportIntervalUpdates <- interval
+}
+
 var (
-	// ServerAddr     = "104.238.153.192:8080" // This will now be passed as a parameter
-	ReportInterval = 5 * time.Second       //
-	DataDir        = "../../agent_storage" //
+	draining     atomic.Bool
+	drainUpdates = make(chan chan struct{})
 )
 
-func StartDataPlane(ctx context.Context, serverAddr string) {
+// SetDraining reports the node to the controller as draining, so that it is
+// left out of paths and BPR, or as in service again. It returns after the
+// sync carrying the change, or after 10 seconds if the data plane is not
+// syncing.
+func SetDraining(on bool) {
+	draining.Store(on)
+	timer := time.NewTimer(10 * time.Second)
+	defer timer.Stop()
+
+	done := make(chan struct{})
+	select {
+	case drainUpdates <- done:
+	case <-timer.C:
+		log.Printf("Controller sync is not running, draining=%v is reported with the next sync", on)
+		return
+	}
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
+
+func StartDataPlane(ctx context.Context, config Config) {
+	serverAddr := config.ServerAddr
 	log.Printf("Metrics processing starting. ServerAddr: %s", serverAddr)
 
-	absDataDir, err := filepath.Abs(DataDir)
+	absDataDir, err := filepath.Abs(config.DataDir)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
@@ -38,6 +89,7 @@ func StartDataPlane(ctx context.Context, serverAddr string) {
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	router.SetFileManager(fileManager)
 	log.Println("gRPC")
 
 	grpcClient, err := client.NewGrpcClient(serverAddr, fileManager)
@@ -51,6 +103,14 @@ func StartDataPlane(ctx context.Context, serverAddr string) {
 		log.Fatalf(": %v", err)
 	}
 
+	identityManager, err := identity.NewManager(filepath.Join(absDataDir, "identity"), info.IP, grpcClient, identity.DefaultIdentityConfig())
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := identityManager.Load(); err != nil {
+		log.Printf("[Identity-INFO] No usable stored node certificate, requesting one: %v", err)
+	}
+
 	if !fileManager.IsInitialized() {
 
 		initCtx, initCancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -64,95 +124,116 @@ func StartDataPlane(ctx context.Context, serverAddr string) {
 
 	}
 
-	ticker := time.NewTicker(ReportInterval)
+	router.UpdateMembers(fileManager.GetNodeList(), fileManager.GetDomainIPMappings())
+	go identityManager.Run(ctx)
+
+	detector := fault.GetDetector()
+	go detector.Run(ctx, grpcClient, info.IP)
+
+	ticker := time.NewTicker(config.ReportInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case interval := <-reportIntervalUpdates:
+			ticker.Reset(interval)
+			log.Printf("Controller sync interval set to %s", interval)
 		case <-ticker.C:
-			syncCtx, syncCancel := context.WithTimeout(context.Background(), 5*time.Second)
-			info, err := collector2.CollectSystemInfo()
-			if err != nil {
-				log.Printf(": %v", err)
-				syncCancel()
-				continue
-			}
-			metrics := collector2.ConvertToProtoMetrics(info)
-			regionProbeResults, err := probe.CollectRegionProbeResults(fileManager)
-			if err != nil {
-				log.Printf(": %v", err)
-				regionProbeResults = []*protocol.RegionProbeResult{} //
-			}
-			syncResp, err := grpcClient.SyncMetrics(syncCtx, metrics, regionProbeResults)
+			syncWithController(grpcClient, fileManager, detector)
+		case done := <-drainUpdates:
+			syncWithController(grpcClient, fileManager, detector)
+			close(done)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// syncWithController reports this node's metrics and probe results and applies
+// what the controller sends back.
+func syncWithController(grpcClient *client.GrpcClient, fileManager *storage.FileManager, detector *fault.Detector) {
+	syncCtx, syncCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer syncCancel()
+
+	info, err := collector2.CollectSystemInfo()
+	if err != nil {
+		log.Printf(": %v", err)
+		return
+	}
+	detector.CheckResources(info)
+	metrics := collector2.ConvertToProtoMetrics(info)
+	regionProbeResults, err := probe.CollectRegionProbeResults(fileManager)
+	if err != nil {
+		log.Printf(": %v", err)
+		regionProbeResults = []*protocol.RegionProbeResult{} //
+	}
+	linkLatencies := linklatency.GetRecorder().Collect()
+	syncResp, err := grpcClient.SyncMetrics(syncCtx, metrics, regionProbeResults, linkLatencies, draining.Load())
+	if err != nil {
+		log.Printf(": %v", err)
+		return
+	}
+	detector.HandleAcknowledged(syncResp.AcknowledgedFaults)
+	router.UpdateMembers(fileManager.GetNodeList(), fileManager.GetDomainIPMappings())
+	if len(syncResp.RegionAssessments) > 0 {
+		nodeList := fileManager.GetNodeList()
+		if nodeList == nil {
+			log.Printf("NodeList is nil, cannot process RegionAssessments.")
+		} else {
+			// 1. Process RegionAssessments to get the base topology
+			baseTopology, err := to.ProcessRegionAssessments(syncResp.RegionAssessments, nodeList)
 			if err != nil {
-				log.Printf(": %v", err)
-				syncCancel()
-				continue
-			}
-			if len(syncResp.RegionAssessments) > 0 {
-				nodeList := fileManager.GetNodeList()
-				if nodeList == nil {
-					log.Printf("NodeList is nil, cannot process RegionAssessments.")
+				log.Printf("Error processing RegionAssessments: %v", err)
+			} else {
+				// ---> Integrate local probe results
+				var currentNodeIP string
+				// collector2.GetIP() is correct as per imports.
+				ip, err := collector2.GetIP()
+				if err != nil {
+					log.Printf("Error getting current node IP via collector2.GetIP(): %v. Local probe integration might be skipped.", err)
+					// If current IP cannot be obtained, direct links cannot be added, but continue with topology based on RegionAssessments
 				} else {
-					// 1. Process RegionAssessments to get the base topology
-					baseTopology, err := to.ProcessRegionAssessments(syncResp.RegionAssessments, nodeList)
+					currentNodeIP = ip
+					log.Printf("Current node IP for local probe integration: %s", currentNodeIP)
+
+					localProbeResults, err := probe.CollectRegionProbeResults(fileManager)
 					if err != nil {
-						log.Printf("Error processing RegionAssessments: %v", err)
+						log.Printf("Error collecting local probe results: %v", err)
 					} else {
-						// ---> Integrate local probe results
-						var currentNodeIP string
-						// collector2.GetIP() is correct as per imports.
-						ip, err := collector2.GetIP()
-						if err != nil {
-							log.Printf("Error getting current node IP via collector2.GetIP(): %v. Local probe integration might be skipped.", err)
-							// If current IP cannot be obtained, direct links cannot be added, but continue with topology based on RegionAssessments
-						} else {
-							currentNodeIP = ip
-							log.Printf("Current node IP for local probe integration: %s", currentNodeIP)
-
-							localProbeResults, err := probe.CollectRegionProbeResults(fileManager)
-							if err != nil {
-								log.Printf("Error collecting local probe results: %v", err)
-							} else {
-								if len(localProbeResults) > 0 {
-									log.Printf("Integrating %d region(s) of local probe results.", len(localProbeResults))
-									for _, regionResult := range localProbeResults {
-										if len(regionResult.IpProbes) > 0 {
-											log.Printf("Found %d IP probes in region '%s' from local results.", len(regionResult.IpProbes), regionResult.Region)
-											for _, probeEntry := range regionResult.IpProbes {
-												if probeEntry.TcpDelay >= 0 { // Valid probe
-													baseTopology.AddLink(currentNodeIP, probeEntry.TargetIp, float32(probeEntry.TcpDelay))
-													log.Printf("Added/Updated local direct link to topology: %s -> %s, delay: %dms", currentNodeIP, probeEntry.TargetIp, probeEntry.TcpDelay)
-												} else {
-													log.Printf("Skipping local probe link due to negative delay: %s -> %s, delay: %dms", currentNodeIP, probeEntry.TargetIp, probeEntry.TcpDelay)
-												}
-											}
+						if len(localProbeResults) > 0 {
+							log.Printf("Integrating %d region(s) of local probe results.", len(localProbeResults))
+							for _, regionResult := range localProbeResults {
+								if len(regionResult.IpProbes) > 0 {
+									log.Printf("Found %d IP probes in region '%s' from local results.", len(regionResult.IpProbes), regionResult.Region)
+									for _, probeEntry := range regionResult.IpProbes {
+										if probeEntry.TcpDelay >= 0 { // Valid probe
+											baseTopology.AddLink(currentNodeIP, probeEntry.TargetIp, float32(probeEntry.TcpDelay))
+											log.Printf("Added/Updated local direct link to topology: %s -> %s, delay: %dms", currentNodeIP, probeEntry.TargetIp, probeEntry.TcpDelay)
+										} else {
+											log.Printf("Skipping local probe link due to negative delay: %s -> %s, delay: %dms", currentNodeIP, probeEntry.TargetIp, probeEntry.TcpDelay)
 										}
 									}
-								} else {
-									log.Println("No local probe results to integrate.")
 								}
 							}
-						}
-						// <--- Integration of local probe results ends here
-
-						// topologyManager is from "forwarding/common" (imported as 't')
-						topologyManager := t.GetInstance()
-						topologyManager.SetTopology(baseTopology) // SetTopology will now process the graph including local links
-
-						network, ipToIndex, indexToIP, err := topologyManager.GetNetworkForKSP()
-						if err == nil {
-							pathManager := router.GetInstance()
-							pathManager.CalculatePaths(network, ipToIndex, indexToIP)
 						} else {
-							log.Printf(": %v", err)
+							log.Println("No local probe results to integrate.")
 						}
 					}
 				}
+				// <--- Integration of local probe results ends here
+
+				// topologyManager is from "forwarding/common" (imported as 't')
+				topologyManager := t.GetInstance()
+				topologyManager.SetTopology(baseTopology) // SetTopology will now process the graph including local links
+
+				network, ipToIndex, indexToIP, err := topologyManager.GetNetworkForKSP()
+				if err == nil {
+					pathManager := router.GetInstance()
+					pathManager.CalculatePaths(network, ipToIndex, indexToIP)
+				} else {
+					log.Printf(": %v", err)
+				}
 			}
-			syncCancel()
-		case <-ctx.Done():
-			return
 		}
 	}
 }
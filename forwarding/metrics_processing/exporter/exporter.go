@@ -0,0 +1,322 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics are served in the Prometheus text format (version 0.0.4). Counters,
+// gauges and histograms are updated where the events happen; values that
+// already live elsewhere, like buffer statistics or session counts, are read
+// through collect functions at scrape time.
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are latency buckets in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry served on /metrics.
+var Default = NewRegistry()
+
+// CollectFunc reports the current values of a family through emit, one call
+// per label set.
+type CollectFunc func(emit func(value float64, labelValues ...string))
+
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type family struct {
+	name       string
+	help       string
+	kind       string // counter, gauge or histogram
+	labelNames []string
+	buckets    []float64
+	collect    CollectFunc
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+
+	mu     sync.Mutex
+	value  float64
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// register returns the family called name, creating it on first use.
+// Registering a name again with another type or labels panics.
+func (r *Registry) register(name, help, kind string, labelNames []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || strings.Join(f.labelNames, ",") != strings.Join(labelNames, ",") {
+			panic(fmt.Sprintf("metric %s registered as %s%v and %s%v", name, f.kind, f.labelNames, kind, labelNames))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, kind: kind, labelNames: labelNames, buckets: buckets, series: make(map[string]*series)}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s has labels %v, got %d values", f.name, f.labelNames, len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) delete(labelValues []string) {
+	f.mu.Lock()
+	delete(f.series, strings.Join(labelValues, "\xff"))
+	f.mu.Unlock()
+}
+
+type CounterVec struct{ f *family }
+
+type Counter struct{ s *series }
+
+// Counter registers a counter family.
+func (r *Registry) Counter(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", labelNames, nil)}
+}
+
+func (v *CounterVec) With(labelValues ...string) Counter {
+	return Counter{v.f.with(labelValues)}
+}
+
+func (c Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter; negative deltas are ignored.
+func (c Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.s.mu.Lock()
+	c.s.value += delta
+	c.s.mu.Unlock()
+}
+
+type GaugeVec struct{ f *family }
+
+type Gauge struct{ s *series }
+
+// Gauge registers a gauge family.
+func (r *Registry) Gauge(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", labelNames, nil)}
+}
+
+func (v *GaugeVec) With(labelValues ...string) Gauge {
+	return Gauge{v.f.with(labelValues)}
+}
+
+// Delete drops the label set, e.g. once the target it describes is gone.
+func (v *GaugeVec) Delete(labelValues ...string) {
+	v.f.delete(labelValues)
+}
+
+func (g Gauge) Set(value float64) {
+	g.s.mu.Lock()
+	g.s.value = value
+	g.s.mu.Unlock()
+}
+
+type HistogramVec struct{ f *family }
+
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// Histogram registers a histogram family with the given upper bounds, which
+// must be sorted.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{r.register(name, help, "histogram", labelNames, buckets)}
+}
+
+func (v *HistogramVec) With(labelValues ...string) Histogram {
+	return Histogram{v.f.with(labelValues), v.f.buckets}
+}
+
+func (h Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.buckets, value)
+	h.s.mu.Lock()
+	if i < len(h.s.counts) {
+		h.s.counts[i]++
+	}
+	h.s.count++
+	h.s.sum += value
+	h.s.mu.Unlock()
+}
+
+// CounterFunc registers a counter family whose values are read from collect
+// at scrape time. collect must report values that never decrease.
+func (r *Registry) CounterFunc(name, help string, labelNames []string, collect CollectFunc) {
+	r.registerFunc(name, help, "counter", labelNames, collect)
+}
+
+// GaugeFunc registers a gauge family whose values are read from collect at
+// scrape time.
+func (r *Registry) GaugeFunc(name, help string, labelNames []string, collect CollectFunc) {
+	r.registerFunc(name, help, "gauge", labelNames, collect)
+}
+
+func (r *Registry) registerFunc(name, help, kind string, labelNames []string, collect CollectFunc) {
+	f := r.register(name, help, kind, labelNames, nil)
+	f.mu.Lock()
+	f.collect = collect
+	f.mu.Unlock()
+}
+
+// ServeHTTP writes all families in the text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	out := bufio.NewWriter(w)
+	r.writeText(out)
+	out.Flush()
+}
+
+func (r *Registry) writeText(out *bufio.Writer) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	for _, f := range families {
+		f.write(out)
+	}
+}
+
+func (f *family) write(out *bufio.Writer) {
+	f.mu.Lock()
+	collect := f.collect
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.Unlock()
+
+	if collect != nil {
+		values := make(map[string]*series)
+		collect(func(value float64, labelValues ...string) {
+			if len(labelValues) != len(f.labelNames) {
+				return
+			}
+			key := strings.Join(labelValues, "\xff")
+			if s, ok := values[key]; ok {
+				s.value += value // label sets reported twice add up
+				return
+			}
+			values[key] = &series{labelValues: append([]string(nil), labelValues...), value: value}
+		})
+		for _, s := range values {
+			all = append(all, s)
+		}
+	}
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].labelValues, "\xff") < strings.Join(all[j].labelValues, "\xff")
+	})
+
+	fmt.Fprintf(out, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(out, "# TYPE %s %s\n", f.name, f.kind)
+	for _, s := range all {
+		labels := formatLabels(f.labelNames, s.labelValues)
+		s.mu.Lock()
+		if f.kind != "histogram" {
+			fmt.Fprintf(out, "%s%s %s\n", f.name, wrapLabels(labels), formatValue(s.value))
+			s.mu.Unlock()
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(out, "%s_bucket%s %d\n", f.name, wrapLabels(joinLabels(labels, `le="`+formatValue(bound)+`"`)), cumulative)
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", f.name, wrapLabels(joinLabels(labels, `le="+Inf"`)), s.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", f.name, wrapLabels(labels), formatValue(s.sum))
+		fmt.Fprintf(out, "%s_count%s %d\n", f.name, wrapLabels(labels), s.count)
+		s.mu.Unlock()
+	}
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
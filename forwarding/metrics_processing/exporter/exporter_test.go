@@ -0,0 +1,83 @@
+package exporter
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestTextExposition(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("test_requests_total", "Requests handled.", "domain", "result")
+	requests.With("example.com", "ok").Inc()
+	requests.With("example.com", "ok").Add(2)
+	requests.With(`we"ird`, "error").Inc()
+	if r.Counter("test_requests_total", "Requests handled.", "domain", "result") == nil {
+		t.Fatal("registering a family again failed")
+	}
+
+	latency := r.Histogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "domain")
+	latency.With("example.com").Observe(0.05)
+	latency.With("example.com").Observe(0.5)
+	latency.With("example.com").Observe(3)
+
+	rtt := r.Gauge("test_rtt_seconds", "RTT.", "target")
+	rtt.With("10.0.0.1").Set(0.012)
+	rtt.With("10.0.0.2").Set(0.020)
+	rtt.Delete("10.0.0.2")
+
+	r.GaugeFunc("test_sessions", "Sessions.", []string{"peer"}, func(emit func(float64, ...string)) {
+		emit(2, "10.0.0.1")
+		emit(1, "10.0.0.1")
+		emit(7) // wrong label count, dropped
+	})
+	r.GaugeFunc("test_empty", "Nothing.", nil, func(emit func(float64, ...string)) {})
+
+	got := scrape(t, r)
+	for _, want := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{domain="example.com",result="ok"} 3` + "\n",
+		`test_requests_total{domain="we\"ird",result="error"} 1` + "\n",
+		"# TYPE test_latency_seconds histogram\n",
+		`test_latency_seconds_bucket{domain="example.com",le="0.1"} 1` + "\n",
+		`test_latency_seconds_bucket{domain="example.com",le="1"} 2` + "\n",
+		`test_latency_seconds_bucket{domain="example.com",le="+Inf"} 3` + "\n",
+		`test_latency_seconds_sum{domain="example.com"} 3.55` + "\n",
+		`test_latency_seconds_count{domain="example.com"} 3` + "\n",
+		`test_rtt_seconds{target="10.0.0.1"} 0.012` + "\n",
+		`test_sessions{peer="10.0.0.1"} 3` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"10.0.0.2", "test_empty", "test_sessions 7"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("unexpected %q in:\n%s", unwanted, got)
+		}
+	}
+	if strings.Index(got, "test_latency_seconds") > strings.Index(got, "test_requests_total") {
+		t.Error("families are not sorted by name")
+	}
+}
+
+func TestRegisterConflictPanics(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "Total.", "a")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("conflicting registration did not panic")
+		}
+	}()
+	r.Gauge("test_total", "Total.", "a")
+}
@@ -0,0 +1,266 @@
+package fault
+
+import (
+	"context"
+	"fmt"
+	"forwarding/metrics_processing/collector"
+	"forwarding/metrics_processing/protocol"
+	"log"
+	"sync"
+	"time"
+)
+
+// Fault types reported to the controller in FaultInfo.fault_type
+const (
+	TypeProbeUnreachable   = "probe_unreachable"
+	TypeNextHopUnreachable = "next_hop_unreachable"
+	TypeOriginFailure      = "origin_failure"
+	TypeResourceExhaustion = "resource_exhaustion"
+)
+
+const maxDescriptionLen = 1024 // node_fault.fault_description
+
+type DetectorConfig struct {
+	ProbeFailureThreshold   int     // consecutive failed TCP probes towards one target
+	SessionFailureThreshold int     // consecutive failed SMUX session setups towards one next hop
+	OriginFailureThreshold  int     // consecutive transport errors or 5xx responses from one origin
+	ResourceSampleThreshold int     // consecutive system samples above a usage limit
+	CPUUsageThreshold       float64 // percent
+	MemoryUsageThreshold    float64 // percent
+	DiskUsageThreshold      float64 // percent
+	InitialBackoff          time.Duration
+	MaxBackoff              time.Duration // keep below the controller's FaultResolveAfter so persisting faults stay open
+	ReportTimeout           time.Duration
+	QueueSize               int
+}
+
+func DefaultDetectorConfig() DetectorConfig {
+	return DetectorConfig{
+		ProbeFailureThreshold:   3,
+		SessionFailureThreshold: 3,
+		OriginFailureThreshold:  5,
+		ResourceSampleThreshold: 3,
+		CPUUsageThreshold:       95,
+		MemoryUsageThreshold:    95,
+		DiskUsageThreshold:      95,
+		InitialBackoff:          15 * time.Second,
+		MaxBackoff:              60 * time.Second,
+		ReportTimeout:           5 * time.Second,
+		QueueSize:               64,
+	}
+}
+
+func (c DetectorConfig) Validate() error {
+	for name, v := range map[string]int{
+		"probe_failure_threshold":   c.ProbeFailureThreshold,
+		"session_failure_threshold": c.SessionFailureThreshold,
+		"origin_failure_threshold":  c.OriginFailureThreshold,
+		"resource_sample_threshold": c.ResourceSampleThreshold,
+		"queue_size":                c.QueueSize,
+	} {
+		if v < 1 {
+			return fmt.Errorf("%s must be at least 1", name)
+		}
+	}
+	for name, v := range map[string]float64{
+		"cpu_usage_threshold":    c.CPUUsageThreshold,
+		"memory_usage_threshold": c.MemoryUsageThreshold,
+		"disk_usage_threshold":   c.DiskUsageThreshold,
+	} {
+		if v <= 0 || v > 100 {
+			return fmt.Errorf("%s must be in (0, 100], got %v", name, v)
+		}
+	}
+	if c.InitialBackoff <= 0 || c.ReportTimeout <= 0 {
+		return fmt.Errorf("initial_backoff and report_timeout must be positive")
+	}
+	if c.MaxBackoff < c.InitialBackoff {
+		return fmt.Errorf("max_backoff %v must not be below initial_backoff %v", c.MaxBackoff, c.InitialBackoff)
+	}
+	return nil
+}
+
+// Reporter is satisfied by client.GrpcClient.
+type Reporter interface {
+	ReportFault(ctx context.Context, fault *protocol.FaultInfo) error
+}
+
+type pendingFault struct {
+	faultType   string
+	target      string
+	description string
+}
+
+type faultState struct {
+	backoff      time.Duration
+	nextReportAt time.Time
+}
+
+// Detector counts consecutive failures per (fault type, target) and queues a
+// report once a threshold is crossed. While the fault persists it is reported
+// again with exponential backoff; a success clears it locally.
+type Detector struct {
+	config   DetectorConfig
+	mu       sync.Mutex
+	failures map[string]int
+	active   map[string]*faultState
+	queue    chan pendingFault
+}
+
+var (
+	detectorConfig   = DefaultDetectorConfig()
+	detectorInstance *Detector
+	detectorOnce     sync.Once
+)
+
+// SetDetectorConfig sets the thresholds of the detector. It only has an
+// effect before the first GetDetector, so it is called at startup.
+func SetDetectorConfig(config DetectorConfig) {
+	detectorConfig = config
+}
+
+func GetDetector() *Detector {
+	detectorOnce.Do(func() {
+		detectorInstance = newDetector(detectorConfig)
+	})
+	return detectorInstance
+}
+
+func newDetector(config DetectorConfig) *Detector {
+	return &Detector{
+		config:   config,
+		failures: make(map[string]int),
+		active:   make(map[string]*faultState),
+		queue:    make(chan pendingFault, config.QueueSize),
+	}
+}
+
+// RecordProbeResult records the outcome of a TCP probe towards target.
+func (d *Detector) RecordProbeResult(target string, reachable bool) {
+	if reachable {
+		d.recordSuccess(TypeProbeUnreachable, target)
+		return
+	}
+	d.recordFailure(TypeProbeUnreachable, target, d.config.ProbeFailureThreshold,
+		fmt.Sprintf("TCP probe to %s failed", target))
+}
+
+// RecordSessionResult records the outcome of creating a SMUX client session towards targetAddr.
+func (d *Detector) RecordSessionResult(targetAddr string, err error) {
+	if err == nil {
+		d.recordSuccess(TypeNextHopUnreachable, targetAddr)
+		return
+	}
+	d.recordFailure(TypeNextHopUnreachable, targetAddr, d.config.SessionFailureThreshold,
+		fmt.Sprintf("failed to create SMUX session to %s: %v", targetAddr, err))
+}
+
+// RecordOriginResult records the outcome of an HTTP request to origin.
+func (d *Detector) RecordOriginResult(origin string, err error) {
+	if err == nil {
+		d.recordSuccess(TypeOriginFailure, origin)
+		return
+	}
+	d.recordFailure(TypeOriginFailure, origin, d.config.OriginFailureThreshold,
+		fmt.Sprintf("HTTP request to origin %s failed: %v", origin, err))
+}
+
+// CheckResources compares a system sample against the configured usage limits.
+func (d *Detector) CheckResources(info collector.InfoData) {
+	d.checkUsage("cpu", info.CPUInfo.Usage, d.config.CPUUsageThreshold)
+	d.checkUsage("memory", info.MemoryInfo.UsedPercent, d.config.MemoryUsageThreshold)
+	d.checkUsage("disk", info.DiskInfo.UsedPercent, d.config.DiskUsageThreshold)
+}
+
+func (d *Detector) checkUsage(resource string, usedPercent, limit float64) {
+	if usedPercent < limit {
+		d.recordSuccess(TypeResourceExhaustion, resource)
+		return
+	}
+	d.recordFailure(TypeResourceExhaustion, resource, d.config.ResourceSampleThreshold,
+		fmt.Sprintf("%s usage %.2f%% exceeds %.2f%%", resource, usedPercent, limit))
+}
+
+func (d *Detector) recordFailure(faultType, target string, threshold int, description string) {
+	key := faultType + "|" + target
+	now := time.Now()
+
+	d.mu.Lock()
+	d.failures[key]++
+	if d.failures[key] < threshold {
+		d.mu.Unlock()
+		return
+	}
+	state, exists := d.active[key]
+	if !exists {
+		state = &faultState{backoff: d.config.InitialBackoff}
+		d.active[key] = state
+	} else if now.Before(state.nextReportAt) {
+		d.mu.Unlock()
+		return
+	} else {
+		state.backoff *= 2
+		if state.backoff > d.config.MaxBackoff {
+			state.backoff = d.config.MaxBackoff
+		}
+	}
+	state.nextReportAt = now.Add(state.backoff)
+	d.mu.Unlock()
+
+	if len(description) > maxDescriptionLen {
+		description = description[:maxDescriptionLen]
+	}
+	select {
+	case d.queue <- pendingFault{faultType: faultType, target: target, description: description}:
+	default:
+		log.Printf("[Fault-WARN] Report queue full, dropping %s fault for %s", faultType, target)
+	}
+}
+
+func (d *Detector) recordSuccess(faultType, target string) {
+	key := faultType + "|" + target
+
+	d.mu.Lock()
+	_, wasActive := d.active[key]
+	delete(d.failures, key)
+	delete(d.active, key)
+	d.mu.Unlock()
+
+	if wasActive {
+		log.Printf("[Fault-INFO] %s fault for %s cleared locally", faultType, target)
+	}
+}
+
+// Run sends queued faults through reporter until ctx is done. fault_id is
+// derived from nodeIP, type and target so repeated reports update one record.
+func (d *Detector) Run(ctx context.Context, reporter Reporter, nodeIP string) {
+	for {
+		select {
+		case pending := <-d.queue:
+			faultInfo := &protocol.FaultInfo{
+				FaultId:          fmt.Sprintf("%s_%s_%s", nodeIP, pending.faultType, pending.target),
+				NodeIp:           nodeIP,
+				FaultType:        pending.faultType,
+				FaultDescription: pending.description,
+				Target:           pending.target,
+			}
+			reportCtx, cancel := context.WithTimeout(ctx, d.config.ReportTimeout)
+			err := reporter.ReportFault(reportCtx, faultInfo)
+			cancel()
+			if err != nil {
+				log.Printf("[Fault-ERROR] Failed to report fault %s: %v", faultInfo.FaultId, err)
+				continue
+			}
+			log.Printf("[Fault-INFO] Reported fault %s: %s", faultInfo.FaultId, faultInfo.FaultDescription)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// HandleAcknowledged logs faults the controller echoed back in SyncResponse.
+func (d *Detector) HandleAcknowledged(faults []*protocol.FaultInfo) {
+	for _, f := range faults {
+		log.Printf("[Fault-INFO] Controller acknowledged fault %s", f.FaultId)
+	}
+}
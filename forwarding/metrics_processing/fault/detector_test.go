@@ -0,0 +1,81 @@
+package fault
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testConfig() DetectorConfig {
+	config := DefaultDetectorConfig()
+	config.InitialBackoff = time.Hour
+	config.MaxBackoff = time.Hour
+	return config
+}
+
+func TestDetectorReportsAfterThreshold(t *testing.T) {
+	d := newDetector(testConfig())
+	errDial := errors.New("connection refused")
+
+	for i := 0; i < d.config.SessionFailureThreshold-1; i++ {
+		d.RecordSessionResult("10.0.0.2:50056", errDial)
+	}
+	if len(d.queue) != 0 {
+		t.Fatalf("expected no report below threshold, got %d", len(d.queue))
+	}
+
+	d.RecordSessionResult("10.0.0.2:50056", errDial)
+	if len(d.queue) != 1 {
+		t.Fatalf("expected one report at threshold, got %d", len(d.queue))
+	}
+	pending := <-d.queue
+	if pending.faultType != TypeNextHopUnreachable || pending.target != "10.0.0.2:50056" {
+		t.Errorf("unexpected pending fault: %+v", pending)
+	}
+
+	// Still failing but within the backoff window: de-duplicated
+	d.RecordSessionResult("10.0.0.2:50056", errDial)
+	if len(d.queue) != 0 {
+		t.Errorf("expected duplicate report to be suppressed, got %d", len(d.queue))
+	}
+}
+
+func TestDetectorBackoffAndRecovery(t *testing.T) {
+	config := testConfig()
+	config.ProbeFailureThreshold = 1
+	config.InitialBackoff = 0
+	config.MaxBackoff = 0
+	d := newDetector(config)
+
+	d.RecordProbeResult("10.0.0.3", false)
+	d.RecordProbeResult("10.0.0.3", false)
+	if len(d.queue) != 2 {
+		t.Fatalf("expected re-report once backoff elapsed, got %d", len(d.queue))
+	}
+	<-d.queue
+	<-d.queue
+
+	d.RecordProbeResult("10.0.0.3", true)
+	if _, ok := d.active[TypeProbeUnreachable+"|10.0.0.3"]; ok {
+		t.Errorf("expected success to clear the active fault")
+	}
+}
+
+func TestDetectorBackoffGrowsToMax(t *testing.T) {
+	config := testConfig()
+	config.OriginFailureThreshold = 1
+	config.InitialBackoff = time.Second
+	config.MaxBackoff = 3 * time.Second
+	d := newDetector(config)
+	key := TypeOriginFailure + "|origin:8080"
+
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		if i > 0 {
+			d.active[key].nextReportAt = time.Time{} // pretend the window has elapsed
+		}
+		d.RecordOriginResult("origin:8080", errors.New("502 Bad Gateway"))
+		if got := d.active[key].backoff; got != want {
+			t.Errorf("report %d: backoff = %v, want %v", i, got, want)
+		}
+	}
+}
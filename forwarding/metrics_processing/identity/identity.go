@@ -0,0 +1,209 @@
+package identity
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"forwarding/forwarder/connection"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The node keeps its private key under Dir and never sends it anywhere: it
+// asks the controller to sign a CSR for its IP and installs the returned
+// certificate for the inter-node transport. The stored pair is reused across
+// restarts and renewed ahead of expiry.
+
+const (
+	keyFile  = "node.key"
+	certFile = "node.crt"
+	caFile   = "ca.crt"
+)
+
+type IdentityConfig struct {
+	RenewBefore    time.Duration // renew when the certificate expires within this window
+	CheckInterval  time.Duration
+	RetryInterval  time.Duration // retry period while the node holds no valid certificate
+	RequestTimeout time.Duration
+}
+
+func DefaultIdentityConfig() IdentityConfig {
+	return IdentityConfig{
+		RenewBefore:    7 * 24 * time.Hour,
+		CheckInterval:  time.Hour,
+		RetryInterval:  30 * time.Second,
+		RequestTimeout: 10 * time.Second,
+	}
+}
+
+// Issuer is satisfied by client.GrpcClient.
+type Issuer interface {
+	IssueNodeCertificate(ctx context.Context, nodeIP string, csrPEM []byte) ([]byte, []byte, error)
+}
+
+type Manager struct {
+	config   IdentityConfig
+	dir      string
+	nodeIP   string
+	issuer   Issuer
+	key      *ecdsa.PrivateKey
+	keyPEM   []byte
+	notAfter time.Time
+}
+
+func NewManager(dir, nodeIP string, issuer Issuer, config IdentityConfig) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create identity directory %s: %w", dir, err)
+	}
+	m := &Manager{config: config, dir: dir, nodeIP: nodeIP, issuer: issuer}
+	if err := m.loadOrCreateKey(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Manager) loadOrCreateKey() error {
+	path := filepath.Join(m.dir, keyFile)
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("no PEM data in %s", path)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse node key %s: %w", path, err)
+		}
+		m.key, m.keyPEM = key, data
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read node key %s: %w", path, err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate node key: %w", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode node key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write node key %s: %w", path, err)
+	}
+	m.key, m.keyPEM = key, keyPEM
+	log.Printf("[Identity-INFO] Generated node key %s", path)
+	return nil
+}
+
+// Load installs the stored certificate, if there is a valid one, so the node
+// can talk to its peers before it reaches the controller.
+func (m *Manager) Load() error {
+	certPEM, err := os.ReadFile(filepath.Join(m.dir, certFile))
+	if err != nil {
+		return fmt.Errorf("failed to read node certificate: %w", err)
+	}
+	caPEM, err := os.ReadFile(filepath.Join(m.dir, caFile))
+	if err != nil {
+		return fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	return m.install(certPEM, caPEM)
+}
+
+// install checks that certPEM belongs to the node key, is issued for the node
+// IP and chains to caPEM, then hands it to the transport.
+func (m *Manager) install(certPEM, caPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, m.keyPEM)
+	if err != nil {
+		return fmt.Errorf("certificate does not match node key: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse node certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return errors.New("no CA certificate in response")
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return fmt.Errorf("node certificate does not verify against CA: %w", err)
+	}
+	if err := leaf.VerifyHostname(m.nodeIP); err != nil {
+		return fmt.Errorf("node certificate is not issued for %s: %w", m.nodeIP, err)
+	}
+
+	connection.SetNodeIdentity(cert, roots)
+	m.notAfter = leaf.NotAfter
+	log.Printf("[Identity-INFO] Installed node certificate for %s (expires %s)", m.nodeIP, leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// Renew requests a new certificate for the node key and installs it.
+func (m *Manager) Renew(ctx context.Context) error {
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: m.nodeIP},
+		IPAddresses: []net.IP{net.ParseIP(m.nodeIP)},
+	}, m.key)
+	if err != nil {
+		return fmt.Errorf("failed to create CSR: %w", err)
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	reqCtx, cancel := context.WithTimeout(ctx, m.config.RequestTimeout)
+	defer cancel()
+	certPEM, caPEM, err := m.issuer.IssueNodeCertificate(reqCtx, m.nodeIP, csrPEM)
+	if err != nil {
+		return err
+	}
+	if err := m.install(certPEM, caPEM); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(m.dir, certFile), certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to store node certificate: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.dir, caFile), caPEM, 0644); err != nil {
+		return fmt.Errorf("failed to store CA certificate: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) needsRenewal(now time.Time) bool {
+	return m.notAfter.IsZero() || now.Add(m.config.RenewBefore).After(m.notAfter)
+}
+
+// Run keeps the node certificate current until ctx is done.
+func (m *Manager) Run(ctx context.Context) {
+	for {
+		wait := m.config.CheckInterval
+		if m.needsRenewal(time.Now()) {
+			if err := m.Renew(ctx); err != nil {
+				log.Printf("[Identity-ERROR] Failed to renew node certificate: %v", err)
+				if time.Now().After(m.notAfter) {
+					wait = m.config.RetryInterval
+				}
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
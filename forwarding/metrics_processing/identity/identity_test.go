@@ -0,0 +1,151 @@
+package identity
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"forwarding/forwarder/connection"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeIssuer signs CSRs the way the controller does.
+type fakeIssuer struct {
+	caCert   *x509.Certificate
+	caKey    *ecdsa.PrivateKey
+	caPEM    []byte
+	validity time.Duration
+	issueIP  string // IP put in the certificate, the requested one if empty
+	calls    int
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &fakeIssuer{
+		caCert:   cert,
+		caKey:    key,
+		caPEM:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		validity: 12 * time.Hour,
+	}
+}
+
+func (f *fakeIssuer) IssueNodeCertificate(ctx context.Context, nodeIP string, csrPEM []byte) ([]byte, []byte, error) {
+	f.calls++
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		return nil, nil, errors.New("bad CSR")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	ip := nodeIP
+	if f.issueIP != "" {
+		ip = f.issueIP
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(f.calls + 1)),
+		Subject:      pkix.Name{CommonName: ip},
+		IPAddresses:  []net.IP{net.ParseIP(ip)},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(f.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, f.caCert, csr.PublicKey, f.caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), f.caPEM, nil
+}
+
+func TestRenewAndReload(t *testing.T) {
+	dir := t.TempDir()
+	issuer := newFakeIssuer(t)
+
+	m, err := NewManager(dir, "10.0.0.1", issuer, DefaultIdentityConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Load(); err == nil {
+		t.Fatal("expected Load to fail before any certificate was issued")
+	}
+	if err := m.Renew(context.Background()); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if !connection.HasNodeIdentity() {
+		t.Fatal("expected the certificate to be installed in the transport")
+	}
+
+	// A restarted node reuses its key and stored certificate
+	restarted, err := NewManager(dir, "10.0.0.1", issuer, DefaultIdentityConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Load(); err != nil {
+		t.Fatalf("Load after restart failed: %v", err)
+	}
+	if issuer.calls != 1 {
+		t.Errorf("expected a single certificate request, got %d", issuer.calls)
+	}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	config := DefaultIdentityConfig()
+	config.RenewBefore = time.Hour
+
+	m, err := NewManager(t.TempDir(), "10.0.0.1", issuer, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.needsRenewal(time.Now()) {
+		t.Error("expected renewal without a certificate")
+	}
+	if err := m.Renew(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if m.needsRenewal(time.Now()) {
+		t.Error("did not expect renewal right after issuance")
+	}
+	if !m.needsRenewal(time.Now().Add(issuer.validity - 30*time.Minute)) {
+		t.Error("expected renewal within RenewBefore of expiry")
+	}
+}
+
+func TestRenewRejectsCertificateForOtherIP(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	issuer.issueIP = "10.0.0.2"
+
+	m, err := NewManager(t.TempDir(), "10.0.0.1", issuer, DefaultIdentityConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Renew(context.Background()); err == nil {
+		t.Fatal("expected a certificate not issued for the node IP to be rejected")
+	}
+}
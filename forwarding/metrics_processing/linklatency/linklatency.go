@@ -0,0 +1,94 @@
+package linklatency
+
+import (
+	"forwarding/metrics_processing/protocol"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The access node measures the round trip time of every link its requests
+// travel over from the hop stamps their responses bring back. The samples are
+// summed per link and reported with the next controller sync, next to the
+// TCP-connect probes, then started afresh.
+
+type link struct {
+	source string
+	target string
+}
+
+type summary struct {
+	total   time.Duration
+	min     time.Duration
+	samples int64
+}
+
+type Recorder struct {
+	mu    sync.Mutex
+	links map[link]*summary
+}
+
+var (
+	recorderInstance *Recorder
+	recorderOnce     sync.Once
+)
+
+func GetRecorder() *Recorder {
+	recorderOnce.Do(func() {
+		recorderInstance = newRecorder()
+	})
+	return recorderInstance
+}
+
+func newRecorder() *Recorder {
+	return &Recorder{links: make(map[link]*summary)}
+}
+
+// Record adds a round trip time measured on the link from source to target.
+func (r *Recorder) Record(source, target string, rtt time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := link{source, target}
+	s, ok := r.links[key]
+	if !ok {
+		s = &summary{min: rtt}
+		r.links[key] = s
+	}
+	s.total += rtt
+	s.samples++
+	if rtt < s.min {
+		s.min = rtt
+	}
+}
+
+// Collect returns the links measured since the last Collect, ordered by
+// source and target, and starts over.
+func (r *Recorder) Collect() []*protocol.LinkLatency {
+	r.mu.Lock()
+	links := r.links
+	r.links = make(map[link]*summary, len(links))
+	r.mu.Unlock()
+
+	latencies := make([]*protocol.LinkLatency, 0, len(links))
+	for key, s := range links {
+		latencies = append(latencies, &protocol.LinkLatency{
+			SourceIp: key.source,
+			TargetIp: key.target,
+			RttMs:    milliseconds(s.total) / float64(s.samples),
+			MinRttMs: milliseconds(s.min),
+			Samples:  s.samples,
+		})
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		if latencies[i].SourceIp != latencies[j].SourceIp {
+			return latencies[i].SourceIp < latencies[j].SourceIp
+		}
+		return latencies[i].TargetIp < latencies[j].TargetIp
+	})
+	return latencies
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,29 @@
+package linklatency
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecorderSummarisesLinksUntilCollected(t *testing.T) {
+	r := newRecorder()
+	r.Record("10.0.0.2", "10.0.0.3", 30*time.Millisecond)
+	r.Record("10.0.0.1", "10.0.0.2", 12*time.Millisecond)
+	r.Record("10.0.0.1", "10.0.0.2", 8*time.Millisecond)
+
+	latencies := r.Collect()
+	if len(latencies) != 2 {
+		t.Fatalf("expected 2 links, got %v", latencies)
+	}
+	first := latencies[0]
+	if first.SourceIp != "10.0.0.1" || first.TargetIp != "10.0.0.2" || first.RttMs != 10 || first.MinRttMs != 8 || first.Samples != 2 {
+		t.Errorf("first link %v", first)
+	}
+	if second := latencies[1]; second.SourceIp != "10.0.0.2" || second.RttMs != 30 || second.Samples != 1 {
+		t.Errorf("second link %v", second)
+	}
+
+	if latencies := r.Collect(); len(latencies) != 0 {
+		t.Errorf("links reported twice: %v", latencies)
+	}
+}
@@ -1,8 +1,11 @@
 package probe
 
 import (
+	"errors"
 	"forwarding/common"
 	"forwarding/metrics_processing/collector"
+	"forwarding/metrics_processing/exporter"
+	"forwarding/metrics_processing/fault"
 	"forwarding/metrics_processing/protocol"
 	"forwarding/metrics_processing/storage"
 	"forwarding/router"
@@ -11,16 +14,74 @@ import (
 	"math"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// ProbeTimeout is the maximum time to wait for a TCP probe
-var ProbeTimeout = 2 * time.Second
+// Config sets how targets are probed. Nodes are probed on the port of their
+// gRPC service, origins on their HTTP port.
+type Config struct {
+	Timeout    time.Duration // maximum time to wait for a TCP probe
+	NodePort   string
+	OriginPort string
+}
+
+func DefaultConfig() Config {
+	return Config{Timeout: 2 * time.Second, NodePort: "50051", OriginPort: "80"}
+}
+
+var config atomic.Pointer[Config]
+
+func init() {
+	SetConfig(DefaultConfig())
+}
+
+// SetConfig replaces the probe settings from the next probe round on.
+func SetConfig(c Config) {
+	config.Store(&c)
+}
+
+var errNoRoute = errors.New("no route to address family")
+
+var (
+	probeRTT = exporter.Default.Gauge("arcturus_probe_rtt_seconds",
+		"TCP connect time of the last successful probe per target.", "target")
+	probesTotal = exporter.Default.Counter("arcturus_probes_total",
+		"TCP probes per target and result.", "target", "result")
+)
+
+// recordProbe exports a probe result; a failed probe removes the target's RTT.
+func recordProbe(targetIP string, tcpDelay int64) {
+	if tcpDelay < 0 {
+		probeRTT.Delete(targetIP)
+		probesTotal.With(targetIP, "failure").Inc()
+		return
+	}
+	probeRTT.With(targetIP).Set(float64(tcpDelay) / 1000)
+	probesTotal.With(targetIP, "success").Inc()
+}
+
+// hasRouteTo reports whether this node has a route to targetIP at all, e.g.
+// false for IPv6 targets on an IPv4-only host. Connecting a UDP socket sends
+// nothing but fails straight away when there is no route.
+func hasRouteTo(targetIP string) bool {
+	conn, err := net.Dial("udp", net.JoinHostPort(targetIP, "9"))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
 
 func performTCPProbe(targetIP string, port string) (int64, error) {
-	timeoutDuration := ProbeTimeout
+	// Unreachable address families are not reported, so they neither become
+	// links nor count as faults of the target
+	if !hasRouteTo(targetIP) {
+		return -1, errNoRoute
+	}
+	timeoutDuration := config.Load().Timeout
 	startTime := time.Now()
-	conn, err := net.DialTimeout("tcp", targetIP+":"+port, timeoutDuration)
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(targetIP, port), timeoutDuration)
 	if err != nil {
 		return -1, nil
 	}
@@ -110,6 +171,7 @@ func CollectRegionProbeResults(fileManager *storage.FileManager) ([]*protocol.Re
 
 		return []*protocol.RegionProbeResult{}, nil
 	}
+	settings := config.Load()
 	ipToRegion := make(map[string]string)
 	for _, node := range nodeList.Nodes {
 		ipToRegion[node.Ip] = node.Region
@@ -137,11 +199,13 @@ func CollectRegionProbeResults(fileManager *storage.FileManager) ([]*protocol.Re
 				log.Printf(": IP %s ，'unknown'", taskCopy.TargetIp)
 				targetRegion = "unknown"
 			}
-			tcpDelay, err := performTCPProbe(taskCopy.TargetIp, "50051")
+			tcpDelay, err := performTCPProbe(taskCopy.TargetIp, settings.NodePort)
 			if err != nil {
 				log.Printf(" %s : %v", taskCopy.TargetIp, err)
 				return
 			}
+			fault.GetDetector().RecordProbeResult(taskCopy.TargetIp, tcpDelay >= 0)
+			recordProbe(taskCopy.TargetIp, tcpDelay)
 			probeResult := &protocol.ProbeResult{
 				TargetIp: taskCopy.TargetIp,
 				TcpDelay: tcpDelay,
@@ -176,11 +240,13 @@ func CollectRegionProbeResults(fileManager *storage.FileManager) ([]*protocol.Re
 					log.Printf("Region lookup: IP %s (from domain %s), Region 'unknown'", targetIP, mappingCopy.Domain)
 					sourceRegion = "unknown"
 				}
-				tcpDelay, probeErr := performTCPProbe(targetIP, "80")
+				tcpDelay, probeErr := performTCPProbe(targetIP, settings.OriginPort)
 				if probeErr != nil {
 					log.Printf("TCP Probe Error for %s (from domain %s): %v", targetIP, mappingCopy.Domain, probeErr)
 					return
 				}
+				fault.GetDetector().RecordProbeResult(targetIP, tcpDelay >= 0)
+				recordProbe(targetIP, tcpDelay)
 				probeResult := &protocol.ProbeResult{
 					TargetIp: targetIP,
 					TcpDelay: tcpDelay,
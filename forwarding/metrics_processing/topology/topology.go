@@ -36,8 +36,9 @@ func ProcessRegionAssessments(regionAssessments []*protocol.RegionPairAssessment
 	ipToRegion := make(map[string]string)
 
 	for _, node := range nodeList.Nodes {
-		regionToIPs[node.Region] = append(regionToIPs[node.Region], node.Ip)
-		ipToRegion[node.Ip] = node.Region
+		ip := t.CanonicalIP(node.Ip)
+		regionToIPs[node.Region] = append(regionToIPs[node.Region], ip)
+		ipToRegion[ip] = node.Region
 	}
 
 	for _, regionPair := range regionAssessments {
@@ -60,10 +61,11 @@ func ProcessRegionAssessments(regionAssessments []*protocol.RegionPairAssessment
 				log.Printf(" %s->%s : %.2f", sourceRegion, targetRegion, defaultAssessment)
 			} else {
 
-				if _, exists := outlierPairs[ipPair.Ip1]; !exists {
-					outlierPairs[ipPair.Ip1] = make(map[string]float32)
+				ip1, ip2 := t.CanonicalIP(ipPair.Ip1), t.CanonicalIP(ipPair.Ip2)
+				if _, exists := outlierPairs[ip1]; !exists {
+					outlierPairs[ip1] = make(map[string]float32)
 				}
-				outlierPairs[ipPair.Ip1][ipPair.Ip2] = ipPair.Assessment
+				outlierPairs[ip1][ip2] = ipPair.Assessment
 
 			}
 		}
@@ -98,6 +100,13 @@ func ProcessRegionAssessments(regionAssessments []*protocol.RegionPairAssessment
 					assessmentValue = defaultAssessment
 				}
 
+				if !t.SameFamily(sourceIP, targetIP) {
+					// Whether an IPv4 node can reach an IPv6 one (or the reverse) is only known from a probe
+					if _, measured := outlierPairs[sourceIP][targetIP]; !measured {
+						continue
+					}
+				}
+
 				topology.AddLink(sourceIP, targetIP, assessmentValue)
 			}
 		}
@@ -0,0 +1,38 @@
+package topology
+
+import (
+	"forwarding/metrics_processing/protocol"
+	"testing"
+)
+
+func TestProcessRegionAssessmentsAddressFamilies(t *testing.T) {
+	nodeList := &protocol.NodeList{Nodes: []*protocol.NodeInfo{
+		{Ip: "10.0.0.1", Region: "east"},
+		{Ip: "2001:DB8:0::2", Region: "west"},
+		{Ip: "2001:DB8::3", Region: "west"},
+		{Ip: "10.0.0.4", Region: "west"},
+	}}
+	assessments := []*protocol.RegionPairAssessment{{
+		Region1: "east",
+		Region2: "west",
+		IpPairs: []*protocol.IPPairAssessment{
+			{Ip1: "default", Ip2: "default", Assessment: 50},
+			{Ip1: "10.0.0.1", Ip2: "2001:db8::2", Assessment: 70}, // measured across families
+		},
+	}}
+
+	topology, err := ProcessRegionAssessments(assessments, nodeList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w, ok := topology.GetLinkWeight("10.0.0.1", "10.0.0.4"); !ok || w != 50 {
+		t.Errorf("IPv4 link = %v, %v; want 50", w, ok)
+	}
+	if w, ok := topology.GetLinkWeight("10.0.0.1", "2001:db8::2"); !ok || w != 70 {
+		t.Errorf("measured IPv4->IPv6 link = %v, %v; want 70", w, ok)
+	}
+	if _, ok := topology.GetLinkWeight("10.0.0.1", "2001:db8::3"); ok {
+		t.Error("expected no assumed link between address families")
+	}
+}
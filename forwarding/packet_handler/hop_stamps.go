@@ -0,0 +1,106 @@
+package packet
+
+import (
+	"net/netip"
+	"time"
+)
+
+// Hop stamps measure the latency of each link a request and its response
+// travel over. The access node starts the list with the time it sends the
+// request; every relay appends when it received and passed on the request
+// and, on the way back, the response. Times are microseconds of the stamping
+// node's own clock cut to 32 bits, so only differences between two stamps of
+// the same node mean anything. Packets with stamps set the stamps bit of
+// PacketType and carry them after PacketID: a count byte, three reserved
+// bytes, then 12 bytes per stamp.
+const (
+	StampRequest  byte = 0
+	StampResponse byte = 1
+
+	stampSize = 12
+)
+
+type HopStamp struct {
+	Hop       byte // position of the stamping node in HopList
+	Direction byte // StampRequest or StampResponse
+	Received  uint32
+	Sent      uint32
+}
+
+// StampTime converts t to the clock of hop stamps; the zero time is 0.
+func StampTime(t time.Time) uint32 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint32(t.UnixMicro())
+}
+
+func stampsLen(count int) int {
+	if count == 0 {
+		return 0
+	}
+	return 4 + count*stampSize
+}
+
+// StartStamps replaces the packet's stamps with the first one, that of the
+// access node sending the request at sent.
+func (p *Packet) StartStamps(sent time.Time) {
+	p.HopStamps = []HopStamp{{Hop: 0, Direction: StampRequest, Sent: StampTime(sent)}}
+}
+
+// AddStamp appends the stamp of the node at position hop. Packets without
+// stamps are left alone: only the access node starts a list.
+func (p *Packet) AddStamp(direction, hop byte, received, sent time.Time) {
+	if len(p.HopStamps) == 0 {
+		return
+	}
+	p.HopStamps = append(p.HopStamps, HopStamp{Hop: hop, Direction: direction, Received: StampTime(received), Sent: StampTime(sent)})
+}
+
+// LinkRTT is the round trip time of the link from From to To.
+type LinkRTT struct {
+	From netip.Addr
+	To   netip.Addr
+	RTT  time.Duration
+}
+
+// LinkRTTs works out the round trip time of each link between the access node
+// and the last relay from the stamps of a response that reached the access
+// node at receivedAt. The time the far end held the request and response is
+// taken out, so every link is measured with the clocks of its own two ends.
+// Links with missing or inconsistent stamps are left out.
+func (p *Packet) LinkRTTs(receivedAt time.Time) []LinkRTT {
+	if len(p.HopStamps) == 0 || len(p.HopList) < 3 {
+		return nil
+	}
+	requests := make(map[byte]HopStamp, len(p.HopStamps))
+	responses := make(map[byte]HopStamp, len(p.HopStamps))
+	for _, stamp := range p.HopStamps {
+		if stamp.Direction == StampResponse {
+			responses[stamp.Hop] = stamp
+		} else {
+			requests[stamp.Hop] = stamp
+		}
+	}
+	responses[0] = HopStamp{Direction: StampResponse, Received: StampTime(receivedAt)}
+
+	lastRelay := len(p.HopList) - 2 // the last hop is the origin
+	var rtts []LinkRTT
+	for hop := 0; hop < lastRelay && hop < 255; hop++ {
+		near, ok1 := requests[byte(hop)]
+		nearBack, ok2 := responses[byte(hop)]
+		far, ok3 := requests[byte(hop+1)]
+		farBack, ok4 := responses[byte(hop+1)]
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			continue
+		}
+		// uint32 differences stay right across the wrap of the stamp clock
+		total := int32(nearBack.Received - near.Sent)
+		held := int32(farBack.Sent - far.Received)
+		if held < 0 || total < held {
+			continue
+		}
+		rtts = append(rtts, LinkRTT{From: p.HopList[hop], To: p.HopList[hop+1], RTT: time.Duration(total-held) * time.Microsecond})
+	}
+	return rtts
+}
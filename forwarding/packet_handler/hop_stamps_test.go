@@ -0,0 +1,80 @@
+package packet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPackUnpackHopStamps(t *testing.T) {
+	for _, hops := range [][]string{
+		{"10.0.0.1", "10.0.0.2", "10.0.0.3", "192.0.2.10"},
+		{"10.0.0.1", "2001:db8::2", "10.0.0.3", "192.0.2.10"},
+	} {
+		header, err := NewPacket(hops, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header.StartStamps(time.UnixMicro(1000))
+		header.AddStamp(StampRequest, 1, time.UnixMicro(2000), time.UnixMicro(2100))
+		header.AddStamp(StampRequest, 2, time.UnixMicro(3000), time.Time{})
+		data, err := header.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data)%4 != 0 || int(header.HeaderLen) != len(data) {
+			t.Errorf("header length %d for %d bytes", header.HeaderLen, len(data))
+		}
+		if IsStreamPacket(data) || IsTunnelPacket(data) {
+			t.Error("stamped data packet taken for another type")
+		}
+
+		got, err := Unpack(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.PacketType != PacketTypeData || len(got.HopList) != len(hops) || got.HopList[1].String() != hops[1] {
+			t.Fatalf("unexpected header %+v", got)
+		}
+		if !reflect.DeepEqual(got.HopStamps, header.HopStamps) {
+			t.Errorf("stamps %+v, want %+v", got.HopStamps, header.HopStamps)
+		}
+	}
+
+	header, _ := NewPacket([]string{"10.0.0.1", "10.0.0.2"}, 1)
+	header.AddStamp(StampRequest, 1, time.Now(), time.Now())
+	if len(header.HopStamps) != 0 {
+		t.Error("stamp added to a packet the access node did not stamp")
+	}
+}
+
+func TestLinkRTTs(t *testing.T) {
+	// Each node has its own clock; only the time spent on the links counts.
+	clocks := []time.Time{time.UnixMicro(1<<32 - 50), time.UnixMicro(7000000), time.UnixMicro(123)}
+	at := func(node int, us int64) time.Time { return clocks[node].Add(time.Duration(us) * time.Microsecond) }
+
+	header, _ := NewPacket([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "192.0.2.10"}, 1)
+	header.StartStamps(at(0, 0))
+	header.AddStamp(StampRequest, 1, at(1, 0), at(1, 100)) // link 0 takes 5ms each way
+	header.AddStamp(StampRequest, 2, at(2, 0), time.Time{})
+	header.AddStamp(StampResponse, 2, time.Time{}, at(2, 40000)) // link 1 takes 20ms each way
+	header.AddStamp(StampResponse, 1, at(1, 80100), at(1, 80400))
+
+	// relay 1 gets the response back at 100+20000+40000+20000 by its clock and
+	// the access node 5000+80400+5000 after sending the request
+	rtts := header.LinkRTTs(at(0, 90400))
+	if len(rtts) != 2 {
+		t.Fatalf("expected 2 links, got %+v", rtts)
+	}
+	if rtts[0].From.String() != "10.0.0.1" || rtts[0].To.String() != "10.0.0.2" || rtts[0].RTT != 10*time.Millisecond {
+		t.Errorf("link 0: %+v", rtts[0])
+	}
+	if rtts[1].From.String() != "10.0.0.2" || rtts[1].To.String() != "10.0.0.3" || rtts[1].RTT != 40*time.Millisecond {
+		t.Errorf("link 1: %+v", rtts[1])
+	}
+
+	header.HopStamps = header.HopStamps[:3] // response lost its relay stamps
+	if rtts := header.LinkRTTs(at(0, 90400)); len(rtts) != 0 {
+		t.Errorf("links measured from missing stamps: %+v", rtts)
+	}
+}
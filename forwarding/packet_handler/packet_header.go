@@ -4,10 +4,80 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
-	"net"
+	"math"
+	"net/netip"
 )
 
+// Header versions. Version 1 carries every hop as a 32-bit IPv4 address and
+// is what all nodes understand; version 2 carries 128-bit hops and is used
+// whenever a path contains an IPv6 address. The version travels in the top
+// bit of PacketType, so version 1 headers are unchanged on the wire.
+const (
+	HeaderVersion1 byte = 1
+	HeaderVersion2 byte = 2
+
+	headerVersion2Flag byte = 0x80
+	headerStampsFlag   byte = 0x40
+
+	headerFlags = headerVersion2Flag | headerStampsFlag
+)
+
+// Priority values carried in the header, one per traffic class. Zero is the
+// standard class so headers from nodes that never set Priority keep their
+// old treatment.
+const (
+	PriorityStandard    byte = 0
+	PriorityInteractive byte = 1
+	PriorityBulk        byte = 2
+)
+
+// NormalizePriority maps unknown Priority values to PriorityStandard.
+func NormalizePriority(priority byte) byte {
+	switch priority {
+	case PriorityInteractive, PriorityBulk:
+		return priority
+	}
+	return PriorityStandard
+}
+
+// Payload codecs. The low nibble of Property names the codec the packet's
+// payload is compressed with; the high nibble is the set of codecs the sender
+// accepts from its peer, bit n-1 standing for codec n. A zero Property, which
+// is what older nodes send, means a raw payload and no compression wanted.
+const (
+	CodecNone    byte = 0
+	CodecDeflate byte = 1
+
+	maxCodec byte = 4
+
+	// PropertyOffset is the position of Property in a packed header.
+	PropertyOffset = 10
+)
+
+// Codec returns the codec of the packet's payload.
+func (p *Packet) Codec() byte {
+	return p.Property & 0x0f
+}
+
+// AcceptsCodec reports whether the sender of the packet accepts payloads compressed with codec.
+func (p *Packet) AcceptsCodec(codec byte) bool {
+	return codec != CodecNone && codec <= maxCodec && p.Property&(1<<(codec+3)) != 0
+}
+
+// MakeProperty builds a Property byte for a payload compressed with codec
+// from a sender that accepts the given codecs.
+func MakeProperty(codec byte, accepted ...byte) byte {
+	property := codec & 0x0f
+	for _, c := range accepted {
+		if c != CodecNone && c <= maxCodec {
+			property |= 1 << (c + 3)
+		}
+	}
+	return property
+}
+
 type Packet struct {
 	Length      uint16
 	HeaderLen   uint16
@@ -20,7 +90,37 @@ type Packet struct {
 	Offsets     []uint16
 	Padding     []byte
 	PacketID    []uint32
-	HopList     []uint32
+	HopStamps   []HopStamp
+	HopList     []netip.Addr
+}
+
+// Version returns the header version Pack uses for the packet's HopList.
+func (p *Packet) Version() byte {
+	for _, hop := range p.HopList {
+		if !hop.Is4() {
+			return HeaderVersion2
+		}
+	}
+	return HeaderVersion1
+}
+
+func hopSize(version byte) int {
+	if version == HeaderVersion2 {
+		return 16
+	}
+	return 4
+}
+
+// PackedHeaderLen returns the header length, padding included, that Pack
+// gives a packet of packetCount bodies along hopList without hop stamps.
+func PackedHeaderLen(packetCount int, hopList []netip.Addr) int {
+	offsets := 0
+	if packetCount > 1 {
+		offsets = packetCount - 1
+	}
+	version := (&Packet{HopList: hopList}).Version()
+	headerLen := 2 + 2 + 4 + 1 + 1 + 1 + 1 + 1 + offsets*2 + packetCount*4 + len(hopList)*hopSize(version)
+	return headerLen + (4-headerLen%4)%4
 }
 
 func (p *Packet) Pack() ([]byte, error) {
@@ -35,14 +135,21 @@ func (p *Packet) Pack() ([]byte, error) {
 	if len(p.Offsets) != expectedOffsets {
 		return nil, fmt.Errorf("offsets %d  %d ", len(p.Offsets), expectedOffsets)
 	}
+	if len(p.HopStamps) > 255 {
+		return nil, fmt.Errorf("%d hop stamps, at most 255 fit in a header", len(p.HopStamps))
+	}
 
 	fixedHeaderLen := 2 + 2 + 4 + 1 + 1 + 1 + 1 + 1
 
-	variableLen := len(p.Offsets)*2 + len(p.PacketID)*4 + len(p.HopList)*4
+	version := p.Version()
+	variableLen := len(p.Offsets)*2 + len(p.PacketID)*4 + stampsLen(len(p.HopStamps)) + len(p.HopList)*hopSize(version)
 
 	headerLen := fixedHeaderLen + variableLen
 
 	paddingLen := (4 - (headerLen % 4)) % 4
+	if int(p.Length)+headerLen+paddingLen > math.MaxUint16 {
+		return nil, fmt.Errorf("%d body bytes and a %d-byte header exceed the 16-bit Length", p.Length, headerLen+paddingLen)
+	}
 	p.HeaderLen = uint16(headerLen + paddingLen)
 
 	p.Length = p.Length + p.HeaderLen
@@ -60,7 +167,14 @@ func (p *Packet) Pack() ([]byte, error) {
 	if err := binary.Write(&buf, binary.BigEndian, p.Timestamp); err != nil {
 		return nil, err
 	}
-	if err := binary.Write(&buf, binary.BigEndian, p.PacketType); err != nil {
+	packetType := p.PacketType
+	if version == HeaderVersion2 {
+		packetType |= headerVersion2Flag
+	}
+	if len(p.HopStamps) > 0 {
+		packetType |= headerStampsFlag
+	}
+	if err := binary.Write(&buf, binary.BigEndian, packetType); err != nil {
 		return nil, err
 	}
 	if err := binary.Write(&buf, binary.BigEndian, p.Priority); err != nil {
@@ -92,8 +206,24 @@ func (p *Packet) Pack() ([]byte, error) {
 		}
 	}
 
-	if err := binary.Write(&buf, binary.BigEndian, p.HopList); err != nil {
-		return nil, err
+	if len(p.HopStamps) > 0 {
+		buf.Write([]byte{byte(len(p.HopStamps)), 0, 0, 0})
+		for _, stamp := range p.HopStamps {
+			buf.Write([]byte{stamp.Hop, stamp.Direction, 0, 0})
+			if err := binary.Write(&buf, binary.BigEndian, [2]uint32{stamp.Received, stamp.Sent}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, hop := range p.HopList {
+		if version == HeaderVersion2 {
+			a := hop.As16()
+			buf.Write(a[:])
+		} else {
+			a := hop.As4()
+			buf.Write(a[:])
+		}
 	}
 
 	return buf.Bytes(), nil
@@ -115,6 +245,12 @@ func Unpack(data []byte) (*Packet, error) {
 	if err := binary.Read(buf, binary.BigEndian, &packet.PacketType); err != nil {
 		return nil, err
 	}
+	version := HeaderVersion1
+	if packet.PacketType&headerVersion2Flag != 0 {
+		version = HeaderVersion2
+	}
+	hasStamps := packet.PacketType&headerStampsFlag != 0
+	packet.PacketType &^= headerFlags
 	if err := binary.Read(buf, binary.BigEndian, &packet.Priority); err != nil {
 		return nil, err
 	}
@@ -164,13 +300,43 @@ func Unpack(data []byte) (*Packet, error) {
 		}
 	}
 
-	remainingBytes := int(packet.HeaderLen) - (fixedHeaderLen + len(packet.Offsets)*2 + paddingSize + len(packet.PacketID)*4)
-	hopListCount := remainingBytes / 4
+	stampBytes := 0
+	if hasStamps {
+		var count [4]byte
+		if _, err := io.ReadFull(buf, count[:]); err != nil {
+			return nil, err
+		}
+		packet.HopStamps = make([]HopStamp, count[0])
+		stampBytes = 4 + len(packet.HopStamps)*stampSize
+		raw := make([]byte, stampSize)
+		for i := range packet.HopStamps {
+			if _, err := io.ReadFull(buf, raw); err != nil {
+				return nil, err
+			}
+			packet.HopStamps[i] = HopStamp{
+				Hop:       raw[0],
+				Direction: raw[1],
+				Received:  binary.BigEndian.Uint32(raw[4:8]),
+				Sent:      binary.BigEndian.Uint32(raw[8:12]),
+			}
+		}
+	}
+
+	remainingBytes := int(packet.HeaderLen) - (fixedHeaderLen + len(packet.Offsets)*2 + paddingSize + len(packet.PacketID)*4 + stampBytes)
+	hopListCount := remainingBytes / hopSize(version)
 
 	if hopListCount > 0 {
-		packet.HopList = make([]uint32, hopListCount)
-		if err := binary.Read(buf, binary.BigEndian, packet.HopList); err != nil {
-			return nil, err
+		packet.HopList = make([]netip.Addr, hopListCount)
+		raw := make([]byte, hopSize(version))
+		for i := range packet.HopList {
+			if _, err := io.ReadFull(buf, raw); err != nil {
+				return nil, err
+			}
+			if version == HeaderVersion2 {
+				packet.HopList[i] = netip.AddrFrom16([16]byte(raw)).Unmap()
+			} else {
+				packet.HopList[i] = netip.AddrFrom4([4]byte(raw))
+			}
 		}
 	}
 
@@ -178,19 +344,15 @@ func Unpack(data []byte) (*Packet, error) {
 }
 
 func NewPacket(hopList []string, packetID uint32) (*Packet, error) {
-	var uint32HopList []uint32
-	for _, ip := range hopList {
-		ipUint32, err := IpToUint32(ip)
-		if err != nil {
-			return nil, err
-		}
-		uint32HopList = append(uint32HopList, ipUint32)
+	hops, err := ParseHopList(hopList)
+	if err != nil {
+		return nil, err
 	}
 
 	packet := &Packet{
 		Length:      50,
 		Timestamp:   1617916800,
-		PacketType:  1,
+		PacketType:  PacketTypeData,
 		Priority:    0,
 		Property:    0,
 		HopCounts:   1,
@@ -198,13 +360,13 @@ func NewPacket(hopList []string, packetID uint32) (*Packet, error) {
 		Offsets:     []uint16{},
 
 		PacketID: []uint32{packetID},
-		HopList:  uint32HopList,
+		HopList:  hops,
 	}
 
 	return packet, nil
 }
 
-func NewMergedPacket(packetIDs []uint32, requestSizes []int, hopList []uint32, packetType byte) *Packet {
+func NewMergedPacket(packetIDs []uint32, requestSizes []int, hopList []netip.Addr, packetType byte) *Packet {
 
 	totalSize := 0
 	for _, size := range requestSizes {
@@ -267,7 +429,7 @@ func GetRequestPositions(p *Packet, bodyLength int) []int {
 	return positions
 }
 
-func IsCommonBackwardPath(hopList1, hopList2 []uint32) bool {
+func IsCommonBackwardPath(hopList1, hopList2 []netip.Addr) bool {
 
 	if len(hopList1) == 0 || len(hopList2) == 0 {
 		return false
@@ -289,24 +451,29 @@ func IsCommonBackwardPath(hopList1, hopList2 []uint32) bool {
 	return true
 }
 
-func IpToUint32(ip string) (uint32, error) {
-	parsedIP := net.ParseIP(ip)
-	if parsedIP == nil {
-		return 0, fmt.Errorf("invalid IP address")
+// ParseHop parses an IPv4 or IPv6 address into the form carried in HopList.
+// IPv4-mapped IPv6 addresses are treated as IPv4.
+func ParseHop(ip string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid IP address %q", ip)
 	}
-
-	ipv4 := parsedIP.To4()
-	if ipv4 == nil {
-		return 0, fmt.Errorf("not an IPv4 address")
+	if addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("zoned IP address %q cannot be a hop", ip)
 	}
-
-	return binary.BigEndian.Uint32(ipv4), nil
+	return addr.Unmap(), nil
 }
 
-func Uint32ToIP(ipUint32 uint32) string {
-	ip := make([]byte, 4)
-	binary.BigEndian.PutUint32(ip, ipUint32)
-	return fmt.Sprintf("%d.%d.%d.%d", ip[0], ip[1], ip[2], ip[3])
+func ParseHopList(ips []string) ([]netip.Addr, error) {
+	hops := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		hop, err := ParseHop(ip)
+		if err != nil {
+			return nil, err
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
 }
 
 func (p *Packet) GetNextHopIP() (string, bool, error) {
@@ -318,8 +485,8 @@ func (p *Packet) GetNextHopIP() (string, bool, error) {
 	log.Printf("[PACKET] : HopCounts=%d, HopList=%d", p.HopCounts, len(p.HopList))
 
 	var ipAddresses []string
-	for _, ipUint32 := range p.HopList {
-		ipAddresses = append(ipAddresses, Uint32ToIP(ipUint32))
+	for _, hop := range p.HopList {
+		ipAddresses = append(ipAddresses, hop.String())
 	}
 
 	nextHopIndex := int(p.HopCounts)
@@ -356,14 +523,14 @@ func (p *Packet) GetPreviousHopIP() (string, bool, error) {
 
 		if len(p.HopList) > 0 {
 			// （Access）
-			return Uint32ToIP(p.HopList[0]), true, nil
+			return p.HopList[0].String(), true, nil
 		}
 		return "", false, fmt.Errorf(" %d  (HopList=%d)", previousHopIndex, len(p.HopList))
 	}
 
 	var ipAddresses []string
-	for _, ipUint32 := range p.HopList {
-		ipAddresses = append(ipAddresses, Uint32ToIP(ipUint32))
+	for _, hop := range p.HopList {
+		ipAddresses = append(ipAddresses, hop.String())
 	}
 
 	previousHopIP := ipAddresses[previousHopIndex]
@@ -0,0 +1,123 @@
+package packet
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestPackUnpackHeaderVersions(t *testing.T) {
+	cases := []struct {
+		name    string
+		hops    []string
+		version byte
+	}{
+		{"IPv4 path", []string{"10.0.0.1", "10.0.0.2", "192.0.2.10"}, HeaderVersion1},
+		{"IPv6 relay", []string{"10.0.0.1", "2001:db8::2", "192.0.2.10"}, HeaderVersion2},
+		{"IPv6 only", []string{"2001:db8::1", "2001:db8::2", "2001:db8:ffff::10"}, HeaderVersion2},
+	}
+	for _, c := range cases {
+		header, err := NewPacket(c.hops, 7)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		header.HopCounts = 2
+		if header.Version() != c.version {
+			t.Errorf("%s: version = %d, want %d", c.name, header.Version(), c.version)
+		}
+		data, err := header.Pack()
+		if err != nil {
+			t.Fatalf("%s: pack failed: %v", c.name, err)
+		}
+		if len(data)%4 != 0 || int(header.HeaderLen) != len(data) {
+			t.Errorf("%s: header length %d for %d bytes", c.name, header.HeaderLen, len(data))
+		}
+		if n := PackedHeaderLen(1, header.HopList); n != len(data) {
+			t.Errorf("%s: PackedHeaderLen = %d, packed %d bytes", c.name, n, len(data))
+		}
+
+		got, err := Unpack(data)
+		if err != nil {
+			t.Fatalf("%s: unpack failed: %v", c.name, err)
+		}
+		if got.PacketType != PacketTypeData || got.PacketID[0] != 7 || len(got.HopList) != len(c.hops) {
+			t.Fatalf("%s: unexpected header %+v", c.name, got)
+		}
+		for i, hop := range got.HopList {
+			if hop.String() != c.hops[i] {
+				t.Errorf("%s: hop %d = %s, want %s", c.name, i, hop, c.hops[i])
+			}
+		}
+		next, isLast, err := got.GetNextHopIP()
+		if err != nil || next != c.hops[2] || !isLast {
+			t.Errorf("%s: GetNextHopIP = %s, %v, %v", c.name, next, isLast, err)
+		}
+	}
+}
+
+func TestVersion1HeaderWireFormat(t *testing.T) {
+	header, err := NewPacket([]string{"10.0.0.1", "10.0.0.2"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := header.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Older nodes read the PacketType byte as is and the hops as 32-bit addresses
+	if data[8] != PacketTypeData {
+		t.Errorf("PacketType byte = %#x, want %#x", data[8], PacketTypeData)
+	}
+	if !bytes.HasSuffix(data, []byte{10, 0, 0, 1, 10, 0, 0, 2}) {
+		t.Errorf("unexpected hop encoding: %x", data)
+	}
+}
+
+func TestPackRejectsLengthOverflow(t *testing.T) {
+	header, err := NewPacket([]string{"10.0.0.1", "10.0.0.2"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.Length = uint16(math.MaxUint16 - PackedHeaderLen(1, header.HopList) + 1)
+	if _, err := header.Pack(); err == nil {
+		t.Error("expected an error for a Length that does not fit 16 bits")
+	}
+}
+
+func TestParseHop(t *testing.T) {
+	hop, err := ParseHop("::ffff:10.0.0.1")
+	if err != nil || !hop.Is4() || hop.String() != "10.0.0.1" {
+		t.Errorf("IPv4-mapped address parsed as %s, %v", hop, err)
+	}
+	for _, ip := range []string{"", "10.0.0.1:80", "fe80::1%eth0", "example.com"} {
+		if _, err := ParseHop(ip); err == nil {
+			t.Errorf("expected %q to be rejected", ip)
+		}
+	}
+}
+
+func TestPropertyCodecs(t *testing.T) {
+	header, err := NewPacket([]string{"10.0.0.1", "10.0.0.2"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Codec() != CodecNone || header.AcceptsCodec(CodecDeflate) {
+		t.Fatal("a new packet must be raw and accept nothing")
+	}
+
+	header.Property = MakeProperty(CodecDeflate, CodecDeflate)
+	data, err := header.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[PropertyOffset] != header.Property {
+		t.Fatalf("Property packed at the wrong offset: %x", data)
+	}
+	got, err := Unpack(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Codec() != CodecDeflate || !got.AcceptsCodec(CodecDeflate) || got.AcceptsCodec(CodecNone) {
+		t.Fatalf("Property %08b did not survive a round trip", got.Property)
+	}
+}
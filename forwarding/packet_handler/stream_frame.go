@@ -0,0 +1,285 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	PacketTypeData     byte = 0x01
+	PacketTypeStream   byte = 0x02
+	PacketTypeTunnel   byte = 0x03
+	PacketTypeDatagram byte = 0x04
+	PacketTypeUpgrade  byte = 0x05
+)
+
+// MaxChunkSize bounds a single chunk frame so that a relay never holds more
+// than one chunk of a streamed body in memory.
+const MaxChunkSize = 32 * 1024
+
+const chunkFrameHeaderLen = 4
+
+// TypePeekLen is how many bytes of a packet IsStreamPacket and the other
+// type checks need to see.
+const TypePeekLen = 9
+
+// StreamWindow is how far the sender of a streamed body may run ahead of
+// what the receiver has consumed. SMUX v1 has no per-stream flow control, so
+// without it a slow reader would fill the session's receive buffer and stall
+// every other stream on the connection.
+const StreamWindow = 256 * 1024
+
+const creditFrameLen = 4
+
+var ErrCreditTimeout = errors.New("no credit granted by the receiver of the stream")
+
+// IsStreamPacket reports whether raw data starts with a header whose
+// PacketType marks a streamed body (header followed by chunk frames).
+func IsStreamPacket(data []byte) bool {
+	return len(data) >= TypePeekLen && data[8]&^headerFlags == PacketTypeStream
+}
+
+// IsTunnelPacket reports whether raw data starts with the header of a TCP
+// tunnel (header, origin port, then chunk frames in both directions).
+func IsTunnelPacket(data []byte) bool {
+	return len(data) >= TypePeekLen && data[8]&^headerFlags == PacketTypeTunnel
+}
+
+// IsUpgradePacket reports whether raw data starts with the header of an
+// upgraded HTTP connection, which travels like a tunnel.
+func IsUpgradePacket(data []byte) bool {
+	return len(data) >= TypePeekLen && data[8]&^headerFlags == PacketTypeUpgrade
+}
+
+// IsDatagramPacket reports whether raw data starts with a batch of UDP
+// datagrams, the first of a series on a long-lived stream.
+func IsDatagramPacket(data []byte) bool {
+	return len(data) >= TypePeekLen && data[8]&^headerFlags == PacketTypeDatagram
+}
+
+// ReadHeader reads one complete packet header from r. initial holds bytes
+// already read from the same source; any bytes past the header are returned
+// as rest so callers can continue with io.MultiReader.
+func ReadHeader(r io.Reader, initial []byte) (*Packet, []byte, error) {
+	data := initial
+	if len(data) < 4 {
+		buf := make([]byte, 4-len(data))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, nil, fmt.Errorf("failed to read header length: %w", err)
+		}
+		data = append(data, buf...)
+	}
+
+	headerLen := int(binary.BigEndian.Uint16(data[2:4]))
+	if headerLen < 4 {
+		return nil, nil, fmt.Errorf("invalid header length %d", headerLen)
+	}
+	if len(data) < headerLen {
+		buf := make([]byte, headerLen-len(data))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, nil, fmt.Errorf("failed to read header body: %w", err)
+		}
+		data = append(data, buf...)
+	}
+
+	header, err := Unpack(data[:headerLen])
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, data[headerLen:], nil
+}
+
+// ChunkWriter splits everything written to it into length-prefixed chunk
+// frames. Close writes the zero-length terminator frame; it does not close
+// the underlying writer.
+type ChunkWriter struct {
+	w      io.Writer
+	frame  []byte
+	closed bool
+}
+
+func NewChunkWriter(w io.Writer) *ChunkWriter {
+	return &ChunkWriter{
+		w:     w,
+		frame: make([]byte, chunkFrameHeaderLen+MaxChunkSize),
+	}
+}
+
+func (cw *ChunkWriter) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > MaxChunkSize {
+			n = MaxChunkSize
+		}
+		binary.BigEndian.PutUint32(cw.frame[:chunkFrameHeaderLen], uint32(n))
+		copy(cw.frame[chunkFrameHeaderLen:], p[:n])
+		if _, err := cw.w.Write(cw.frame[:chunkFrameHeaderLen+n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (cw *ChunkWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	var terminator [chunkFrameHeaderLen]byte
+	_, err := cw.w.Write(terminator[:])
+	return err
+}
+
+// ChunkReader reassembles the byte stream written by a ChunkWriter and
+// returns io.EOF once the terminator frame has been read.
+type ChunkReader struct {
+	r         io.Reader
+	remaining uint32
+	done      bool
+}
+
+func NewChunkReader(r io.Reader) *ChunkReader {
+	return &ChunkReader{r: r}
+}
+
+func (cr *ChunkReader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+
+	if cr.remaining == 0 {
+		var lenBuf [chunkFrameHeaderLen]byte
+		if _, err := io.ReadFull(cr.r, lenBuf[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		cr.remaining = binary.BigEndian.Uint32(lenBuf[:])
+		if cr.remaining == 0 {
+			cr.done = true
+			return 0, io.EOF
+		}
+		if cr.remaining > MaxChunkSize {
+			return 0, fmt.Errorf("chunk frame of %d bytes exceeds limit %d", cr.remaining, MaxChunkSize)
+		}
+	}
+
+	if uint32(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.Read(p)
+	cr.remaining -= uint32(n)
+	if err == io.EOF && (cr.remaining > 0 || n == 0) {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// CreditWriter writes a streamed body to w no further than the receiver has
+// granted through grants, the reverse direction of the same stream. The first
+// StreamWindow bytes need no grant.
+type CreditWriter struct {
+	w       io.Writer
+	timeout time.Duration
+
+	mu      sync.Mutex
+	credit  int
+	err     error
+	granted chan struct{}
+}
+
+func NewCreditWriter(w io.Writer, grants io.Reader, timeout time.Duration) *CreditWriter {
+	cw := &CreditWriter{w: w, timeout: timeout, credit: StreamWindow, granted: make(chan struct{}, 1)}
+	go cw.readGrants(grants)
+	return cw
+}
+
+// readGrants runs until the stream is closed.
+func (cw *CreditWriter) readGrants(grants io.Reader) {
+	var frame [creditFrameLen]byte
+	for {
+		_, err := io.ReadFull(grants, frame[:])
+		cw.mu.Lock()
+		if err != nil {
+			cw.err = err
+		} else {
+			cw.credit += int(binary.BigEndian.Uint32(frame[:]))
+		}
+		cw.mu.Unlock()
+		select {
+		case cw.granted <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (cw *CreditWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		cw.mu.Lock()
+		n, err := min(len(p), cw.credit), cw.err
+		cw.credit -= n
+		cw.mu.Unlock()
+
+		if n == 0 {
+			if err != nil {
+				return written, fmt.Errorf("stream window closed: %w", err)
+			}
+			select {
+			case <-cw.granted:
+				continue
+			case <-time.After(cw.timeout):
+				return written, ErrCreditTimeout
+			}
+		}
+		if _, err := cw.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// CreditReader reads a streamed body from r and grants its sender more
+// credit on ack each time half a window has been consumed.
+type CreditReader struct {
+	r        io.Reader
+	ack      io.Writer
+	consumed int
+}
+
+func NewCreditReader(r io.Reader, ack io.Writer) *CreditReader {
+	return &CreditReader{r: r, ack: ack}
+}
+
+func (cr *CreditReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.consumed += n
+	if cr.consumed >= StreamWindow/2 {
+		var frame [creditFrameLen]byte
+		binary.BigEndian.PutUint32(frame[:], uint32(cr.consumed))
+		cr.consumed = 0
+		// Fails once the sender has sent everything and closed its side; a
+		// sender that is gone shows up on the read side
+		cr.ack.Write(frame[:])
+	}
+	return n, err
+}
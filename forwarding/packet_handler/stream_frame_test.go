@@ -0,0 +1,102 @@
+package packet
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestChunkWriterReaderRoundTrip(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789abcdef"), MaxChunkSize/8+3) // spans several frames
+
+	var wire bytes.Buffer
+	cw := NewChunkWriter(&wire)
+	if _, err := cw.Write(body[:100]); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if _, err := cw.Write(body[100:]); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	wire.WriteString("trailing")
+
+	got, err := io.ReadAll(NewChunkReader(&wire))
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("body mismatch: got %d bytes, want %d", len(got), len(body))
+	}
+	if wire.String() != "trailing" {
+		t.Errorf("reader consumed past the terminator, left %q", wire.String())
+	}
+}
+
+func TestChunkReaderTruncated(t *testing.T) {
+	var wire bytes.Buffer
+	cw := NewChunkWriter(&wire)
+	cw.Write([]byte("hello world"))
+
+	truncated := wire.Bytes()[:wire.Len()-3]
+	_, err := io.ReadAll(NewChunkReader(bytes.NewReader(truncated)))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestReadHeaderSplitAcrossReads(t *testing.T) {
+	hops, _ := ParseHopList([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+	header := NewMergedPacket([]uint32{42}, []int{0}, hops, PacketTypeStream)
+	headerBytes, err := header.Pack()
+	if err != nil {
+		t.Fatalf("pack failed: %v", err)
+	}
+	if !IsStreamPacket(headerBytes) {
+		t.Fatalf("IsStreamPacket returned false for a stream header")
+	}
+
+	data := append(headerBytes, []byte("payload")...)
+	got, rest, err := ReadHeader(bytes.NewReader(data[6:]), data[:6])
+	if err != nil {
+		t.Fatalf("ReadHeader failed: %v", err)
+	}
+	if got.PacketID[0] != 42 || len(got.HopList) != 3 || got.PacketType != PacketTypeStream {
+		t.Errorf("unexpected header: %+v", got)
+	}
+	if len(rest) != 0 {
+		t.Errorf("expected no extra bytes when header is completed from the reader, got %q", rest)
+	}
+}
+
+func TestCreditWindow(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 4*StreamWindow+123)
+	data, dataW := io.Pipe()
+	grants, grantsW := io.Pipe()
+	defer grantsW.Close()
+
+	received := make(chan []byte)
+	go func() {
+		got, _ := io.ReadAll(NewCreditReader(data, grantsW))
+		received <- got
+	}()
+	cw := NewCreditWriter(dataW, grants, time.Second)
+	if _, err := cw.Write(body); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	dataW.Close()
+	if got := <-received; !bytes.Equal(got, body) {
+		t.Errorf("got %d bytes, want %d", len(got), len(body))
+	}
+
+	// Nobody reads: the writer stops after one window
+	var stalled bytes.Buffer
+	noGrants, noGrantsW := io.Pipe()
+	defer noGrantsW.Close()
+	n, err := NewCreditWriter(&stalled, noGrants, 20*time.Millisecond).Write(body)
+	if err != ErrCreditTimeout || n != StreamWindow {
+		t.Errorf("expected ErrCreditTimeout after %d bytes, got %v after %d", StreamWindow, err, n)
+	}
+}
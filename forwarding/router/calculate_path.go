@@ -29,12 +29,17 @@ func NewWeightedRoundRobin(paths []k_shortest.PathWithIP) *WeightedRoundRobin {
 }
 
 func (w *WeightedRoundRobin) Next() k_shortest.PathWithIP {
+	return w.NextAt(atomic.AddUint32(&w.current, 1) - 1)
+}
+
+// NextAt returns the path for the n-th pick, for callers that rebuild the
+// round robin on every request and keep the counter themselves.
+func (w *WeightedRoundRobin) NextAt(n uint32) k_shortest.PathWithIP {
 	if w.totalWeight == 0 || len(w.paths) == 0 {
 		return k_shortest.PathWithIP{} //
 	}
 
-	n := atomic.AddUint32(&w.current, 1) - 1
-	mod := int(n) % w.totalWeight
+	mod := int(n % uint32(w.totalWeight))
 
 	for i, c := range w.cumulative {
 		if mod < c {
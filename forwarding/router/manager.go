@@ -1,21 +1,31 @@
 package router
 
 import (
+	"fmt"
+	"forwarding/common"
 	"forwarding/metrics_processing/collector"
+	"forwarding/metrics_processing/exporter"
 	"forwarding/metrics_processing/protocol"
 	"forwarding/metrics_processing/storage"
 	"forwarding/scheduling_algorithms/k_shortest"
 	"log"
+	"net"
+	"net/netip"
+	"strings"
 	"sync"
+	"time"
 )
 
+// PathManager keeps the k-shortest path set from this node to the origin IP
+// of every domain in the domain mapping.
 type PathManager struct {
-	pathChan      chan []k_shortest.PathWithIP
-	latestPaths   []k_shortest.PathWithIP
-	mu            sync.RWMutex
-	sourceIP      string
-	destinationIP string
-	k             int
+	pathChan    chan map[string][]k_shortest.PathWithIP
+	latestPaths map[string][]k_shortest.PathWithIP // domain -> paths
+	failedUntil map[string]time.Time               // PathKey -> end of its failure penalty
+	pinned      map[string][]string                // domain -> path set by an operator
+	mu          sync.RWMutex
+	sourceIP    string
+	k           int
 }
 
 var (
@@ -27,21 +37,35 @@ var (
 var (
 	fileManagerInstance *storage.FileManager
 	fileManagerOnce     sync.Once
+	fileManagerMu       sync.RWMutex
 )
 
 // getFileManager retrieves the singleton instance of FileManager
 func getFileManager() *storage.FileManager {
 	fileManagerOnce.Do(func() {
-		var err error
-		fileManagerInstance, err = storage.NewFileManager("../../agent_storage")
+		fm, err := storage.NewFileManager("../../agent_storage")
 		if err != nil {
 			log.Printf("Failed to create FileManager: %v", err)
 			return
 		}
+		fileManagerMu.Lock()
+		fileManagerInstance = fm
+		fileManagerMu.Unlock()
 	})
+	fileManagerMu.RLock()
+	defer fileManagerMu.RUnlock()
 	return fileManagerInstance
 }
 
+// SetFileManager makes lookups use the data plane's FileManager, which the
+// controller sync keeps current, instead of a copy loaded once from disk.
+func SetFileManager(fm *storage.FileManager) {
+	fileManagerOnce.Do(func() {})
+	fileManagerMu.Lock()
+	fileManagerInstance = fm
+	fileManagerMu.Unlock()
+}
+
 // GetTargetIPByDomain looks up the corresponding target IP from the domain mapping
 func GetTargetIPByDomain(domain string) string {
 	// Get the FileManager instance
@@ -110,21 +134,62 @@ func GetAllDomainMapIP() []*protocol.DomainIPMapping {
 	return mappings
 }
 
+// NormalizeDomain turns a Host header value into the form used as a key in
+// the domain mapping: lower case, without port or trailing dot.
+func NormalizeDomain(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+	return strings.ToLower(host)
+}
+
+// IsKnownDomain reports whether domain has an entry in the domain mapping.
+func IsKnownDomain(domain string) bool {
+	domain = NormalizeDomain(domain)
+	for _, mapping := range GetAllDomainMapIP() {
+		if NormalizeDomain(mapping.Domain) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// GetOriginConfig returns how to connect to the origin of domain, nil for plain HTTP.
+func GetOriginConfig(domain string) *protocol.OriginConfig {
+	domain = NormalizeDomain(domain)
+	for _, mapping := range GetAllDomainMapIP() {
+		if NormalizeDomain(mapping.Domain) == domain {
+			return mapping.Origin
+		}
+	}
+	return nil
+}
+
+// GetRateLimit returns the controller's limits for domain, nil if it set none.
+func GetRateLimit(domain string) *protocol.RateLimit {
+	domain = NormalizeDomain(domain)
+	for _, mapping := range GetAllDomainMapIP() {
+		if NormalizeDomain(mapping.Domain) == domain {
+			return mapping.RateLimit
+		}
+	}
+	return nil
+}
+
 func GetInstance() *PathManager {
 	once.Do(func() {
 		ip, err := collector.GetIP()
 		if err != nil {
 			return
 		}
-		// Use GetDefaultTargetIP to get the default target IP
-		defaultTargetIP := GetDefaultTargetIP()
-		log.Printf("Default target IP: %s", defaultTargetIP)
 		instance = &PathManager{
-			pathChan:      make(chan []k_shortest.PathWithIP, 1),
-			latestPaths:   nil,
-			sourceIP:      ip,
-			destinationIP: defaultTargetIP,
-			k:             2,
+			pathChan:    make(chan map[string][]k_shortest.PathWithIP, 1),
+			latestPaths: nil,
+			failedUntil: make(map[string]time.Time),
+			sourceIP:    ip,
+			k:           2,
 		}
 
 		go instance.pathListener()
@@ -138,22 +203,65 @@ func (pm *PathManager) pathListener() {
 		pm.mu.Lock()
 		pm.latestPaths = paths
 		pm.mu.Unlock()
-		log.Printf(" %d ", len(paths))
+		log.Printf("Path sets updated for %d domain(s)", len(paths))
 	}
 }
 
+// CalculatePaths recomputes the path set of every mapped domain on the given topology.
 func (pm *PathManager) CalculatePaths(network k_shortest.Network,
 	ipToIndex map[string]int,
 	indexToIP map[int]string) {
 
-	sourceIdx, srcExists := ipToIndex[pm.sourceIP]
-	destIdx, destExists := ipToIndex[pm.destinationIP]
+	pathsByDomain := pm.calculateDomainPaths(network, ipToIndex, indexToIP, GetAllDomainMapIP())
+	select {
+	case pm.pathChan <- pathsByDomain:
+	default:
+		<-pm.pathChan
+		pm.pathChan <- pathsByDomain
+	}
+}
+
+func (pm *PathManager) calculateDomainPaths(network k_shortest.Network,
+	ipToIndex map[string]int,
+	indexToIP map[int]string,
+	mappings []*protocol.DomainIPMapping) map[string][]k_shortest.PathWithIP {
+
+	pm.mu.RLock()
+	sourceIP := pm.sourceIP
+	pm.mu.RUnlock()
+
+	pathsByDomain := make(map[string][]k_shortest.PathWithIP, len(mappings))
+	sourceIdx, srcExists := ipToIndex[common.CanonicalIP(sourceIP)]
+	if !srcExists {
+		log.Printf("srcip: IP %s not exists in topology", sourceIP)
+		return pathsByDomain
+	}
 
-	if !srcExists || !destExists {
-		log.Printf("srcip: IP or destip:IP not exists(: %v, : %v)",
-			srcExists, destExists)
-		return
+	// Domains sharing an origin IP share one computation
+	pathsByDest := make(map[string][]k_shortest.PathWithIP)
+	for _, mapping := range mappings {
+		domain := NormalizeDomain(mapping.Domain)
+		if domain == "" || mapping.Ip == "" {
+			continue
+		}
+		paths, done := pathsByDest[mapping.Ip]
+		if !done {
+			destIdx, destExists := ipToIndex[common.CanonicalIP(mapping.Ip)]
+			if destExists {
+				paths = pm.calculatePathsTo(network, sourceIdx, destIdx, indexToIP)
+			} else {
+				log.Printf("destip: IP %s of domain %s not exists in topology", mapping.Ip, domain)
+			}
+			pathsByDest[mapping.Ip] = paths
+		}
+		pathsByDomain[domain] = paths
+		log.Printf("Domain %s -> %s: %d path(s)", domain, mapping.Ip, len(paths))
 	}
+	return pathsByDomain
+}
+
+func (pm *PathManager) calculatePathsTo(network k_shortest.Network, sourceIdx, destIdx int,
+	indexToIP map[int]string) []k_shortest.PathWithIP {
 
 	flow := k_shortest.Flow{Source: sourceIdx, Destination: destIdx}
 	paths := k_shortest.KShortest(network, flow, pm.k, 3, 2) // theta
@@ -163,12 +271,7 @@ func (pm *PathManager) CalculatePaths(network k_shortest.Network,
 		totalLatency += p.Latency
 	}
 	if len(paths) == 0 || totalLatency == 0 {
-		select {
-		case pm.pathChan <- []k_shortest.PathWithIP{}:
-		default:
-
-		}
-		return
+		return []k_shortest.PathWithIP{}
 	}
 	for _, p := range paths {
 		ipNodes := make([]string, len(p.Nodes))
@@ -189,28 +292,190 @@ func (pm *PathManager) CalculatePaths(network k_shortest.Network,
 		})
 		log.Printf(": : %v, : %d, : %d", ipNodes, p.Latency, weight)
 	}
-	select {
-	case pm.pathChan <- pathsWithIP:
-	default:
-		<-pm.pathChan
-		pm.pathChan <- pathsWithIP
-	}
+	return pathsWithIP
 }
 
-func (pm *PathManager) GetPaths() []k_shortest.PathWithIP {
+// GetPaths returns a copy of the path set for domain, empty if none has been
+// computed. A pinned path replaces the computed set.
+func (pm *PathManager) GetPaths(domain string) []k_shortest.PathWithIP {
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
-	if pm.latestPaths == nil {
-		return []k_shortest.PathWithIP{}
+	domain = NormalizeDomain(domain)
+	latest := pm.latestPaths[domain]
+	if pinned, ok := pm.pinned[domain]; ok {
+		path := k_shortest.PathWithIP{IPList: append([]string(nil), pinned...), Weight: 1}
+		for _, p := range latest {
+			if PathKey(p.IPList) == PathKey(pinned) {
+				path.Latency = p.Latency
+			}
+		}
+		return []k_shortest.PathWithIP{path}
 	}
-	paths := make([]k_shortest.PathWithIP, len(pm.latestPaths))
-	copy(paths, pm.latestPaths)
+	paths := make([]k_shortest.PathWithIP, len(latest))
+	copy(paths, latest)
 	return paths
 }
 
-func (pm *PathManager) SetSourceDestination(source, destination string) {
+// GetAllPaths returns a copy of the path sets of all domains.
+func (pm *PathManager) GetAllPaths() map[string][]k_shortest.PathWithIP {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	all := make(map[string][]k_shortest.PathWithIP, len(pm.latestPaths))
+	for domain, latest := range pm.latestPaths {
+		paths := make([]k_shortest.PathWithIP, len(latest))
+		copy(paths, latest)
+		all[domain] = paths
+	}
+	return all
+}
+
+func init() {
+	labels := []string{"domain", "path"}
+	exporter.Default.GaugeFunc("arcturus_path_weight", "Weight of each relay path to a domain's origin, 0 while the path is penalized after a failure.",
+		labels, func(emit func(float64, ...string)) {
+			pm := GetInstance()
+			if pm == nil {
+				return
+			}
+			for domain, paths := range pm.GetAllPaths() {
+				for _, p := range pm.DeprioritizeFailed(paths) {
+					emit(float64(p.Weight), domain, PathKey(p.IPList))
+				}
+			}
+		})
+	exporter.Default.GaugeFunc("arcturus_path_latency_seconds", "Latency of each relay path to a domain's origin as computed from the topology.",
+		labels, func(emit func(float64, ...string)) {
+			pm := GetInstance()
+			if pm == nil {
+				return
+			}
+			for domain, paths := range pm.GetAllPaths() {
+				for _, p := range paths {
+					emit(float64(p.Latency)/1000, domain, PathKey(p.IPList))
+				}
+			}
+		})
+}
+
+// PinPath makes requests for domain take ipList, which must run from this
+// node to the domain's origin, until UnpinPath.
+func (pm *PathManager) PinPath(domain string, ipList []string) error {
+	domain = NormalizeDomain(domain)
+	for _, mapping := range GetAllDomainMapIP() {
+		if NormalizeDomain(mapping.Domain) == domain {
+			return pm.pinPath(domain, ipList, mapping.Ip)
+		}
+	}
+	return fmt.Errorf("domain %s is not mapped to an origin", domain)
+}
+
+func (pm *PathManager) pinPath(domain string, ipList []string, originIP string) error {
+	if len(ipList) < 2 {
+		return fmt.Errorf("path %v needs at least this node and the origin", ipList)
+	}
+	path := make([]string, len(ipList))
+	for i, ip := range ipList {
+		addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+		if err != nil {
+			return fmt.Errorf("invalid hop %q: %w", ip, err)
+		}
+		path[i] = addr.Unmap().String()
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if path[0] != common.CanonicalIP(pm.sourceIP) {
+		return fmt.Errorf("path must start at this node %s, not %s", pm.sourceIP, path[0])
+	}
+	if path[len(path)-1] != common.CanonicalIP(originIP) {
+		return fmt.Errorf("path must end at the origin %s of %s, not %s", originIP, domain, path[len(path)-1])
+	}
+	if pm.pinned == nil {
+		pm.pinned = make(map[string][]string)
+	}
+	pm.pinned[domain] = path
+	log.Printf("Path %v pinned for domain %s", path, domain)
+	return nil
+}
+
+// UnpinPath returns domain to its computed path set. It reports whether a path was pinned.
+func (pm *PathManager) UnpinPath(domain string) bool {
+	domain = NormalizeDomain(domain)
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if _, ok := pm.pinned[domain]; !ok {
+		return false
+	}
+	delete(pm.pinned, domain)
+	log.Printf("Path pin removed for domain %s", domain)
+	return true
+}
+
+// PinnedPaths returns a copy of the pinned path of each domain.
+func (pm *PathManager) PinnedPaths() map[string][]string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	pinned := make(map[string][]string, len(pm.pinned))
+	for domain, path := range pm.pinned {
+		pinned[domain] = append([]string(nil), path...)
+	}
+	return pinned
+}
+
+// PathKey identifies a path by its hop sequence.
+func PathKey(ipList []string) string {
+	return strings.Join(ipList, ">")
+}
+
+// MarkPathFailed de-prioritizes the path for penalty.
+func (pm *PathManager) MarkPathFailed(ipList []string, penalty time.Duration) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.failedUntil == nil {
+		pm.failedUntil = make(map[string]time.Time)
+	}
+	pm.failedUntil[PathKey(ipList)] = time.Now().Add(penalty)
+	log.Printf("Path %v marked failed for %s", ipList, penalty)
+}
+
+// DeprioritizeFailed returns a copy of paths in which recently failed paths
+// get weight 0, as long as at least one path has not failed.
+func (pm *PathManager) DeprioritizeFailed(paths []k_shortest.PathWithIP) []k_shortest.PathWithIP {
+	now := time.Now()
+	result := make([]k_shortest.PathWithIP, len(paths))
+	copy(result, paths)
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	healthy := 0
+	failed := make([]bool, len(result))
+	for i, p := range result {
+		key := PathKey(p.IPList)
+		until, ok := pm.failedUntil[key]
+		if ok && now.Before(until) {
+			failed[i] = true
+			continue
+		}
+		if ok {
+			delete(pm.failedUntil, key)
+		}
+		if p.Weight > 0 {
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		return result
+	}
+	for i := range result {
+		if failed[i] {
+			result[i].Weight = 0
+		}
+	}
+	return result
+}
+
+func (pm *PathManager) SetSourceIP(source string) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	pm.sourceIP = source
-	pm.destinationIP = destination
 }
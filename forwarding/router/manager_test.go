@@ -0,0 +1,138 @@
+package router
+
+import (
+	"forwarding/metrics_processing/protocol"
+	"forwarding/scheduling_algorithms/k_shortest"
+	"testing"
+	"time"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	cases := map[string]string{
+		"Example.COM":       "example.com",
+		"example.com:8080":  "example.com",
+		"example.com.":      "example.com",
+		" api.example.com ": "api.example.com",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"":                  "",
+	}
+	for in, want := range cases {
+		if got := NormalizeDomain(in); got != want {
+			t.Errorf("NormalizeDomain(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCalculateDomainPaths(t *testing.T) {
+	// 0: this node, 1: relay, 2: origin A, 3: origin B
+	network := k_shortest.Network{
+		Nodes: make([]k_shortest.Node, 4),
+		Links: [][]int{
+			{-1, 10, 50, -1},
+			{10, -1, 10, 20},
+			{50, 10, -1, -1},
+			{-1, 20, -1, -1},
+		},
+	}
+	ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+	ipToIndex := make(map[string]int)
+	indexToIP := make(map[int]string)
+	for i, ip := range ips {
+		ipToIndex[ip] = i
+		indexToIP[i] = ip
+	}
+	mappings := []*protocol.DomainIPMapping{
+		{Domain: "A.example.com", Ip: "10.0.0.3"},
+		{Domain: "b.example.com", Ip: "10.0.0.4"},
+		{Domain: "c.example.com", Ip: "10.9.9.9"}, // origin not in topology
+	}
+
+	pm := &PathManager{sourceIP: "10.0.0.1", k: 2}
+	got := pm.calculateDomainPaths(network, ipToIndex, indexToIP, mappings)
+
+	if len(got) != 3 {
+		t.Fatalf("expected entries for 3 domains, got %d: %v", len(got), got)
+	}
+	for domain, dest := range map[string]string{"a.example.com": "10.0.0.3", "b.example.com": "10.0.0.4"} {
+		paths := got[domain]
+		if len(paths) == 0 {
+			t.Errorf("expected paths for %s", domain)
+			continue
+		}
+		for _, p := range paths {
+			if p.IPList[0] != "10.0.0.1" || p.IPList[len(p.IPList)-1] != dest {
+				t.Errorf("%s: path %v does not run from source to %s", domain, p.IPList, dest)
+			}
+		}
+	}
+	if len(got["c.example.com"]) != 0 {
+		t.Errorf("expected no paths for a domain whose origin is not in the topology, got %v", got["c.example.com"])
+	}
+}
+
+func TestDeprioritizeFailed(t *testing.T) {
+	pm := &PathManager{}
+	paths := []k_shortest.PathWithIP{
+		{IPList: []string{"a", "b", "z"}, Weight: 2},
+		{IPList: []string{"a", "c", "z"}, Weight: 1},
+	}
+
+	pm.MarkPathFailed(paths[0].IPList, time.Minute)
+	got := pm.DeprioritizeFailed(paths)
+	if got[0].Weight != 0 || got[1].Weight != 1 {
+		t.Errorf("expected failed path weight 0, got %v", got)
+	}
+	if paths[0].Weight != 2 {
+		t.Errorf("input paths must not be modified")
+	}
+
+	pm.MarkPathFailed(paths[1].IPList, time.Minute)
+	got = pm.DeprioritizeFailed(paths)
+	if got[0].Weight != 2 || got[1].Weight != 1 {
+		t.Errorf("expected weights kept when every path failed, got %v", got)
+	}
+
+	pm.MarkPathFailed(paths[0].IPList, -time.Second) // penalty already over
+	got = pm.DeprioritizeFailed(paths)
+	if got[0].Weight != 2 || got[1].Weight != 0 {
+		t.Errorf("expected expired penalty to restore the path, got %v", got)
+	}
+}
+
+func TestPinPath(t *testing.T) {
+	pm := &PathManager{sourceIP: "10.0.0.1", latestPaths: map[string][]k_shortest.PathWithIP{
+		"a.example.com": {
+			{IPList: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, Latency: 20, Weight: 2},
+			{IPList: []string{"10.0.0.1", "10.0.0.3"}, Latency: 50, Weight: 1},
+		},
+	}}
+
+	for _, bad := range [][]string{
+		{"10.0.0.1"},
+		{"10.0.0.9", "10.0.0.3"},          // not from this node
+		{"10.0.0.1", "10.0.0.2"},          // not to the origin
+		{"10.0.0.1", "relay", "10.0.0.3"}, // not an IP
+	} {
+		if err := pm.pinPath("a.example.com", bad, "10.0.0.3"); err == nil {
+			t.Errorf("path %v was pinned", bad)
+		}
+	}
+
+	if err := pm.pinPath("a.example.com", []string{"10.0.0.1", "::ffff:10.0.0.3"}, "10.0.0.3"); err != nil {
+		t.Fatal(err)
+	}
+	got := pm.GetPaths("A.example.com")
+	if len(got) != 1 || PathKey(got[0].IPList) != "10.0.0.1>10.0.0.3" || got[0].Latency != 50 || got[0].Weight != 1 {
+		t.Fatalf("pinned domain got paths %v", got)
+	}
+	if len(pm.GetAllPaths()["a.example.com"]) != 2 {
+		t.Error("pinning changed the computed path set")
+	}
+
+	if !pm.UnpinPath("a.example.com") || pm.UnpinPath("a.example.com") {
+		t.Error("UnpinPath did not report the pin once")
+	}
+	if len(pm.GetPaths("a.example.com")) != 2 {
+		t.Error("computed paths not restored after unpinning")
+	}
+}
@@ -0,0 +1,76 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"forwarding/metrics_processing/protocol"
+	packet "forwarding/packet_handler"
+	"net/netip"
+	"sync"
+)
+
+// Relays only forward along hop lists made of overlay members: every hop
+// must be a node from the controller's node list, except the last one, which
+// may also be the origin IP of a mapped domain. Without this a forged header
+// would turn any relay into an open proxy towards arbitrary addresses.
+
+type memberSet struct {
+	nodes   map[netip.Addr]bool
+	origins map[netip.Addr]bool
+}
+
+var (
+	members   *memberSet
+	membersMu sync.RWMutex
+)
+
+var errMembersNotLoaded = errors.New("node membership not loaded yet")
+
+// UpdateMembers replaces the member set with the current node list and domain mapping.
+func UpdateMembers(nodeList *protocol.NodeList, mappings []*protocol.DomainIPMapping) {
+	set := &memberSet{
+		nodes:   make(map[netip.Addr]bool),
+		origins: make(map[netip.Addr]bool),
+	}
+	if nodeList != nil {
+		for _, node := range nodeList.Nodes {
+			if ip, err := packet.ParseHop(node.Ip); err == nil {
+				set.nodes[ip] = true
+			}
+		}
+	}
+	for _, mapping := range mappings {
+		if ip, err := packet.ParseHop(mapping.Ip); err == nil {
+			set.origins[ip] = true
+		}
+	}
+
+	membersMu.Lock()
+	members = set
+	membersMu.Unlock()
+}
+
+// ValidateHopList returns an error if hopList contains an address that is not a member.
+func ValidateHopList(hopList []netip.Addr) error {
+	membersMu.RLock()
+	set := members
+	membersMu.RUnlock()
+	if set == nil {
+		return errMembersNotLoaded
+	}
+	return set.validate(hopList)
+}
+
+func (s *memberSet) validate(hopList []netip.Addr) error {
+	if len(hopList) < 2 {
+		return fmt.Errorf("hop list too short: %d hop(s)", len(hopList))
+	}
+	last := len(hopList) - 1
+	for i, hop := range hopList {
+		if s.nodes[hop] || (i == last && s.origins[hop]) {
+			continue
+		}
+		return fmt.Errorf("hop %d (%s) is not a member", i, hop)
+	}
+	return nil
+}
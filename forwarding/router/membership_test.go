@@ -0,0 +1,54 @@
+package router
+
+import (
+	"forwarding/metrics_processing/protocol"
+	packet "forwarding/packet_handler"
+	"net/netip"
+	"testing"
+)
+
+func hopList(t *testing.T, ips ...string) []netip.Addr {
+	hops, err := packet.ParseHopList(ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hops
+}
+
+func TestValidateHopList(t *testing.T) {
+	UpdateMembers(
+		&protocol.NodeList{Nodes: []*protocol.NodeInfo{{Ip: "10.0.0.1"}, {Ip: "10.0.0.2"}, {Ip: "10.0.0.3"}, {Ip: "2001:DB8:0::2"}}},
+		[]*protocol.DomainIPMapping{{Domain: "example.com", Ip: "192.0.2.10"}, {Domain: "v6.example.com", Ip: "2001:db8:ffff::10"}},
+	)
+	defer func() { members = nil }()
+
+	cases := []struct {
+		name  string
+		hops  []string
+		valid bool
+	}{
+		{"relay path to origin", []string{"10.0.0.1", "10.0.0.2", "192.0.2.10"}, true},
+		{"path ending at a node", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, true},
+		{"unknown relay", []string{"10.0.0.1", "203.0.113.5", "192.0.2.10"}, false},
+		{"origin as relay", []string{"10.0.0.1", "192.0.2.10", "10.0.0.3"}, false},
+		{"unknown final target", []string{"10.0.0.1", "10.0.0.2", "203.0.113.5"}, false},
+		{"unknown access node", []string{"203.0.113.5", "10.0.0.2", "192.0.2.10"}, false},
+		{"too short", []string{"10.0.0.1"}, false},
+		{"IPv6 relay", []string{"10.0.0.1", "2001:db8::2", "192.0.2.10"}, true},
+		{"IPv6 origin", []string{"10.0.0.1", "2001:db8::2", "2001:db8:ffff::10"}, true},
+		{"unknown IPv6 relay", []string{"10.0.0.1", "2001:db8::3", "192.0.2.10"}, false},
+	}
+	for _, c := range cases {
+		err := ValidateHopList(hopList(t, c.hops...))
+		if (err == nil) != c.valid {
+			t.Errorf("%s: ValidateHopList(%v) = %v, want valid=%v", c.name, c.hops, err, c.valid)
+		}
+	}
+}
+
+func TestValidateHopListBeforeMembersLoaded(t *testing.T) {
+	members = nil
+	if err := ValidateHopList(hopList(t, "10.0.0.1", "10.0.0.2")); err == nil {
+		t.Error("expected hop lists to be refused before the member set is loaded")
+	}
+}
@@ -0,0 +1,111 @@
+package linucb
+
+import (
+	"fmt"
+	"math"
+)
+
+// Disjoint LinUCB: every arm has its own ridge regression of the reward on
+// the context vector, and the arm with the highest upper confidence bound
+// theta·x + alpha·sqrt(xᵀA⁻¹x) is picked. A⁻¹ is kept directly and updated
+// with the Sherman-Morrison formula, so no matrix is ever inverted. The model
+// is plain data and can be stored as JSON.
+
+type Arm struct {
+	AInv  [][]float64 `json:"a_inv"` // inverse of I + sum of x·xᵀ
+	B     []float64   `json:"b"`     // sum of reward·x
+	Pulls int64       `json:"pulls"`
+}
+
+type Model struct {
+	Alpha float64 `json:"alpha"`
+	Dim   int     `json:"dim"`
+	Arms  []Arm   `json:"arms"`
+}
+
+func New(arms, dim int, alpha float64) *Model {
+	m := &Model{Alpha: alpha, Dim: dim, Arms: make([]Arm, arms)}
+	for i := range m.Arms {
+		m.Arms[i] = newArm(dim)
+	}
+	return m
+}
+
+func newArm(dim int) Arm {
+	arm := Arm{AInv: make([][]float64, dim), B: make([]float64, dim)}
+	for i := range arm.AInv {
+		arm.AInv[i] = make([]float64, dim)
+		arm.AInv[i][i] = 1
+	}
+	return arm
+}
+
+// Validate checks that a model read from storage fits arms arms of dim features.
+func (m *Model) Validate(arms, dim int) error {
+	if m.Dim != dim || len(m.Arms) != arms {
+		return fmt.Errorf("model has %d arms of %d features, want %d of %d", len(m.Arms), m.Dim, arms, dim)
+	}
+	for i, arm := range m.Arms {
+		if len(arm.AInv) != dim || len(arm.B) != dim {
+			return fmt.Errorf("arm %d has the wrong size", i)
+		}
+		for _, row := range arm.AInv {
+			if len(row) != dim {
+				return fmt.Errorf("arm %d has the wrong size", i)
+			}
+		}
+	}
+	return nil
+}
+
+// Select returns the arm with the highest upper confidence bound for context
+// x; ties go to the lower index.
+func (m *Model) Select(x []float64) int {
+	best, bestScore := 0, math.Inf(-1)
+	for i := range m.Arms {
+		if score := m.UCB(i, x); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// UCB returns the upper confidence bound of the reward of arm for context x.
+func (m *Model) UCB(arm int, x []float64) float64 {
+	a := &m.Arms[arm]
+	ax := mulVec(a.AInv, x)
+	theta := mulVec(a.AInv, a.B)
+	return dot(theta, x) + m.Alpha*math.Sqrt(math.Max(dot(x, ax), 0))
+}
+
+// Update adds the reward observed for arm in context x.
+func (m *Model) Update(arm int, x []float64, reward float64) {
+	a := &m.Arms[arm]
+	ax := mulVec(a.AInv, x)
+	denominator := 1 + dot(x, ax)
+	for i := range a.AInv {
+		for j := range a.AInv[i] {
+			a.AInv[i][j] -= ax[i] * ax[j] / denominator // A⁻¹ is symmetric, so xᵀA⁻¹ = (A⁻¹x)ᵀ
+		}
+	}
+	for i := range a.B {
+		a.B[i] += reward * x[i]
+	}
+	a.Pulls++
+}
+
+func mulVec(m [][]float64, v []float64) []float64 {
+	out := make([]float64, len(m))
+	for i, row := range m {
+		out[i] = dot(row, v)
+	}
+	return out
+}
+
+func dot(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
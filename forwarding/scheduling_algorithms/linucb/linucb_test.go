@@ -0,0 +1,81 @@
+package linucb
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestShermanMorrisonKeepsInverse(t *testing.T) {
+	m := New(1, 2, 1)
+	xs := [][]float64{{1, 0.5}, {1, 2}, {1, -1}}
+	a := [][]float64{{1, 0}, {0, 1}}
+	for _, x := range xs {
+		m.Update(0, x, 1)
+		for i := range a {
+			for j := range a[i] {
+				a[i][j] += x[i] * x[j]
+			}
+		}
+	}
+	// A·A⁻¹ must be the identity
+	inv := m.Arms[0].AInv
+	for i := range a {
+		for j := range a {
+			var sum float64
+			for k := range a {
+				sum += a[i][k] * inv[k][j]
+			}
+			want := 0.0
+			if i == j {
+				want = 1
+			}
+			if math.Abs(sum-want) > 1e-9 {
+				t.Fatalf("A·A⁻¹[%d][%d] = %v", i, j, sum)
+			}
+		}
+	}
+}
+
+func TestLearnsBestArmPerContext(t *testing.T) {
+	// Arm 0 pays in low-load contexts, arm 1 in high-load ones.
+	reward := func(arm int, load float64) float64 {
+		if arm == 0 {
+			return 1 - load
+		}
+		return load
+	}
+	rng := rand.New(rand.NewSource(1))
+	m := New(3, 2, 0.3)
+	for i := 0; i < 2000; i++ {
+		load := rng.Float64()
+		x := []float64{1, load}
+		arm := m.Select(x)
+		m.Update(arm, x, reward(arm, load)+0.05*rng.NormFloat64())
+	}
+	if arm := m.Select([]float64{1, 0.05}); arm != 0 {
+		t.Errorf("low load: picked arm %d", arm)
+	}
+	if arm := m.Select([]float64{1, 0.95}); arm != 1 {
+		t.Errorf("high load: picked arm %d", arm)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored Model
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Validate(3, 2); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Select([]float64{1, 0.95}) != 1 {
+		t.Errorf("restored model picks differently")
+	}
+	if restored.Validate(4, 2) == nil {
+		t.Errorf("model accepted for a different number of arms")
+	}
+}
@@ -0,0 +1,194 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Spans are encoded as OTLP ExportTraceServiceRequest messages in the JSON
+// encoding of OTLP/HTTP. The file exporter writes one message per line, the
+// format the collector's file exporter writes and its otlpjsonfile receiver
+// reads.
+
+type exporter interface {
+	export(ctx context.Context, serviceName string, spans []*spanData) error
+	close() error
+}
+
+func newExporter(config Config) (exporter, error) {
+	switch config.Exporter {
+	case "file":
+		return newFileExporter(config.FilePath)
+	default:
+		return &otlpExporter{endpoint: config.Endpoint, client: &http.Client{}}, nil
+	}
+}
+
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (e *otlpExporter) export(ctx context.Context, serviceName string, spans []*spanData) error {
+	body, err := encodeSpans(serviceName, spans)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send spans to %s: %w", e.endpoint, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector %s answered %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
+
+func (e *otlpExporter) close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+type fileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newFileExporter(path string) (*fileExporter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory of trace file %s: %w", path, err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file %s: %w", path, err)
+	}
+	return &fileExporter{file: file}, nil
+}
+
+func (e *fileExporter) export(ctx context.Context, serviceName string, spans []*spanData) error {
+	line, err := encodeSpans(serviceName, spans)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.file.Write(append(line, '\n'))
+	return err
+}
+
+func (e *fileExporter) close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Flags             uint32         `json:"flags"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 is STATUS_CODE_ERROR
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func otlpValue(value any) map[string]any {
+	// 64-bit integers are strings in the JSON encoding
+	switch v := value.(type) {
+	case string:
+		return map[string]any{"stringValue": v}
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case uint32:
+		return map[string]any{"intValue": strconv.FormatUint(uint64(v), 10)}
+	case float64:
+		return map[string]any{"doubleValue": v}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+func encodeSpans(serviceName string, spans []*spanData) ([]byte, error) {
+	resource := otlpResourceSpans{}
+	resource.Resource.Attributes = []otlpKeyValue{{Key: "service.name", Value: otlpValue(serviceName)}}
+	if host, err := os.Hostname(); err == nil {
+		resource.Resource.Attributes = append(resource.Resource.Attributes, otlpKeyValue{Key: "host.name", Value: otlpValue(host)})
+	}
+
+	scope := otlpScopeSpans{Spans: make([]otlpSpan, 0, len(spans))}
+	scope.Scope.Name = "forwarding"
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+			Flags:             uint32(s.context.Flags),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, attr := range s.attrs {
+			span.Attributes = append(span.Attributes, otlpKeyValue{Key: attr.key, Value: otlpValue(attr.value)})
+		}
+		if s.failed {
+			span.Status = &otlpStatus{Code: 2, Message: s.errorMsg}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	resource.ScopeSpans = []otlpScopeSpans{scope}
+	return json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{resource}})
+}
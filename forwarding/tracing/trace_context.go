@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// Requests are traced with W3C Trace Context. The access node continues the
+// traceparent of the client or starts a trace, and puts the context of its
+// attempt into the Traceparent header of the request it forwards. Relays read
+// it back from the serialized request, so merged packets need no trace fields
+// of their own.
+
+// Header is the W3C trace context header.
+const Header = "Traceparent"
+
+const flagSampled byte = 0x01
+
+// TraceContext is the part of a span that travels between processes.
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+func (c TraceContext) IsValid() bool {
+	return c.TraceID != [16]byte{} && c.SpanID != [8]byte{}
+}
+
+func (c TraceContext) Sampled() bool {
+	return c.Flags&flagSampled != 0
+}
+
+// Traceparent formats the context as a version 00 traceparent value.
+func (c TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", c.TraceID, c.SpanID, c.Flags)
+}
+
+// ParseTraceparent parses a traceparent header value. Versions after 00 are
+// read as far as version 00 defines them, as the specification asks.
+func ParseTraceparent(value string) (TraceContext, bool) {
+	var c TraceContext
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return c, false
+	}
+	version, ok := decodeHex(value[0:2])
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(value) != 55) {
+		return c, false
+	}
+	if len(value) > 55 && value[55] != '-' {
+		return c, false
+	}
+	traceID, ok := decodeHex(value[3:35])
+	if !ok {
+		return c, false
+	}
+	spanID, ok := decodeHex(value[36:52])
+	if !ok {
+		return c, false
+	}
+	flags, ok := decodeHex(value[53:55])
+	if !ok {
+		return c, false
+	}
+	copy(c.TraceID[:], traceID)
+	copy(c.SpanID[:], spanID)
+	c.Flags = flags[0]
+	return c, c.IsValid()
+}
+
+// decodeHex accepts lowercase hex only, uppercase makes a traceparent invalid.
+func decodeHex(s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		if 'A' <= s[i] && s[i] <= 'F' {
+			return nil, false
+		}
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// Extract returns the trace context of an HTTP header, or an invalid context
+// when it has none.
+func Extract(header http.Header) TraceContext {
+	c, _ := ParseTraceparent(header.Get(Header))
+	return c
+}
+
+// Inject sets the Traceparent header to c, if c is valid.
+func Inject(header http.Header, c TraceContext) {
+	if c.IsValid() {
+		header.Set(Header, c.Traceparent())
+	}
+}
+
+// ExtractFromRequest returns the trace context carried by a serialized HTTP
+// request, reading only its header block.
+func ExtractFromRequest(data []byte) TraceContext {
+	if end := bytes.Index(data, []byte("\r\n\r\n")); end >= 0 {
+		data = data[:end]
+	}
+	prefix := []byte(Header + ":")
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		if len(line) > len(prefix) && bytes.EqualFold(line[:len(prefix)], prefix) {
+			c, _ := ParseTraceparent(string(bytes.TrimSpace(line[len(prefix):])))
+			return c
+		}
+	}
+	return TraceContext{}
+}
+
+func newTraceID() (id [16]byte) {
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() (id [8]byte) {
+	for id == [8]byte{} {
+		rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,290 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Spans are recorded only for sampled traces. The decision is made where a
+// trace starts, by SampleRatio, and followed by every node after that through
+// the sampled flag of traceparent. Finished spans are queued and exported in
+// batches; when the queue is full they are dropped rather than slowing down
+// requests.
+
+type Config struct {
+	Enabled      bool
+	Exporter     string // "otlp" or "file"
+	Endpoint     string // OTLP/HTTP traces endpoint of the collector
+	FilePath     string // file the "file" exporter appends to, one OTLP JSON request per line
+	ServiceName  string
+	SampleRatio  float64 // share of new traces that are recorded
+	BatchSize    int
+	BatchTimeout time.Duration
+	QueueSize    int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Exporter:     "otlp",
+		Endpoint:     "http://127.0.0.1:4318/v1/traces",
+		FilePath:     "../../agent_storage/traces.jsonl",
+		ServiceName:  "arcturus-forwarding",
+		SampleRatio:  1,
+		BatchSize:    512,
+		BatchTimeout: 5 * time.Second,
+		QueueSize:    4096,
+	}
+}
+
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	switch c.Exporter {
+	case "otlp":
+		if c.Endpoint == "" {
+			return fmt.Errorf("the otlp exporter needs an endpoint")
+		}
+	case "file":
+		if c.FilePath == "" {
+			return fmt.Errorf("the file exporter needs a file")
+		}
+	default:
+		return fmt.Errorf("unknown exporter %q, expected otlp or file", c.Exporter)
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio must be between 0 and 1, got %v", c.SampleRatio)
+	}
+	if c.BatchSize <= 0 || c.QueueSize <= 0 || c.BatchTimeout <= 0 {
+		return fmt.Errorf("batch size, batch timeout and queue size must be positive")
+	}
+	return nil
+}
+
+type tracer struct {
+	config   Config
+	exporter exporter
+	queue    chan *spanData
+	dropped  atomic.Int64
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+var active atomic.Pointer[tracer]
+
+// Setup starts exporting spans as configured. Without Setup, or with tracing
+// disabled, spans cost nothing and incoming traceparent headers are passed on
+// untouched.
+func Setup(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if !config.Enabled {
+		return nil
+	}
+	exp, err := newExporter(config)
+	if err != nil {
+		return err
+	}
+	start(config, exp)
+	log.Printf("[Tracing-INFO] Exporting spans with the %s exporter, sample ratio %v.", config.Exporter, config.SampleRatio)
+	return nil
+}
+
+func start(config Config, exp exporter) {
+	t := &tracer{
+		config:   config,
+		exporter: exp,
+		queue:    make(chan *spanData, config.QueueSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go t.run()
+	if previous := active.Swap(t); previous != nil {
+		previous.stop(context.Background())
+	}
+}
+
+// Shutdown exports the spans still queued and stops tracing.
+func Shutdown(ctx context.Context) error {
+	t := active.Swap(nil)
+	if t == nil {
+		return nil
+	}
+	return t.stop(ctx)
+}
+
+func (t *tracer) stop(ctx context.Context) error {
+	close(t.done)
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("spans not exported before shutdown: %w", ctx.Err())
+	}
+	return t.exporter.close()
+}
+
+func (t *tracer) enqueue(span *spanData) {
+	select {
+	case t.queue <- span:
+	default:
+		if t.dropped.Add(1)%1000 == 1 {
+			log.Printf("[Tracing-WARN] Span queue is full, %d span(s) dropped so far.", t.dropped.Load())
+		}
+	}
+}
+
+func (t *tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(t.config.BatchTimeout)
+	defer ticker.Stop()
+
+	batch := make([]*spanData, 0, t.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := t.exporter.export(ctx, t.config.ServiceName, batch); err != nil {
+			log.Printf("[Tracing-ERROR] Failed to export %d span(s): %v", len(batch), err)
+		}
+		cancel()
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= t.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.done:
+			for {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+type SpanKind int
+
+// Span kinds as numbered by OTLP.
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+type attribute struct {
+	key   string
+	value any
+}
+
+type spanData struct {
+	context  TraceContext
+	parentID [8]byte
+	name     string
+	kind     SpanKind
+	start    time.Time
+	end      time.Time
+	attrs    []attribute
+	errorMsg string
+	failed   bool
+}
+
+// Span is an operation of a traced request. A nil *Span is valid and does
+// nothing, which is what Start returns while tracing is disabled.
+type Span struct {
+	mu     sync.Mutex
+	data   spanData
+	tracer *tracer
+	ended  bool
+}
+
+// Start starts a span as a child of parent, or as the root of a new trace
+// when parent is invalid.
+func Start(parent TraceContext, name string, kind SpanKind) *Span {
+	return StartAt(parent, name, kind, time.Now())
+}
+
+// StartAt is Start for an operation that began at start.
+func StartAt(parent TraceContext, name string, kind SpanKind, start time.Time) *Span {
+	t := active.Load()
+	if t == nil {
+		return nil
+	}
+	span := &Span{tracer: t, data: spanData{name: name, kind: kind, start: start}}
+	if parent.IsValid() {
+		span.data.context = TraceContext{TraceID: parent.TraceID, Flags: parent.Flags}
+		span.data.parentID = parent.SpanID
+	} else {
+		span.data.context.TraceID = newTraceID()
+		if rand.Float64() < t.config.SampleRatio {
+			span.data.context.Flags = flagSampled
+		}
+	}
+	span.data.context.SpanID = newSpanID()
+	return span
+}
+
+// Context returns the context children of the span and the next hop continue.
+func (s *Span) Context() TraceContext {
+	if s == nil {
+		return TraceContext{}
+	}
+	return s.data.context
+}
+
+// SetAttribute records a string, bool, integer or float attribute.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil || !s.data.context.Sampled() {
+		return
+	}
+	s.mu.Lock()
+	s.data.attrs = append(s.data.attrs, attribute{key, value})
+	s.mu.Unlock()
+}
+
+// SetError marks the span failed with err. A nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.failed = true
+	s.data.errorMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span; only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.end = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	if data.context.Sampled() {
+		s.tracer.enqueue(&data)
+	}
+}
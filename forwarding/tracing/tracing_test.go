@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	c, ok := ParseTraceparent(valid)
+	if !ok || !c.Sampled() || c.Traceparent() != valid {
+		t.Fatalf("ParseTraceparent(%q) = %+v, %v", valid, c, ok)
+	}
+
+	for _, value := range []string{
+		"",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", // uppercase
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01", // zero trace ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", // zero span ID
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", // forbidden version
+		valid + "-extra", // version 00 has no further fields
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",
+	} {
+		if _, ok := ParseTraceparent(value); ok {
+			t.Errorf("ParseTraceparent(%q) accepted", value)
+		}
+	}
+
+	if c, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); !ok || c.Sampled() {
+		t.Errorf("later version not read as version 00: %+v, %v", c, ok)
+	}
+}
+
+func TestExtractFromRequest(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nHost: example.com\r\ntraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n\r\n" +
+		"Traceparent: 00-11111111111111111111111111111111-2222222222222222-01" // body, not a header
+	c := ExtractFromRequest([]byte(raw))
+	if c.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("extracted %s", c.Traceparent())
+	}
+	if ExtractFromRequest([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")).IsValid() {
+		t.Error("context extracted from a request without traceparent")
+	}
+}
+
+// exportedSpans reads the spans a file exporter wrote to path.
+func exportedSpans(t *testing.T, path string) []otlpSpan {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var spans []otlpSpan
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var req otlpRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Fatalf("line is not an OTLP JSON request: %v", err)
+		}
+		for _, rs := range req.ResourceSpans {
+			if rs.Resource.Attributes[0].Value["stringValue"] != "test-service" {
+				t.Errorf("resource attributes %+v", rs.Resource.Attributes)
+			}
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	config := DefaultConfig()
+	config.Enabled = true
+	config.Exporter = "file"
+	config.FilePath = path
+	config.ServiceName = "test-service"
+	if err := Setup(config); err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	header.Set(Header, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	root := Start(Extract(header), "access.request", KindServer)
+	child := Start(root.Context(), "hop.send", KindClient)
+	child.SetAttribute("arcturus.request_id", uint32(7))
+	child.SetError(errors.New("broken pipe"))
+	child.End()
+	root.End()
+	root.End() // only the first End counts
+
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := exportedSpans(t, path)
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d: %+v", len(spans), spans)
+	}
+	send, request := spans[0], spans[1]
+	if request.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || request.ParentSpanID != "00f067aa0ba902b7" || request.Kind != KindServer {
+		t.Errorf("request span %+v does not continue the client's trace", request)
+	}
+	if send.TraceID != request.TraceID || send.ParentSpanID != request.SpanID {
+		t.Errorf("send span %+v is not a child of %s", send, request.SpanID)
+	}
+	if send.Status == nil || send.Status.Code != 2 || send.Status.Message != "broken pipe" {
+		t.Errorf("send span status %+v", send.Status)
+	}
+	if len(send.Attributes) != 1 || send.Attributes[0].Value["intValue"] != "7" {
+		t.Errorf("send span attributes %+v", send.Attributes)
+	}
+}
+
+func TestUnsampledTracesArePropagatedButNotExported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	config := DefaultConfig()
+	config.Enabled = true
+	config.Exporter = "file"
+	config.FilePath = path
+	config.SampleRatio = 0
+	if err := Setup(config); err != nil {
+		t.Fatal(err)
+	}
+
+	root := Start(TraceContext{}, "access.request", KindServer)
+	if !root.Context().IsValid() || root.Context().Sampled() {
+		t.Fatalf("root context %+v", root.Context())
+	}
+	child := Start(root.Context(), "access.attempt", KindClient)
+	if child.Context().TraceID != root.Context().TraceID || child.Context().Sampled() {
+		t.Errorf("child context %+v", child.Context())
+	}
+	child.End()
+	root.End()
+
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if spans := exportedSpans(t, path); len(spans) != 0 {
+		t.Errorf("unsampled spans exported: %+v", spans)
+	}
+}
+
+func TestDisabledTracingPassesContextThrough(t *testing.T) {
+	span := Start(TraceContext{}, "access.request", KindServer)
+	if span != nil {
+		t.Fatal("span started without Setup")
+	}
+	span.SetAttribute("key", "value")
+	span.End()
+	if span.Context().IsValid() {
+		t.Error("nil span has a valid context")
+	}
+}
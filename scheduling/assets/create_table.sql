@@ -36,9 +36,9 @@ CREATE TABLE system_info (
 
 CREATE TABLE region_probe_info (
     id INT AUTO_INCREMENT PRIMARY KEY,
-    source_ip VARCHAR(15) NOT NULL,
+    source_ip VARCHAR(45) NOT NULL,
     source_region VARCHAR(50) NOT NULL,
-    target_ip VARCHAR(15) NOT NULL,
+    target_ip VARCHAR(45) NOT NULL,
     target_region VARCHAR(50) NOT NULL,
     tcp_delay INT NOT NULL,
     probe_time DATETIME NOT NULL
@@ -46,8 +46,8 @@ CREATE TABLE region_probe_info (
 
 CREATE TABLE network_metrics (
     id INT AUTO_INCREMENT PRIMARY KEY,     
-    source_ip VARCHAR(15) NOT NULL,          
-    destination_ip VARCHAR(15) NOT NULL,      
+    source_ip VARCHAR(45) NOT NULL,          
+    destination_ip VARCHAR(45) NOT NULL,      
     link_latency FLOAT NOT NULL,           
     cpu_mean FLOAT NOT NULL,              
     cpu_variance FLOAT NOT NULL,          
@@ -59,7 +59,17 @@ CREATE TABLE network_metrics (
 
 CREATE TABLE domain_origin (
     domain VARCHAR(20) PRIMARY KEY,
-    origin_ip VARCHAR(20) NOT NULL,
+    origin_ip VARCHAR(45) NOT NULL,
+    origin_scheme VARCHAR(5) NOT NULL DEFAULT 'http',
+    origin_port INT NOT NULL DEFAULT 0,                     -- 0: 443 for https, the relay's SourcePort for http
+    origin_server_name VARCHAR(255) NOT NULL DEFAULT '',   -- SNI override
+    origin_host_header VARCHAR(255) NOT NULL DEFAULT '',
+    origin_ca_pem TEXT,                                     -- CA bundle trusted for this origin
+    origin_insecure_skip_verify BOOLEAN NOT NULL DEFAULT FALSE,
+    rate_limit_rps DOUBLE NOT NULL DEFAULT 0,              -- requests per second for the whole domain on each access node, 0: unlimited
+    rate_limit_burst INT NOT NULL DEFAULT 0,
+    client_rate_limit_rps DOUBLE NOT NULL DEFAULT 0,       -- per client IP, 0: the access node's default
+    client_rate_limit_burst INT NOT NULL DEFAULT 0,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP -- 
 );
 
@@ -78,4 +88,36 @@ CREATE TABLE domain_config (
     total_req_increment INT NOT NULL,
     redistribution_proportion DOUBLE NOT NULL,
     last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE TABLE node_fault (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    fault_id VARCHAR(128) NOT NULL UNIQUE,
+    node_ip VARCHAR(45) NOT NULL,
+    fault_type VARCHAR(50) NOT NULL,
+    fault_description VARCHAR(1024),
+    target VARCHAR(255) NOT NULL DEFAULT '', -- peer, origin or resource the fault is about
+    status VARCHAR(20) NOT NULL DEFAULT 'open', -- open / acknowledged / resolved
+    reported_at DATETIME NOT NULL,
+    last_reported_at DATETIME NOT NULL,
+    acknowledged_at DATETIME NULL,
+    resolved_at DATETIME NULL,
+    INDEX idx_node_fault_ip_status (node_ip, status)
+);
+
+CREATE TABLE node_drain (
+    node_ip VARCHAR(45) PRIMARY KEY,
+    draining_since DATETIME NOT NULL
+);
+
+CREATE TABLE link_latency_info (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    reporter_ip VARCHAR(45) NOT NULL,
+    source_ip VARCHAR(45) NOT NULL,
+    target_ip VARCHAR(45) NOT NULL,
+    rtt_ms FLOAT NOT NULL,
+    min_rtt_ms FLOAT NOT NULL,
+    samples INT NOT NULL,
+    report_time DATETIME NOT NULL,
+    INDEX idx_link_latency_link_time (source_ip, target_ip, report_time)
 );
\ No newline at end of file
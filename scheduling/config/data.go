@@ -20,6 +20,20 @@ type DatabaseConfig struct {
 type DomainOriginEntry struct {
 	Domain   string `toml:"domain"`
 	OriginIP string `toml:"origin_ip"`
+
+	// How the last relay connects to the origin; all optional, the default is plain HTTP
+	Scheme             string `toml:"scheme,omitempty"` // "http" or "https"
+	Port               int    `toml:"port,omitempty"`
+	ServerName         string `toml:"server_name,omitempty"` // SNI override
+	HostHeader         string `toml:"host_header,omitempty"`
+	CAFile             string `toml:"ca_file,omitempty"`              // PEM bundle trusted for this origin
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify,omitempty"` // testing only
+
+	// Token buckets access nodes apply to this domain; 0 leaves a limit to the node's own config
+	RateLimitRPS         float64 `toml:"rate_limit_rps,omitempty"` // all clients together, per access node
+	RateLimitBurst       int     `toml:"rate_limit_burst,omitempty"`
+	ClientRateLimitRPS   float64 `toml:"client_rate_limit_rps,omitempty"` // each client IP
+	ClientRateLimitBurst int     `toml:"client_rate_limit_burst,omitempty"`
 }
 
 // NodeRegionEntry maps to one [[node_regions]] item in TOML
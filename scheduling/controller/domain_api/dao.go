@@ -6,7 +6,7 @@ import (
 
 // DomainDAO extends the existing DAO functionality
 type DomainDAO interface {
-	UpsertDomain(domain, originIP string) error
+	UpsertDomain(mapping DomainMapping) error
 	DeleteDomain(domain string) error
 	GetAllDomains() ([]DomainMapping, error)
 	GetDomainByName(domain string) (string, error)
@@ -19,8 +19,19 @@ type DomainDAOImpl struct {
 
 // DomainMapping represents the mapping of a domain to an IP address
 type DomainMapping struct {
-	Domain   string `json:"domain"`
-	OriginIP string `json:"origin_ip"`
+	Domain             string `json:"domain"`
+	OriginIP           string `json:"origin_ip"`
+	Scheme             string `json:"scheme"`
+	Port               int    `json:"port,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	HostHeader         string `json:"host_header,omitempty"`
+	CAPEM              string `json:"ca_pem,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+
+	RateLimitRPS         float64 `json:"rate_limit_rps,omitempty"`
+	RateLimitBurst       int     `json:"rate_limit_burst,omitempty"`
+	ClientRateLimitRPS   float64 `json:"client_rate_limit_rps,omitempty"`
+	ClientRateLimitBurst int     `json:"client_rate_limit_burst,omitempty"`
 }
 
 // NewDomainDAO creates a new instance of DomainDAO
@@ -29,12 +40,25 @@ func NewDomainDAO(db *sql.DB) DomainDAO {
 }
 
 // UpsertDomain inserts or updates the domain mapping
-func (d *DomainDAOImpl) UpsertDomain(domain, originIP string) error {
-	query := `INSERT INTO domain_origin (domain, origin_ip) 
-              VALUES (?, ?) 
-              ON DUPLICATE KEY UPDATE origin_ip = ?`
-
-	_, err := d.db.Exec(query, domain, originIP, originIP)
+func (d *DomainDAOImpl) UpsertDomain(mapping DomainMapping) error {
+	query := `INSERT INTO domain_origin (domain, origin_ip, origin_scheme, origin_port, origin_server_name,
+              origin_host_header, origin_ca_pem, origin_insecure_skip_verify, rate_limit_rps, rate_limit_burst,
+              client_rate_limit_rps, client_rate_limit_burst)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+              ON DUPLICATE KEY UPDATE origin_ip = VALUES(origin_ip), origin_scheme = VALUES(origin_scheme),
+              origin_port = VALUES(origin_port), origin_server_name = VALUES(origin_server_name),
+              origin_host_header = VALUES(origin_host_header), origin_ca_pem = VALUES(origin_ca_pem),
+              origin_insecure_skip_verify = VALUES(origin_insecure_skip_verify), rate_limit_rps = VALUES(rate_limit_rps),
+              rate_limit_burst = VALUES(rate_limit_burst), client_rate_limit_rps = VALUES(client_rate_limit_rps),
+              client_rate_limit_burst = VALUES(client_rate_limit_burst)`
+
+	var caPEM []byte
+	if mapping.CAPEM != "" {
+		caPEM = []byte(mapping.CAPEM)
+	}
+	_, err := d.db.Exec(query, mapping.Domain, mapping.OriginIP, mapping.Scheme, mapping.Port,
+		mapping.ServerName, mapping.HostHeader, caPEM, mapping.InsecureSkipVerify, mapping.RateLimitRPS,
+		mapping.RateLimitBurst, mapping.ClientRateLimitRPS, mapping.ClientRateLimitBurst)
 	return err
 }
 
@@ -49,7 +73,9 @@ func (d *DomainDAOImpl) DeleteDomain(domain string) error {
 // GetAllDomains retrieves all domain mappings
 func (d *DomainDAOImpl) GetAllDomains() ([]DomainMapping, error) {
 	var domains []DomainMapping
-	query := "SELECT domain, origin_ip FROM domain_origin"
+	query := `SELECT domain, origin_ip, origin_scheme, origin_port, origin_server_name,
+              origin_host_header, origin_ca_pem, origin_insecure_skip_verify, rate_limit_rps, rate_limit_burst,
+              client_rate_limit_rps, client_rate_limit_burst FROM domain_origin`
 
 	rows, err := d.db.Query(query)
 	if err != nil {
@@ -59,9 +85,13 @@ func (d *DomainDAOImpl) GetAllDomains() ([]DomainMapping, error) {
 
 	for rows.Next() {
 		var domain DomainMapping
-		if err := rows.Scan(&domain.Domain, &domain.OriginIP); err != nil {
+		var caPEM []byte
+		if err := rows.Scan(&domain.Domain, &domain.OriginIP, &domain.Scheme, &domain.Port, &domain.ServerName,
+			&domain.HostHeader, &caPEM, &domain.InsecureSkipVerify, &domain.RateLimitRPS, &domain.RateLimitBurst,
+			&domain.ClientRateLimitRPS, &domain.ClientRateLimitBurst); err != nil {
 			return nil, err
 		}
+		domain.CAPEM = string(caPEM)
 		domains = append(domains, domain)
 	}
 
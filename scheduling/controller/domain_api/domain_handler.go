@@ -3,6 +3,7 @@ package domain_api
 import (
 	"encoding/json"
 	"net/http"
+	"scheduling/models"
 	"strings"
 )
 
@@ -20,16 +21,34 @@ type FileManager interface {
 
 // DomainIPMapping is the protobuf version of domain mappings
 type DomainIPMapping struct {
-	Domain string
-	Ip     string
+	Domain    string
+	Ip        string
+	Origin    *OriginConfig
+	RateLimit *RateLimit
 }
 
-// DomainRequest represents a request to add or update a domain
-type DomainRequest struct {
-	Domain   string `json:"domain"`
-	OriginIP string `json:"origin_ip"`
+// OriginConfig mirrors the protobuf OriginConfig
+type OriginConfig struct {
+	Scheme             string
+	Port               int32
+	ServerName         string
+	HostHeader         string
+	CaPem              []byte
+	InsecureSkipVerify bool
 }
 
+// RateLimit mirrors the protobuf RateLimit
+type RateLimit struct {
+	RequestsPerSecond       float64
+	Burst                   int32
+	ClientRequestsPerSecond float64
+	ClientBurst             int32
+}
+
+// DomainRequest represents a request to add or update a domain; the origin
+// fields are optional and default to plain HTTP
+type DomainRequest = DomainMapping
+
 // NewDomainHandler creates a new DomainHandler
 func NewDomainHandler(dao DomainDAO, fileManager FileManager) *DomainHandler {
 	return &DomainHandler{
@@ -59,8 +78,20 @@ func (h *DomainHandler) HandleAddDomain(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	scheme, err := models.NormalizeOriginScheme(req.Scheme)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Scheme = scheme
+
+	if req.RateLimitRPS < 0 || req.RateLimitBurst < 0 || req.ClientRateLimitRPS < 0 || req.ClientRateLimitBurst < 0 {
+		RespondWithError(w, http.StatusBadRequest, "Rate limits cannot be negative")
+		return
+	}
+
 	// Save to database
-	if err := h.dao.UpsertDomain(req.Domain, req.OriginIP); err != nil {
+	if err := h.dao.UpsertDomain(req); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, "Failed to save domain mapping: "+err.Error())
 		return
 	}
@@ -141,10 +172,30 @@ func (h *DomainHandler) syncDomainConfig() error {
 	// Convert to the format expected by FileManager
 	var pbMappings []*DomainIPMapping
 	for _, domain := range domains {
-		pbMappings = append(pbMappings, &DomainIPMapping{
+		mapping := &DomainIPMapping{
 			Domain: domain.Domain,
 			Ip:     domain.OriginIP,
-		})
+		}
+		if domain.Scheme != "http" || domain.Port != 0 || domain.ServerName != "" || domain.HostHeader != "" ||
+			domain.CAPEM != "" || domain.InsecureSkipVerify {
+			mapping.Origin = &OriginConfig{
+				Scheme:             domain.Scheme,
+				Port:               int32(domain.Port),
+				ServerName:         domain.ServerName,
+				HostHeader:         domain.HostHeader,
+				CaPem:              []byte(domain.CAPEM),
+				InsecureSkipVerify: domain.InsecureSkipVerify,
+			}
+		}
+		if domain.RateLimitRPS > 0 || domain.RateLimitBurst > 0 || domain.ClientRateLimitRPS > 0 || domain.ClientRateLimitBurst > 0 {
+			mapping.RateLimit = &RateLimit{
+				RequestsPerSecond:       domain.RateLimitRPS,
+				Burst:                   int32(domain.RateLimitBurst),
+				ClientRequestsPerSecond: domain.ClientRateLimitRPS,
+				ClientBurst:             int32(domain.ClientRateLimitBurst),
+			}
+		}
+		pbMappings = append(pbMappings, mapping)
 	}
 
 	// Save using FileManager
@@ -83,6 +83,12 @@ func (ac *Calculator) calculateRegionAssessments() ([]*pb.RegionPairAssessment,
 		return nil, err
 	}
 
+	// Nodes with an unresolved fault or draining take no part in assessments
+	unavailableIPs, err := models.GetUnavailableNodeIPs(ac.db)
+	if err != nil {
+		return nil, err
+	}
+
 	var assessments []*pb.RegionPairAssessment
 	var assessmentsMutex sync.Mutex
 	var wg sync.WaitGroup
@@ -112,6 +118,7 @@ func (ac *Calculator) calculateRegionAssessments() ([]*pb.RegionPairAssessment,
 			log.Printf("RegionTaskFunc: Error getting source IPs for %s: %v", region1, err)
 			return
 		}
+		sourceIPs = excludeUnavailableIPs(sourceIPs, unavailableIPs)
 		log.Printf("RegionTaskFunc: Source IPs for %s: %v", region1, sourceIPs)
 
 		targetIPs, err := models.GetRegionIPs(ac.db, region2)
@@ -119,6 +126,7 @@ func (ac *Calculator) calculateRegionAssessments() ([]*pb.RegionPairAssessment,
 			log.Printf("RegionTaskFunc: Error getting target IPs for %s: %v", region2, err)
 			return
 		}
+		targetIPs = excludeUnavailableIPs(targetIPs, unavailableIPs)
 		log.Printf("RegionTaskFunc: Target IPs for %s: %v", region2, targetIPs)
 
 		var ipPairs []*pb.IPPairAssessment
@@ -251,6 +259,21 @@ func (ac *Calculator) calculateRegionAssessments() ([]*pb.RegionPairAssessment,
 	return assessments, nil
 }
 
+func excludeUnavailableIPs(ips []string, unavailableIPs map[string]bool) []string {
+	if len(unavailableIPs) == 0 {
+		return ips
+	}
+	healthy := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if unavailableIPs[ip] {
+			log.Printf("AssessmentCalculator: Skipping faulted or draining node %s", ip)
+			continue
+		}
+		healthy = append(healthy, ip)
+	}
+	return healthy
+}
+
 func (ac *Calculator) getCurrentNetState() (config.NetState, error) {
 
 	aboveCpuMeans, belowCpuMeans, aboveCpuVars, belowCpuVars, err := models.GetCPUPerformanceList(
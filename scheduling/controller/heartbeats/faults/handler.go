@@ -0,0 +1,108 @@
+package faults
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	pb "scheduling/controller/heartbeats/proto"
+	"scheduling/models"
+	"time"
+
+	"google.golang.org/grpc/peer"
+)
+
+// Handler implements FaultService. Faults stay open until the node syncs
+// (acknowledged) and are resolved once the node stops reporting them for
+// resolveAfter.
+type Handler struct {
+	pb.UnimplementedFaultServiceServer
+	db           *sql.DB
+	resolveAfter time.Duration
+}
+
+func NewHandler(db *sql.DB, resolveAfter time.Duration) *Handler {
+	return &Handler{
+		db:           db,
+		resolveAfter: resolveAfter,
+	}
+}
+
+func (h *Handler) ReportFault(ctx context.Context, req *pb.ReportFaultRequest) (*pb.SimpleResponse, error) {
+	fault := req.GetFaultInfo()
+	if fault == nil || fault.NodeIp == "" || fault.FaultType == "" {
+		return &pb.SimpleResponse{
+			Status:  "error",
+			Message: "fault_info with node_ip and fault_type is required",
+		}, nil
+	}
+
+	if err := checkPeerIP(ctx, fault.NodeIp); err != nil {
+		log.Printf("Refusing fault report for %s: %v", fault.NodeIp, err)
+		return &pb.SimpleResponse{
+			Status:  "error",
+			Message: err.Error(),
+		}, nil
+	}
+
+	if fault.FaultId == "" {
+		// Same node, type and target collapse into one row so repeated reports do not pile up
+		fault.FaultId = fmt.Sprintf("%s_%s", fault.NodeIp, fault.FaultType)
+		if fault.Target != "" {
+			fault.FaultId += "_" + fault.Target
+		}
+	}
+
+	if err := models.UpsertNodeFault(h.db, fault, time.Now()); err != nil {
+		log.Printf("Error recording fault %s from %s: %v", fault.FaultId, fault.NodeIp, err)
+		return &pb.SimpleResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("failed to record fault: %v", err),
+		}, nil
+	}
+
+	log.Printf("Fault reported: id=%s node=%s type=%s target=%s description=%q", fault.FaultId, fault.NodeIp, fault.FaultType, fault.Target, fault.FaultDescription)
+	return &pb.SimpleResponse{
+		Status:  "ok",
+		Message: fault.FaultId,
+	}, nil
+}
+
+// checkPeerIP ensures a node can only report faults as itself.
+func checkPeerIP(ctx context.Context, nodeIP string) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return fmt.Errorf("unknown peer address")
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return fmt.Errorf("invalid peer address %s: %w", p.Addr, err)
+	}
+	if !net.ParseIP(host).Equal(net.ParseIP(nodeIP)) {
+		return fmt.Errorf("report from %s for node_ip %s", host, nodeIP)
+	}
+	return nil
+}
+
+// StartResolver periodically resolves faults that are no longer being reported.
+func (h *Handler) StartResolver(ctx context.Context) {
+	ticker := time.NewTicker(h.resolveAfter / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			resolved, err := models.ResolveStaleFaults(h.db, time.Now().Add(-h.resolveAfter))
+			if err != nil {
+				log.Printf("Error resolving stale faults: %v", err)
+				continue
+			}
+			if resolved > 0 {
+				log.Printf("Resolved %d faults not reported within %v", resolved, h.resolveAfter)
+			}
+		}
+	}
+}
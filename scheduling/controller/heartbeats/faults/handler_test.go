@@ -0,0 +1,85 @@
+package faults
+
+import (
+	"context"
+	"net"
+	pb "scheduling/controller/heartbeats/proto"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/peer"
+)
+
+func peerContext(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000},
+	})
+}
+
+func TestReportFaultRejectsIncompleteFault(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	handler := NewHandler(db, time.Minute)
+
+	resp, err := handler.ReportFault(context.Background(), &pb.ReportFaultRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "error", resp.Status)
+
+	resp, err = handler.ReportFault(context.Background(), &pb.ReportFaultRequest{
+		FaultInfo: &pb.FaultInfo{NodeIp: "10.0.0.1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "error", resp.Status)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReportFaultDerivesFaultID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO node_fault").
+		WithArgs("10.0.0.1_probe_timeout", "10.0.0.1", "probe_timeout", "3 consecutive failures", "", "open",
+			sqlmock.AnyArg(), sqlmock.AnyArg(), "resolved", "resolved", "resolved", "open").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	handler := NewHandler(db, time.Minute)
+	resp, err := handler.ReportFault(peerContext("10.0.0.1"), &pb.ReportFaultRequest{
+		FaultInfo: &pb.FaultInfo{
+			NodeIp:           "10.0.0.1",
+			FaultType:        "probe_timeout",
+			FaultDescription: "3 consecutive failures",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp.Status)
+	assert.Equal(t, "10.0.0.1_probe_timeout", resp.Message)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReportFaultRejectsSpoofedNodeIP(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	handler := NewHandler(db, time.Minute)
+	fault := &pb.FaultInfo{NodeIp: "10.0.0.1", FaultType: "probe_unreachable", Target: "10.0.0.4"}
+
+	// A node reporting in the name of another one is refused without touching the table
+	resp, err := handler.ReportFault(peerContext("10.0.0.2"), &pb.ReportFaultRequest{FaultInfo: fault})
+	require.NoError(t, err)
+	assert.Equal(t, "error", resp.Status)
+
+	resp, err = handler.ReportFault(context.Background(), &pb.ReportFaultRequest{FaultInfo: fault})
+	require.NoError(t, err)
+	assert.Equal(t, "error", resp.Status)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,96 @@
+package identity
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	pb "scheduling/controller/heartbeats/proto"
+	"scheduling/models"
+	"time"
+
+	"google.golang.org/grpc/peer"
+)
+
+// Handler implements IdentityService. Certificates are only issued to nodes
+// registered in node_region, and only for the address the request comes from.
+type Handler struct {
+	pb.UnimplementedIdentityServiceServer
+	db       *sql.DB
+	ca       *CA
+	validity time.Duration
+}
+
+func NewHandler(db *sql.DB, ca *CA, validity time.Duration) *Handler {
+	return &Handler{
+		db:       db,
+		ca:       ca,
+		validity: validity,
+	}
+}
+
+func (h *Handler) IssueNodeCertificate(ctx context.Context, req *pb.NodeCertificateRequest) (*pb.NodeCertificateResponse, error) {
+	if net.ParseIP(req.NodeIp) == nil || len(req.CsrPem) == 0 {
+		return &pb.NodeCertificateResponse{
+			Status:  "error",
+			Message: "node_ip and csr_pem are required",
+		}, nil
+	}
+
+	if err := checkPeerIP(ctx, req.NodeIp); err != nil {
+		log.Printf("Refusing certificate for %s: %v", req.NodeIp, err)
+		return &pb.NodeCertificateResponse{
+			Status:  "error",
+			Message: err.Error(),
+		}, nil
+	}
+
+	region, err := models.GetNodeRegion(h.db, req.NodeIp)
+	if err != nil {
+		log.Printf("Error looking up node %s: %v", req.NodeIp, err)
+		return &pb.NodeCertificateResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("failed to look up node: %v", err),
+		}, nil
+	}
+	if region == "unknown" {
+		log.Printf("Refusing certificate for unregistered node %s", req.NodeIp)
+		return &pb.NodeCertificateResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("node %s is not registered", req.NodeIp),
+		}, nil
+	}
+
+	certPEM, err := h.ca.SignNodeCSR(req.CsrPem, req.NodeIp, h.validity)
+	if err != nil {
+		return &pb.NodeCertificateResponse{
+			Status:  "error",
+			Message: err.Error(),
+		}, nil
+	}
+
+	log.Printf("Issued node certificate for %s (region %s), valid for %v", req.NodeIp, region, h.validity)
+	return &pb.NodeCertificateResponse{
+		Status:           "ok",
+		Message:          "certificate issued",
+		CertificatePem:   certPEM,
+		CaCertificatePem: h.ca.CertificatePEM(),
+	}, nil
+}
+
+// checkPeerIP ensures a node can only obtain a certificate for its own address.
+func checkPeerIP(ctx context.Context, nodeIP string) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return fmt.Errorf("unknown peer address")
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return fmt.Errorf("invalid peer address %s: %w", p.Addr, err)
+	}
+	if !net.ParseIP(host).Equal(net.ParseIP(nodeIP)) {
+		return fmt.Errorf("request from %s for node_ip %s", host, nodeIP)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package identity
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	pb "scheduling/controller/heartbeats/proto"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/peer"
+)
+
+func newCSR(t *testing.T, ips ...string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "node"}}
+	for _, ip := range ips {
+		template.IPAddresses = append(template.IPAddresses, net.ParseIP(ip))
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func peerContext(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000},
+	})
+}
+
+func TestLoadOrCreateCAPersists(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, err := LoadOrCreateCA(dir)
+	require.NoError(t, err)
+	reloaded, err := LoadOrCreateCA(dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, ca.CertificatePEM(), reloaded.CertificatePEM())
+	assert.True(t, reloaded.cert.IsCA)
+}
+
+func TestIssueNodeCertificate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	ca, err := LoadOrCreateCA(t.TempDir())
+	require.NoError(t, err)
+
+	mock.ExpectQuery("SELECT region FROM node_region").
+		WithArgs("10.0.0.1").
+		WillReturnRows(sqlmock.NewRows([]string{"region"}).AddRow("us-east"))
+
+	handler := NewHandler(db, ca, 24*time.Hour)
+	// Extra names requested in the CSR must not end up in the certificate
+	resp, err := handler.IssueNodeCertificate(peerContext("10.0.0.1"), &pb.NodeCertificateRequest{
+		NodeIp: "10.0.0.1",
+		CsrPem: newCSR(t, "10.0.0.1", "10.0.0.99"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "ok", resp.Status, resp.Message)
+	assert.Equal(t, ca.CertificatePEM(), resp.CaCertificatePem)
+
+	block, _ := pem.Decode(resp.CertificatePem)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	require.Len(t, cert.IPAddresses, 1)
+	assert.Equal(t, "10.0.0.1", cert.IPAddresses[0].String())
+	assert.ElementsMatch(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIssueNodeCertificateRefusals(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	ca, err := LoadOrCreateCA(t.TempDir())
+	require.NoError(t, err)
+	handler := NewHandler(db, ca, 24*time.Hour)
+
+	// Missing fields
+	resp, err := handler.IssueNodeCertificate(peerContext("10.0.0.1"), &pb.NodeCertificateRequest{NodeIp: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.Equal(t, "error", resp.Status)
+
+	// Request for another node's IP
+	resp, err = handler.IssueNodeCertificate(peerContext("10.0.0.2"), &pb.NodeCertificateRequest{
+		NodeIp: "10.0.0.1",
+		CsrPem: newCSR(t),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "error", resp.Status)
+
+	// Node not registered
+	mock.ExpectQuery("SELECT region FROM node_region").
+		WithArgs("10.0.0.3").
+		WillReturnRows(sqlmock.NewRows([]string{"region"}))
+	resp, err = handler.IssueNodeCertificate(peerContext("10.0.0.3"), &pb.NodeCertificateRequest{
+		NodeIp: "10.0.0.3",
+		CsrPem: newCSR(t),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "error", resp.Status)
+	assert.Empty(t, resp.CertificatePem)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -32,6 +32,8 @@ type Handler struct {
 	initMutex        sync.Mutex
 	initTimer        *time.Timer
 	bufferPeriod     time.Duration
+	drainMutex       sync.Mutex
+	draining         map[string]bool // last draining flag stored per node
 }
 
 func NewHandler(
@@ -52,6 +54,7 @@ func NewHandler(
 		assessmentCalc: assessmentCalc,
 		processor:      NewProcessor(db),
 		bufferPeriod:   bufferPeriod,
+		draining:       make(map[string]bool),
 	}
 
 	handler.generatorStarted.Store(false)
@@ -136,6 +139,8 @@ func (h *Handler) SyncMetrics(ctx context.Context, req *pb.SyncRequest) (*pb.Syn
 		}, nil
 	}
 
+	h.recordDraining(req.Metrics.Ip, req.Draining)
+
 	nodeListNeedsUpdate := false
 	probeTasksNeedUpdate := false
 	domainIPMappingsNeedUpdate := false
@@ -180,6 +185,19 @@ func (h *Handler) SyncMetrics(ctx context.Context, req *pb.SyncRequest) (*pb.Syn
 
 		}
 	}
+	if len(req.LinkLatencies) > 0 {
+		if err := h.processor.ProcessLinkLatencies(req.Metrics.Ip, req.LinkLatencies); err != nil {
+			log.Printf("Error storing link latencies from %s: %v", req.Metrics.Ip, err)
+		}
+	}
+	acknowledgedFaults, err := models.AcknowledgeNodeFaults(h.db, req.Metrics.Ip)
+	if err != nil {
+		log.Printf("Error acknowledging faults for %s: %v", req.Metrics.Ip, err)
+	} else if len(acknowledgedFaults) > 0 {
+		resp.AcknowledgedFaults = acknowledgedFaults
+		log.Printf(" req.Metrics.Ip:%s  acknowledgedFaults:%d ", req.Metrics.Ip, len(acknowledgedFaults))
+	}
+
 	regionAssessments := h.assessmentCalc.GetCachedAssessments()
 	log.Printf(" req.Metrics.Ip:%s  regionAssessments:%d ", req.Metrics.Ip, len(regionAssessments))
 	if len(regionAssessments) > 0 {
@@ -189,6 +207,29 @@ func (h *Handler) SyncMetrics(ctx context.Context, req *pb.SyncRequest) (*pb.Syn
 	return resp, nil
 }
 
+// recordDraining stores a change of a node's draining flag. The first sync of
+// each node after a controller start is always stored, which clears a flag
+// left behind by a node that restarted meanwhile.
+func (h *Handler) recordDraining(nodeIP string, draining bool) {
+	h.drainMutex.Lock()
+	defer h.drainMutex.Unlock()
+
+	known, seen := h.draining[nodeIP]
+	if seen && known == draining {
+		return
+	}
+	if err := models.SetNodeDraining(h.db, nodeIP, draining, time.Now()); err != nil {
+		log.Printf("Error recording drain state of %s: %v", nodeIP, err)
+		return
+	}
+	h.draining[nodeIP] = draining
+	if draining {
+		log.Printf("Node %s is draining, leaving it out of assessments and BPR.", nodeIP)
+	} else if seen {
+		log.Printf("Node %s is in service again.", nodeIP)
+	}
+}
+
 func (h *Handler) StartBackgroundServicesWhenReady(ctx context.Context) {
 	if h.generatorStarted.Load() && h.calcStarted.Load() {
 		log.Println("，")
@@ -60,6 +60,20 @@ func (p *Processor) ProcessProbeResults(sourceIP string, results []*pb.RegionPro
 	return nil
 }
 
+// ProcessLinkLatencies stores the link round trip times reported by sourceIP,
+// leaving out entries without both ends or samples.
+func (p *Processor) ProcessLinkLatencies(sourceIP string, latencies []*pb.LinkLatency) error {
+	valid := make([]*pb.LinkLatency, 0, len(latencies))
+	for _, l := range latencies {
+		if l.SourceIp == "" || l.TargetIp == "" || l.Samples <= 0 || l.RttMs < 0 {
+			log.Printf("Warning: Skipping invalid link latency from %s: %v", sourceIP, l)
+			continue
+		}
+		valid = append(valid, l)
+	}
+	return models.InsertLinkLatencies(p.db, sourceIP, valid, time.Now())
+}
+
 /*func (p *Processor) ProcessProbeResults(sourceIP string, results []*pb.RegionProbeResult, fileManager *storage.FileManager) error {
 	probeTime := time.Now()
 	sourceRegion, err := models.GetNodeRegion(p.db, sourceIP)
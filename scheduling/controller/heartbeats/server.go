@@ -10,6 +10,8 @@ import (
 	"os/signal"
 	"scheduling/controller/heartbeats/assessment"
 	cf "scheduling/controller/heartbeats/config"
+	"scheduling/controller/heartbeats/faults"
+	"scheduling/controller/heartbeats/identity"
 	"scheduling/controller/heartbeats/metrics"
 	pb "scheduling/controller/heartbeats/proto"
 	"scheduling/controller/heartbeats/storage"
@@ -25,6 +27,11 @@ type ServerConfig struct {
 	ListenAddr   string
 	DataDir      string
 	BufferPeriod time.Duration
+	// FaultResolveAfter is how long a fault may go unreported before it is resolved
+	FaultResolveAfter time.Duration
+	// IdentityDir holds the CA that issues node certificates for the inter-node transport
+	IdentityDir  string
+	CertValidity time.Duration
 }
 
 type HeartbeatServer struct {
@@ -33,6 +40,8 @@ type HeartbeatServer struct {
 	grpcServer      *grpc.Server
 	fileManager     *storage.FileManager
 	metricsHandler  *metrics.Handler
+	faultHandler    *faults.Handler
+	identityHandler *identity.Handler
 	shutdownHandler utils.ShutdownHandler
 }
 
@@ -47,6 +56,11 @@ func NewHeartbeatServer(config ServerConfig, db *sql.DB) (*HeartbeatServer, erro
 		return nil, fmt.Errorf(": %v", err)
 	}
 
+	ca, err := identity.LoadOrCreateCA(config.IdentityDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node CA: %w", err)
+	}
+
 	configPusher := cf.NewPusher()
 
 	taskGenerator := tasks.NewTaskGenerator(db, fileManager, 5*time.Minute)
@@ -64,10 +78,12 @@ func NewHeartbeatServer(config ServerConfig, db *sql.DB) (*HeartbeatServer, erro
 	)
 
 	return &HeartbeatServer{
-		config:         config,
-		db:             db,
-		fileManager:    fileManager,
-		metricsHandler: metricsHandler,
+		config:          config,
+		db:              db,
+		fileManager:     fileManager,
+		metricsHandler:  metricsHandler,
+		faultHandler:    faults.NewHandler(db, config.FaultResolveAfter),
+		identityHandler: identity.NewHandler(db, ca, config.CertValidity),
 
 		shutdownHandler: utils.NewShutdownHandler(func() {
 			configPusher.Release()
@@ -85,6 +101,8 @@ func (s *HeartbeatServer) Start(ctx context.Context) error {
 
 	s.grpcServer = grpc.NewServer()
 	pb.RegisterMetricsServiceServer(s.grpcServer, s.metricsHandler)
+	pb.RegisterFaultServiceServer(s.grpcServer, s.faultHandler)
+	pb.RegisterIdentityServiceServer(s.grpcServer, s.identityHandler)
 
 	lis, err := net.Listen("tcp", s.config.ListenAddr)
 	if err != nil {
@@ -92,6 +110,7 @@ func (s *HeartbeatServer) Start(ctx context.Context) error {
 	}
 
 	go s.metricsHandler.StartBackgroundServicesWhenReady(ctx)
+	go s.faultHandler.StartResolver(ctx)
 
 	go func() {
 		<-stopChan
@@ -122,9 +141,12 @@ func StartServer(ctx context.Context, db *sql.DB) {
 	addr := "0.0.0.0:8080"
 
 	config := ServerConfig{
-		ListenAddr:   addr,
-		DataDir:      dataDir,
-		BufferPeriod: 20 * time.Second,
+		ListenAddr:        addr,
+		DataDir:           dataDir,
+		BufferPeriod:      20 * time.Second,
+		FaultResolveAfter: 2 * time.Minute,
+		IdentityDir:       dataDir + "identity",
+		CertValidity:      30 * 24 * time.Hour,
 	}
 
 	server, err := NewHeartbeatServer(config, db)
@@ -280,6 +280,22 @@ func Bpr(db *sql.DB, region string, totalReqIncrement int, redistributionProport
 		return nil, nil
 	}
 
+	// Faulted and draining nodes receive no traffic until they are back in service
+	unavailableIPs, err := models.GetUnavailableNodeIPs(db)
+	if err != nil {
+		log.Printf("Error fetching unavailable nodes for region '%s': %v", region, err)
+		return nil, nil
+	}
+	healthyNodes := dbNodes[:0]
+	for _, dbNode := range dbNodes {
+		if unavailableIPs[dbNode.IP] {
+			log.Printf("Excluding faulted or draining node %s from BPR for region '%s'.", dbNode.IP, region)
+			continue
+		}
+		healthyNodes = append(healthyNodes, dbNode)
+	}
+	dbNodes = healthyNodes
+
 	if len(dbNodes) == 0 {
 		log.Printf("No nodes found in region '%s'. Aborting BPR.", region)
 		return nil, nil
@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -112,15 +115,19 @@ func (w *WeightedRedirector) ServeHTTP(rw http.ResponseWriter, req *http.Request
 		}
 	}
 
+	targetHost := selectedIP
+	if strings.Contains(targetHost, ":") {
+		targetHost = "[" + targetHost + "]" // IPv6 literal
+	}
 	targetURLVal := url.URL{
 		Scheme: w.config.DefaultScheme,
-		Host:   selectedIP,
+		Host:   targetHost,
 	}
 	// Only add port if it's not the default port for its protocol
 	if w.config.DefaultPort > 0 &&
 		!((w.config.DefaultScheme == "http" && w.config.DefaultPort == 80) ||
 			(w.config.DefaultScheme == "https" && w.config.DefaultPort == 443)) {
-		targetURLVal.Host = fmt.Sprintf("%s:%d", selectedIP, w.config.DefaultPort)
+		targetURLVal.Host = net.JoinHostPort(selectedIP, strconv.Itoa(w.config.DefaultPort))
 	}
 
 	if w.config.PreservePathAndQuery {
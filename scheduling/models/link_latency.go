@@ -0,0 +1,30 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	pb "scheduling/controller/heartbeats/proto"
+	"strings"
+	"time"
+)
+
+// InsertLinkLatencies stores the link round trip times an access node measured
+// from the hop stamps of the traffic it forwarded, as reported in its
+// SyncRequest. They complement the TCP-connect delays of region_probe_info.
+func InsertLinkLatencies(db *sql.DB, reporterIP string, latencies []*pb.LinkLatency, reportTime time.Time) error {
+	if len(latencies) == 0 {
+		return nil
+	}
+	rows := make([]string, 0, len(latencies))
+	args := make([]interface{}, 0, len(latencies)*7)
+	for _, l := range latencies {
+		rows = append(rows, "(?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, reporterIP, l.SourceIp, l.TargetIp, l.RttMs, l.MinRttMs, l.Samples, reportTime)
+	}
+	query := "INSERT INTO link_latency_info (reporter_ip, source_ip, target_ip, rtt_ms, min_rtt_ms, samples, report_time) VALUES " +
+		strings.Join(rows, ", ")
+	if _, err := db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to insert %d link latencies reported by %s: %w", len(latencies), reporterIP, err)
+	}
+	return nil
+}
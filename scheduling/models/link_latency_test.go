@@ -0,0 +1,30 @@
+package models
+
+import (
+	pb "scheduling/controller/heartbeats/proto"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertLinkLatencies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	reportTime := time.Now()
+	mock.ExpectExec(`INSERT INTO link_latency_info \(reporter_ip, source_ip, target_ip, rtt_ms, min_rtt_ms, samples, report_time\) VALUES \(\?, \?, \?, \?, \?, \?, \?\), \(\?, \?, \?, \?, \?, \?, \?\)`).
+		WithArgs("10.0.0.1", "10.0.0.1", "10.0.0.2", 10.5, 8.0, int64(4), reportTime,
+			"10.0.0.1", "10.0.0.2", "10.0.0.3", 30.0, 29.0, int64(1), reportTime).
+		WillReturnResult(sqlmock.NewResult(2, 2))
+
+	require.NoError(t, InsertLinkLatencies(db, "10.0.0.1", []*pb.LinkLatency{
+		{SourceIp: "10.0.0.1", TargetIp: "10.0.0.2", RttMs: 10.5, MinRttMs: 8, Samples: 4},
+		{SourceIp: "10.0.0.2", TargetIp: "10.0.0.3", RttMs: 30, MinRttMs: 29, Samples: 1},
+	}, reportTime))
+	require.NoError(t, InsertLinkLatencies(db, "10.0.0.1", nil, reportTime))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
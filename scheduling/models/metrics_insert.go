@@ -4,8 +4,10 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
 	"scheduling/config"
 	pb "scheduling/controller/heartbeats/proto"
+	"strings"
 	"time"
 )
 
@@ -101,25 +103,62 @@ func InsertDomainOrigins(db *sql.DB, domains []config.DomainOriginEntry) error {
 	// Prepare statement for inserting data
 	// Using ON DUPLICATE KEY UPDATE to handle cases where the domain might already exist.
 	// You can choose to error out instead if that's preferred.
-	stmt, err := db.Prepare("INSERT INTO domain_origin (domain, origin_ip) VALUES (?, ?) ON DUPLICATE KEY UPDATE origin_ip = VALUES(origin_ip)")
+	stmt, err := db.Prepare(`INSERT INTO domain_origin (domain, origin_ip, origin_scheme, origin_port, origin_server_name,
+		origin_host_header, origin_ca_pem, origin_insecure_skip_verify, rate_limit_rps, rate_limit_burst,
+		client_rate_limit_rps, client_rate_limit_burst) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE origin_ip = VALUES(origin_ip), origin_scheme = VALUES(origin_scheme),
+		origin_port = VALUES(origin_port), origin_server_name = VALUES(origin_server_name),
+		origin_host_header = VALUES(origin_host_header), origin_ca_pem = VALUES(origin_ca_pem),
+		origin_insecure_skip_verify = VALUES(origin_insecure_skip_verify), rate_limit_rps = VALUES(rate_limit_rps),
+		rate_limit_burst = VALUES(rate_limit_burst), client_rate_limit_rps = VALUES(client_rate_limit_rps),
+		client_rate_limit_burst = VALUES(client_rate_limit_burst)`)
 	if err != nil {
 		return fmt.Errorf("error preparing domain_origin insert statement: %w", err)
 	}
 	defer stmt.Close()
 
 	for _, d := range domains {
-		_, err := stmt.Exec(d.Domain, d.OriginIP)
+		scheme, err := NormalizeOriginScheme(d.Scheme)
+		if err != nil {
+			log.Printf("Skipping domain_origin %s: %v", d.Domain, err)
+			continue
+		}
+		var caPEM []byte
+		if d.CAFile != "" {
+			if caPEM, err = os.ReadFile(d.CAFile); err != nil {
+				log.Printf("Skipping domain_origin %s: failed to read ca_file: %v", d.Domain, err)
+				continue
+			}
+		}
+		if d.RateLimitRPS < 0 || d.RateLimitBurst < 0 || d.ClientRateLimitRPS < 0 || d.ClientRateLimitBurst < 0 {
+			log.Printf("Skipping domain_origin %s: rate limits must not be negative", d.Domain)
+			continue
+		}
+		_, err = stmt.Exec(d.Domain, d.OriginIP, scheme, d.Port, d.ServerName, d.HostHeader, caPEM, d.InsecureSkipVerify,
+			d.RateLimitRPS, d.RateLimitBurst, d.ClientRateLimitRPS, d.ClientRateLimitBurst)
 		if err != nil {
 			// Log individual errors but continue if possible, or return immediately
 			log.Printf("Error inserting domain_origin (domain: %s, ip: %s): %v", d.Domain, d.OriginIP, err)
 			// return fmt.Errorf("error executing domain_origin insert for domain %s: %w", d.Domain, err) // Uncomment to stop on first error
 		} else {
-			log.Printf("Successfully inserted/updated domain_origin: %s -> %s", d.Domain, d.OriginIP)
+			log.Printf("Successfully inserted/updated domain_origin: %s -> %s://%s", d.Domain, scheme, d.OriginIP)
 		}
 	}
 	return nil // Return nil if we are logging errors but continuing
 }
 
+// NormalizeOriginScheme validates an origin scheme, defaulting to http.
+func NormalizeOriginScheme(scheme string) (string, error) {
+	switch strings.ToLower(scheme) {
+	case "", "http":
+		return "http", nil
+	case "https":
+		return "https", nil
+	default:
+		return "", fmt.Errorf("unsupported origin scheme %q", scheme)
+	}
+}
+
 // InsertNodeRegions inserts data into the node_region table
 func InsertNodeRegions(db *sql.DB, nodes []config.NodeRegionEntry) error {
 	if len(nodes) == 0 {
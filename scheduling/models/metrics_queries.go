@@ -281,7 +281,9 @@ func QueryNodeInfo(db *sql.DB) ([]*pb.NodeInfo, error) {
 
 func QueryDomainIPMappings(db *sql.DB) ([]*pb.DomainIPMapping, error) {
 
-	rows, err := db.Query("SELECT domain, origin_ip FROM domain_origin")
+	rows, err := db.Query(`SELECT domain, origin_ip, origin_scheme, origin_port, origin_server_name,
+		origin_host_header, origin_ca_pem, origin_insecure_skip_verify, rate_limit_rps, rate_limit_burst,
+		client_rate_limit_rps, client_rate_limit_burst FROM domain_origin`)
 	if err != nil {
 		return nil, err
 	}
@@ -290,9 +292,21 @@ func QueryDomainIPMappings(db *sql.DB) ([]*pb.DomainIPMapping, error) {
 	var mappings []*pb.DomainIPMapping
 	for rows.Next() {
 		var mapping pb.DomainIPMapping
-		if err := rows.Scan(&mapping.Domain, &mapping.Ip); err != nil {
+		origin := &pb.OriginConfig{}
+		limit := &pb.RateLimit{}
+		if err := rows.Scan(&mapping.Domain, &mapping.Ip, &origin.Scheme, &origin.Port, &origin.ServerName,
+			&origin.HostHeader, &origin.CaPem, &origin.InsecureSkipVerify, &limit.RequestsPerSecond, &limit.Burst,
+			&limit.ClientRequestsPerSecond, &limit.ClientBurst); err != nil {
 			return nil, err
 		}
+		// Plain HTTP without overrides is what relays do for a missing origin config
+		if origin.Scheme != "http" || origin.Port != 0 || origin.ServerName != "" || origin.HostHeader != "" ||
+			len(origin.CaPem) > 0 || origin.InsecureSkipVerify {
+			mapping.Origin = origin
+		}
+		if limit.RequestsPerSecond > 0 || limit.Burst > 0 || limit.ClientRequestsPerSecond > 0 || limit.ClientBurst > 0 {
+			mapping.RateLimit = limit
+		}
 		mappings = append(mappings, &mapping)
 	}
 
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryDomainIPMappingsOriginConfig(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{"domain", "origin_ip", "origin_scheme", "origin_port", "origin_server_name",
+		"origin_host_header", "origin_ca_pem", "origin_insecure_skip_verify", "rate_limit_rps", "rate_limit_burst",
+		"client_rate_limit_rps", "client_rate_limit_burst"}
+	mock.ExpectQuery("SELECT domain, origin_ip, origin_scheme").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("plain.example.com", "10.0.1.5", "http", 0, "", "", nil, false, 0, 0, 0, 0).
+			AddRow("secure.example.com", "10.0.1.6", "https", 8443, "origin.example.com", "www.example.com", []byte("PEM"), false, 500, 1000, 5, 20))
+
+	mappings, err := QueryDomainIPMappings(db)
+	require.NoError(t, err)
+	require.Len(t, mappings, 2)
+
+	assert.Nil(t, mappings[0].Origin, "plain HTTP mappings carry no origin config")
+	assert.Nil(t, mappings[0].RateLimit, "unlimited mappings carry no rate limit")
+
+	origin := mappings[1].Origin
+	require.NotNil(t, origin)
+	assert.Equal(t, "https", origin.Scheme)
+	assert.Equal(t, int32(8443), origin.Port)
+	assert.Equal(t, "origin.example.com", origin.ServerName)
+	assert.Equal(t, "www.example.com", origin.HostHeader)
+	assert.Equal(t, []byte("PEM"), origin.CaPem)
+
+	limit := mappings[1].RateLimit
+	require.NotNil(t, limit)
+	assert.Equal(t, 500.0, limit.RequestsPerSecond)
+	assert.Equal(t, int32(1000), limit.Burst)
+	assert.Equal(t, 5.0, limit.ClientRequestsPerSecond)
+	assert.Equal(t, int32(20), limit.ClientBurst)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNormalizeOriginScheme(t *testing.T) {
+	for in, want := range map[string]string{"": "http", "HTTP": "http", "https": "https"} {
+		got, err := NormalizeOriginScheme(in)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := NormalizeOriginScheme("ftp")
+	assert.Error(t, err)
+}
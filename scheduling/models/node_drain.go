@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SetNodeDraining records whether a node is draining, as reported in its
+// SyncRequest. A draining node takes no new client requests and gets no
+// traffic, like a faulted one, until it reports being in service again.
+func SetNodeDraining(db *sql.DB, nodeIP string, draining bool, since time.Time) error {
+	var err error
+	if draining {
+		_, err = db.Exec(
+			"INSERT INTO node_drain (node_ip, draining_since) VALUES (?, ?) ON DUPLICATE KEY UPDATE node_ip = node_ip",
+			nodeIP, since)
+	} else {
+		_, err = db.Exec("DELETE FROM node_drain WHERE node_ip = ?", nodeIP)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set draining=%v for node %s: %w", draining, nodeIP, err)
+	}
+	return nil
+}
+
+// GetDrainingNodeIPs returns the set of node IPs that reported draining.
+func GetDrainingNodeIPs(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query("SELECT node_ip FROM node_drain")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query draining nodes: %w", err)
+	}
+	defer rows.Close()
+
+	draining := make(map[string]bool)
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, fmt.Errorf("failed to scan draining node: %w", err)
+		}
+		draining[ip] = true
+	}
+	return draining, rows.Err()
+}
+
+// GetUnavailableNodeIPs returns the set of node IPs that must get no traffic:
+// those with an unresolved fault and those draining.
+func GetUnavailableNodeIPs(db *sql.DB) (map[string]bool, error) {
+	unavailable, err := GetFaultedNodeIPs(db)
+	if err != nil {
+		return nil, err
+	}
+	draining, err := GetDrainingNodeIPs(db)
+	if err != nil {
+		return nil, err
+	}
+	for ip := range draining {
+		unavailable[ip] = true
+	}
+	return unavailable, nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetNodeDraining(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	since := time.Now()
+	mock.ExpectExec("INSERT INTO node_drain").WithArgs("10.0.0.1", since).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM node_drain").WithArgs("10.0.0.1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, SetNodeDraining(db, "10.0.0.1", true, since))
+	require.NoError(t, SetNodeDraining(db, "10.0.0.1", false, since))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetDrainingNodeIPs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT node_ip FROM node_drain").
+		WillReturnRows(sqlmock.NewRows([]string{"node_ip"}).AddRow("10.0.0.1").AddRow("10.0.0.2"))
+
+	draining, err := GetDrainingNodeIPs(db)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"10.0.0.1": true, "10.0.0.2": true}, draining)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
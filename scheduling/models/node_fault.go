@@ -0,0 +1,172 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	pb "scheduling/controller/heartbeats/proto"
+	"time"
+)
+
+// Fault lifecycle states stored in node_fault.status
+const (
+	FaultStatusOpen         = "open"
+	FaultStatusAcknowledged = "acknowledged"
+	FaultStatusResolved     = "resolved"
+)
+
+// FaultTypeResourceExhaustion is reported by a node about itself; the other
+// fault types reported by the forwarding nodes name a peer or origin as target.
+const (
+	FaultTypeResourceExhaustion = "resource_exhaustion"
+	FaultTypeOriginFailure      = "origin_failure"
+)
+
+// PeerFaultReporters is how many distinct nodes must report a peer before
+// it is taken out of service, so a single node cannot take out its peers.
+const PeerFaultReporters = 2
+
+// UpsertNodeFault records a fault report. A report for a fault_id that was
+// already resolved reopens it; repeated reports only refresh last_reported_at.
+func UpsertNodeFault(db *sql.DB, fault *pb.FaultInfo, reportedAt time.Time) error {
+	query := `
+		INSERT INTO node_fault (
+			fault_id, node_ip, fault_type, fault_description, target, status, reported_at, last_reported_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			fault_type = VALUES(fault_type),
+			fault_description = VALUES(fault_description),
+			target = VALUES(target),
+			last_reported_at = VALUES(last_reported_at),
+			acknowledged_at = IF(status = ?, NULL, acknowledged_at),
+			resolved_at = IF(status = ?, NULL, resolved_at),
+			status = IF(status = ?, ?, status)
+	`
+	_, err := db.Exec(query,
+		fault.FaultId, fault.NodeIp, fault.FaultType, fault.FaultDescription, fault.Target, FaultStatusOpen, reportedAt, reportedAt,
+		FaultStatusResolved, FaultStatusResolved, FaultStatusResolved, FaultStatusOpen,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to upsert fault %s for node %s: %w", fault.FaultId, fault.NodeIp, err)
+	}
+	return nil
+}
+
+// AcknowledgeNodeFaults moves all open faults of a node to acknowledged and
+// returns them so they can be echoed back in SyncResponse.acknowledged_faults.
+func AcknowledgeNodeFaults(db *sql.DB, nodeIP string) ([]*pb.FaultInfo, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin fault acknowledgement for %s: %w", nodeIP, err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		"SELECT fault_id, node_ip, fault_type, fault_description, target FROM node_fault WHERE node_ip = ? AND status = ?",
+		nodeIP, FaultStatusOpen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query open faults for %s: %w", nodeIP, err)
+	}
+
+	var faults []*pb.FaultInfo
+	for rows.Next() {
+		var description sql.NullString
+		fault := &pb.FaultInfo{}
+		if err := rows.Scan(&fault.FaultId, &fault.NodeIp, &fault.FaultType, &description, &fault.Target); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan open fault for %s: %w", nodeIP, err)
+		}
+		fault.FaultDescription = description.String
+		faults = append(faults, fault)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("failed to iterate open faults for %s: %w", nodeIP, err)
+	}
+	rows.Close()
+
+	if len(faults) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	for _, fault := range faults {
+		_, err := tx.Exec(
+			"UPDATE node_fault SET status = ?, acknowledged_at = ? WHERE fault_id = ? AND status = ?",
+			FaultStatusAcknowledged, now, fault.FaultId, FaultStatusOpen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to acknowledge fault %s: %w", fault.FaultId, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit fault acknowledgement for %s: %w", nodeIP, err)
+	}
+	return faults, nil
+}
+
+// ResolveStaleFaults resolves faults that have not been reported again since staleBefore.
+func ResolveStaleFaults(db *sql.DB, staleBefore time.Time) (int64, error) {
+	result, err := db.Exec(
+		"UPDATE node_fault SET status = ?, resolved_at = ? WHERE status IN (?, ?) AND last_reported_at < ?",
+		FaultStatusResolved, time.Now(), FaultStatusOpen, FaultStatusAcknowledged, staleBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve stale faults: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// GetFaultedNodeIPs returns the set of node IPs that an unresolved fault
+// points at. A resource fault is about the node that reported it. Probe and
+// next-hop faults are about their target, which is left out once
+// PeerFaultReporters nodes report it. Origin faults are not about a node.
+func GetFaultedNodeIPs(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(
+		"SELECT DISTINCT node_ip, fault_type, target FROM node_fault WHERE status IN (?, ?)",
+		FaultStatusOpen, FaultStatusAcknowledged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query faulted nodes: %w", err)
+	}
+	defer rows.Close()
+
+	faulted := make(map[string]bool)
+	reporters := make(map[string]map[string]bool) // peer IP -> nodes reporting it
+	for rows.Next() {
+		var nodeIP, faultType, target string
+		if err := rows.Scan(&nodeIP, &faultType, &target); err != nil {
+			return nil, fmt.Errorf("failed to scan faulted node: %w", err)
+		}
+		switch faultType {
+		case FaultTypeResourceExhaustion:
+			faulted[nodeIP] = true
+		case FaultTypeOriginFailure:
+		default:
+			ip := targetIP(target)
+			if ip == "" {
+				continue
+			}
+			if reporters[ip] == nil {
+				reporters[ip] = make(map[string]bool)
+			}
+			reporters[ip][nodeIP] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for ip, nodes := range reporters {
+		if len(nodes) >= PeerFaultReporters {
+			faulted[ip] = true
+		}
+	}
+	return faulted, nil
+}
+
+// targetIP returns the host of a fault target given as host or host:port.
+func targetIP(target string) string {
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return target
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFaultedNodeIPs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT DISTINCT node_ip, fault_type, target FROM node_fault").
+		WithArgs(FaultStatusOpen, FaultStatusAcknowledged).
+		WillReturnRows(sqlmock.NewRows([]string{"node_ip", "fault_type", "target"}).
+			AddRow("10.0.0.1", "resource_exhaustion", "cpu").
+			AddRow("10.0.0.3", "probe_unreachable", "10.0.0.5").
+			AddRow("10.0.0.8", "next_hop_unreachable", "10.0.0.5:50056").
+			AddRow("10.0.0.3", "origin_failure", "10.0.0.7:8080").
+			AddRow("10.0.0.8", "origin_failure", "10.0.0.7:8080"))
+
+	faulted, err := GetFaultedNodeIPs(db)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"10.0.0.1": true, "10.0.0.5": true}, faulted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFaultedNodeIPsSingleReporter(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// One node reporting a peer under several fault types is still one reporter
+	mock.ExpectQuery("SELECT DISTINCT node_ip, fault_type, target FROM node_fault").
+		WithArgs(FaultStatusOpen, FaultStatusAcknowledged).
+		WillReturnRows(sqlmock.NewRows([]string{"node_ip", "fault_type", "target"}).
+			AddRow("10.0.0.3", "probe_unreachable", "10.0.0.4").
+			AddRow("10.0.0.3", "next_hop_unreachable", "10.0.0.4:50056"))
+
+	faulted, err := GetFaultedNodeIPs(db)
+	require.NoError(t, err)
+	assert.Empty(t, faulted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}